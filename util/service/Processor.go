@@ -4,9 +4,44 @@
 
 package rocserv
 
+import (
+	"context"
+)
+
 type Processor interface {
 	// init
 	Init() error
 	// interace driver
 	Driver() (string, interface{})
 }
+
+// BaseInitializer processor可选实现，实现时代替Init调用，
+// 用于需要读取配置、服务信息才能构造driver的processor
+type BaseInitializer interface {
+	InitWithBase(sb ServBase) error
+}
+
+func initProcessor(sb ServBase, p Processor) error {
+	if bi, ok := p.(BaseInitializer); ok {
+		return bi.InitWithBase(sb)
+	}
+	return p.Init()
+}
+
+// Shutdowner processor可选实现，服务下线(drain)时在关闭监听前调用，
+// 用于processor刷新自身缓冲、按序释放资源
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Discoverable processor可选实现，返回false时只启动监听，不注册到服务发现，如内部调试页面
+type Discoverable interface {
+	Discoverable() bool
+}
+
+func isDiscoverable(p Processor) bool {
+	if d, ok := p.(Discoverable); ok {
+		return d.Discoverable()
+	}
+	return true
+}
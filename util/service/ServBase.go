@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	etcd "github.com/coreos/etcd/client"
 	"github.com/shawnfeng/sutil/dbrouter"
@@ -18,6 +19,12 @@ type ServInfo struct {
 	Type   string `json:"type"`
 	Addr   string `json:"addr"`
 	Servid int    `json:"-"`
+	// 实例发布的配置，见 [discovery] publish
+	Attrs map[string]string `json:"attrs,omitempty"`
+	// 实例权重，用于客户端按权重选择实例，为0时按DEFAULT_SERV_WEIGHT处理
+	Weight int `json:"weight,omitempty"`
+	// 开启TLS时为https，为空时使用明文连接
+	Scheme string `json:"scheme,omitempty"`
 	//Processor string    `json:"processor"`
 }
 
@@ -79,6 +86,8 @@ type ServBase interface {
 
 	// 获取服务的配置
 	ServConfig(cfg interface{}) error
+	// 服务配置变更时回调，参数为变更后的原始配置，新配置无法解析时不回调
+	WatchConfig(fn func(newRaw []byte))
 	// 任意路径的配置信息
 	//ArbiConfig(location string) (string, error)
 
@@ -116,4 +125,14 @@ type ServBase interface {
 
 	// db router
 	Dbrouter() *dbrouter.Router
+
+	// 注册依赖探测，框架按interval定时探测，探测失败时服务处于not ready状态
+	AddDependencyProbe(name string, interval time.Duration, probe func(ctx context.Context) error) error
+}
+
+// DependencyDeclarer ServBase可选实现，ServBaseV2及TestServBase均已实现，通过类型断言获取，
+// 不放在ServBase中，避免业务自己实现的ServBase因新增方法无法编译；声明的依赖由TopologyClient读取生成依赖图
+type DependencyDeclarer interface {
+	// 声明依赖的其他服务(servLoc)，注册到服务发现中用于生成服务依赖图
+	DeclareDependencies(servLocs ...string) error
 }
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	// now use 73a8ef737e8ea002281a28b4cb92a1de121ad4c6
 	//"github.com/coreos/go-etcd/etcd"
@@ -50,6 +51,8 @@ const (
 	BASE_LOC_REG_MANUAL = "manual"
 	// sla metrics注册的位置
 	BASE_LOC_REG_METRICS = "metrics"
+	// 服务声明的依赖注册的位置
+	BASE_LOC_REG_DEPS = "deps"
 
 	PROCESSOR_GRPC_PROPERTY_NAME = "proc_grpc"
 
@@ -57,11 +60,30 @@ const (
 
 	//预演环境分组标识
 	ENV_GROUP_PRE = "pre"
+
+	defaultDependencyDownThreshold = time.Second * 10
+
+	// 注册信息的默认ttl，每ttl/3刷新一次
+	registerTTL = time.Second * 60
+	// etcd v2 ttl精度为秒，过小时续约来不及
+	minRegisterTTL = time.Second * 3
+	// 下线时延迟清理注册信息的默认时长，防止新实例还没有完成注册
+	defaultDeregisterDelay = time.Second * 2
 )
 
 type configEtcd struct {
 	etcdAddrs  []string
 	useBaseloc string
+	// 连接参数，为空时使用SetEtcdOptions设置的值
+	opts *etcdOptions
+}
+
+// clientConfig 使用本配置的连接参数连接endpoints
+func (m configEtcd) clientConfig(endpoints []string) etcd.Config {
+	if m.opts == nil {
+		return newEtcdConfig(endpoints)
+	}
+	return buildEtcdConfig(endpoints, *m.opts)
 }
 
 type ServBaseV2 struct {
@@ -95,9 +117,33 @@ type ServBaseV2 struct {
 
 	muStop sync.Mutex
 	stop   bool
+	// 下线时关闭，唤醒等待中的注册续约循环
+	stopC chan struct{}
+	// 注册续约循环，下线时等待全部退出后再删除注册信息
+	regLoops sync.WaitGroup
 
 	muReg    sync.Mutex
 	regInfos map[string]string
+	// 注册信息的ttl，进程异常退出时注册信息最多保留该时长
+	regTTL time.Duration
+	// 下线时删除注册信息前等待的时长
+	deregDelay time.Duration
+
+	probes    *dependencyProbes
+	keepalive *registryKeepalive
+	// WatchConfig注册的回调
+	configWatch *configWatcher
+
+	// 当前注册到manual中的分组及启动时的disable配置
+	muGroup    sync.Mutex
+	regGroup   string
+	regDisable bool
+
+	// 因关键依赖不可用被摘除
+	drained int32
+
+	// 为true时只记录并打印注册信息，不写入etcd
+	dryRun bool
 }
 
 func (m *ServBaseV2) isStop() bool {
@@ -107,17 +153,107 @@ func (m *ServBaseV2) isStop() bool {
 	return m.stop
 }
 
+// Stop 停止续约并删除注册信息，client立即不再路由到本实例，不等待ttl过期。
+// 使用的是etcd v2 keys api，没有lease可以revoke，注册信息带ttl并由后台循环续约，
+// 所以先等待续约循环全部退出，避免进行中的Set在删除后重新创建注册信息，再按PrevValue删除
 func (m *ServBaseV2) Stop() {
 	m.setStatusToStop()
+	m.probes.stop()
+	m.configWatch.stop()
+	m.regLoops.Wait()
+
+	// 没有需要删除的注册信息时不等待
+	if !m.dryRun && len(m.registerInfos()) > 0 {
+		time.Sleep(m.deregisterDelay())
+	}
 	m.clearRegisterInfos()
 	m.clearCrossDCRegisterInfos()
 }
 
+// setDeregisterDelay <0 时不等待
+func (m *ServBaseV2) setDeregisterDelay(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	m.muReg.Lock()
+	defer m.muReg.Unlock()
+
+	m.deregDelay = d
+}
+
+func (m *ServBaseV2) deregisterDelay() time.Duration {
+	m.muReg.Lock()
+	defer m.muReg.Unlock()
+
+	return m.deregDelay
+}
+
+// setRegisterTTL 需要在注册前调用
+func (m *ServBaseV2) setRegisterTTL(ttl time.Duration) {
+	if ttl < minRegisterTTL {
+		ttl = minRegisterTTL
+	}
+
+	m.muReg.Lock()
+	m.regTTL = ttl
+	m.muReg.Unlock()
+
+	m.keepalive.setTTL(ttl)
+}
+
+// registerTTL 注册信息的ttl及续约间隔
+func (m *ServBaseV2) registerTTL() (ttl, interval time.Duration) {
+	m.muReg.Lock()
+	defer m.muReg.Unlock()
+
+	ttl = m.regTTL
+	if ttl <= 0 {
+		ttl = registerTTL
+	}
+	return ttl, ttl / 3
+}
+
+// deregister 删除注册信息，只删除值和本实例注册数据相同的节点，避免误删新实例的注册
+func deregister(client etcd.KeysAPI, path, regInfo string) error {
+	_, err := client.Delete(context.Background(), path, &etcd.DeleteOptions{PrevValue: regInfo})
+	if e, ok := err.(etcd.Error); ok && e.Code == etcd.ErrorCodeKeyNotFound {
+		return nil
+	}
+	return err
+}
+
 func (m *ServBaseV2) setStatusToStop() {
 	m.muStop.Lock()
 	defer m.muStop.Unlock()
 
+	if m.stop {
+		return
+	}
 	m.stop = true
+	if m.stopC != nil {
+		close(m.stopC)
+	}
+}
+
+// goRegister 启动注册续约循环，已下线时不再启动；loop在stopC关闭后需要尽快返回
+func (m *ServBaseV2) goRegister(loop func(stopC <-chan struct{})) {
+	m.muStop.Lock()
+	defer m.muStop.Unlock()
+
+	if m.stop {
+		return
+	}
+	if m.stopC == nil {
+		m.stopC = make(chan struct{})
+	}
+
+	stopC := m.stopC
+	m.regLoops.Add(1)
+	go func() {
+		defer m.regLoops.Done()
+		loop(stopC)
+	}()
 }
 
 func (m *ServBaseV2) addRegisterInfo(path, regInfo string) {
@@ -127,24 +263,39 @@ func (m *ServBaseV2) addRegisterInfo(path, regInfo string) {
 	m.regInfos[path] = regInfo
 }
 
-func (m *ServBaseV2) clearRegisterInfos() {
-	fun := "ServBaseV2.clearRegisterInfos -->"
+func (m *ServBaseV2) registerInfo(path string) (string, bool) {
+	m.muReg.Lock()
+	defer m.muReg.Unlock()
 
-	//延迟清理注册信息,防止新实例还没有完成注册
-	time.Sleep(time.Second * 2)
+	info, ok := m.regInfos[path]
+	return info, ok
+}
 
+// registerInfos 已注册(dry run时为将要注册)的路径及数据
+func (m *ServBaseV2) registerInfos() map[string]string {
 	m.muReg.Lock()
 	defer m.muReg.Unlock()
 
-	for path, _ := range m.regInfos {
-		_, err := m.etcdClient.Set(context.Background(), path, "", &etcd.SetOptions{
-			PrevExist: etcd.PrevExist,
-			TTL:       0,
-			Refresh:   true,
-		})
-		if err != nil {
+	infos := make(map[string]string, len(m.regInfos))
+	for path, info := range m.regInfos {
+		infos[path] = info
+	}
+	return infos
+}
+
+func (m *ServBaseV2) clearRegisterInfos() {
+	fun := "ServBaseV2.clearRegisterInfos -->"
+
+	if m.dryRun {
+		return
+	}
+
+	for path, regInfo := range m.registerInfos() {
+		if err := deregister(m.etcdClient, path, regInfo); err != nil {
 			slog.Warnf("%s path:%s err:%v", fun, path, err)
+			continue
 		}
+		slog.Infof("%s deregister path:%s", fun, path)
 	}
 }
 
@@ -186,6 +337,22 @@ func (m *ServBaseV2) RegisterMetrics(servs map[string]*ServInfo) error {
 
 }
 
+// DeclareDependencies 声明服务依赖的其他服务，用于生成服务依赖图
+func (m *ServBaseV2) DeclareDependencies(servLocs ...string) error {
+	fun := "ServBaseV2.DeclareDependencies -->"
+
+	js, err := json.Marshal(servLocs)
+	if err != nil {
+		return err
+	}
+
+	slog.Infof("%s deps:%s", fun, js)
+
+	path := fmt.Sprintf("%s/%s/%s/%d/%s", m.confEtcd.useBaseloc, BASE_LOC_DIST_V2, m.servLocation, m.servId, BASE_LOC_REG_DEPS)
+
+	return m.doRegister(path, string(js), true)
+}
+
 // {type:http/thrift, addr:10.3.3.3:23233, processor:fuck}
 func (m *ServBaseV2) RegisterService(servs map[string]*ServInfo) error {
 	fun := "ServBaseV2.RegisterService -->"
@@ -295,12 +462,164 @@ func (m *ServBaseV2) SetGroupAndDisable(group string, disable bool) error {
 	}
 
 	slog.Infof("%s path:%s old value:%s new value:%s", fun, path, value, newValue)
-	err = m.setValueToEtcd(path, string(newValue), nil)
+	if m.dryRun {
+		m.addRegisterInfo(path, string(newValue))
+	} else {
+		err = m.setValueToEtcd(path, string(newValue), nil)
+		if err != nil {
+			slog.Errorf("%s setValueToEtcd err, path:%s value:%s", fun, path, newValue)
+			return err
+		}
+	}
+
+	m.muGroup.Lock()
+	m.regGroup = group
+	m.regDisable = disable
+	m.muGroup.Unlock()
+
+	return nil
+}
+
+// Group 当前实例所在的分组
+func (m *ServBaseV2) Group() string {
+	m.muGroup.Lock()
+	defer m.muGroup.Unlock()
+
+	return m.regGroup
+}
+
+// ChangeGroup 运行时变更实例分组，从manual的分组列表中移除老分组并加入新分组
+func (m *ServBaseV2) ChangeGroup(group string) error {
+	fun := "ServBaseV2.ChangeGroup -->"
+
+	m.muGroup.Lock()
+	defer m.muGroup.Unlock()
+
+	err := m.updateManualCtrl(func(ctrl *ServCtrl) {
+		ctrl.Groups = replaceGroup(ctrl.Groups, m.regGroup, group)
+	})
 	if err != nil {
-		slog.Errorf("%s setValueToEtcd err, path:%s value:%s", fun, path, newValue)
+		slog.Errorf("%s old group:%s new group:%s err:%s", fun, m.regGroup, group, err)
+		return err
 	}
 
-	return err
+	slog.Infof("%s old group:%s new group:%s", fun, m.regGroup, group)
+	m.regGroup = group
+	return nil
+}
+
+// setDrain 通过manual中的disable将实例从服务发现中摘除或恢复，恢复时还原为启动时的disable配置，
+// 和ChangeGroup使用同一把锁，避免并发读写manual时互相覆盖
+func (m *ServBaseV2) setDrain(drain bool) error {
+	m.muGroup.Lock()
+	defer m.muGroup.Unlock()
+
+	err := m.updateManualCtrl(func(ctrl *ServCtrl) {
+		ctrl.Disable = drain || m.regDisable
+	})
+	if err != nil {
+		return err
+	}
+
+	var v int32
+	if drain {
+		v = 1
+	}
+	atomic.StoreInt32(&m.drained, v)
+	return nil
+}
+
+func (m *ServBaseV2) isDrained() bool {
+	return atomic.LoadInt32(&m.drained) == 1
+}
+
+// updateManualCtrl 读取manual配置，修改后写回etcd
+func (m *ServBaseV2) updateManualCtrl(update func(ctrl *ServCtrl)) error {
+	fun := "ServBaseV2.updateManualCtrl -->"
+
+	path := fmt.Sprintf("%s/%s/%s/%d/%s", m.confEtcd.useBaseloc, BASE_LOC_DIST_V2, m.servLocation, m.servId, BASE_LOC_REG_MANUAL)
+	value, err := getValue(m.etcdClient, path)
+	if err != nil {
+		slog.Warnf("%s getValue err, path:%s, err:%v", fun, path, err)
+	}
+
+	manual := &ManualData{}
+	if len(value) > 0 {
+		err = json.Unmarshal(value, manual)
+		if err != nil {
+			slog.Errorf("%s unmarshal err, value:%s, err:%v", fun, value, err)
+			return err
+		}
+	}
+
+	if manual.Ctrl == nil {
+		manual.Ctrl = &ServCtrl{}
+	}
+	update(manual.Ctrl)
+	if manual.Ctrl.Weight == 0 {
+		manual.Ctrl.Weight = 100
+	}
+
+	newValue, err := json.Marshal(manual)
+	if err != nil {
+		slog.Errorf("%s marshal err, manual:%v, err:%v", fun, manual, err)
+		return err
+	}
+
+	slog.Infof("%s path:%s old value:%s new value:%s", fun, path, value, newValue)
+	return m.setValueToEtcd(path, string(newValue), nil)
+}
+
+// startDependencyDrain 根据配置监控关键依赖，持续不可用时摘除实例
+func (m *ServBaseV2) startDependencyDrain() error {
+	fun := "ServBaseV2.startDependencyDrain -->"
+
+	var depConfig DependencyConfig
+	err := m.ServConfig(&depConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	critical := make(map[string]time.Duration)
+	for name, c := range depConfig.Dependency {
+		m.probes.setOptional(name, c.Optional)
+
+		if !c.Critical {
+			continue
+		}
+
+		threshold := time.Duration(c.DownThreshold) * time.Millisecond
+		if threshold <= 0 {
+			threshold = defaultDependencyDownThreshold
+		}
+		critical[name] = threshold
+	}
+
+	if len(critical) == 0 {
+		return nil
+	}
+
+	slog.Infof("%s critical dependencies:%v", fun, critical)
+	drainer := &dependencyDrainer{
+		probes:   m.probes,
+		critical: critical,
+		setDrain: m.setDrain,
+	}
+	go drainer.run(time.Second)
+
+	return nil
+}
+
+func replaceGroup(groups []string, old, group string) []string {
+	var res []string
+	for _, g := range groups {
+		if g != old && g != group {
+			res = append(res, g)
+		}
+	}
+
+	return append(res, group)
 }
 
 func (m *ServBaseV2) getValueFromEtcd(path string) (value string, err error) {
@@ -335,34 +654,64 @@ func (m *ServBaseV2) setValueToEtcd(path, value string, opts *etcd.SetOptions) e
 func (m *ServBaseV2) doRegister(path, js string, refresh bool) error {
 	fun := "ServBaseV2.doRegister -->"
 
+	_, registered := m.registerInfo(path)
 	m.addRegisterInfo(path, js)
+	if m.dryRun {
+		slog.Infof("%s dry run, skip register path:%s data:%s", fun, path, js)
+		return nil
+	}
+
+	// 已经在刷新的路径只更新注册数据，如动态添加processor
+	if registered {
+		slog.Infof("%s update path:%s data:%s", fun, path, js)
+		ttl, _ := m.registerTTL()
+		_, err := m.etcdClient.Set(context.Background(), path, js, &etcd.SetOptions{
+			TTL: ttl,
+		})
+		if err != nil {
+			slog.Errorf("%s update path:%s err:%v", fun, path, err)
+		}
+		return err
+	}
+	m.keepalive.track(path)
 
 	// 创建完成标志
 	var iscreate bool
 
 	slog.Infof("%s path:%s data:%s refresh:%t", fun, path, js, refresh)
 
-	go func() {
+	m.goRegister(func(stopC <-chan struct{}) {
 
 		for i := 0; ; i++ {
+			// 下线时不再创建已删除的注册信息
+			if m.isStop() {
+				slog.Infof("%s service stop, register [%s] stop", fun, path)
+				return
+			}
+
+			ttl, interval := m.registerTTL()
 			var err error
 			var r *etcd.Response
 			if !iscreate {
+				// 注册数据可能已经更新
+				if v, ok := m.registerInfo(path); ok {
+					js = v
+				}
 				slog.Warnf("%s create idx:%d servs:%s", fun, i, js)
 				r, err = m.etcdClient.Set(context.Background(), path, js, &etcd.SetOptions{
-					TTL: time.Second * 60,
+					TTL: ttl,
 				})
 			} else {
 				if refresh {
 					// 在刷新ttl时候，不允许变更value
 					r, err = m.etcdClient.Set(context.Background(), path, "", &etcd.SetOptions{
 						PrevExist: etcd.PrevExist,
-						TTL:       time.Second * 60,
+						TTL:       ttl,
 						Refresh:   true,
 					})
 				} else {
 					r, err = m.etcdClient.Set(context.Background(), path, js, &etcd.SetOptions{
-						TTL: time.Second * 60,
+						TTL: ttl,
 					})
 				}
 
@@ -374,17 +723,16 @@ func (m *ServBaseV2) doRegister(path, js string, refresh bool) error {
 
 			} else {
 				iscreate = true
+				m.keepalive.ok(path, time.Now())
 			}
 
-			time.Sleep(time.Second * 20)
-
-			if m.isStop() {
-				slog.Infof("%s service stop, register [%s] stop", fun, path)
-				return
+			select {
+			case <-stopC:
+			case <-time.After(interval):
 			}
 		}
 
-	}()
+	})
 
 	return nil
 }
@@ -406,8 +754,64 @@ func (m *ServBaseV2) Dbrouter() *dbrouter.Router {
 	return m.dbRouter
 }
 
+func (m *ServBaseV2) AddDependencyProbe(name string, interval time.Duration, probe func(ctx context.Context) error) error {
+	fun := "ServBaseV2.AddDependencyProbe -->"
+
+	err := m.probes.add(name, interval, probe)
+	if err != nil {
+		slog.Errorf("%s add dependency:%s err:%s", fun, name, err)
+		return err
+	}
+
+	slog.Infof("%s dependency:%s interval:%s", fun, name, interval)
+	return nil
+}
+
 func (m *ServBaseV2) ServConfig(cfg interface{}) error {
-	fun := "ServBaseV2.ServConfig -->"
+	tf, err := m.loadServConfig()
+	if err != nil {
+		return err
+	}
+
+	return tf.Unmarshal(cfg)
+}
+
+// loadServConfig 加载全局配置及服务配置，服务配置覆盖全局配置，
+// 设置了ROC_ENV时各自再叠加对应环境的配置，配置中的 ${scheme:ref} 替换为解析后的secret
+func (m *ServBaseV2) loadServConfig() (*sconf.TierConf, error) {
+	layers, err := m.loadConfigLayers()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, layer := range layers {
+		layers[i], err = resolveSecrets(layer)
+		if err != nil {
+			slog.Errorf("ServBaseV2.loadServConfig --> resolve secrets err:%s", err)
+			return nil, err
+		}
+	}
+	return mergeConfig(layers...)
+}
+
+// loadRedactedServConfig 同loadServConfig，secret引用替换为掩码，用于展示
+func (m *ServBaseV2) loadRedactedServConfig() (*sconf.TierConf, error) {
+	layers, err := m.loadConfigLayers()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, layer := range layers {
+		layers[i] = redactSecrets(layer)
+	}
+	return mergeConfig(layers...)
+}
+
+// loadConfigLayers 按覆盖顺序返回各层原始配置
+func (m *ServBaseV2) loadConfigLayers() ([][]byte, error) {
+	fun := "ServBaseV2.loadConfigLayers -->"
+	env := configEnv()
+
 	// 获取全局配置
 	path := fmt.Sprintf("%s/%s", m.confEtcd.useBaseloc, BASE_LOC_ETC_GLOBAL)
 	scfg_global, err := getValue(m.etcdClient, path)
@@ -415,41 +819,60 @@ func (m *ServBaseV2) ServConfig(cfg interface{}) error {
 		slog.Warnf("%s serv config global value path:%s err:%s", fun, path, err)
 	}
 	slog.Infof("%s global cfg:%s path:%s", fun, scfg_global, path)
+	scfg_global_env := m.loadOverlay(path, env)
 
-	path = fmt.Sprintf("%s/%s/%s", m.confEtcd.useBaseloc, BASE_LOC_ETC, m.servLocation)
+	path = m.servConfigPath()
 	scfg, err := getValue(m.etcdClient, path)
 	if err != nil {
 		slog.Warnf("%s serv config value path:%s err:%s", fun, path, err)
 	}
 
 	slog.Infof("%s cfg:%s path:%s", fun, scfg, path)
-	tf := sconf.NewTierConf()
-	err = tf.Load(scfg_global)
+
+	scfg, err = migrateConfig(scfg)
 	if err != nil {
-		return err
+		slog.Errorf("%s migrate config path:%s err:%s", fun, path, err)
+		return nil, err
 	}
+	scfg_env := m.loadOverlay(path, env)
+
+	return [][]byte{scfg_global, scfg_global_env, scfg, scfg_env}, nil
+}
+
+// loadOverlay 加载环境配置，env为空或不存在时返回nil
+func (m *ServBaseV2) loadOverlay(path, env string) []byte {
+	fun := "ServBaseV2.loadOverlay -->"
 
-	err = tf.Load(scfg)
+	if len(env) == 0 {
+		return nil
+	}
+
+	path = overlayPath(path, env)
+	cfg, err := getValue(m.etcdClient, path)
 	if err != nil {
-		return err
+		slog.Infof("%s env:%s overlay path:%s err:%s", fun, env, path, err)
+		return nil
 	}
 
-	err = tf.Unmarshal(cfg)
+	slog.Infof("%s env:%s overlay cfg:%s path:%s", fun, env, cfg, path)
+	return cfg
+}
+
+// publishedAttrs 按 [discovery] publish 配置的key读取需要发布到服务发现中的配置
+func (m *ServBaseV2) publishedAttrs() (map[string]string, error) {
+	tf, err := m.loadServConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return configAttrs(tf), nil
 }
 
 // etcd v2 接口
 func NewServBaseV2(confEtcd configEtcd, servLocation, skey, envGroup string, sidOffset int) (*ServBaseV2, error) {
 	fun := "NewServBaseV2 -->"
 
-	cfg := etcd.Config{
-		Endpoints: confEtcd.etcdAddrs,
-		Transport: etcd.DefaultTransport,
-	}
+	cfg := confEtcd.clientConfig(confEtcd.etcdAddrs)
 
 	c, err := etcd.New(cfg)
 	if err != nil {
@@ -494,6 +917,11 @@ func NewServBaseV2(confEtcd configEtcd, servLocation, skey, envGroup string, sid
 		locks:                make(map[string]*ssync.Mutex),
 		hearts:               make(map[string]*distLockHeart),
 		regInfos:             make(map[string]string),
+		probes:               newDependencyProbes(),
+		regTTL:               registerTTL,
+		deregDelay:           defaultDeregisterDelay,
+		keepalive:            newRegistryKeepalive(registerTTL),
+		configWatch:          newConfigWatcher(),
 
 		dbRouter: dr,
 
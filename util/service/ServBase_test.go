@@ -5,9 +5,20 @@
 package rocserv
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	etcd "github.com/coreos/etcd/client"
 )
 
 func TestIt(t *testing.T) {
@@ -16,7 +27,7 @@ func TestIt(t *testing.T) {
 	//skey = "beauty"
 	var sb ServBase
 	var err error
-	sb, err = NewServBaseV2(configEtcd{etcds, "/roc"}, "niubi/fuck", skey, "",0)
+	sb, err = NewServBaseV2(configEtcd{etcdAddrs: etcds, useBaseloc: "/roc"}, "niubi/fuck", skey, "",0)
 
 	if err != nil {
 		t.Errorf("create err:%s", err)
@@ -95,3 +106,538 @@ func TestIt(t *testing.T) {
 	sb.Lock("testlock")
 	time.Sleep(time.Second * 50)
 }
+
+func TestReplaceGroup(t *testing.T) {
+	groups := replaceGroup([]string{"", "stable"}, "stable", "canary")
+	if len(groups) != 2 || groups[0] != "" || groups[1] != "canary" {
+		t.Errorf("unexpected groups:%v", groups)
+	}
+
+	groups = replaceGroup([]string{"canary"}, "stable", "canary")
+	if len(groups) != 1 || groups[0] != "canary" {
+		t.Errorf("unexpected groups:%v", groups)
+	}
+}
+
+func TestChangeGroupDiscovery(t *testing.T) {
+	keys := newTestRegKeysAPI()
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		servId:       3,
+		etcdClient:   keys,
+		regInfos:     make(map[string]string),
+		probes:       newDependencyProbes(),
+		keepalive:    newRegistryKeepalive(registerTTL),
+		configWatch:  newConfigWatcher(),
+	}
+	defer sb.Stop()
+
+	servs := map[string]*ServInfo{
+		"proc_http": &ServInfo{Type: PROCESSOR_HTTP, Addr: "10.1.2.3:8080"},
+	}
+	if err := sb.RegisterService(servs); err != nil {
+		t.Fatalf("register err:%s", err)
+	}
+	if err := sb.SetGroupAndDisable("", false); err != nil {
+		t.Fatalf("set group err:%s", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, _, ok := keys.get("/roc/dist2/base/test/3/serve"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("register timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	servPath := "/roc/dist2/base/test"
+	cli := &ClientEtcdV2{servPath: servPath, distLoc: BASE_LOC_DIST_V2}
+	check := func(group string, want int) {
+		r, err := keys.Get(context.Background(), servPath, &etcd.GetOptions{Recursive: true})
+		if err != nil {
+			t.Fatalf("get err:%s", err)
+		}
+		cli.parseResponse(r)
+		if servs := cli.GetAllServAddrWithGroup(group, "proc_http"); len(servs) != want {
+			t.Errorf("group:%q servs:%d want:%d", group, len(servs), want)
+		}
+	}
+	check("", 1)
+	check(GROUP_CANARY, 0)
+
+	old := service
+	service = NewService()
+	service.sbase = sb
+	defer func() { service = old }()
+
+	_, driver := (&backDoorHttp{}).Driver()
+	router := driver.(http.Handler)
+
+	// GET只查看，不变更分组
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/backdoor/group?set="+GROUP_CANARY, nil))
+	if w.Code != http.StatusOK || sb.Group() != "" {
+		t.Fatalf("get group code:%d group:%s", w.Code, sb.Group())
+	}
+	check("", 1)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/backdoor/group?set="+GROUP_CANARY, nil))
+	if w.Code != http.StatusOK || sb.Group() != GROUP_CANARY {
+		t.Fatalf("change group code:%d body:%s", w.Code, w.Body.String())
+	}
+
+	check("", 0)
+	check(GROUP_CANARY, 1)
+}
+
+func TestDryRunRegister(t *testing.T) {
+	// etcdClient为nil，任何etcd写入都会panic
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		servId:       3,
+		regInfos:     make(map[string]string),
+		dryRun:       true,
+	}
+
+	servs := map[string]*ServInfo{
+		"proc_http": &ServInfo{Type: PROCESSOR_HTTP, Addr: "127.0.0.1:8080"},
+	}
+	if err := sb.RegisterService(servs); err != nil {
+		t.Fatalf("register err:%s", err)
+	}
+
+	infos := sb.registerInfos()
+	if len(infos) != 2 {
+		t.Fatalf("register infos:%v", infos)
+	}
+	if _, ok := infos["/roc/dist2/base/test/3/serve"]; !ok {
+		t.Errorf("v2 path not found:%v", infos)
+	}
+	if _, ok := infos["/roc/dist/base/test/3"]; !ok {
+		t.Errorf("v1 path not found:%v", infos)
+	}
+}
+
+// testRegKeysAPI 内存中的注册节点，记录写入时的ttl
+type testRegKeysAPI struct {
+	etcd.KeysAPI
+
+	mu     sync.Mutex
+	values map[string]string
+	ttls   map[string]time.Duration
+}
+
+func newTestRegKeysAPI() *testRegKeysAPI {
+	return &testRegKeysAPI{values: make(map[string]string), ttls: make(map[string]time.Duration)}
+}
+
+func (m *testRegKeysAPI) Set(ctx context.Context, key, value string, opts *etcd.SetOptions) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.values[key]
+	if opts != nil && opts.PrevExist == etcd.PrevExist && !ok {
+		return nil, etcd.Error{Code: etcd.ErrorCodeKeyNotFound}
+	}
+	if opts == nil || !opts.Refresh {
+		m.values[key] = value
+	}
+	if opts != nil {
+		m.ttls[key] = opts.TTL
+	}
+	return &etcd.Response{Action: "set", Node: &etcd.Node{Key: key, Value: m.values[key]}}, nil
+}
+
+func (m *testRegKeysAPI) Delete(ctx context.Context, key string, opts *etcd.DeleteOptions) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.values[key]
+	if !ok {
+		return nil, etcd.Error{Code: etcd.ErrorCodeKeyNotFound}
+	}
+	if opts != nil && len(opts.PrevValue) > 0 && opts.PrevValue != v {
+		return nil, etcd.Error{Code: etcd.ErrorCodeTestFailed}
+	}
+	delete(m.values, key)
+	return &etcd.Response{Action: "delete", Node: &etcd.Node{Key: key}}, nil
+}
+
+// Get key存在时返回该节点，否则返回以key为前缀的目录
+func (m *testRegKeysAPI) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if v, ok := m.values[key]; ok {
+		return &etcd.Response{Action: "get", Node: &etcd.Node{Key: key, Value: v}}, nil
+	}
+
+	node := m.dir(key)
+	if len(node.Nodes) == 0 {
+		return nil, etcd.Error{Code: etcd.ErrorCodeKeyNotFound}
+	}
+	return &etcd.Response{Action: "get", Node: node}, nil
+}
+
+func (m *testRegKeysAPI) dir(key string) *etcd.Node {
+	node := &etcd.Node{Key: key, Dir: true}
+	children := make(map[string]bool)
+	for k, v := range m.values {
+		if !strings.HasPrefix(k, key+"/") {
+			continue
+		}
+		name := strings.SplitN(k[len(key)+1:], "/", 2)[0]
+		if children[name] {
+			continue
+		}
+		children[name] = true
+
+		if k == key+"/"+name {
+			node.Nodes = append(node.Nodes, &etcd.Node{Key: k, Value: v})
+		} else {
+			node.Nodes = append(node.Nodes, m.dir(key+"/"+name))
+		}
+	}
+	return node
+}
+
+func (m *testRegKeysAPI) get(key string) (string, time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.values[key]
+	return v, m.ttls[key], ok
+}
+
+func TestDeregisterOnShutdown(t *testing.T) {
+	keys := newTestRegKeysAPI()
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		servId:       3,
+		etcdClient:   keys,
+		regInfos:     make(map[string]string),
+		probes:       newDependencyProbes(),
+		keepalive:    newRegistryKeepalive(registerTTL),
+		configWatch:  newConfigWatcher(),
+	}
+	sb.setRegisterTTL(time.Second * 5)
+
+	servs := map[string]*ServInfo{
+		"proc_http": &ServInfo{Type: PROCESSOR_HTTP, Addr: "10.1.2.3:8080"},
+	}
+	if err := sb.RegisterService(servs); err != nil {
+		t.Fatalf("register err:%s", err)
+	}
+
+	v2, v1 := "/roc/dist2/base/test/3/serve", "/roc/dist/base/test/3"
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, ttl2, ok2 := keys.get(v2)
+		_, ttl1, ok1 := keys.get(v1)
+		if ok1 && ok2 {
+			if ttl1 != time.Second*5 || ttl2 != time.Second*5 {
+				t.Errorf("register ttl:%s %s", ttl1, ttl2)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("register timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// 新实例使用相同路径注册了不同的数据，不删除
+	keys.Set(context.Background(), v1, "new instance", nil)
+
+	m := NewService()
+	m.sbase = sb
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown err:%s", err)
+	}
+
+	if _, _, ok := keys.get(v2); ok {
+		t.Errorf("path:%s should be deleted after shutdown", v2)
+	}
+	if v, _, _ := keys.get(v1); v != "new instance" {
+		t.Errorf("path:%s of new instance should be kept, got:%q", v1, v)
+	}
+}
+
+// blockingRegKeysAPI Set在release关闭前阻塞，模拟下线时正在进行的注册
+type blockingRegKeysAPI struct {
+	*testRegKeysAPI
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingRegKeysAPI) Set(ctx context.Context, key, value string, opts *etcd.SetOptions) (*etcd.Response, error) {
+	select {
+	case m.entered <- struct{}{}:
+	default:
+	}
+	<-m.release
+	return m.testRegKeysAPI.Set(ctx, key, value, opts)
+}
+
+func TestDeregisterWaitRegisterLoop(t *testing.T) {
+	keys := &blockingRegKeysAPI{
+		testRegKeysAPI: newTestRegKeysAPI(),
+		entered:        make(chan struct{}, 1),
+		release:        make(chan struct{}),
+	}
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		servId:       3,
+		etcdClient:   keys,
+		regInfos:     make(map[string]string),
+		probes:       newDependencyProbes(),
+		keepalive:    newRegistryKeepalive(registerTTL),
+		configWatch:  newConfigWatcher(),
+	}
+
+	servs := map[string]*ServInfo{
+		"backdoor": &ServInfo{Type: PROCESSOR_HTTP, Addr: "10.1.2.3:60000"},
+	}
+	if err := sb.RegisterBackDoor(servs); err != nil {
+		t.Fatalf("register err:%s", err)
+	}
+	select {
+	case <-keys.entered:
+	case <-time.After(time.Second):
+		t.Fatalf("register timeout")
+	}
+
+	// 注册进行中时Stop等待其完成后再删除，删除后不会被重新创建
+	done := make(chan struct{})
+	go func() {
+		sb.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("stop should wait for register in progress")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	close(keys.release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("stop timeout")
+	}
+
+	path := "/roc/dist2/base/test/3/backdoor"
+	if _, _, ok := keys.get(path); ok {
+		t.Errorf("path:%s should be deleted after stop", path)
+	}
+}
+
+func TestDeregisterDelay(t *testing.T) {
+	keys := newTestRegKeysAPI()
+	newServBase := func() *ServBaseV2 {
+		sb := &ServBaseV2{
+			confEtcd:     configEtcd{useBaseloc: "/roc"},
+			servLocation: "base/test",
+			servId:       3,
+			etcdClient:   keys,
+			regInfos:     make(map[string]string),
+			probes:       newDependencyProbes(),
+			keepalive:    newRegistryKeepalive(registerTTL),
+			configWatch:  newConfigWatcher(),
+		}
+		sb.setDeregisterDelay(time.Millisecond * 300)
+		return sb
+	}
+
+	// 没有注册信息时不等待
+	st := time.Now()
+	newServBase().Stop()
+	if d := time.Since(st); d > time.Millisecond*200 {
+		t.Errorf("stop without registration took:%s", d)
+	}
+
+	sb := newServBase()
+	servs := map[string]*ServInfo{
+		"proc_http": &ServInfo{Type: PROCESSOR_HTTP, Addr: "10.1.2.3:8080"},
+	}
+	if err := sb.RegisterService(servs); err != nil {
+		t.Fatalf("register err:%s", err)
+	}
+	path := "/roc/dist2/base/test/3/serve"
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, _, ok := keys.get(path); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("register timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// 等待期间注册信息保留，之后删除
+	done := make(chan struct{})
+	go func() {
+		sb.Stop()
+		close(done)
+	}()
+	time.Sleep(time.Millisecond * 100)
+	if _, _, ok := keys.get(path); !ok {
+		t.Errorf("path:%s should be kept during deregister delay", path)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("stop timeout")
+	}
+	if _, _, ok := keys.get(path); ok {
+		t.Errorf("path:%s should be deleted after stop", path)
+	}
+}
+
+func TestSetDrainConcurrentWithChangeGroup(t *testing.T) {
+	keys := newTestRegKeysAPI()
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		servId:       3,
+		etcdClient:   keys,
+	}
+	manual := "/roc/dist2/base/test/3/manual"
+
+	// 并发读写manual时两次修改都要保留
+	for i := 0; i < 100; i++ {
+		keys.Set(context.Background(), manual, `{"ctrl":{"weight":100,"groups":[""]}}`, nil)
+		group := fmt.Sprintf("g%d", i)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := sb.ChangeGroup(group); err != nil {
+				t.Errorf("change group err:%s", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := sb.setDrain(true); err != nil {
+				t.Errorf("set drain err:%s", err)
+			}
+		}()
+		wg.Wait()
+
+		v, _, _ := keys.get(manual)
+		var data ManualData
+		if err := json.Unmarshal([]byte(v), &data); err != nil {
+			t.Fatalf("unmarshal manual:%s err:%s", v, err)
+		}
+		if !data.Ctrl.Disable || data.Ctrl.Groups[len(data.Ctrl.Groups)-1] != group {
+			t.Fatalf("lost update, manual:%s group:%s", v, group)
+		}
+	}
+}
+
+func TestBackdoorPortFallback(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	defer lis.Close()
+	occupied := lis.Addr().String()
+
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		servId:       3,
+		regInfos:     make(map[string]string),
+		dryRun:       true,
+	}
+
+	m := NewService()
+	binfos, err := m.loadBackdoor(sb, &backDoorHttp{}, occupied)
+	if err != nil {
+		t.Fatalf("load backdoor err:%s", err)
+	}
+	addr := binfos["_PROC_BACKDOOR"].Addr
+	if addr == occupied {
+		t.Fatalf("backdoor bind occupied addr:%s", addr)
+	}
+
+	if err := sb.RegisterBackDoor(binfos); err != nil {
+		t.Fatalf("register backdoor err:%s", err)
+	}
+	data := sb.registerInfos()["/roc/dist2/base/test/3/backdoor"]
+	if !strings.Contains(data, addr) {
+		t.Errorf("registered:%s want addr:%s", data, addr)
+	}
+}
+
+func TestResolveBackdoorAddr(t *testing.T) {
+	cases := []struct {
+		flagAddr, confAddr, want string
+	}{
+		{"", "", defaultBackdoorAddr},
+		{"", "127.0.0.1:60100", "127.0.0.1:60100"},
+		{"127.0.0.1:60200", "0.0.0.0:60100", "127.0.0.1:60200"},
+	}
+	for _, c := range cases {
+		addr, err := resolveBackdoorAddr(c.flagAddr, c.confAddr)
+		if err != nil || addr != c.want {
+			t.Errorf("flag:%s conf:%s addr:%s err:%v want:%s", c.flagAddr, c.confAddr, addr, err, c.want)
+		}
+	}
+
+	if _, err := resolveBackdoorAddr("60000", ""); err == nil {
+		t.Errorf("addr without host should fail")
+	}
+
+	m := NewService()
+	args, err := m.parseArgs(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-serv", "base/test", "-skey", "k", "-backdoor-addr", "127.0.0.1:60300"}, func(string) string { return "" })
+	if err != nil || args.backdoorAddr != "127.0.0.1:60300" {
+		t.Errorf("backdoor addr flag args:%+v err:%v", args, err)
+	}
+}
+
+func TestBackdoorInfo(t *testing.T) {
+	old := service
+	service = NewService()
+	defer func() { service = old }()
+
+	service.infos = map[string]*ServInfo{"api": {Type: PROCESSOR_HTTP, Addr: "10.0.0.1:8080"}}
+	BuildCommit = "abc123"
+	defer func() { BuildCommit = "" }()
+
+	_, driver := (&backDoorHttp{}).Driver()
+	w := httptest.NewRecorder()
+	driver.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", "/backdoor/info", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("info code:%d", w.Code)
+	}
+
+	var res struct {
+		Commit     string
+		GoVersion  string `json:"go_version"`
+		Pid        int
+		Processors map[string]struct {
+			Type string
+			Addr string
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal err:%s body:%s", err, w.Body.String())
+	}
+	if res.Commit != "abc123" || len(res.GoVersion) == 0 || res.Pid == 0 {
+		t.Errorf("info:%s", w.Body.String())
+	}
+	if p := res.Processors["api"]; p.Addr != "10.0.0.1:8080" || p.Type != PROCESSOR_HTTP {
+		t.Errorf("processors:%+v", res.Processors)
+	}
+}
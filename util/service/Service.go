@@ -5,20 +5,28 @@
 package rocserv
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	stat "gitlab.pri.ibanyu.com/middleware/seaweed/xstat/sys"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 	"github.com/gin-gonic/gin"
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/shawnfeng/sutil/slog"
 	"github.com/shawnfeng/sutil/slog/statlog"
 	"github.com/shawnfeng/sutil/trace"
@@ -31,9 +39,22 @@ const (
 	PROCESSOR_THRIFT = "thrift"
 	PROCESSOR_GRPC   = "gprc"
 	PROCESSOR_GIN    = "gin"
+	PROCESSOR_WS     = "ws"
+
+	PROCESSOR_GRPC_GATEWAY = "grpc_gateway"
 
 	MODEL_SERVER      = 0
 	MODEL_MASTERSLAVE = 1
+
+	defaultBindParallel = 8
+
+	defaultInitRetryInterval    = time.Second
+	maxInitRetryInterval        = time.Second * 30
+	defaultInitProgressInterval = time.Second * 10
+
+	defaultProcessorShutdownTimeout = time.Second * 10
+	// 收到SIGTERM/SIGINT后下线的最长时间
+	defaultShutdownTimeout = time.Second * 30
 )
 
 var service = NewService()
@@ -43,11 +64,81 @@ type Service struct {
 
 	mutex   sync.Mutex
 	servers map[string]interface{}
+
+	// 并发启动processor的数量
+	bindParallel int
+	// 注册地址不可路由时启动失败
+	strictAdvertise bool
+	// 注册到服务发现的ip，为空时使用监听地址
+	advertiseIP string
+
+	middlewares *middlewareRegistry
+	// 各processor注册的路由
+	routes *routeRegistry
+
+	// 已启动的processor，下线时调用其Shutdown
+	procs map[string]Processor
+	// 已注册到服务发现的processor地址
+	infos map[string]*ServInfo
+	// 保证注册数据按顺序更新
+	regMutex sync.Mutex
+
+	// Init开始时间，服务ready后关闭readyC
+	initStart   time.Time
+	readyOnce   sync.Once
+	readyC      chan struct{}
+	timeToReady time.Duration
+
+	// metrics processor启动前执行，用于注册自定义collector
+	beforeMetricsInit []func(prometheus.Registerer)
+
+	// 配置了statsd时推送metrics
+	statsd *statsdExporter
+
+	// 各processor启动的server，下线时停止
+	handles map[string]powerHandle
+
+	// Shutdown完成后关闭stopC，Init返回
+	stopOnce sync.Once
+	stopC    chan struct{}
+	stopErr  error
+
+	// 业务注册的健康检查
+	healthChecks *healthCheckers
+
+	// processor名称到监听地址，优先于Driver返回的地址
+	addrOverrides map[string]string
+
+	// 注册到服务发现的实例权重
+	weight int
+
+	// 开启TLS的processor
+	tlsConfigs map[string]*tls.Config
+
+	// Use添加的中间件
+	uses *useRegistry
+
+	// Serve/MasterSlave/Init只能调用一次，调用后为1
+	serving int32
+
+	// 传给initfn的ctx，开始下线时取消
+	startCtx    context.Context
+	startCancel context.CancelFunc
 }
 
 func NewService() *Service {
 	return &Service{
-		servers: make(map[string]interface{}),
+		servers:      make(map[string]interface{}),
+		bindParallel: defaultBindParallel,
+		middlewares:  newMiddlewareRegistry(),
+		routes:       newRouteRegistry(),
+		readyC:       make(chan struct{}),
+		handles:      make(map[string]powerHandle),
+		stopC:        make(chan struct{}),
+		healthChecks: newHealthCheckers(),
+		uses:         newUseRegistry(),
+		startCtx:     context.Background(),
+		startCancel:  func() {},
 	}
 }
 
@@ -61,20 +152,73 @@ type cmdArgs struct {
 	group         string
 	disable       bool
 	model         int
+	// 为true时忽略值为nil的processor，只打日志，不再报错
+	skipNilProcessor bool
+	// 为true时只打印将要写入etcd的注册信息后退出
+	printRegistration bool
+	// 后门监听地址，为空时使用配置或默认地址
+	backdoorAddr string
+	// processor监听地址，name=addr,name2=addr2
+	processorAddrs string
+	// 注册到服务发现的实例权重
+	weight int
 }
 
 func (m *Service) parseFlag() (*cmdArgs, error) {
-	var serv, logDir, skey, group string
-	var logMaxSize, logMaxBackups, sidOffset int
-	flag.IntVar(&logMaxSize, "logmaxsize", 0, "logMaxSize is the maximum size in megabytes of the log file")
-	flag.IntVar(&logMaxBackups, "logmaxbackups", 0, "logmaxbackups is the maximum number of old log files to retain")
-	flag.StringVar(&serv, "serv", "", "servic name")
-	flag.StringVar(&logDir, "logdir", "", "serice log dir")
-	flag.StringVar(&skey, "skey", "", "service session key")
-	flag.IntVar(&sidOffset, "sidoffset", 0, "service id offset for different data center")
-	flag.StringVar(&group, "group", "", "service group")
-
-	flag.Parse()
+	return m.parseArgs(flag.CommandLine, os.Args[1:], os.Getenv)
+}
+
+// parseArgs 命令行参数优先，未指定的参数使用对应的ROC_*环境变量，见flag_env.go
+func (m *Service) parseArgs(fs *flag.FlagSet, arguments []string, getenv func(string) string) (*cmdArgs, error) {
+	var serv, logDir, skey, group, backdoorAddr, processorAddrs string
+	var logMaxSize, logMaxBackups, sidOffset, weight int
+	var skipNilProcessor, printRegistration bool
+
+	// 环境变量作为参数默认值
+	defLogMaxSize, err := envInt(getenv, envLogMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	defLogMaxBackups, err := envInt(getenv, envLogMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	defSidOffset, err := envInt(getenv, envSidOffset)
+	if err != nil {
+		return nil, err
+	}
+	defSkipNilProcessor, err := envBool(getenv, envSkipNilProcessor)
+	if err != nil {
+		return nil, err
+	}
+	defPrintRegistration, err := envBool(getenv, envPrintRegistration)
+	if err != nil {
+		return nil, err
+	}
+	defWeight, err := envInt(getenv, envWeight)
+	if err != nil {
+		return nil, err
+	}
+	if defWeight == 0 {
+		defWeight = DEFAULT_SERV_WEIGHT
+	}
+
+	fs.IntVar(&logMaxSize, "logmaxsize", defLogMaxSize, "logMaxSize is the maximum size in megabytes of the log file, env "+envLogMaxSize)
+	fs.IntVar(&logMaxBackups, "logmaxbackups", defLogMaxBackups, "logmaxbackups is the maximum number of old log files to retain, env "+envLogMaxBackups)
+	fs.StringVar(&serv, "serv", getenv(envServ), "servic name, env "+envServ)
+	fs.StringVar(&logDir, "logdir", getenv(envLogDir), "serice log dir, env "+envLogDir)
+	fs.StringVar(&skey, "skey", getenv(envSkey), "service session key, env "+envSkey)
+	fs.IntVar(&sidOffset, "sidoffset", defSidOffset, "service id offset for different data center, env "+envSidOffset)
+	fs.StringVar(&group, "group", getenv(envGroup), "service group, env "+envGroup)
+	fs.BoolVar(&skipNilProcessor, "skipnilprocessor", defSkipNilProcessor, "skip nil processor instead of failing, env "+envSkipNilProcessor)
+	fs.BoolVar(&printRegistration, "printregistration", defPrintRegistration, "print registration info and exit without writing etcd, env "+envPrintRegistration)
+	fs.StringVar(&backdoorAddr, "backdoor-addr", getenv(envBackdoorAddr), "backdoor listen addr, e.g. 127.0.0.1:60000, env "+envBackdoorAddr)
+	fs.StringVar(&processorAddrs, "processor-addr", getenv(envProcessorAddr), "processor listen addrs, e.g. api=:8080,rpc=:9090, env "+envProcessorAddr)
+	fs.IntVar(&weight, "weight", defWeight, "instance weight registered for load balancing, env "+envWeight)
+
+	if err := fs.Parse(arguments); err != nil {
+		return nil, err
+	}
 
 	if len(serv) == 0 {
 		return nil, fmt.Errorf("serv args need!")
@@ -84,6 +228,10 @@ func (m *Service) parseFlag() (*cmdArgs, error) {
 		return nil, fmt.Errorf("skey args need!")
 	}
 
+	if weight <= 0 {
+		return nil, fmt.Errorf("weight:%d must be positive", weight)
+	}
+
 	return &cmdArgs{
 		logMaxSize:    logMaxSize,
 		logMaxBackups: logMaxBackups,
@@ -92,288 +240,1427 @@ func (m *Service) parseFlag() (*cmdArgs, error) {
 		sessKey:       skey,
 		sidOffset:     sidOffset,
 		group:         group,
+
+		skipNilProcessor:  skipNilProcessor,
+		printRegistration: printRegistration,
+		backdoorAddr:      backdoorAddr,
+		processorAddrs:    processorAddrs,
+		weight:            weight,
 	}, nil
 
 }
 
-func (m *Service) loadDriver(sb ServBase, procs map[string]Processor) (map[string]*ServInfo, error) {
+// loadDriver 启动processor，addrs中有指定地址的processor使用指定地址代替Driver返回的地址
+func (m *Service) loadDriver(sb ServBase, procs map[string]Processor, addrs map[string]string) (map[string]*ServInfo, error) {
 	fun := "Service.loadDriver -->"
 
+	// grpc-gateway需要转发到的grpc processor的监听地址，在其他processor之后启动
+	var names, gateways []string
+	for n, p := range procs {
+		if isGrpcGateway(p) {
+			gateways = append(gateways, n)
+		} else {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(gateways)
+
+	// 启动时由addrOverrideProcessor.Driver记录Driver返回的地址及替换后的地址
+	procs = withAddrOverrides(procs, addrs)
+
+	loaded := make([]*ServInfo, len(names)+len(gateways))
+	errs := make([]error, len(names)+len(gateways))
+
+	m.powerParallel(names, procs, loaded, errs)
+	m.powerParallel(gateways, procs, loaded[len(names):], errs[len(names):])
+	names = append(names, gateways...)
+
+	// 按processor名称顺序汇总错误，保证输出稳定
+	var errMsgs []string
+	var started []string
 	infos := make(map[string]*ServInfo)
+	for idx, n := range names {
+		if errs[idx] != nil {
+			slog.Errorf("%s processor:%s load err:%s", fun, n, errs[idx])
+			errMsgs = append(errMsgs, errs[idx].Error())
+			continue
+		}
 
-	for n, p := range procs {
-		addr, driver := p.Driver()
-		if driver == nil {
-			slog.Infof("%s processor:%s no driver", fun, n)
+		started = append(started, n)
+		if loaded[idx] != nil {
+			infos[n] = loaded[idx]
+		}
+	}
+
+	if len(errMsgs) > 0 {
+		// 部分processor启动失败时停止已经启动的，释放监听的端口
+		m.unloadProcessors(started)
+		return nil, fmt.Errorf("%s", strings.Join(errMsgs, "; "))
+	}
+
+	return infos, nil
+}
+
+// unloadProcessors 停止loadDriver中已经启动的processor
+func (m *Service) unloadProcessors(names []string) {
+	fun := "Service.unloadProcessors -->"
+
+	handles := make(map[string]powerHandle, len(names))
+	m.mutex.Lock()
+	for _, n := range names {
+		if h, ok := m.handles[n]; ok {
+			handles[n] = h
+			delete(m.handles, n)
+			delete(m.servers, n)
+		}
+	}
+	m.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultProcessorShutdownTimeout)
+	defer cancel()
+
+	for n, h := range handles {
+		m.middlewares.remove(n)
+		m.routes.remove(n)
+		if err := h.Stop(ctx); err != nil {
+			slog.Errorf("%s stop processor:%s err:%s", fun, n, err)
 			continue
 		}
+		slog.Infof("%s processor:%s stopped", fun, n)
+	}
+}
 
-		slog.Infof("%s processor:%s type:%s addr:%s", fun, n, reflect.TypeOf(driver), addr)
+// powerParallel 按bindParallel并发启动processor，结果按names的顺序写入loaded、errs
+func (m *Service) powerParallel(names []string, procs map[string]Processor, loaded []*ServInfo, errs []error) {
+	parallel := m.getBindParallel()
+	if parallel > len(names) {
+		parallel = len(names)
+	}
 
-		switch d := driver.(type) {
-		case *httprouter.Router:
-			sa, err := powerHttp(addr, d)
-			if err != nil {
-				return nil, err
+	var wg sync.WaitGroup
+	idxs := make(chan int)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxs {
+				loaded[idx], errs[idx] = m.powerProcessor(names[idx], procs[names[idx]], false)
 			}
+		}()
+	}
+	for idx := range names {
+		idxs <- idx
+	}
+	close(idxs)
+	wg.Wait()
+}
 
-			slog.Infof("%s load ok processor:%s serv addr:%s", fun, n, sa)
-			infos[n] = &ServInfo{
-				Type: PROCESSOR_HTTP,
-				Addr: sa,
-			}
+// powerProcessor 启动单个processor，没有driver时返回nil
+func (m *Service) powerProcessor(n string, p Processor, replace bool) (*ServInfo, error) {
+	fun := "Service.powerProcessor -->"
 
-		case thrift.TProcessor:
-			sa, err := powerThrift(addr, d)
-			if err != nil {
-				return nil, err
-			}
+	addr, driver := p.Driver()
+	if driver == nil {
+		slog.Infof("%s processor:%s no driver", fun, n)
+		return nil, nil
+	}
 
-			slog.Infof("%s load ok processor:%s serv addr:%s", fun, n, sa)
-			infos[n] = &ServInfo{
-				Type: PROCESSOR_THRIFT,
-				Addr: sa,
-			}
-		case *GrpcServer:
-			sa, err := powerGrpc(addr, d)
-			if err != nil {
-				return nil, err
-			}
+	slog.Infof("%s processor:%s type:%s addr:%s", fun, n, reflect.TypeOf(driver), addr)
+	if err := validateListenAddr(addr); err != nil {
+		return nil, fmt.Errorf("processor:%s invalid addr:%q, need host:port, err:%s", n, addr, err)
+	}
+
+	routes, driver, hasRoutes := driverRoutes(driver)
+	tlsConfig := m.getTlsConfig(n)
+
+	var info *ServInfo
+	// server用于reloadRouter等需要原始server的场景，handle用于下线
+	var server interface{}
+	var handle powerHandle
+	// 实际组装的中间件，按执行顺序
+	var middlewares []MiddlewareInfo
+	switch d := driver.(type) {
+	case *httprouter.Router:
+		chain := m.businessHTTPChain(frameworkHTTPChain(), n, d)
+		middlewares = chain.infos
+		sa, h, err := powerHttp(n, addr, chain.then(d), tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("processor:%s power http err:%s", n, err)
+		}
+
+		handle = h
+
+		info = &ServInfo{
+			Type:   PROCESSOR_HTTP,
+			Addr:   sa,
+			Scheme: schemeOf(tlsConfig),
+		}
+
+	case thrift.TProcessor:
+		// thriftTracingProcessor由powerThrift添加在最外层
+		middlewares = append(frameworkMiddlewares(middlewareTracing, middlewareRed, middlewareSlo, middlewarePause, middlewareRateLimit), m.uses.infos(d)...)
+		sa, h, err := powerThrift(addr, &redThriftProcessor{&sloThriftProcessor{&pauseThriftProcessor{&rateLimitThriftProcessor{m.uses.wrapThrift(d), n}}, n}, n})
+		if err != nil {
+			return nil, fmt.Errorf("processor:%s power thrift err:%s", n, err)
+		}
+
+		handle = h
+
+		info = &ServInfo{
+			Type: PROCESSOR_THRIFT,
+			Addr: sa,
+		}
+	case *GrpcServer:
+		d.processor = n
+		d.uses = m.uses.grpcChain()
+		middlewares = d.middlewares(m.uses.infos(d))
+		sa, h, err := powerGrpc(addr, d, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("processor:%s power grpc err:%s", n, err)
+		}
+		d.addr = sa
+
+		server, handle = d, h
+
+		info = &ServInfo{
+			Type:   PROCESSOR_GRPC,
+			Addr:   sa,
+			Scheme: schemeOf(tlsConfig),
+		}
+	case *gin.Engine:
+		chain := m.businessHTTPChain(frameworkHTTPChain(), n, d)
+		middlewares = append(chain.infos, ginHandlerInfos(d)...)
+		sa, h, err := powerGin(n, addr, chain.then(d), tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("processor:%s power gin err:%s", n, err)
+		}
+
+		server, handle = h.server, h
+
+		info = &ServInfo{
+			Type:   PROCESSOR_GIN,
+			Addr:   sa,
+			Scheme: schemeOf(tlsConfig),
+		}
+	case *GrpcGateway:
+		endpoint, err := m.grpcEndpoint(d.GrpcProcessor)
+		if err != nil {
+			return nil, fmt.Errorf("processor:%s %s", n, err)
+		}
+		chain := m.businessHTTPChain(frameworkHTTPChain(), n, d)
+		middlewares = chain.infos
+		sa, h, err := powerGrpcGateway(n, addr, d, endpoint, chain, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("processor:%s power grpc gateway err:%s", n, err)
+		}
+
+		handle = h
+
+		info = &ServInfo{
+			Type:   PROCESSOR_GRPC_GATEWAY,
+			Addr:   sa,
+			Scheme: schemeOf(tlsConfig),
+		}
+	case *WsRouter:
+		chain := m.businessHTTPChain(frameworkWsChain(), n, d)
+		middlewares = chain.infos
+		sa, h, err := powerWs(n, addr, d, chain.then(d), tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("processor:%s power ws err:%s", n, err)
+		}
+
+		handle = h
+
+		info = &ServInfo{
+			Type:   PROCESSOR_WS,
+			Addr:   sa,
+			Scheme: schemeOf(tlsConfig),
+		}
+	default:
+		return nil, fmt.Errorf("processor:%s driver not recognition", n)
+
+	}
+
+	info.Addr = advertiseAddr(info.Addr, m.getAdvertiseIP(), interfaceIPs)
+	if err := checkAdvertiseAddr(info.Addr); err != nil {
+		if m.isStrictAdvertise() {
+			handle.Stop(context.Background())
+			return nil, fmt.Errorf("processor:%s %s", n, err)
+		}
+		slog.Warnf("%s processor:%s %s", fun, n, err)
+	}
+
+	old, err := m.addServer(n, server, handle, replace)
+	if err != nil {
+		handle.Stop(context.Background())
+		return nil, err
+	}
+	if old != nil {
+		slog.Infof("%s processor:%s replaced, stop old server", fun, n)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultProcessorShutdownTimeout)
+			defer cancel()
+			old.Stop(ctx)
+		}()
+	}
+
+	if hasRoutes {
+		m.routes.set(n, routes)
+	}
+	m.middlewares.set(n, middlewares)
+
+	slog.Infof("%s load ok processor:%s serv addr:%s", fun, n, info.Addr)
+	return info, nil
+}
+
+// isBusinessProcessor 框架内部的processor以'_'开头
+func isBusinessProcessor(n string) bool {
+	return len(n) > 0 && n[0] != '_'
+}
+
+func (m *Service) getBindParallel() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.bindParallel <= 0 {
+		return 1
+	}
+	return m.bindParallel
+}
+
+func (m *Service) initGrpcConfig(sb *ServBaseV2) error {
+	fun := "Service.initGrpcConfig -->"
+
+	var grpcConfig GrpcConfig
+	err := sb.ServConfig(&grpcConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	setGrpcMaxConcurrentStreams(grpcConfig.Grpc.MaxConcurrentStreams)
+	slog.Infof("%s max concurrent streams:%d", fun, getGrpcMaxConcurrentStreams())
+	return nil
+}
+
+func (m *Service) initBalancerConfig(sb *ServBaseV2) error {
+	fun := "Service.initBalancerConfig -->"
+
+	var balancerConfig BalancerConfig
+	err := sb.ServConfig(&balancerConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	setLocalZone(balancerConfig.Balancer.Zone)
+	slog.Infof("%s local zone:%s", fun, getLocalZone())
+
+	setCanaryPercent(balancerConfig.Balancer.CanaryPercent)
+	slog.Infof("%s canary percent:%d", fun, getCanaryPercent())
+
+	outlier := balancerConfig.Balancer.Outlier
+	setOutlierConfig(outlierConfig{
+		errorRate:       outlier.ErrorRate,
+		minRequests:     outlier.MinRequests,
+		maxLatency:      time.Duration(outlier.MaxLatency) * time.Millisecond,
+		interval:        time.Duration(outlier.Interval) * time.Millisecond,
+		cooldown:        time.Duration(outlier.Cooldown) * time.Millisecond,
+		maxEjectPercent: outlier.MaxEjectPercent,
+	})
+	slog.Infof("%s outlier config:%+v", fun, getOutlierConfig())
+
+	breaker := balancerConfig.Balancer.Breaker
+	setBreakerConfig(breakerConfig{
+		failureRate:    breaker.FailureRate,
+		minRequests:    breaker.MinRequests,
+		window:         time.Duration(breaker.Window) * time.Millisecond,
+		cooldown:       time.Duration(breaker.Cooldown) * time.Millisecond,
+		halfOpenProbes: breaker.HalfOpenProbes,
+	})
+	slog.Infof("%s breaker config:%+v", fun, getBreakerConfig())
+	return nil
+}
+
+func (m *Service) initRegistryConfig(sb *ServBaseV2) error {
+	fun := "Service.initRegistryConfig -->"
+
+	var registryConfig RegistryConfig
+	err := sb.ServConfig(&registryConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	setReloadDebounce(time.Duration(registryConfig.Registry.ReloadDebounce) * time.Millisecond)
+	if registryConfig.Registry.TTL > 0 {
+		sb.setRegisterTTL(time.Duration(registryConfig.Registry.TTL) * time.Second)
+	}
+	if registryConfig.Registry.DeregisterDelay != 0 {
+		sb.setDeregisterDelay(time.Duration(registryConfig.Registry.DeregisterDelay) * time.Millisecond)
+	}
+	ttl, _ := sb.registerTTL()
+	slog.Infof("%s reload debounce:%s register ttl:%s deregister delay:%s", fun, getReloadDebounce(), ttl, sb.deregisterDelay())
+	return nil
+}
+
+func (m *Service) initSloConfig(sb *ServBaseV2) error {
+	fun := "Service.initSloConfig -->"
+
+	var sloConfig SloConfig
+	err := sb.ServConfig(&sloConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	objectives := make(map[string]sloObjective, len(sloConfig.Slo))
+	for processor, c := range sloConfig.Slo {
+		objectives[processor] = sloObjective{
+			latency:   time.Duration(c.LatencyP99) * time.Millisecond,
+			errorRate: c.ErrorRate,
+			window:    time.Duration(c.Window) * time.Millisecond,
+		}
+		slog.Infof("%s processor:%s slo:%+v", fun, processor, objectives[processor])
+	}
+	setSloObjectives(objectives)
+	return nil
+}
+
+// initRedConfig 在processor启动前注册RED metrics，读取配置失败时使用默认耗时分布
+func (m *Service) initRedConfig(sb *ServBaseV2) error {
+	fun := "Service.initRedConfig -->"
+
+	var metricConfig MetricConfig
+	err := sb.ServConfig(&metricConfig)
+	if err != nil {
+		slog.Warnf("%s serv config err:%s", fun, err)
+	}
+
+	err = setRedMetrics(prometheus.DefaultRegisterer, metricConfig.Metric.Buckets)
+	if err != nil {
+		slog.Errorf("%s register red metrics err:%s", fun, err)
+		return err
+	}
+	slog.Infof("%s red metrics buckets:%v", fun, metricConfig.Metric.Buckets)
+	return nil
+}
+
+// initAccessLogConfig 格式配置错误时使用text
+func (m *Service) initAccessLogConfig(sb *ServBaseV2) error {
+	fun := "Service.initAccessLogConfig -->"
+
+	var accessLogConfig AccessLogConfig
+	err := sb.ServConfig(&accessLogConfig)
+	if err != nil {
+		slog.Warnf("%s serv config err:%s", fun, err)
+	}
+
+	enable, format := accessLogConfig.AccessLog.Enable, accessLogConfig.AccessLog.Format
+	if err := setAccessLog(enable, format); err != nil {
+		slog.Errorf("%s err:%s, use %s", fun, err, ACCESS_LOG_FORMAT_TEXT)
+		format = ACCESS_LOG_FORMAT_TEXT
+		setAccessLog(enable, format)
+	}
+	slog.Infof("%s access log enable:%t format:%s", fun, enable, format)
+	return nil
+}
+
+// initRateLimitConfig 读取限流规则，配置变更时重新加载
+func (m *Service) initRateLimitConfig(sb *ServBaseV2) error {
+	fun := "Service.initRateLimitConfig -->"
+
+	load := func() error {
+		var rateLimitConfig RateLimitConfig
+		if err := sb.ServConfig(&rateLimitConfig); err != nil {
+			slog.Errorf("%s serv config err:%s", fun, err)
+			return err
+		}
+
+		rateLimits.set(rateLimitConfig.RateLimit)
+		slog.Infof("%s rate limit:%v", fun, rateLimitConfig.RateLimit)
+		return nil
+	}
+
+	sb.WatchConfig(func(newRaw []byte) {
+		load()
+	})
+	return load()
+}
+
+func (m *Service) isStrictAdvertise() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.strictAdvertise
+}
+
+func (m *Service) getAdvertiseIP() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.advertiseIP
+}
+
+func (m *Service) initNetConfig(sb *ServBaseV2) error {
+	fun := "Service.initNetConfig -->"
+
+	var netConfig NetConfig
+	err := sb.ServConfig(&netConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if netConfig.Net.BindParallel > 0 {
+		m.bindParallel = netConfig.Net.BindParallel
+	}
+	m.strictAdvertise = netConfig.Net.StrictAdvertise
+	m.advertiseIP = ""
+	if ip := strings.TrimSpace(netConfig.Net.AdvertiseIP); len(ip) > 0 {
+		if net.ParseIP(ip) == nil {
+			slog.Errorf("%s advertise ip:%s invalid, ignore", fun, ip)
+		} else {
+			m.advertiseIP = ip
+		}
+	}
+	setBindRetries(netConfig.Net.BindRetries)
+	connLimits.set(netConfig.Net.MaxConnsPerIP, parseTrustedProxies(netConfig.Net.TrustedProxies))
+	SetInboundDeadlineFloor(time.Duration(netConfig.Net.DeadlineFloor) * time.Millisecond)
+
+	slog.Infof("%s bind parallel:%d strict advertise:%t advertise ip:%s bind retries:%d", fun, m.bindParallel, m.strictAdvertise, m.advertiseIP, netConfig.Net.BindRetries)
+	return nil
+}
+
+// addServer 记录processor启动的server，processor已存在且replace为false时返回错误，避免误覆盖；
+// replace为true时返回被替换的server，由调用方停止
+func (m *Service) addServer(processor string, server interface{}, handle powerHandle, replace bool) (powerHandle, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	old, ok := m.handles[processor]
+	if ok && !replace {
+		return nil, fmt.Errorf("processor:%s already exists", processor)
+	}
+
+	if server != nil {
+		m.servers[processor] = server
+	} else {
+		delete(m.servers, processor)
+	}
+	m.handles[processor] = handle
+	return old, nil
+}
+
+// Use 添加作用于所有业务processor的中间件，按添加顺序执行，各processor类型可用的hook见Middleware；
+// 在processor启动时生效，需要在启动前(如initfn中)调用，之后启动的processor(AddProcessor、ReloadRouter)也会使用
+func (m *Service) Use(ms ...Middleware) {
+	m.uses.add(ms...)
+}
+
+// businessHTTPChain 业务processor上的暂停流量、限流及Use添加的中间件，框架内部的processor(后门、metrics)不使用
+func (m *Service) businessHTTPChain(chain *httpChain, processor string, driver interface{}) *httpChain {
+	if !isBusinessProcessor(processor) {
+		return chain
+	}
+	return chain.
+		add(middlewarePause, pauseMiddleware).
+		add(middlewareRateLimit, m.rateLimitMiddleware).
+		addInfos(m.uses.infos(driver), m.uses.wrapHTTP)
+}
+
+func (m *Service) reloadRouter(processor string, driver interface{}) error {
+	//fun := "Service.reloadRouter -->"
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	server, ok := m.servers[processor]
+	if !ok {
+		return fmt.Errorf("processor:%s driver not recognition", processor)
+	}
+
+	routes, driver, hasRoutes := driverRoutes(driver)
+	router, ok := driver.(*gin.Engine)
+	if !ok {
+		return fmt.Errorf("processor:%s driver not recognition", processor)
+	}
+
+	chain := m.businessHTTPChain(frameworkHTTPChain(), processor, router)
+	if err := reloadRouter(processor, server, chain.then(router)); err != nil {
+		return err
+	}
+	if hasRoutes {
+		m.routes.set(processor, routes)
+	}
+	m.middlewares.set(processor, append(chain.infos, ginHandlerInfos(router)...))
+	return nil
+}
+
+// ErrAlreadyServing 重复调用Serve/MasterSlave/Init时返回
+var ErrAlreadyServing = errors.New("service already serving")
+
+// startServing 标记服务已启动，重复调用时返回ErrAlreadyServing，失败后也不能再次启动，
+// 避免重复解析命令行参数、监听端口及注册服务
+func (m *Service) startServing() error {
+	if !atomic.CompareAndSwapInt32(&m.serving, 0, 1) {
+		return ErrAlreadyServing
+	}
+	return nil
+}
+
+// withoutContext 兼容不接收ctx的initfn
+func withoutContext(initfn func(ServBase) error) func(context.Context, ServBase) error {
+	return func(ctx context.Context, sb ServBase) error {
+		return initfn(sb)
+	}
+}
+
+func (m *Service) Serve(confEtcd configEtcd, initfn func(ServBase) error, procs map[string]Processor) error {
+	return m.ServeWithContext(context.Background(), confEtcd, withoutContext(initfn), procs)
+}
+
+// ServeWithContext 同Serve，initfn的ctx在ctx结束或服务开始下线时取消，ctx结束时服务下线
+func (m *Service) ServeWithContext(ctx context.Context, confEtcd configEtcd, initfn func(context.Context, ServBase) error, procs map[string]Processor) error {
+	fun := "Service.ServeWithContext -->"
+
+	if err := m.startServing(); err != nil {
+		slog.Errorf("%s err:%s", fun, err)
+		return err
+	}
+
+	args, err := m.parseFlag()
+	if err != nil {
+		slog.Panicf("%s parse arg err:%s", fun, err)
+		return err
+	}
+
+	return m.serve(ctx, confEtcd, args, initfn, procs)
+}
+
+func (m *Service) initLog(sb *ServBaseV2, args *cmdArgs) error {
+	fun := "Service.initLog -->"
+
+	logDir := args.logDir
+	var logConfig LogConfig
+	logConfig.Log.Level = "INFO"
+
+	err := sb.ServConfig(&logConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	var logdir string
+	if len(logConfig.Log.Dir) > 0 {
+		logdir = fmt.Sprintf("%s/%s", logConfig.Log.Dir, sb.Copyname())
+	}
+
+	if len(logDir) > 0 {
+		logdir = fmt.Sprintf("%s/%s", logDir, sb.Copyname())
+	}
+
+	if logDir == "console" {
+		logdir = ""
+	}
+
+	maxSize, maxBackups := logRotationConfig(logConfig, args)
+	slog.Infof("%s init log dir:%s name:%s level:%s maxsize:%dMB maxbackups:%d", fun, logdir, args.servLoc, logConfig.Log.Level, maxSize, maxBackups)
+
+	currentLog.setup(logdir, logConfig.Log.Level)
+	initStatLog := func() { statlog.Init(logdir, "stat.log", args.servLoc) }
+	initStatLog()
+
+	if len(logdir) > 0 {
+		r := newLogRotator(logdir, maxSize, maxBackups,
+			rotatedLog{name: "serv.log", reopen: currentLog.reopen},
+			rotatedLog{name: "stat.log", reopen: initStatLog},
+		)
+		watchLogRotation(sb, r, args)
+		go m.rotateLogsLoop(r)
+	}
+	return nil
+}
+
+func (m *Service) Init(confEtcd configEtcd, args *cmdArgs, initfn func(ServBase) error, procs map[string]Processor) error {
+	fun := "Service.Init -->"
+
+	if err := m.startServing(); err != nil {
+		slog.Errorf("%s err:%s", fun, err)
+		return err
+	}
 
-			slog.Infof("%s load ok processor:%s serv addr:%s", fun, n, sa)
-			infos[n] = &ServInfo{
-				Type: PROCESSOR_GRPC,
-				Addr: sa,
+	return m.serve(context.Background(), confEtcd, args, withoutContext(initfn), procs)
+}
+
+func (m *Service) serve(ctx context.Context, confEtcd configEtcd, args *cmdArgs, initfn func(context.Context, ServBase) error, procs map[string]Processor) error {
+	fun := "Service.serve -->"
+
+	startCtx := m.initStartCtx(ctx)
+	defer m.cancelStart()
+	go m.stopOnDone(ctx)
+
+	m.initStart = time.Now()
+	servLoc := args.servLoc
+	sessKey := args.sessKey
+
+	sb, err := NewServBaseV2(confEtcd, servLoc, sessKey, args.group, args.sidOffset)
+	if err != nil {
+		slog.Panicf("%s init servbase loc:%s key:%s err:%s", fun, servLoc, sessKey, err)
+		return err
+	}
+	m.sbase = sb
+	sb.dryRun = args.printRegistration
+	m.weight = args.weight
+
+	// 初始化日志
+	m.initLog(sb, args)
+
+	// 初始化服务进程打点
+	stat.Init(sb.servGroup, sb.servName, "")
+
+	// 读取网络相关配置
+	m.initNetConfig(sb)
+	m.initGrpcConfig(sb)
+	m.initBalancerConfig(sb)
+	m.initRegistryConfig(sb)
+	m.initSloConfig(sb)
+	m.initRedConfig(sb)
+	m.initAccessLogConfig(sb)
+	m.initRateLimitConfig(sb)
+	if err := m.initTlsConfig(sb); err != nil {
+		slog.Panicf("%s init tls err:%s", fun, err)
+		return err
+	}
+	m.initProcessorAddrs(sb, args.processorAddrs)
+
+	defer syncLogs()
+
+	err = runStartup(m.startupOrder(sb), map[string]func() error{
+		startupBackdoor: func() error {
+			// NOTE: initBackdoork会启动http服务，但由于health check的http请求不需要追踪，且它是判断服务启动与否的关键，所以initTracer可以放在它之后进行
+			m.initBackdoork(sb, args.backdoorAddr)
+			return nil
+		},
+		startupProcessors: func() error {
+			return m.startProcessors(startCtx, sb, args, initfn, procs)
+		},
+		startupMetrics: func() error {
+			m.initMetric(sb)
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if args.printRegistration {
+		printRegistration(sb)
+		return nil
+	}
+
+	sb.startDependencyDrain()
+	m.markReady(sb)
+
+	go m.handleSignals()
+	m.waitForStop()
+
+	return nil
+}
+
+// startProcessors 应用初始化并启动业务processor
+func (m *Service) startProcessors(ctx context.Context, sb *ServBaseV2, args *cmdArgs, initfn func(context.Context, ServBase) error, procs map[string]Processor) error {
+	fun := "Service.startProcessors -->"
+
+	err := m.handleModel(sb, args.servLoc, args.model)
+	if err != nil {
+		slog.Panicf("%s handleModel err:%s", fun, err)
+		return err
+	}
+
+	// App层初始化
+	err = m.initApp(ctx, sb, initfn)
+	if err != nil {
+		slog.Panicf("%s callInitFunc err:%s", fun, err)
+		return err
+	}
+
+	// NOTE: processor 在初始化 trace middleware 前需要保证 opentracing.GlobalTracer() 初始化完毕
+	m.initTracer(args.servLoc)
+
+	procs, err = buildConfiguredProcessors(sb, procs)
+	if err != nil {
+		slog.Panicf("%s build configured processors err:%s", fun, err)
+		return err
+	}
+
+	err = m.initProcessor(sb, procs, args.skipNilProcessor)
+	if err != nil {
+		slog.Panicf("%s initProcessor err:%s", fun, err)
+		return err
+	}
+
+	sb.SetGroupAndDisable(args.group, args.disable)
+	return nil
+}
+
+// Ready 服务注册完成可以接收请求后关闭
+func (m *Service) Ready() <-chan struct{} {
+	return m.readyC
+}
+
+// TimeToReady 从Init开始到服务ready的耗时，ready前返回0
+func (m *Service) TimeToReady() time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.timeToReady
+}
+
+func (m *Service) markReady(sb *ServBaseV2) {
+	m.readyOnce.Do(func() {
+		d := time.Since(m.initStart)
+
+		m.mutex.Lock()
+		m.timeToReady = d
+		m.mutex.Unlock()
+
+		if sb != nil {
+			_metricTimeToReady.With(xprom.LabelGroupName, sb.servGroup, xprom.LabelServiceName, sb.servName).Set(d.Seconds())
+			slog.Infof("======== service:%s servid:%d group:%s ready, time to ready:%s ========", sb.servLocation, sb.servId, sb.Group(), d)
+		}
+
+		close(m.readyC)
+	})
+}
+
+// printRegistration 按路径顺序打印将要写入etcd的注册信息
+func printRegistration(sb *ServBaseV2) {
+	fun := "printRegistration -->"
+
+	infos := sb.registerInfos()
+	paths := make([]string, 0, len(infos))
+	for path := range infos {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		slog.Infof("%s path:%s data:%s", fun, path, infos[path])
+		fmt.Printf("%s %s\n", path, infos[path])
+	}
+}
+
+func (m *Service) initApp(ctx context.Context, sb *ServBaseV2, initfn func(context.Context, ServBase) error) error {
+	fun := "Service.initApp -->"
+
+	var initConfig InitConfig
+	err := sb.ServConfig(&initConfig)
+	if err != nil {
+		slog.Warnf("%s serv config err:%s", fun, err)
+	}
+
+	progress := time.Duration(initConfig.Init.ProgressInterval) * time.Millisecond
+	softLimit := time.Duration(initConfig.Init.SoftLimit) * time.Millisecond
+	stop := watchInit(progress, softLimit, slog.Infof, slog.Warnf)
+	defer stop()
+
+	interval := time.Duration(initConfig.Init.RetryInterval) * time.Millisecond
+	return callInitFunc(ctx, sb, initfn, initConfig.Init.Retries, interval)
+}
+
+// watchInit initfn执行期间每interval打印一次进度，超过softLimit时告警，返回的函数用于停止
+func watchInit(interval, softLimit time.Duration, infof, warnf func(format string, args ...interface{})) func() {
+	fun := "watchInit -->"
+
+	if interval <= 0 {
+		interval = defaultInitProgressInterval
+	}
+
+	start := time.Now()
+	stopC := make(chan struct{})
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		warned := false
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-ticker.C:
+				elapsed := time.Since(start)
+				infof("%s still initializing (%ds elapsed)", fun, int(elapsed.Seconds()))
+				if softLimit > 0 && elapsed >= softLimit && !warned {
+					warned = true
+					warnf("%s initfn exceeds soft limit:%s, elapsed:%s", fun, softLimit, elapsed)
+				}
 			}
-		case *gin.Engine:
-			sa, serv, err := powerGin(addr, d)
-			if err != nil {
-				return nil, err
+		}
+	}()
+
+	return func() {
+		close(stopC)
+		<-doneC
+		infof("%s initialized in %s", fun, time.Since(start))
+	}
+}
+
+// callInitFunc 调用initfn，失败时按interval指数退避重试retries次，ctx结束时不再重试
+func callInitFunc(ctx context.Context, sb ServBase, initfn func(context.Context, ServBase) error, retries int, interval time.Duration) error {
+	fun := "callInitFunc -->"
+
+	if interval <= 0 {
+		interval = defaultInitRetryInterval
+	}
+
+	var err error
+	for i := 0; ; i++ {
+		err = initfn(ctx, sb)
+		if err == nil {
+			return nil
+		}
+
+		if i >= retries || ctx.Err() != nil {
+			break
+		}
+
+		slog.Warnf("%s initfn err:%s retry:%d/%d in %s", fun, err, i+1, retries, interval)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return err
+		}
+
+		interval *= 2
+		if interval > maxInitRetryInterval {
+			interval = maxInitRetryInterval
+		}
+	}
+
+	return err
+}
+
+// handleSignals 收到SIGTERM/SIGINT时下线服务，服务停止后返回
+func (m *Service) handleSignals() {
+	c := make(chan os.Signal, 1)
+	signals := []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGPIPE}
+	signal.Reset(signals...)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case s := <-c:
+			slog.Infof("receive a signal:%s", s.String())
+
+			if s == syscall.SIGTERM || s == syscall.SIGINT {
+				slog.Infof("receive a signal:%s, stop service", s.String())
+				ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+				m.Shutdown(ctx)
+				cancel()
+				return
 			}
 
-			m.addServer(n, serv)
+		case <-m.stopC:
+			return
+		}
+	}
+}
+
+// initStartCtx 创建传给initfn的ctx
+func (m *Service) initStartCtx(ctx context.Context) context.Context {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.startCtx, m.startCancel = context.WithCancel(ctx)
+	return m.startCtx
+}
+
+func (m *Service) cancelStart() {
+	m.mutex.Lock()
+	cancel := m.startCancel
+	m.mutex.Unlock()
+
+	cancel()
+}
+
+// stopOnDone ctx结束时下线服务，服务停止后返回
+func (m *Service) stopOnDone(ctx context.Context) {
+	fun := "Service.stopOnDone -->"
+
+	select {
+	case <-ctx.Done():
+		slog.Infof("%s ctx done:%s, stop service", fun, ctx.Err())
+		sctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+		m.Shutdown(sctx)
+		cancel()
+	case <-m.stopC:
+	}
+}
+
+// waitForStop 阻塞直到Shutdown完成(收到退出信号或调用Shutdown)，Init/Serve随后返回
+func (m *Service) waitForStop() {
+	<-m.stopC
+}
+
+// Shutdown 优雅下线：从服务发现摘除，调用processor的Shutdown，
+// 按业务processor、框架processor(metrics、后门)的顺序停止监听并等待处理中的请求结束，
+// 最后落盘日志；ctx结束时强制关闭，多次调用只执行一次
+func (m *Service) Shutdown(ctx context.Context) error {
+	m.stopOnce.Do(func() {
+		m.stopErr = m.shutdown(ctx)
+		close(m.stopC)
+	})
+
+	select {
+	case <-m.stopC:
+		return m.stopErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *Service) shutdown(ctx context.Context) error {
+	fun := "Service.shutdown -->"
+
+	slog.Infof("%s stop service", fun)
+
+	// 启动中的initfn尽快退出
+	m.cancelStart()
+
+	// 先从服务发现摘除，client不再发送新请求
+	if sb, ok := m.sbase.(interface{ Stop() }); ok {
+		sb.Stop()
+	}
+
+	var errs []string
+	pctx, cancel := context.WithTimeout(ctx, defaultProcessorShutdownTimeout)
+	if err := m.shutdownProcessors(pctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+	cancel()
+
+	m.notifyGrpcShutdown()
+
+	if err := m.stopHandles(ctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	slog.Infof("%s service stopped", fun)
+	syncLog()
+	syncStatLog()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// stopHandles 并发停止业务processor，全部结束后再停止框架processor，保证下线过程中metrics、后门可用，
+// grpc-gateway最先停止，避免转发的请求被已停止的grpc processor拒绝
+func (m *Service) stopHandles(ctx context.Context) error {
+	fun := "Service.stopHandles -->"
+
+	m.mutex.Lock()
+	var gateways, business, internal []string
+	handles := make(map[string]powerHandle, len(m.handles))
+	for n, h := range m.handles {
+		handles[n] = h
+		if _, ok := h.(*grpcGatewayHandle); ok {
+			gateways = append(gateways, n)
+		} else if isBusinessProcessor(n) {
+			business = append(business, n)
+		} else {
+			internal = append(internal, n)
+		}
+	}
+	m.mutex.Unlock()
+
+	var mu sync.Mutex
+	var errs []string
+	for _, names := range [][]string{gateways, business, internal} {
+		var wg sync.WaitGroup
+		for _, n := range names {
+			wg.Add(1)
+			go func(n string) {
+				defer wg.Done()
+
+				slog.Infof("%s stop processor:%s", fun, n)
+				if err := handles[n].Stop(ctx); err != nil {
+					slog.Errorf("%s stop processor:%s err:%s", fun, n, err)
+					mu.Lock()
+					errs = append(errs, fmt.Sprintf("%s: %s", n, err))
+					mu.Unlock()
+				}
+			}(n)
+		}
+		wg.Wait()
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("stop processors: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// notifyGrpcShutdown 通知grpc server上进行中的stream服务即将下线
+func (m *Service) notifyGrpcShutdown() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, s := range m.servers {
+		if gs, ok := s.(*GrpcServer); ok {
+			gs.NotifyShutdown()
+		}
+	}
+}
+
+// shutdownProcessors 按名称顺序调用实现了Shutdowner的processor，需在关闭监听前执行
+func (m *Service) shutdownProcessors(ctx context.Context) error {
+	fun := "Service.shutdownProcessors -->"
+
+	m.mutex.Lock()
+	procs := m.procs
+	m.mutex.Unlock()
+
+	names := make([]string, 0, len(procs))
+	for n := range procs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var errs []string
+	for _, n := range names {
+		s, ok := procs[n].(Shutdowner)
+		if !ok {
+			continue
+		}
+
+		slog.Infof("%s shutdown processor:%s", fun, n)
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Errorf("%s shutdown processor:%s err:%s", fun, n, err)
+			errs = append(errs, fmt.Sprintf("%s: %s", n, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("shutdown processors: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
 
-			slog.Infof("%s load ok processor:%s serv addr:%s", fun, n, sa)
-			infos[n] = &ServInfo{
-				Type: PROCESSOR_GIN,
-				Addr: sa,
-			}
-		default:
-			return nil, fmt.Errorf("processor:%s driver not recognition", n)
+func (m *Service) handleModel(sb *ServBaseV2, servLoc string, model int) error {
+	fun := "Service.handleModel -->"
 
+	if model == MODEL_MASTERSLAVE {
+		lockKey := fmt.Sprintf("%s-master-slave", servLoc)
+		if err := sb.LockGlobal(lockKey); err != nil {
+			slog.Errorf("%s LockGlobal key: %s, err: %s", fun, lockKey, err)
+			return err
 		}
+
+		slog.Infof("%s LockGlobal succ, key: %s", fun, lockKey)
 	}
 
-	return infos, nil
+	return nil
 }
 
-func (m *Service) addServer(processor string, server interface{}) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+func (m *Service) initProcessor(sb *ServBaseV2, procs map[string]Processor, skipNil bool) error {
+	fun := "Service.initProcessor -->"
 
-	m.servers[processor] = server
-}
+	procs, err := checkProcessors(sb, procs, skipNil)
+	if err != nil {
+		slog.Errorf("%s check processor err:%s", fun, err)
+		return err
+	}
 
-func (m *Service) reloadRouter(processor string, driver interface{}) error {
-	//fun := "Service.reloadRouter -->"
+	infos, err := m.loadDriver(sb, procs, m.getAddrOverrides())
+	if err != nil {
+		slog.Errorf("%s load driver err:%s", fun, err)
+		return err
+	}
 
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	server, ok := m.servers[processor]
-	if !ok {
-		return fmt.Errorf("processor:%s driver not recognition", processor)
+	m.procs = procs
+	m.mutex.Unlock()
+
+	attrs, err := sb.publishedAttrs()
+	if err != nil {
+		slog.Warnf("%s published attrs err:%s", fun, err)
 	}
+	setServAttrs(infos, withZoneAttr(attrs, getLocalZone()))
+	setServWeight(infos, m.getWeight())
 
-	return reloadRouter(processor, server, driver)
-}
+	infos = discoverableInfos(procs, infos)
 
-func (m *Service) Serve(confEtcd configEtcd, initfn func(ServBase) error, procs map[string]Processor) error {
-	fun := "Service.Serve -->"
+	m.regMutex.Lock()
+	defer m.regMutex.Unlock()
 
-	args, err := m.parseFlag()
+	m.mutex.Lock()
+	m.infos = infos
+	m.mutex.Unlock()
+
+	err = sb.RegisterService(infos)
 	if err != nil {
-		slog.Panicf("%s parse arg err:%s", fun, err)
+		slog.Errorf("%s regist service err:%s", fun, err)
+		return err
+	}
+
+	// 注册跨机房服务
+	err = sb.RegisterCrossDCService(infos)
+	if err != nil {
+		slog.Errorf("%s register cross dc failed, err: %v", fun, err)
 		return err
 	}
 
-	return m.Init(confEtcd, args, initfn, procs)
+	return nil
 }
 
-func (m *Service) initLog(sb *ServBaseV2, args *cmdArgs) error {
-	fun := "Service.initLog -->"
+// AddProcessor 服务启动后动态添加processor，初始化、启动监听并更新服务发现中的注册信息，
+// 下线时和启动时添加的processor一起停止
+func (m *Service) AddProcessor(name string, p Processor) error {
+	fun := "Service.AddProcessor -->"
 
-	logDir := args.logDir
-	var logConfig struct {
-		Log struct {
-			Level string
-			Dir   string
-		}
+	if err := checkProcessorName(name); err != nil {
+		slog.Errorf("%s %s", fun, err)
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("processor:%s is nil", name)
 	}
-	logConfig.Log.Level = "INFO"
 
-	err := sb.ServConfig(&logConfig)
-	if err != nil {
-		slog.Errorf("%s serv config err:%s", fun, err)
-		return err
+	sb := m.sbase
+	if sb == nil {
+		return fmt.Errorf("service not init")
 	}
 
-	var logdir string
-	if len(logConfig.Log.Dir) > 0 {
-		logdir = fmt.Sprintf("%s/%s", logConfig.Log.Dir, sb.Copyname())
+	m.mutex.Lock()
+	_, exists := m.procs[name]
+	m.mutex.Unlock()
+	if exists {
+		return fmt.Errorf("processor:%s already exists", name)
 	}
 
-	if len(logDir) > 0 {
-		logdir = fmt.Sprintf("%s/%s", logDir, sb.Copyname())
+	if err := initProcessor(sb, p); err != nil {
+		slog.Errorf("%s processor:%s init err:%s", fun, name, err)
+		return fmt.Errorf("processor:%s init err:%s", name, err)
 	}
 
-	if logDir == "console" {
-		logdir = ""
+	info, err := m.powerProcessor(name, p, false)
+	if err != nil {
+		slog.Errorf("%s processor:%s power err:%s", fun, name, err)
+		return err
 	}
 
-	slog.Infof("%s init log dir:%s name:%s level:%s", fun, logdir, args.servLoc, logConfig.Log.Level)
+	m.regMutex.Lock()
+	defer m.regMutex.Unlock()
 
-	slog.Init(logdir, "serv.log", logConfig.Log.Level)
-	statlog.Init(logdir, "stat.log", args.servLoc)
-	return nil
-}
+	// 复制后替换，shutdownProcessors等读取方不持锁遍历
+	m.mutex.Lock()
+	procs := make(map[string]Processor, len(m.procs)+1)
+	for n, proc := range m.procs {
+		procs[n] = proc
+	}
+	procs[name] = p
+	m.procs = procs
+	infos := make(map[string]*ServInfo, len(m.infos)+1)
+	for n, i := range m.infos {
+		infos[n] = i
+	}
+	m.mutex.Unlock()
 
-func (m *Service) Init(confEtcd configEtcd, args *cmdArgs, initfn func(ServBase) error, procs map[string]Processor) error {
-	fun := "Service.Init -->"
+	if info == nil || !isDiscoverable(p) {
+		slog.Infof("%s processor:%s added, not registered", fun, name)
+		return nil
+	}
 
-	servLoc := args.servLoc
-	sessKey := args.sessKey
+	if sbv2, ok := sb.(*ServBaseV2); ok {
+		attrs, err := sbv2.publishedAttrs()
+		if err != nil {
+			slog.Warnf("%s published attrs err:%s", fun, err)
+		}
+		setServAttrs(map[string]*ServInfo{name: info}, withZoneAttr(attrs, getLocalZone()))
+	}
+	setServWeight(map[string]*ServInfo{name: info}, m.getWeight())
+	infos[name] = info
 
-	sb, err := NewServBaseV2(confEtcd, servLoc, sessKey, args.group, args.sidOffset)
-	if err != nil {
-		slog.Panicf("%s init servbase loc:%s key:%s err:%s", fun, servLoc, sessKey, err)
+	if err := sb.RegisterService(infos); err != nil {
+		slog.Errorf("%s processor:%s register service err:%s", fun, name, err)
+		return err
+	}
+	if err := sb.RegisterCrossDCService(infos); err != nil {
+		slog.Errorf("%s processor:%s register cross dc err:%s", fun, name, err)
 		return err
 	}
-	m.sbase = sb
-
-	// 初始化日志
-	m.initLog(sb, args)
 
-	// 初始化服务进程打点
-	stat.Init(sb.servGroup, sb.servName, "")
+	m.mutex.Lock()
+	m.infos = infos
+	m.mutex.Unlock()
 
-	defer slog.Sync()
-	defer statlog.Sync()
+	slog.Infof("%s processor:%s added, addr:%s", fun, name, info.Addr)
+	return nil
+}
 
-	// NOTE: initBackdoork会启动http服务，但由于health check的http请求不需要追踪，且它是判断服务启动与否的关键，所以initTracer可以放在它之后进行
-	m.initBackdoork(sb)
+// RemoveProcessor 运行中下线processor：先从服务发现中摘除，再等待进行中的请求完成并关闭监听，
+// 不影响其他processor，框架processor不能删除
+func (m *Service) RemoveProcessor(name string) error {
+	fun := "Service.RemoveProcessor -->"
 
-	err = m.handleModel(sb, servLoc, args.model)
-	if err != nil {
-		slog.Panicf("%s handleModel err:%s", fun, err)
+	if err := checkProcessorName(name); err != nil {
+		slog.Errorf("%s %s", fun, err)
 		return err
 	}
 
-	// App层初始化
-	err = initfn(sb)
-	if err != nil {
-		slog.Panicf("%s callInitFunc err:%s", fun, err)
-		return err
+	sb := m.sbase
+	if sb == nil {
+		return fmt.Errorf("service not init")
 	}
 
-	// NOTE: processor 在初始化 trace middleware 前需要保证 opentracing.GlobalTracer() 初始化完毕
-	m.initTracer(servLoc)
+	m.regMutex.Lock()
+	defer m.regMutex.Unlock()
 
-	err = m.initProcessor(sb, procs)
-	if err != nil {
-		slog.Panicf("%s initProcessor err:%s", fun, err)
-		return err
+	m.mutex.Lock()
+	p, exists := m.procs[name]
+	if !exists {
+		m.mutex.Unlock()
+		return fmt.Errorf("processor:%s not exists", name)
+	}
+	_, registered := m.infos[name]
+	infos := make(map[string]*ServInfo, len(m.infos))
+	for n, i := range m.infos {
+		if n != name {
+			infos[n] = i
+		}
 	}
+	m.mutex.Unlock()
 
-	sb.SetGroupAndDisable(args.group, args.disable)
-	m.initMetric(sb)
-	m.awaitSignal(sb)
+	if registered {
+		if err := sb.RegisterService(infos); err != nil {
+			slog.Errorf("%s processor:%s deregister service err:%s", fun, name, err)
+			return err
+		}
+		if err := sb.RegisterCrossDCService(infos); err != nil {
+			slog.Errorf("%s processor:%s deregister cross dc err:%s", fun, name, err)
+			return err
+		}
+	}
+
+	m.mutex.Lock()
+	procs := make(map[string]Processor, len(m.procs))
+	for n, proc := range m.procs {
+		if n != name {
+			procs[n] = proc
+		}
+	}
+	m.procs = procs
+	m.infos = infos
+	handle := m.handles[name]
+	delete(m.handles, name)
+	delete(m.servers, name)
+	m.mutex.Unlock()
+	m.middlewares.remove(name)
+	m.routes.remove(name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+
+	var errs []string
+	if s, ok := p.(Shutdowner); ok {
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Errorf("%s shutdown processor:%s err:%s", fun, name, err)
+			errs = append(errs, err.Error())
+		}
+	}
+	if handle != nil {
+		if err := handle.Stop(ctx); err != nil {
+			slog.Errorf("%s stop processor:%s err:%s", fun, name, err)
+			errs = append(errs, err.Error())
+		}
+	}
 
+	if len(errs) > 0 {
+		return fmt.Errorf("remove processor:%s err:%s", name, strings.Join(errs, "; "))
+	}
+	slog.Infof("%s processor:%s removed", fun, name)
 	return nil
 }
 
-func (m *Service) awaitSignal(sb *ServBaseV2) {
-	c := make(chan os.Signal, 1)
-	signals := []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGPIPE}
-	signal.Reset(signals...)
-	signal.Notify(c, signals...)
-
-	for {
-		select {
-		case s := <-c:
-			slog.Infof("receive a signal:%s", s.String())
+// registeredInfos 已注册到服务发现的processor
+func (m *Service) registeredInfos() map[string]*ServInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
-			if s.String() == syscall.SIGTERM.String() {
-				slog.Infof("receive a signal:%s, stop service", s.String())
-				sb.Stop()
-				<-(chan int)(nil)
-			}
-		}
+	infos := make(map[string]*ServInfo, len(m.infos))
+	for n, info := range m.infos {
+		infos[n] = info
 	}
-
+	return infos
 }
 
-func (m *Service) handleModel(sb *ServBaseV2, servLoc string, model int) error {
-	fun := "Service.handleModel -->"
+// discoverableInfos 去掉不需要注册到服务发现的processor
+func discoverableInfos(procs map[string]Processor, infos map[string]*ServInfo) map[string]*ServInfo {
+	fun := "discoverableInfos -->"
 
-	if model == MODEL_MASTERSLAVE {
-		lockKey := fmt.Sprintf("%s-master-slave", servLoc)
-		if err := sb.LockGlobal(lockKey); err != nil {
-			slog.Errorf("%s LockGlobal key: %s, err: %s", fun, lockKey, err)
-			return err
+	regInfos := make(map[string]*ServInfo, len(infos))
+	for n, info := range infos {
+		if p, ok := procs[n]; ok && !isDiscoverable(p) {
+			slog.Infof("%s processor:%s addr:%s not discoverable, skip register", fun, n, info.Addr)
+			continue
 		}
+		regInfos[n] = info
+	}
+	return regInfos
+}
 
-		slog.Infof("%s LockGlobal succ, key: %s", fun, lockKey)
+// checkProcessorName 业务processor名称不能为空，不能以框架保留的'_'开头
+func checkProcessorName(n string) error {
+	if len(n) == 0 {
+		return fmt.Errorf("processor name empty")
 	}
 
+	if n[0] == '_' {
+		return fmt.Errorf("processor name can not prefix '_'")
+	}
 	return nil
 }
 
-func (m *Service) initProcessor(sb *ServBaseV2, procs map[string]Processor) error {
-	fun := "Service.initProcessor -->"
+// checkProcessors 检查processor名称并调用Init(实现了BaseInitializer时调用InitWithBase)，返回可用的processor
+// skipNil为true时，值为nil的processor只打日志并跳过，否则返回错误
+func checkProcessors(sb ServBase, procs map[string]Processor, skipNil bool) (map[string]Processor, error) {
+	fun := "checkProcessors -->"
 
+	valid := make(map[string]Processor, len(procs))
 	for n, p := range procs {
-		if len(n) == 0 {
-			slog.Errorf("%s processor name empty", fun)
-			return fmt.Errorf("processor name empty")
-		}
-
-		if n[0] == '_' {
-			slog.Errorf("%s processor name can not prefix '_'", fun)
-			return fmt.Errorf("processor name can not prefix '_'")
+		if err := checkProcessorName(n); err != nil {
+			slog.Errorf("%s %s", fun, err)
+			return nil, err
 		}
 
 		if p == nil {
-			slog.Errorf("%s processor:%s is nil", fun, n)
-			return fmt.Errorf("processor:%s is nil", n)
-		} else {
-			err := p.Init()
-			if err != nil {
-				slog.Errorf("%s processor:%s init err:%s", fun, err)
-				return fmt.Errorf("processor:%s init err:%s", n, err)
+			if skipNil {
+				slog.Warnf("%s processor:%s is nil, skip", fun, n)
+				continue
 			}
+			slog.Errorf("%s processor:%s is nil", fun, n)
+			return nil, fmt.Errorf("processor:%s is nil", n)
 		}
-	}
-
-	infos, err := m.loadDriver(sb, procs)
-	if err != nil {
-		slog.Errorf("%s load driver err:%s", fun, err)
-		return err
-	}
-
-	err = sb.RegisterService(infos)
-	if err != nil {
-		slog.Errorf("%s regist service err:%s", fun, err)
-		return err
-	}
 
-	// 注册跨机房服务
-	err = sb.RegisterCrossDCService(infos)
-	if err != nil {
-		slog.Errorf("%s register cross dc failed, err: %v", fun, err)
-		return err
+		err := initProcessor(sb, p)
+		if err != nil {
+			slog.Errorf("%s processor:%s init err:%s", fun, n, err)
+			return nil, fmt.Errorf("processor:%s init err:%s", n, err)
+		}
+		valid[n] = p
 	}
 
-	return nil
+	return valid, nil
 }
 
 func (m *Service) initTracer(servLoc string) error {
@@ -392,18 +1679,77 @@ func (m *Service) initTracer(servLoc string) error {
 	return err
 }
 
-func (m *Service) initBackdoork(sb *ServBaseV2) error {
+func (m *Service) initHealthConfig(sb *ServBaseV2) error {
+	fun := "Service.initHealthConfig -->"
+
+	var healthConfig HealthConfig
+	err := sb.ServConfig(&healthConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	healthStatusCodes.set(map[string]int{
+		HEALTH_STATE_HEALTHY:   healthConfig.Health.HealthyCode,
+		HEALTH_STATE_DEGRADED:  healthConfig.Health.DegradedCode,
+		HEALTH_STATE_DRAINING:  healthConfig.Health.DrainingCode,
+		HEALTH_STATE_UNHEALTHY: healthConfig.Health.UnhealthyCode,
+	})
+	return nil
+}
+
+// initBackdoork addr为启动参数指定的监听地址，优先于配置 [backdoor] addr
+func (m *Service) initBackdoork(sb *ServBaseV2, addr string) error {
 	fun := "Service.initBackdoork -->"
 
-	backdoor := &backDoorHttp{}
+	m.initHealthConfig(sb)
+	if err := watchBackdoorToken(sb, backdoorAuthToken); err != nil {
+		slog.Errorf("%s init backdoor auth err:%s", fun, err)
+	}
+
+	var backdoorConfig BackdoorConfig
+	if err := sb.ServConfig(&backdoorConfig); err != nil {
+		slog.Warnf("%s serv config err:%s", fun, err)
+	}
+
+	var healthConfig HealthConfig
+	if err := sb.ServConfig(&healthConfig); err != nil {
+		slog.Warnf("%s serv config err:%s", fun, err)
+	}
+
+	backdoor := &backDoorHttp{
+		pprof:            backdoorConfig.Backdoor.Pprof,
+		metrics:          backdoorConfig.Backdoor.Metrics,
+		extraHealthPaths: checkExtraHealthPaths(healthConfig.Health.ExtraPaths),
+		restart:          backdoorAuthToken.isRequired(),
+	}
+	if backdoor.pprof {
+		slog.Infof("%s backdoor pprof enabled", fun)
+	}
+	if !backdoor.restart {
+		slog.Infof("%s backdoor restart disabled, need [backdoor] tokenfile", fun)
+	}
+	if backdoor.metrics {
+		slog.Infof("%s backdoor metrics enabled", fun)
+	}
+	if len(backdoor.extraHealthPaths) > 0 {
+		slog.Infof("%s extra health paths:%v", fun, backdoor.extraHealthPaths)
+	}
 	err := backdoor.Init()
 	if err != nil {
 		slog.Errorf("%s init backdoor err:%s", fun, err)
 		return err
 	}
 
-	binfos, err := m.loadDriver(sb, map[string]Processor{"_PROC_BACKDOOR": backdoor})
+	addr, err = resolveBackdoorAddr(addr, backdoorConfig.Backdoor.Addr)
+	if err != nil {
+		slog.Errorf("%s use default addr:%s, err:%s", fun, defaultBackdoorAddr, err)
+		addr = defaultBackdoorAddr
+	}
+
+	binfos, err := m.loadBackdoor(sb, backdoor, addr)
 	if err == nil {
+		slog.Infof("%s backdoor listen on:%s", fun, binfos["_PROC_BACKDOOR"].Addr)
 		err = sb.RegisterBackDoor(binfos)
 		if err != nil {
 			slog.Errorf("%s register backdoor err:%s", fun, err)
@@ -416,16 +1762,90 @@ func (m *Service) initBackdoork(sb *ServBaseV2) error {
 	return err
 }
 
+// loadBackdoor 端口被占用时依次尝试其他端口，保证后门可用，注册的是实际监听的地址
+func (m *Service) loadBackdoor(sb ServBase, backdoor *backDoorHttp, addr string) (map[string]*ServInfo, error) {
+	fun := "Service.loadBackdoor -->"
+
+	var err error
+	for _, a := range backdoorAddrs(addr) {
+		backdoor.addr = a
+
+		var binfos map[string]*ServInfo
+		binfos, err = m.loadDriver(sb, map[string]Processor{"_PROC_BACKDOOR": backdoor}, nil)
+		if err == nil {
+			if a != addr {
+				slog.Warnf("%s backdoor addr:%s unavailable, use:%s", fun, addr, binfos["_PROC_BACKDOOR"].Addr)
+			}
+			return binfos, nil
+		}
+		slog.Warnf("%s load backdoor addr:%s err:%s", fun, a, err)
+	}
+
+	return nil, err
+}
+
+func (m *Service) initStatsd(metricConfig MetricConfig) {
+	fun := "Service.initStatsd -->"
+
+	statsd := metricConfig.Metric.Statsd
+	if len(statsd.Addr) == 0 {
+		return
+	}
+
+	exporter, err := newStatsdExporter(statsd.Addr, statsd.Prefix, statsd.DogStatsD, prometheus.DefaultGatherer)
+	if err != nil {
+		slog.Errorf("%s statsd addr:%s err:%s", fun, statsd.Addr, err)
+		return
+	}
+	exporter.run(time.Duration(statsd.Interval) * time.Millisecond)
+
+	m.mutex.Lock()
+	m.statsd = exporter
+	m.mutex.Unlock()
+
+	slog.Infof("%s push metrics to statsd:%s dogstatsd:%t", fun, statsd.Addr, statsd.DogStatsD)
+}
+
+// flushMetrics 立即推送metrics，未配置推送时不处理
+func (m *Service) flushMetrics() error {
+	m.mutex.Lock()
+	exporter := m.statsd
+	m.mutex.Unlock()
+
+	if exporter == nil {
+		return nil
+	}
+	return exporter.flush()
+}
+
 func (m *Service) initMetric(sb *ServBaseV2) error {
 	fun := "Service.initMetric -->"
 
-	metrics := xprom.NewMetricProcessor()
-	err := metrics.Init()
+	var metricConfig MetricConfig
+	err := sb.ServConfig(&metricConfig)
+	if err != nil {
+		slog.Warnf("%s serv config err:%s", fun, err)
+	}
+
+	m.runBeforeMetricsInit(prometheus.DefaultRegisterer)
+	if err := registerMetric(prometheus.DefaultRegisterer, sloCollector{}); err != nil {
+		slog.Warnf("%s register slo metrics err:%s", fun, err)
+	}
+	m.initStatsd(metricConfig)
+
+	metrics, err := newMetricsProcessor(xprom.NewMetricProcessor(), metricConfig.Metric.Path)
+	if err != nil {
+		slog.Warnf("%s metrics path err:%s", fun, err)
+		metrics, _ = newMetricsProcessor(xprom.NewMetricProcessor(), "")
+	}
+	metrics.cacheTTL = time.Duration(metricConfig.Metric.CacheTTL) * time.Millisecond
+
+	err = metrics.Init()
 	if err != nil {
 		slog.Warnf("%s init metrics err:%s", fun, err)
 	}
 
-	minfos, err := m.loadDriver(sb, map[string]Processor{"_PROC_METRICS": metrics})
+	minfos, err := m.loadDriver(sb, map[string]Processor{"_PROC_METRICS": metrics}, m.getAddrOverrides())
 	if err == nil {
 		err = sb.RegisterMetrics(minfos)
 		if err != nil {
@@ -438,21 +1858,69 @@ func (m *Service) initMetric(sb *ServBaseV2) error {
 	return err
 }
 
+// BeforeMetricsInit 注册在metrics processor开始服务前执行的函数，
+// 在其中注册的collector在第一次抓取时即可见，需要在Serve之前调用
+func (m *Service) BeforeMetricsInit(fn func(registry prometheus.Registerer)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.beforeMetricsInit = append(m.beforeMetricsInit, fn)
+}
+
+func (m *Service) runBeforeMetricsInit(registry prometheus.Registerer) {
+	m.mutex.Lock()
+	fns := m.beforeMetricsInit
+	m.mutex.Unlock()
+
+	for _, fn := range fns {
+		fn(safeRegisterer{registry})
+	}
+}
+
+func BeforeMetricsInit(fn func(registry prometheus.Registerer)) {
+	service.BeforeMetricsInit(fn)
+}
+
+// Use 添加作用于所有业务processor的中间件，见Service.Use
+func Use(ms ...Middleware) {
+	service.Use(ms...)
+}
+
 func ReloadRouter(processor string, driver interface{}) error {
 	return service.reloadRouter(processor, driver)
 }
 
 func Serve(etcds []string, baseLoc string, initfn func(ServBase) error, procs map[string]Processor) error {
-	return service.Serve(configEtcd{etcds, baseLoc}, initfn, procs)
+	return service.Serve(configEtcd{etcdAddrs: etcds, useBaseloc: baseLoc}, initfn, procs)
+}
+
+// ServeWithConfig 同Serve，etcd需要TLS、用户名密码等连接参数时使用
+func ServeWithConfig(conf EtcdConfig, initfn func(ServBase) error, procs map[string]Processor) error {
+	confEtcd, err := conf.toConfigEtcd()
+	if err != nil {
+		return err
+	}
+	return service.Serve(confEtcd, initfn, procs)
+}
+
+// ServeWithContext 同Serve，initfn接收ctx，在ctx结束或服务开始下线时取消，用于中止耗时的初始化；
+// ctx结束时服务下线
+func ServeWithContext(ctx context.Context, etcds []string, baseLoc string, initfn func(context.Context, ServBase) error, procs map[string]Processor) error {
+	return service.ServeWithContext(ctx, configEtcd{etcdAddrs: etcds, useBaseloc: baseLoc}, initfn, procs)
 }
 
 func MasterSlave(etcds []string, baseLoc string, initfn func(ServBase) error, procs map[string]Processor) error {
-	return service.MasterSlave(configEtcd{etcds, baseLoc}, initfn, procs)
+	return service.MasterSlave(configEtcd{etcdAddrs: etcds, useBaseloc: baseLoc}, initfn, procs)
 }
 
 func (m *Service) MasterSlave(confEtcd configEtcd, initfn func(ServBase) error, procs map[string]Processor) error {
 	fun := "Service.MasterSlave -->"
 
+	if err := m.startServing(); err != nil {
+		slog.Errorf("%s err:%s", fun, err)
+		return err
+	}
+
 	args, err := m.parseFlag()
 	if err != nil {
 		slog.Panicf("%s parse arg err:%s", fun, err)
@@ -460,10 +1928,12 @@ func (m *Service) MasterSlave(confEtcd configEtcd, initfn func(ServBase) error,
 	}
 	args.model = MODEL_MASTERSLAVE
 
-	return m.Init(confEtcd, args, initfn, procs)
+	return m.serve(context.Background(), confEtcd, args, withoutContext(initfn), procs)
 }
 
+// deprecated
 func Init(etcds []string, baseLoc string, servLoc, servKey, logDir string, initfn func(ServBase) error, procs map[string]Processor) error {
+	deprecated("rocserv.Init", "rocserv.Serve")
 	args := &cmdArgs{
 		logMaxSize:    0,
 		logMaxBackups: 0,
@@ -471,7 +1941,12 @@ func Init(etcds []string, baseLoc string, servLoc, servKey, logDir string, initf
 		logDir:        logDir,
 		sessKey:       servKey,
 	}
-	return service.Init(configEtcd{etcds, baseLoc}, args, initfn, procs)
+	return service.Init(configEtcd{etcdAddrs: etcds, useBaseloc: baseLoc}, args, initfn, procs)
+}
+
+// Shutdown 优雅下线服务，阻塞到处理中的请求结束或ctx结束，之后Serve/Init返回
+func Shutdown(ctx context.Context) error {
+	return service.Shutdown(ctx)
 }
 
 func GetServBase() ServBase {
@@ -503,6 +1978,7 @@ func GetServId() (servId int) {
 	return
 }
 
+// Test 连接etcd启动服务并阻塞到服务下线，单元测试不需要etcd时使用NewTestService
 func Test(etcds []string, baseLoc, servLoc string, initfn func(ServBase) error) error {
 	args := &cmdArgs{
 		logMaxSize:    0,
@@ -512,5 +1988,5 @@ func Test(etcds []string, baseLoc, servLoc string, initfn func(ServBase) error)
 		logDir:        "console",
 		disable:       true,
 	}
-	return service.Init(configEtcd{etcds, baseLoc}, args, initfn, nil)
+	return service.Init(configEtcd{etcdAddrs: etcds, useBaseloc: baseLoc}, args, initfn, nil)
 }
@@ -0,0 +1,908 @@
+// Copyright 2014 The roc Author. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rocserv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type testProcessor struct {
+	inited bool
+	addr   string
+	driver interface{}
+}
+
+func (m *testProcessor) Init() error {
+	m.inited = true
+	return nil
+}
+
+func (m *testProcessor) Driver() (string, interface{}) {
+	return m.addr, m.driver
+}
+
+func TestCheckProcessorsSkipNil(t *testing.T) {
+	p := &testProcessor{}
+	procs := map[string]Processor{
+		"ok":  p,
+		"nil": nil,
+	}
+
+	if _, err := checkProcessors(nil, procs, false); err == nil {
+		t.Errorf("nil processor should fail without skip")
+	}
+
+	valid, err := checkProcessors(nil, procs, true)
+	if err != nil {
+		t.Fatalf("check processors err:%s", err)
+	}
+
+	if len(valid) != 1 || valid["ok"] != p {
+		t.Errorf("unexpected processors:%v", valid)
+	}
+
+	if !p.inited {
+		t.Errorf("processor not inited")
+	}
+}
+
+func TestInitProcessorSkipNil(t *testing.T) {
+	keys := newTestRegKeysAPI()
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		servId:       3,
+		etcdClient:   keys,
+		regInfos:     make(map[string]string),
+		probes:       newDependencyProbes(),
+		keepalive:    newRegistryKeepalive(registerTTL),
+		configWatch:  newConfigWatcher(),
+	}
+
+	router := httprouter.New()
+	router.GET("/ping", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Write([]byte("pong"))
+	})
+	procs := map[string]Processor{
+		"api": &testProcessor{addr: "127.0.0.1:", driver: router},
+		"nil": nil,
+	}
+
+	m := NewService()
+	m.sbase = sb
+	defer m.Shutdown(context.Background())
+
+	if err := m.initProcessor(sb, procs, false); err == nil {
+		t.Fatalf("nil processor should fail without skip")
+	}
+	if err := m.initProcessor(sb, procs, true); err != nil {
+		t.Fatalf("init processor err:%s", err)
+	}
+
+	// 跳过nil processor，其他processor正常监听
+	info, ok := m.infos["api"]
+	if !ok {
+		t.Fatalf("api not loaded:%v", m.infos)
+	}
+	if _, ok := m.infos["nil"]; ok {
+		t.Errorf("nil processor loaded")
+	}
+	resp, err := http.Get("http://" + info.Addr + "/ping")
+	if err != nil {
+		t.Fatalf("get err:%s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "pong" {
+		t.Errorf("body:%s", body)
+	}
+
+	// 并注册到服务发现
+	path := "/roc/dist2/base/test/3/serve"
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _, ok := keys.get(path); ok {
+			var rd RegData
+			if err := json.Unmarshal([]byte(v), &rd); err != nil {
+				t.Fatalf("unmarshal reg data:%s err:%s", v, err)
+			}
+			if rd.Servs["api"] == nil || rd.Servs["api"].Addr != info.Addr {
+				t.Errorf("api not registered:%s", v)
+			}
+			if _, ok := rd.Servs["nil"]; ok {
+				t.Errorf("nil processor registered:%s", v)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("register timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestLoadDriverParallel(t *testing.T) {
+	m := NewService()
+	m.bindParallel = 4
+
+	procs := make(map[string]Processor)
+	for i := 0; i < 20; i++ {
+		procs[fmt.Sprintf("http%02d", i)] = &testProcessor{addr: "127.0.0.1:", driver: httprouter.New()}
+	}
+
+	infos, err := m.loadDriver(nil, procs, nil)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+	if len(infos) != len(procs) {
+		t.Errorf("loaded:%d want:%d", len(infos), len(procs))
+	}
+
+	m.Shutdown(context.Background())
+
+	m = NewService()
+	m.bindParallel = 4
+	procs["bad_b"] = &testProcessor{addr: "127.0.0.1:", driver: 1}
+	procs["bad_a"] = &testProcessor{addr: "127.0.0.1:", driver: "x"}
+	_, err = m.loadDriver(nil, procs, nil)
+	if err == nil {
+		t.Fatalf("unrecognized driver should fail")
+	}
+
+	want := "processor:bad_a driver not recognition; processor:bad_b driver not recognition"
+	if err.Error() != want {
+		t.Errorf("err:%s want:%s", err, want)
+	}
+	if len(m.handles) != 0 {
+		t.Errorf("started processors should be stopped, handles:%d", len(m.handles))
+	}
+}
+
+func TestLoadDriverPartialFailureReleasesPorts(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	defer occupied.Close()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	good := free.Addr().String()
+	free.Close()
+
+	m := NewService()
+	m.bindParallel = 2
+	_, err = m.loadDriver(nil, map[string]Processor{
+		"bad":  &testProcessor{addr: occupied.Addr().String(), driver: httprouter.New()},
+		"good": &testProcessor{addr: good, driver: httprouter.New()},
+	}, nil)
+	if err == nil {
+		t.Fatalf("bind occupied addr should fail")
+	}
+
+	// 启动成功的processor已经停止，端口可以重新监听
+	lis, err := net.Listen("tcp", good)
+	if err != nil {
+		t.Fatalf("addr:%s of started processor not released, err:%s", good, err)
+	}
+	lis.Close()
+}
+
+func TestCallInitFuncRetry(t *testing.T) {
+	calls := 0
+	flaky := func(sb ServBase) error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("dependency unavailable")
+		}
+		return nil
+	}
+
+	if err := callInitFunc(context.Background(), nil, withoutContext(flaky), 1, time.Millisecond); err == nil {
+		t.Errorf("should fail when retries exhausted")
+	}
+
+	calls = 0
+	if err := callInitFunc(context.Background(), nil, withoutContext(flaky), 3, time.Millisecond); err != nil {
+		t.Errorf("init err:%s", err)
+	}
+	if calls != 3 {
+		t.Errorf("initfn calls:%d", calls)
+	}
+}
+
+func TestCallInitFuncCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	initfn := func(ctx context.Context, sb ServBase) error {
+		calls++
+		cancel()
+		return ctx.Err()
+	}
+
+	// ctx结束后不再重试
+	if err := callInitFunc(ctx, nil, initfn, 3, time.Hour); err != context.Canceled {
+		t.Errorf("init err:%v", err)
+	}
+	if calls != 1 {
+		t.Errorf("initfn calls:%d", calls)
+	}
+}
+
+func TestShutdownCancelStartCtx(t *testing.T) {
+	m := NewService()
+	ctx := m.initStartCtx(context.Background())
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown err:%s", err)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Errorf("start ctx should be canceled when shutdown begins")
+	}
+}
+
+func TestStopOnDone(t *testing.T) {
+	m := NewService()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		m.stopOnDone(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-m.stopC:
+	case <-time.After(time.Second):
+		t.Fatalf("service should stop when ctx done")
+	}
+	<-done
+}
+
+type shutdownProcessor struct {
+	testProcessor
+	order *[]string
+	name  string
+	err   error
+}
+
+func (m *shutdownProcessor) Shutdown(ctx context.Context) error {
+	*m.order = append(*m.order, m.name)
+	return m.err
+}
+
+func TestShutdownProcessors(t *testing.T) {
+	var order []string
+	m := NewService()
+	m.procs = map[string]Processor{
+		"b":     &shutdownProcessor{order: &order, name: "b", err: errors.New("flush failed")},
+		"a":     &shutdownProcessor{order: &order, name: "a"},
+		"plain": &testProcessor{},
+	}
+
+	err := m.shutdownProcessors(context.Background())
+	if err == nil {
+		t.Errorf("shutdown error not returned")
+	}
+	if fmt.Sprint(order) != "[a b]" {
+		t.Errorf("shutdown order:%v", order)
+	}
+}
+
+func TestWatchInitProgress(t *testing.T) {
+	var mu sync.Mutex
+	var progress, warns int
+	infof := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.Contains(format, "still initializing") {
+			progress++
+		}
+	}
+	warnf := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		warns++
+	}
+
+	stop := watchInit(10*time.Millisecond, 30*time.Millisecond, infof, warnf)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if progress < 3 {
+		t.Errorf("progress logs:%d", progress)
+	}
+	if warns != 1 {
+		t.Errorf("soft limit warns:%d", warns)
+	}
+}
+
+func TestMarkReady(t *testing.T) {
+	m := NewService()
+	m.initStart = time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	select {
+	case <-m.Ready():
+		t.Fatalf("ready before markReady")
+	default:
+	}
+
+	m.markReady(nil)
+	m.markReady(nil)
+
+	select {
+	case <-m.Ready():
+	default:
+		t.Fatalf("ready channel not closed")
+	}
+
+	d := m.TimeToReady()
+	if d < 10*time.Millisecond || d > time.Second {
+		t.Errorf("time to ready:%s", d)
+	}
+}
+
+type hiddenProcessor struct {
+	testProcessor
+}
+
+func (m *hiddenProcessor) Discoverable() bool {
+	return false
+}
+
+func TestNonDiscoverableProcessor(t *testing.T) {
+	procs := map[string]Processor{
+		"api":   &testProcessor{addr: "127.0.0.1:", driver: httprouter.New()},
+		"debug": &hiddenProcessor{testProcessor{addr: "127.0.0.1:", driver: httprouter.New()}},
+	}
+
+	m := NewService()
+	infos, err := m.loadDriver(nil, procs, nil)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+	if infos["debug"] == nil {
+		t.Fatalf("non-discoverable processor not bound")
+	}
+
+	regInfos := discoverableInfos(procs, infos)
+	if _, ok := regInfos["debug"]; ok {
+		t.Errorf("non-discoverable processor registered")
+	}
+	if _, ok := regInfos["api"]; !ok {
+		t.Errorf("discoverable processor not registered")
+	}
+}
+
+type testConfigServBase struct {
+	ServBase
+	prefix string
+}
+
+func (m *testConfigServBase) ServConfig(cfg interface{}) error {
+	cfg.(*struct{ Api struct{ Prefix string } }).Api.Prefix = m.prefix
+	return nil
+}
+
+type baseProcessor struct {
+	testProcessor
+	prefix string
+}
+
+func (m *baseProcessor) InitWithBase(sb ServBase) error {
+	var cfg struct{ Api struct{ Prefix string } }
+	if err := sb.ServConfig(&cfg); err != nil {
+		return err
+	}
+	m.prefix = cfg.Api.Prefix
+
+	router := httprouter.New()
+	router.GET(m.prefix+"/echo", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {})
+	m.driver = router
+	return nil
+}
+
+func TestInitWithBase(t *testing.T) {
+	p := &baseProcessor{}
+	valid, err := checkProcessors(&testConfigServBase{prefix: "/v2"}, map[string]Processor{"api": p}, false)
+	if err != nil {
+		t.Fatalf("check processors err:%s", err)
+	}
+	if valid["api"] != p {
+		t.Fatalf("unexpected processors:%v", valid)
+	}
+	if p.inited {
+		t.Errorf("Init should not be called when InitWithBase implemented")
+	}
+
+	_, driver := p.Driver()
+	if h, _, _ := driver.(*httprouter.Router).Lookup("GET", "/v2/echo"); h == nil {
+		t.Errorf("route from config not found")
+	}
+}
+
+func TestServiceShutdown(t *testing.T) {
+	started := make(chan struct{})
+	var finished int32
+	router := httprouter.New()
+	router.GET("/slow", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+		atomic.StoreInt32(&finished, 1)
+	})
+
+	m := NewService()
+	infos, err := m.loadDriver(nil, map[string]Processor{
+		"api": &testProcessor{addr: "127.0.0.1:", driver: router},
+	}, nil)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+	addr := infos["api"].Addr
+
+	type result struct {
+		body string
+		err  error
+	}
+	resC := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			resC <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		resC <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown err:%s", err)
+	}
+
+	// Shutdown返回时处理中的请求已经完成
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Errorf("shutdown returned before in-flight request drained")
+	}
+	select {
+	case res := <-resC:
+		if res.err != nil || res.body != "done" {
+			t.Errorf("in-flight request body:%s err:%v", res.body, res.err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("in-flight request not finished")
+	}
+
+	if _, err := http.Get("http://" + addr + "/slow"); err == nil {
+		t.Errorf("new request accepted after shutdown")
+	}
+
+	select {
+	case <-m.stopC:
+	default:
+		t.Errorf("stop channel not closed")
+	}
+
+	// 重复调用直接返回
+	if err := m.Shutdown(ctx); err != nil {
+		t.Errorf("second shutdown err:%s", err)
+	}
+}
+
+type testHandle struct {
+	stopped bool
+}
+
+func (m *testHandle) Stop(ctx context.Context) error {
+	m.stopped = true
+	return nil
+}
+
+func TestAddServerDuplicate(t *testing.T) {
+	m := NewService()
+
+	first := &testHandle{}
+	if _, err := m.addServer("api", nil, first, false); err != nil {
+		t.Fatalf("add server err:%s", err)
+	}
+
+	second := &testHandle{}
+	if _, err := m.addServer("api", nil, second, false); err == nil {
+		t.Errorf("duplicate processor should fail without replace")
+	}
+	if m.handles["api"] != first {
+		t.Errorf("existing server clobbered")
+	}
+
+	old, err := m.addServer("api", nil, second, true)
+	if err != nil {
+		t.Fatalf("replace server err:%s", err)
+	}
+	if old != first || m.handles["api"] != second {
+		t.Errorf("replace old:%v current:%v", old, m.handles["api"])
+	}
+}
+
+// testRegServBase 记录注册信息的ServBase
+type testRegServBase struct {
+	ServBase
+
+	mu      sync.Mutex
+	servs   map[string]*ServInfo
+	stopped bool
+}
+
+func (m *testRegServBase) RegisterService(servs map[string]*ServInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.servs = servs
+	return nil
+}
+
+func (m *testRegServBase) RegisterCrossDCService(servs map[string]*ServInfo) error {
+	return nil
+}
+
+func (m *testRegServBase) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+	m.servs = nil
+}
+
+func (m *testRegServBase) registered() map[string]*ServInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.servs
+}
+
+func TestAddProcessor(t *testing.T) {
+	sb := &testRegServBase{}
+	m := NewService()
+	m.sbase = sb
+
+	if err := m.AddProcessor("_plugin", &testProcessor{}); err == nil {
+		t.Errorf("reserved prefix should fail")
+	}
+
+	router := httprouter.New()
+	router.GET("/plugin", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Write([]byte("plugin"))
+	})
+	p := &testProcessor{addr: "127.0.0.1:", driver: router}
+	if err := m.AddProcessor("plugin", p); err != nil {
+		t.Fatalf("add processor err:%s", err)
+	}
+	if !p.inited {
+		t.Errorf("processor not inited")
+	}
+
+	if err := m.AddProcessor("plugin", &testProcessor{addr: "127.0.0.1:", driver: httprouter.New()}); err == nil {
+		t.Errorf("duplicate processor should fail")
+	}
+
+	info := sb.registered()["plugin"]
+	if info == nil {
+		t.Fatalf("processor not registered:%v", sb.registered())
+	}
+
+	resp, err := http.Get("http://" + info.Addr + "/plugin")
+	if err != nil {
+		t.Fatalf("request plugin err:%s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "plugin" {
+		t.Errorf("plugin body:%s", body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown err:%s", err)
+	}
+	if !sb.stopped || len(sb.registered()) != 0 {
+		t.Errorf("service not deregistered on shutdown")
+	}
+	if _, err := http.Get("http://" + info.Addr + "/plugin"); err == nil {
+		t.Errorf("plugin still serving after shutdown")
+	}
+}
+
+func TestWaitForStop(t *testing.T) {
+	m := NewService()
+
+	done := make(chan struct{})
+	go func() {
+		m.waitForStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("waitForStop returned before shutdown")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go m.Shutdown(context.Background())
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("waitForStop not returned after shutdown")
+	}
+}
+
+func TestRemoveProcessor(t *testing.T) {
+	sb := &testRegServBase{}
+	m := NewService()
+	m.sbase = sb
+
+	newRouter := func(body string) *httprouter.Router {
+		router := httprouter.New()
+		router.GET("/ping", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			w.Write([]byte(body))
+		})
+		return router
+	}
+	for _, n := range []string{"api", "feature"} {
+		if err := m.AddProcessor(n, &testProcessor{addr: "127.0.0.1:", driver: newRouter(n)}); err != nil {
+			t.Fatalf("add processor:%s err:%s", n, err)
+		}
+	}
+	defer m.Shutdown(context.Background())
+
+	servs := sb.registered()
+	api, feature := servs["api"], servs["feature"]
+	if api == nil || feature == nil {
+		t.Fatalf("processors not registered:%v", servs)
+	}
+
+	if err := m.RemoveProcessor("_PROC_BACKDOOR"); err == nil {
+		t.Errorf("remove framework processor should fail")
+	}
+	if err := m.RemoveProcessor("missing"); err == nil {
+		t.Errorf("remove missing processor should fail")
+	}
+	if err := m.RemoveProcessor("feature"); err != nil {
+		t.Fatalf("remove processor err:%s", err)
+	}
+
+	servs = sb.registered()
+	if _, ok := servs["feature"]; ok {
+		t.Errorf("removed processor still registered")
+	}
+	if _, ok := servs["api"]; !ok {
+		t.Errorf("other processor deregistered")
+	}
+
+	if _, err := http.Get("http://" + feature.Addr + "/ping"); err == nil {
+		t.Errorf("removed processor still serving")
+	}
+	resp, err := http.Get("http://" + api.Addr + "/ping")
+	if err != nil {
+		t.Fatalf("request api err:%s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "api" {
+		t.Errorf("api body:%s", body)
+	}
+
+	if err := m.AddProcessor("feature", &testProcessor{addr: "127.0.0.1:", driver: newRouter("feature")}); err != nil {
+		t.Errorf("re-add removed processor err:%s", err)
+	}
+}
+
+func TestParseArgsEnvFallback(t *testing.T) {
+	env := map[string]string{
+		envServ:      "base/account",
+		envSkey:      "env-key",
+		envGroup:     "canary",
+		envSidOffset: "100",
+	}
+	getenv := func(k string) string { return env[k] }
+
+	m := NewService()
+	args, err := m.parseArgs(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-skey", "flag-key"}, getenv)
+	if err != nil {
+		t.Fatalf("parse args err:%s", err)
+	}
+	if args.servLoc != "base/account" || args.group != "canary" || args.sidOffset != 100 {
+		t.Errorf("env not used:%+v", args)
+	}
+	if args.sessKey != "flag-key" {
+		t.Errorf("flag should take precedence, skey:%s", args.sessKey)
+	}
+
+	_, err = m.parseArgs(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-skey", "k"}, func(string) string { return "" })
+	if err == nil || err.Error() != "serv args need!" {
+		t.Errorf("missing serv err:%v", err)
+	}
+
+	env[envSidOffset] = "abc"
+	if _, err := m.parseArgs(flag.NewFlagSet("test", flag.ContinueOnError), nil, getenv); err == nil {
+		t.Errorf("invalid int env should fail")
+	}
+}
+
+func TestServWeight(t *testing.T) {
+	m := NewService()
+	parse := func(arguments []string, env map[string]string) (*cmdArgs, error) {
+		return m.parseArgs(flag.NewFlagSet("test", flag.ContinueOnError), append([]string{"-serv", "base/account", "-skey", "k"}, arguments...), func(k string) string { return env[k] })
+	}
+
+	args, err := parse(nil, nil)
+	if err != nil || args.weight != DEFAULT_SERV_WEIGHT {
+		t.Fatalf("default weight:%v err:%v", args, err)
+	}
+	if args, _ := parse(nil, map[string]string{envWeight: "50"}); args.weight != 50 {
+		t.Errorf("env weight:%d", args.weight)
+	}
+	if args, _ := parse([]string{"-weight", "200"}, map[string]string{envWeight: "50"}); args.weight != 200 {
+		t.Errorf("flag weight:%d", args.weight)
+	}
+	if _, err := parse([]string{"-weight", "-1"}, nil); err == nil {
+		t.Errorf("negative weight should fail")
+	}
+
+	m.weight = 30
+	infos := map[string]*ServInfo{"proc_http": {Type: PROCESSOR_HTTP, Addr: "10.1.1.1:8080"}}
+	setServWeight(infos, m.getWeight())
+	js, _ := json.Marshal(&RegData{Servs: infos})
+	if !strings.Contains(string(js), `"weight":30`) {
+		t.Errorf("weight not registered:%s", js)
+	}
+}
+
+func TestBackdoorRestart(t *testing.T) {
+	old := service
+	service = NewService()
+	defer func() { service = old }()
+
+	exited := make(chan int, 1)
+	exitProcess = func(code int) { exited <- code }
+	defer func() { exitProcess = os.Exit }()
+	restartOnce = sync.Once{}
+
+	// 没有配置后门鉴权时不开启
+	_, driver := (&backDoorHttp{}).Driver()
+	w := httptest.NewRecorder()
+	driver.(http.Handler).ServeHTTP(w, httptest.NewRequest("POST", "/backdoor/restart", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("restart without auth code:%d", w.Code)
+	}
+	select {
+	case <-exited:
+		t.Fatalf("process should not exit")
+	default:
+	}
+
+	_, driver = (&backDoorHttp{restart: true}).Driver()
+	w = httptest.NewRecorder()
+	driver.(http.Handler).ServeHTTP(w, httptest.NewRequest("POST", "/backdoor/restart", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("restart code:%d", w.Code)
+	}
+
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Errorf("exit code:%d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("process not exited after restart")
+	}
+
+	select {
+	case <-service.stopC:
+	default:
+		t.Errorf("service not shutdown before exit")
+	}
+}
+
+func TestLoadDriverAddrOverride(t *testing.T) {
+	if _, err := parseAddrOverrides("api"); err == nil {
+		t.Errorf("item without addr should fail")
+	}
+	if _, err := parseAddrOverrides("api=8080"); err == nil {
+		t.Errorf("addr without host should fail")
+	}
+
+	addrs, err := parseAddrOverrides(" api=127.0.0.1:0, ")
+	if err != nil || addrs["api"] != "127.0.0.1:0" {
+		t.Fatalf("parse addrs:%v err:%v", addrs, err)
+	}
+
+	m := NewService()
+	defer m.Shutdown(context.Background())
+
+	// Driver返回的地址不可用，使用指定的地址监听
+	procs := map[string]Processor{
+		"api":   &testProcessor{addr: "256.0.0.1:80", driver: httprouter.New()},
+		"admin": &testProcessor{addr: "127.0.0.1:", driver: httprouter.New()},
+	}
+	infos, err := m.loadDriver(nil, procs, addrs)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+	if !strings.HasPrefix(infos["api"].Addr, "127.0.0.1:") || !strings.HasPrefix(infos["admin"].Addr, "127.0.0.1:") {
+		t.Errorf("infos:%v", infos)
+	}
+	if _, ok := procs["api"].(*testProcessor); !ok {
+		t.Errorf("caller procs should not be modified")
+	}
+}
+
+func TestValidateListenAddr(t *testing.T) {
+	for _, addr := range []string{"", ":", "127.0.0.1:", "127.0.0.1:0", "0.0.0.0:8080", "[::1]:80", "localhost:8080", "my-host.local:"} {
+		if err := validateListenAddr(addr); err != nil {
+			t.Errorf("addr:%q err:%s", addr, err)
+		}
+	}
+
+	for _, addr := range []string{"localhost", "127.0.0.1", "8080", "127.0.0.1:http", "127.0.0.1:65536", "127.0.0.1:-1", "256.0.0.1:80", "::1:80", "http://127.0.0.1:80", "my host:80"} {
+		if err := validateListenAddr(addr); err == nil {
+			t.Errorf("addr:%q should be invalid", addr)
+		}
+	}
+
+	m := NewService()
+	_, err := m.loadDriver(nil, map[string]Processor{"api": &testProcessor{addr: "localhost", driver: httprouter.New()}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "processor:api invalid addr") {
+		t.Errorf("load driver err:%v", err)
+	}
+}
+
+func TestServeTwice(t *testing.T) {
+	m := NewService()
+
+	// 没有etcd地址，第一次调用启动失败
+	first := m.Serve(configEtcd{}, nil, nil)
+	if first == nil || first == ErrAlreadyServing {
+		t.Fatalf("first serve err:%v", first)
+	}
+
+	if err := m.Serve(configEtcd{}, nil, nil); err != ErrAlreadyServing {
+		t.Errorf("second serve err:%v", err)
+	}
+	if err := m.Init(configEtcd{}, &cmdArgs{}, nil, nil); err != ErrAlreadyServing {
+		t.Errorf("init after serve err:%v", err)
+	}
+	if err := m.MasterSlave(configEtcd{}, nil, nil); err != ErrAlreadyServing {
+		t.Errorf("master slave after serve err:%v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package rocserv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/shawnfeng/sutil/slog"
+	"github.com/uber/jaeger-client-go"
+)
+
+const (
+	middlewareAccessLog = "access_log"
+
+	AccessLogID = "ACCESS"
+
+	ACCESS_LOG_FORMAT_TEXT = "text"
+	ACCESS_LOG_FORMAT_JSON = "json"
+)
+
+// accessLogFormat 为空时不记录访问日志
+var accessLogFormat atomic.Value
+
+// accessLogf 输出访问日志，测试时替换
+var accessLogf = slog.Infof
+
+func init() {
+	accessLogFormat.Store("")
+}
+
+// setAccessLog 开启或关闭访问日志，format为空时使用text
+func setAccessLog(enable bool, format string) error {
+	if !enable {
+		accessLogFormat.Store("")
+		return nil
+	}
+
+	switch format {
+	case "":
+		format = ACCESS_LOG_FORMAT_TEXT
+	case ACCESS_LOG_FORMAT_TEXT, ACCESS_LOG_FORMAT_JSON:
+	default:
+		return fmt.Errorf("unknown access log format:%s", format)
+	}
+	accessLogFormat.Store(format)
+	return nil
+}
+
+type accessLogEntry struct {
+	Processor string  `json:"processor"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Latency   float64 `json:"latency_ms"`
+	Remote    string  `json:"remote"`
+	TraceID   string  `json:"tid,omitempty"`
+}
+
+func (m *accessLogEntry) format(format string) string {
+	if format == ACCESS_LOG_FORMAT_JSON {
+		bs, _ := json.Marshal(m)
+		return string(bs)
+	}
+	return fmt.Sprintf("processor:%s method:%s path:%s status:%d latency:%.3fms remote:%s tid:%s",
+		m.Processor, m.Method, m.Path, m.Status, m.Latency, m.Remote, m.TraceID)
+}
+
+// traceIDFromContext ctx中jaeger span的trace id，没有时返回空
+func traceIDFromContext(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// accessLogMiddleware 需要在tracing之内以获取trace id，在processorMiddleware之内以获取processor
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := accessLogFormat.Load().(string)
+		if len(format) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &sloWriter{ResponseWriter: w}
+		st := time.Now()
+		next.ServeHTTP(sw, r)
+
+		code := sw.code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		e := &accessLogEntry{
+			Processor: processorFromContext(r.Context()),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    code,
+			Latency:   float64(time.Since(st)) / float64(time.Millisecond),
+			Remote:    r.RemoteAddr,
+			TraceID:   traceIDFromContext(r.Context()),
+		}
+		accessLogf("%s\t%s", AccessLogID, e.format(format))
+	})
+}
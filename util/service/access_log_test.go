@@ -0,0 +1,60 @@
+package rocserv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var lines []string
+	accessLogf = func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	defer func() {
+		accessLogf = slog.Infof
+		setAccessLog(false, "")
+	}()
+
+	h := processorMiddleware("api", accessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})))
+
+	// 默认关闭
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/echo", nil))
+	if len(lines) != 0 {
+		t.Fatalf("access log should be disabled, got:%v", lines)
+	}
+
+	if err := setAccessLog(true, ""); err != nil {
+		t.Fatalf("set access log err:%s", err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/echo", nil))
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], AccessLogID+"\tprocessor:api method:GET path:/v1/echo status:404 ") {
+		t.Fatalf("text access log:%v", lines)
+	}
+
+	if err := setAccessLog(true, ACCESS_LOG_FORMAT_JSON); err != nil {
+		t.Fatalf("set access log err:%s", err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/echo", nil))
+	if len(lines) != 2 {
+		t.Fatalf("json access log:%v", lines)
+	}
+	var e accessLogEntry
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], AccessLogID+"\t")), &e); err != nil {
+		t.Fatalf("unmarshal %s err:%s", lines[1], err)
+	}
+	if e.Processor != "api" || e.Method != "POST" || e.Path != "/v1/echo" || e.Status != http.StatusNotFound {
+		t.Errorf("entry:%+v", e)
+	}
+
+	if err := setAccessLog(true, "xml"); err == nil {
+		t.Errorf("unknown format should fail")
+	}
+}
@@ -0,0 +1,81 @@
+package rocserv
+
+import (
+	"fmt"
+	"net"
+)
+
+// checkAdvertiseAddr 检查注册的地址是否可以被其他机器访问，回环及通配地址注册后无法使用
+func checkAdvertiseAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("advertise addr:%s invalid: %s", addr, err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("advertise addr:%s is not an ip, configure a routable listen ip", addr)
+	}
+
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return fmt.Errorf("advertise addr:%s is not routable, no routable ip found on this host, configure a routable listen ip in processor addr", addr)
+	}
+
+	return nil
+}
+
+// advertiseAddr 注册到服务发现的地址，端口使用监听的端口；
+// 配置了advertiseIP时使用该ip，监听通配地址时使用ips中同协议族优先的第一个可路由ip，ipv6地址加[]
+func advertiseAddr(addr, advertiseIP string, ips func() []net.IP) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if len(advertiseIP) > 0 {
+		return net.JoinHostPort(advertiseIP, port)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsUnspecified() {
+		return net.JoinHostPort(host, port)
+	}
+
+	if rip := routableIP(ips(), ip.To4() == nil); rip != nil {
+		return net.JoinHostPort(rip.String(), port)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// routableIP 选择可路由的ip，优先ipv6为preferIPv6的地址
+func routableIP(ips []net.IP, preferIPv6 bool) net.IP {
+	var other net.IP
+	for _, ip := range ips {
+		if !ip.IsGlobalUnicast() {
+			continue
+		}
+		if (ip.To4() == nil) == preferIPv6 {
+			return ip
+		}
+		if other == nil {
+			other = ip
+		}
+	}
+	return other
+}
+
+// interfaceIPs 本机网卡上的ip
+func interfaceIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips
+}
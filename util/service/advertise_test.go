@@ -0,0 +1,71 @@
+package rocserv
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestCheckAdvertiseAddr(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8080", "0.0.0.0:8080", "[::1]:8080", "[::]:8080", "localhost:8080"} {
+		if err := checkAdvertiseAddr(addr); err == nil {
+			t.Errorf("addr:%s should not be routable", addr)
+		}
+	}
+
+	if err := checkAdvertiseAddr("10.1.2.3:8080"); err != nil {
+		t.Errorf("routable addr err:%s", err)
+	}
+}
+
+func TestStrictAdvertiseLoopback(t *testing.T) {
+	m := NewService()
+	m.strictAdvertise = true
+
+	procs := map[string]Processor{
+		"http": &testProcessor{addr: "127.0.0.1:", driver: httprouter.New()},
+	}
+
+	_, err := m.loadDriver(nil, procs, nil)
+	if err == nil {
+		t.Fatalf("strict advertise should fail on loopback")
+	}
+	if !strings.Contains(err.Error(), "not routable") {
+		t.Errorf("unhelpful err:%s", err)
+	}
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	ips := func() []net.IP {
+		return []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("fe80::1"), net.ParseIP("2001:db8::1"), net.ParseIP("10.1.2.3")}
+	}
+
+	for _, c := range []struct {
+		addr, advertiseIP, want string
+	}{
+		{"10.1.2.3:8080", "", "10.1.2.3:8080"},
+		{"0.0.0.0:8080", "", "10.1.2.3:8080"},
+		{"[::]:8080", "", "[2001:db8::1]:8080"},
+		{"0.0.0.0:8080", "192.168.1.10", "192.168.1.10:8080"},
+		{"10.1.2.3:8080", "2001:db8::2", "[2001:db8::2]:8080"},
+		{"[2001:db8::1]:8080", "", "[2001:db8::1]:8080"},
+	} {
+		if got := advertiseAddr(c.addr, c.advertiseIP, ips); got != c.want {
+			t.Errorf("addr:%s advertise ip:%s got:%s want:%s", c.addr, c.advertiseIP, got, c.want)
+		}
+	}
+
+	// 只有回环地址时保留通配地址，由checkAdvertiseAddr告警
+	loopback := func() []net.IP { return []net.IP{net.ParseIP("127.0.0.1")} }
+	if got := advertiseAddr("0.0.0.0:8080", "", loopback); got != "0.0.0.0:8080" {
+		t.Errorf("got:%s", got)
+	}
+
+	// 只有另一协议族的ip时使用该ip
+	v4 := func() []net.IP { return []net.IP{net.ParseIP("10.1.2.3")} }
+	if got := advertiseAddr("[::]:8080", "", v4); got != "10.1.2.3:8080" {
+		t.Errorf("got:%s", got)
+	}
+}
@@ -5,19 +5,42 @@
 package rocserv
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/shawnfeng/sutil/slog"
 	"github.com/shawnfeng/sutil/snetutil"
 	"gitlab.pri.ibanyu.com/middleware/seaweed/xfile"
 )
 
+const (
+	defaultBackdoorAddr = "0.0.0.0:60000"
+	// 后门端口被占用时依次尝试之后的端口数量，都失败则使用系统分配的端口
+	backdoorPortRetries = 10
+)
+
 type backDoorHttp struct {
+	addr string
+	// 注册/backdoor/debug/pprof/*，默认关闭，避免误暴露
+	pprof bool
+	// 注册/backdoor/metrics，默认关闭
+	metrics bool
+	// 和就绪检查返回相同结果的路径
+	extraHealthPaths []string
+	// 注册/backdoor/restart，配置了后门token时才开启，避免能访问后门端口的任意主机停止进程
+	restart bool
 }
 
 var (
@@ -25,6 +48,12 @@ var (
 	startUpTime string
 )
 
+// 编译时通过 -ldflags "-X github.com/shawnfeng/roc/util/service.BuildVersion=xxx" 设置
+var (
+	BuildVersion string
+	BuildCommit  string
+)
+
 func (m *backDoorHttp) Init() error {
 	if len(os.Args) > 0 {
 		filePath, err := os.Executable()
@@ -42,15 +71,122 @@ func (m *backDoorHttp) Init() error {
 func (m *backDoorHttp) Driver() (string, interface{}) {
 	//fun := "backDoorHttp.Driver -->"
 
-	router := httprouter.New()
-	// 重启
-	//router.POST("/restart", snetutil.HttpRequestWrapper(FactoryRestart))
+	router := NewHttpRouter()
+	// 重启，优雅下线后退出进程，由进程管理方拉起
+	if m.restart {
+		router.POST("/backdoor/restart", backdoorAuth(handleRestart))
+	}
 	router.GET("/backdoor/health/check", snetutil.HttpRequestWrapper(FactoryHealthCheck))
+	// 存活检查，进程能处理请求即返回200
+	router.GET("/backdoor/health/live", handleLive)
+	// 就绪检查，服务完成注册、续约正常且依赖可用时返回200
+	router.GET("/backdoor/health/ready", handleReady)
 
 	// 获取实例md5值
 	router.GET("/backdoor/md5", snetutil.HttpRequestWrapper(FactoryMD5))
 
-	return "0.0.0.0:60000", router
+	// 获取实例信息，服务名、版本、已注册的processor等
+	router.GET("/backdoor/info", snetutil.HttpRequestWrapper(FactoryInfo))
+
+	// 查看或变更实例分组, 变更使用 POST /backdoor/group?set=canary
+	router.GET("/backdoor/group", backdoorAuth(handleGroup))
+	router.POST("/backdoor/group", backdoorAuth(handleSetGroup))
+
+	// 查看业务流量状态，暂停或恢复使用 POST /backdoor/traffic?pause=1，不从服务发现摘除
+	router.GET("/backdoor/traffic", backdoorAuth(handleTraffic))
+	router.POST("/backdoor/traffic", backdoorAuth(handleSetTraffic))
+
+	// 落盘日志并推送metrics，下线节点前调用
+	router.POST("/backdoor/flush", backdoorAuth(handleFlush))
+
+	// 查看各processor生效的中间件
+	router.GET("/backdoor/middleware", backdoorAuth(handleMiddleware))
+
+	// 查看各processor的SLO达成情况，目标见 [slo] 配置
+	router.GET("/backdoor/slo", handleSlo)
+
+	// 查看各processor注册的路由，httprouter需使用NewHttpRouter创建
+	router.GET("/backdoor/routes", backdoorAuth(handleRoutes))
+
+	// 查看或变更日志级别, POST body: {"level":"DEBUG"}
+	router.GET("/backdoor/log/level", handleGetLogLevel)
+	router.POST("/backdoor/log/level", backdoorAuth(handleSetLogLevel))
+
+	// 查看合并环境配置后生效的配置
+	router.GET("/backdoor/config", backdoorAuth(handleConfig))
+
+	if m.pprof {
+		registerPprof(router)
+	}
+
+	if m.metrics {
+		router.Handler("GET", "/backdoor/metrics", promhttp.Handler())
+	}
+
+	for _, path := range m.extraHealthPaths {
+		router.GET(path, handleReady)
+	}
+
+	if len(m.addr) == 0 {
+		return defaultBackdoorAddr, router
+	}
+	return m.addr, router
+}
+
+// checkExtraHealthPaths 去掉无效及重复的路径，不能使用 /backdoor/ 下的路径及通配符，避免和后门路由冲突
+func checkExtraHealthPaths(paths []string) []string {
+	fun := "checkExtraHealthPaths -->"
+
+	var valid []string
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if len(path) == 0 || seen[path] {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "/backdoor/") || strings.ContainsAny(path, ":*") {
+			slog.Warnf("%s invalid health path:%s ignored", fun, path)
+			continue
+		}
+		seen[path] = true
+		valid = append(valid, path)
+	}
+	return valid
+}
+
+// resolveBackdoorAddr 依次使用启动参数、配置中的地址，都为空时使用默认地址
+func resolveBackdoorAddr(flagAddr, confAddr string) (string, error) {
+	addr := flagAddr
+	if len(addr) == 0 {
+		addr = confAddr
+	}
+	if len(addr) == 0 {
+		return defaultBackdoorAddr, nil
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", fmt.Errorf("invalid backdoor addr:%s err:%s", addr, err)
+	}
+	return addr, nil
+}
+
+// backdoorAddrs 后门依次尝试监听的地址，最后为系统分配的端口
+func backdoorAddrs(addr string) []string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil || p == 0 {
+		return []string{addr}
+	}
+
+	addrs := make([]string, 0, backdoorPortRetries+1)
+	for i := 0; i < backdoorPortRetries && p+i <= 65535; i++ {
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(p+i)))
+	}
+	return append(addrs, host+":")
 }
 
 // ==============================
@@ -62,13 +198,44 @@ func FactoryRestart() snetutil.HandleRequest {
 }
 
 func (m *Restart) Handle(r *snetutil.HttpRequest) snetutil.HttpResponse {
-
 	slog.Infof("RECEIVE RESTART COMMAND")
-	os.Exit(0)
-	// 这里的代码执行不到了，因为之前已经退出了
+	restartService()
 	return snetutil.NewHttpRespString(200, "{}")
 }
 
+// exitProcess 方便测试替换
+var exitProcess = os.Exit
+
+var restartOnce sync.Once
+
+// restartService 异步下线服务(摘除注册、停止接收新请求、等待进行中的请求、落盘日志)后退出，
+// 先返回响应，避免后门在响应前被关闭
+func restartService() {
+	restartOnce.Do(func() {
+		go func() {
+			fun := "restartService -->"
+
+			ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+			defer cancel()
+
+			if err := service.Shutdown(ctx); err != nil {
+				slog.Errorf("%s shutdown err:%s", fun, err)
+			}
+			slog.Infof("%s exit", fun)
+			syncLog()
+			exitProcess(0)
+		}()
+	})
+}
+
+func handleRestart(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	fun := "handleRestart -->"
+
+	slog.Warnf("%s receive restart command, remote:%s", fun, r.RemoteAddr)
+	restartService()
+	writeJSON(w, http.StatusOK, map[string]string{"status": "restarting"})
+}
+
 // ==============================
 type HealthCheck struct {
 }
@@ -81,7 +248,63 @@ func (m *HealthCheck) Handle(r *snetutil.HttpRequest) snetutil.HttpResponse {
 	fun := "HealthCheck -->"
 	slog.Infof("%s in", fun)
 
-	return snetutil.NewHttpRespString(200, "{}")
+	if sb, ok := GetServBase().(*ServBaseV2); ok {
+		report := sb.readiness()
+		if report.State != HEALTH_STATE_HEALTHY {
+			s, _ := json.Marshal(report)
+			slog.Warnf("%s state:%s report:%s", fun, report.State, s)
+			return snetutil.NewHttpRespString(healthStatusCodes.get(report.State), string(s))
+		}
+	}
+
+	if ok, status := service.healthChecks.run(); !ok {
+		s, _ := json.Marshal(status)
+		slog.Warnf("%s health check failed:%v", fun, failedChecks(status))
+		return snetutil.NewHttpRespString(http.StatusServiceUnavailable, string(s))
+	}
+
+	if isTrafficPaused() {
+		return snetutil.NewHttpRespString(healthStatusCodes.get(HEALTH_STATE_HEALTHY), `{"paused":true}`)
+	}
+	return snetutil.NewHttpRespString(healthStatusCodes.get(HEALTH_STATE_HEALTHY), "{}")
+}
+
+func handleLive(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]bool{"live": true})
+}
+
+// handleReady 服务注册完成前返回503，避免监听已启动但还未注册的实例接收流量
+func handleReady(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	fun := "handleReady -->"
+
+	select {
+	case <-service.Ready():
+	default:
+		writeJSON(w, http.StatusServiceUnavailable, &healthReport{State: HEALTH_STATE_STARTING})
+		return
+	}
+
+	report := &healthReport{Ready: true, State: HEALTH_STATE_HEALTHY, Registry: healthStatusOK}
+	if sb, ok := GetServBase().(*ServBaseV2); ok {
+		report = sb.readiness()
+	}
+
+	if ok, status := service.healthChecks.run(); len(status) > 0 {
+		report.Checks = status
+		if !ok {
+			report.Ready = false
+			if report.State == HEALTH_STATE_HEALTHY || report.State == HEALTH_STATE_DEGRADED {
+				report.State = HEALTH_STATE_UNHEALTHY
+			}
+		}
+	}
+
+	if !report.Ready {
+		slog.Warnf("%s not ready, state:%s", fun, report.State)
+		writeJSON(w, http.StatusServiceUnavailable, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
 }
 
 //MD5 ...
@@ -104,3 +327,98 @@ func (m *MD5) Handle(r *snetutil.HttpRequest) snetutil.HttpResponse {
 	s, _ := json.Marshal(res)
 	return snetutil.NewHttpRespString(200, string(s))
 }
+
+// ==============================
+type Info struct {
+}
+
+func FactoryInfo() snetutil.HandleRequest {
+	return new(Info)
+}
+
+type processorInfo struct {
+	Type string `json:"type"`
+	Addr string `json:"addr"`
+}
+
+func (m *Info) Handle(r *snetutil.HttpRequest) snetutil.HttpResponse {
+	res := struct {
+		Servname   string                   `json:"servname"`
+		Servid     int                      `json:"servid"`
+		Group      string                   `json:"group"`
+		Version    string                   `json:"version"`
+		Commit     string                   `json:"commit"`
+		GoVersion  string                   `json:"go_version"`
+		Hostname   string                   `json:"hostname"`
+		Pid        int                      `json:"pid"`
+		Md5        string                   `json:"md5"`
+		StartUp    string                   `json:"start_up"`
+		Processors map[string]processorInfo `json:"processors"`
+	}{
+		Version:    BuildVersion,
+		Commit:     BuildCommit,
+		GoVersion:  runtime.Version(),
+		Pid:        os.Getpid(),
+		Md5:        serviceMD5,
+		StartUp:    startUpTime,
+		Processors: make(map[string]processorInfo),
+	}
+	res.Hostname, _ = os.Hostname()
+
+	if sb := GetServBase(); sb != nil {
+		res.Servname = sb.Servname()
+		res.Servid = sb.Servid()
+	}
+	if sb, ok := GetServBase().(*ServBaseV2); ok {
+		res.Group = sb.Group()
+	}
+
+	for n, info := range service.registeredInfos() {
+		res.Processors[n] = processorInfo{Type: info.Type, Addr: info.Addr}
+	}
+
+	s, _ := json.Marshal(res)
+	return snetutil.NewHttpRespString(200, string(s))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	s, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(s)
+}
+
+func handleGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	sb, ok := GetServBase().(*ServBaseV2)
+	if !ok {
+		writeJSON(w, 503, map[string]string{"error": "service not init"})
+		return
+	}
+
+	writeJSON(w, 200, map[string]string{"group": sb.Group()})
+}
+
+// handleSetGroup 变更实例分组，只接受POST，避免爬虫、预取等GET请求误变更
+func handleSetGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	fun := "handleSetGroup -->"
+
+	sb, ok := GetServBase().(*ServBaseV2)
+	if !ok {
+		writeJSON(w, 503, map[string]string{"error": "service not init"})
+		return
+	}
+
+	group := r.FormValue("set")
+	if len(group) == 0 {
+		writeJSON(w, 400, map[string]string{"error": "set required"})
+		return
+	}
+
+	slog.Infof("%s change group from:%s to:%s remote:%s", fun, sb.Group(), group, r.RemoteAddr)
+	if err := sb.ChangeGroup(group); err != nil {
+		writeJSON(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, 200, map[string]string{"group": sb.Group()})
+}
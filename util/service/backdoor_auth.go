@@ -0,0 +1,124 @@
+package rocserv
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	BACKDOOR_TOKEN_HEADER = "X-Backdoor-Token"
+)
+
+// backdoorToken 后门管理接口的token，可以从文件(如挂载的secret)中加载并在配置变更时轮换
+type backdoorToken struct {
+	token atomic.Value
+	// 为1时配置了token文件，token加载成功前拒绝所有请求
+	required int32
+}
+
+var backdoorAuthToken = &backdoorToken{}
+
+func (m *backdoorToken) set(token string) {
+	m.token.Store(token)
+}
+
+// require 配置了token文件时调用，之后token为空时不再放行
+func (m *backdoorToken) require() {
+	atomic.StoreInt32(&m.required, 1)
+}
+
+func (m *backdoorToken) isRequired() bool {
+	return atomic.LoadInt32(&m.required) == 1
+}
+
+// get 未设置token时返回空，此时不做鉴权
+func (m *backdoorToken) get() string {
+	token, _ := m.token.Load().(string)
+	return token
+}
+
+// load 从文件读取token，内容变化时替换，旧token立即失效；文件为空时返回错误，保留之前的token
+func (m *backdoorToken) load(path string) error {
+	fun := "backdoorToken.load -->"
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	token := strings.TrimSpace(string(data))
+	if len(token) == 0 {
+		return fmt.Errorf("token file:%s empty", path)
+	}
+	if token != m.get() {
+		slog.Infof("%s backdoor token rotated, file:%s", fun, path)
+		m.set(token)
+	}
+	return nil
+}
+
+// watchBackdoorToken 按配置 [backdoor] tokenfile 加载token，配置变更时重新加载，
+// 通过修改tokenfile指向新的secret文件(或变更配置触发重新读取)轮换token，旧token立即失效；
+// 配置中去掉tokenfile时保留当前token，需重启才关闭鉴权。sb停止时不再回调
+func watchBackdoorToken(sb ServBase, tk *backdoorToken) error {
+	fun := "watchBackdoorToken -->"
+
+	load := func() error {
+		var backdoorConfig BackdoorConfig
+		if err := sb.ServConfig(&backdoorConfig); err != nil {
+			slog.Errorf("%s serv config err:%s", fun, err)
+			return err
+		}
+
+		path := backdoorConfig.Backdoor.TokenFile
+		if len(path) == 0 {
+			if tk.isRequired() {
+				slog.Warnf("%s token file removed from config, keep current token until restart", fun)
+			}
+			return nil
+		}
+
+		// 配置了token文件后不再放行未鉴权的请求，加载失败时拒绝所有请求，直到配置变更后重新加载成功
+		tk.require()
+		if err := tk.load(path); err != nil {
+			slog.Errorf("%s load token file:%s err:%s, reject backdoor admin requests until loaded", fun, path, err)
+			return err
+		}
+		slog.Infof("%s backdoor auth enabled, token file:%s", fun, path)
+		return nil
+	}
+
+	sb.WatchConfig(func(newRaw []byte) {
+		load()
+	})
+	return load()
+}
+
+func (m *backdoorToken) check(r *http.Request) bool {
+	token := m.get()
+	if len(token) == 0 {
+		return !m.isRequired()
+	}
+
+	got := r.Header.Get(BACKDOOR_TOKEN_HEADER)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// backdoorAuth 后门管理接口鉴权，每次请求读取当前token
+func backdoorAuth(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if !backdoorAuthToken.check(r) {
+			slog.Warnf("backdoorAuth --> unauthorized request path:%s remote:%s", r.URL.Path, r.RemoteAddr)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		h(w, r, ps)
+	}
+}
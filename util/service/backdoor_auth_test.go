@@ -0,0 +1,162 @@
+package rocserv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestBackdoorTokenRotate(t *testing.T) {
+	defer backdoorAuthToken.set("")
+
+	dir, err := ioutil.TempDir("", "backdoor")
+	if err != nil {
+		t.Fatalf("temp dir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	h := backdoorAuth(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	})
+	call := func(token string) int {
+		r := httptest.NewRequest("GET", "/backdoor/group", nil)
+		r.Header.Set(BACKDOOR_TOKEN_HEADER, token)
+		w := httptest.NewRecorder()
+		h(w, r, nil)
+		return w.Code
+	}
+
+	ioutil.WriteFile(path, []byte("old-token\n"), 0600)
+	if err := backdoorAuthToken.load(path); err != nil {
+		t.Fatalf("load err:%s", err)
+	}
+	if code := call("old-token"); code != http.StatusOK {
+		t.Errorf("old token code:%d", code)
+	}
+	if code := call("bad"); code != http.StatusUnauthorized {
+		t.Errorf("bad token code:%d", code)
+	}
+
+	ioutil.WriteFile(path, []byte("new-token\n"), 0600)
+	if err := backdoorAuthToken.load(path); err != nil {
+		t.Fatalf("reload err:%s", err)
+	}
+	if code := call("old-token"); code != http.StatusUnauthorized {
+		t.Errorf("rotated old token code:%d", code)
+	}
+	if code := call("new-token"); code != http.StatusOK {
+		t.Errorf("new token code:%d", code)
+	}
+}
+
+func TestBackdoorTokenRequired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backdoor")
+	if err != nil {
+		t.Fatalf("temp dir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	check := func(tk *backdoorToken, token string) bool {
+		r := httptest.NewRequest("GET", "/backdoor/restart", nil)
+		r.Header.Set(BACKDOOR_TOKEN_HEADER, token)
+		return tk.check(r)
+	}
+
+	// 没有配置token文件时不鉴权
+	tk := &backdoorToken{}
+	if !check(tk, "") {
+		t.Errorf("no token file should not require auth")
+	}
+
+	// 配置的token文件无法读取时拒绝所有请求
+	tk.require()
+	if err := tk.load(path); err == nil {
+		t.Fatalf("load missing file should fail")
+	}
+	if check(tk, "") {
+		t.Errorf("unloaded token file should reject requests")
+	}
+
+	ioutil.WriteFile(path, []byte("\n"), 0600)
+	if err := tk.load(path); err == nil {
+		t.Errorf("load empty file should fail")
+	}
+	if check(tk, "") {
+		t.Errorf("empty token file should reject requests")
+	}
+
+	// 重新加载成功后使用文件中的token
+	ioutil.WriteFile(path, []byte("token\n"), 0600)
+	if err := tk.load(path); err != nil {
+		t.Fatalf("load err:%s", err)
+	}
+	if !check(tk, "token") || check(tk, "") {
+		t.Errorf("loaded token should be checked")
+	}
+}
+
+func TestBackdoorTokenConfigWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backdoor")
+	if err != nil {
+		t.Fatalf("temp dir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	oldPath := filepath.Join(dir, "token-v1")
+	newPath := filepath.Join(dir, "token-v2")
+	ioutil.WriteFile(oldPath, []byte("old-token\n"), 0600)
+	ioutil.WriteFile(newPath, []byte("new-token\n"), 0600)
+
+	config := func(path string) string {
+		return fmt.Sprintf("[backdoor]\ntokenfile = %q\n", path)
+	}
+	sb, err := NewTestServBase("base/test", 1, config(oldPath))
+	if err != nil {
+		t.Fatalf("new servbase err:%s", err)
+	}
+
+	check := func(tk *backdoorToken, token string) bool {
+		r := httptest.NewRequest("POST", "/backdoor/restart", nil)
+		r.Header.Set(BACKDOOR_TOKEN_HEADER, token)
+		return tk.check(r)
+	}
+
+	tk := &backdoorToken{}
+	if err := watchBackdoorToken(sb, tk); err != nil {
+		t.Fatalf("watch token err:%s", err)
+	}
+	if !check(tk, "old-token") || check(tk, "") {
+		t.Errorf("old token should be checked")
+	}
+
+	// 配置指向新的token文件后旧token立即失效
+	if err := sb.SetConfig(config(newPath)); err != nil {
+		t.Fatalf("set config err:%s", err)
+	}
+	if check(tk, "old-token") || !check(tk, "new-token") {
+		t.Errorf("rotated token should be checked")
+	}
+
+	// 新的文件无法读取时保留当前token
+	if err := sb.SetConfig(config(filepath.Join(dir, "missing"))); err != nil {
+		t.Fatalf("set config err:%s", err)
+	}
+	if !check(tk, "new-token") {
+		t.Errorf("token should be kept when reload failed")
+	}
+
+	// 去掉配置时不关闭鉴权
+	if err := sb.SetConfig("[backdoor]\n"); err != nil {
+		t.Fatalf("set config err:%s", err)
+	}
+	if !check(tk, "new-token") || check(tk, "") {
+		t.Errorf("token should be kept when token file removed")
+	}
+}
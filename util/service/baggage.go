@@ -0,0 +1,29 @@
+package rocserv
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// SetBaggage 在ctx的span上设置baggage，随trace传递给之后所有的下游调用，
+// 如实验id等需要在整个调用链上透传的数据，ctx中没有span时返回错误
+func SetBaggage(ctx context.Context, key, value string) error {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return fmt.Errorf("set baggage key:%s no span in context", key)
+	}
+
+	span.SetBaggageItem(key, value)
+	return nil
+}
+
+// GetBaggage 获取上游或本服务设置的baggage，不存在时返回空
+func GetBaggage(ctx context.Context, key string) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	return span.BaggageItem(key)
+}
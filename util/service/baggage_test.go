@@ -0,0 +1,53 @@
+package rocserv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestBaggagePropagation(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	if err := SetBaggage(context.Background(), "experiment", "a"); err == nil {
+		t.Errorf("set baggage without span should fail")
+	}
+
+	// 上游设置baggage后调用本服务
+	span := tracer.StartSpan("upstream")
+	upstream := opentracing.ContextWithSpan(context.Background(), span)
+	if err := SetBaggage(upstream, "experiment", "exp-42"); err != nil {
+		t.Fatalf("set baggage err:%s", err)
+	}
+
+	// 本服务收到的请求及其发起的下游调用
+	hop := func(ctx context.Context) context.Context {
+		header := thriftHeader(ctx, "echo")
+		carrier := opentracing.TextMapCarrier{}
+		for k := range header {
+			carrier[k] = header.Get(k)
+		}
+		spanCtx, err := tracer.Extract(opentracing.TextMap, carrier)
+		if err != nil {
+			t.Fatalf("extract err:%s", err)
+		}
+		return opentracing.ContextWithSpan(context.Background(), tracer.StartSpan("echo", opentracing.ChildOf(spanCtx)))
+	}
+
+	inbound := hop(upstream)
+	if v := GetBaggage(inbound, "experiment"); v != "exp-42" {
+		t.Errorf("inbound baggage:%s", v)
+	}
+
+	outbound := hop(inbound)
+	if v := GetBaggage(outbound, "experiment"); v != "exp-42" {
+		t.Errorf("outbound baggage:%s", v)
+	}
+	if v := GetBaggage(outbound, "missing"); v != "" {
+		t.Errorf("missing baggage:%s", v)
+	}
+}
@@ -0,0 +1,86 @@
+package rocserv
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// BalancePolicy 在服务发现得到的可用实例中选择一个，key为servLoc/processor
+type BalancePolicy interface {
+	Pick(key string, servs []*ServInfo) *ServInfo
+}
+
+type roundRobinPolicy struct {
+	mu   sync.Mutex
+	next map[string]*uint64
+}
+
+// NewRoundRobinPolicy 在实例间轮询
+func NewRoundRobinPolicy() BalancePolicy {
+	return newRoundRobinPolicy()
+}
+
+func newRoundRobinPolicy() *roundRobinPolicy {
+	return &roundRobinPolicy{
+		next: make(map[string]*uint64),
+	}
+}
+
+func (m *roundRobinPolicy) Pick(key string, servs []*ServInfo) *ServInfo {
+	m.mu.Lock()
+	next, ok := m.next[key]
+	if !ok {
+		next = new(uint64)
+		m.next[key] = next
+	}
+	m.mu.Unlock()
+
+	idx := atomic.AddUint64(next, 1)
+	return servs[idx%uint64(len(servs))]
+}
+
+type randomPolicy struct {
+	roll func(n int) int
+}
+
+// NewRandomPolicy 随机选择实例
+func NewRandomPolicy() BalancePolicy {
+	return &randomPolicy{roll: rand.Intn}
+}
+
+func (m *randomPolicy) Pick(key string, servs []*ServInfo) *ServInfo {
+	return servs[m.roll(len(servs))]
+}
+
+type weightedPolicy struct {
+	roll func(n int) int
+}
+
+// NewWeightedPolicy 按实例注册的Weight随机选择，未注册权重的实例按DEFAULT_SERV_WEIGHT计算
+func NewWeightedPolicy() BalancePolicy {
+	return &weightedPolicy{roll: rand.Intn}
+}
+
+func servWeight(s *ServInfo) int {
+	if s.Weight <= 0 {
+		return DEFAULT_SERV_WEIGHT
+	}
+	return s.Weight
+}
+
+func (m *weightedPolicy) Pick(key string, servs []*ServInfo) *ServInfo {
+	total := 0
+	for _, s := range servs {
+		total += servWeight(s)
+	}
+
+	n := m.roll(total)
+	for _, s := range servs {
+		n -= servWeight(s)
+		if n < 0 {
+			return s
+		}
+	}
+	return servs[len(servs)-1]
+}
@@ -0,0 +1,233 @@
+package rocserv
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/consistent"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// servLookup 获取服务某个processor的所有可用实例
+type servLookup interface {
+	GetAllServAddr(processor string) []*ServInfo
+}
+
+func newEtcdServLookup(servLoc string) (servLookup, error) {
+	sb, ok := GetServBase().(*ServBaseV2)
+	if !ok {
+		return nil, fmt.Errorf("service not init")
+	}
+	return NewClientEtcdV2(sb.confEtcd, servLoc)
+}
+
+// Balancer 在服务发现得到的实例间做负载均衡，按servLoc缓存服务发现的client
+type Balancer struct {
+	newLookup func(servLoc string) (servLookup, error)
+
+	mu      sync.Mutex
+	lookups map[string]servLookup
+	rr      *roundRobinPolicy
+	rings   map[string]*hashRing
+
+	outlier *outlierDetector
+	breaker *circuitBreaker
+	// 优先选择和调用方同zone的实例
+	zoneAware bool
+	// GetConn使用的选择策略，为空时轮询
+	policy BalancePolicy
+
+	roll func(n int) int
+}
+
+// hashRing 实例列表不变时复用的一致性hash环
+type hashRing struct {
+	sign  string
+	hash  *consistent.Consistent
+	servs map[string]*ServInfo
+}
+
+func NewBalancer() *Balancer {
+	return &Balancer{
+		newLookup: newEtcdServLookup,
+		lookups:   make(map[string]servLookup),
+		rr:        newRoundRobinPolicy(),
+		rings:     make(map[string]*hashRing),
+		outlier:   newOutlierDetector(),
+		breaker:   newCircuitBreaker(),
+		roll:      rand.Intn,
+	}
+}
+
+// NewBalancerWithPolicy GetConn使用指定的策略选择实例，如NewWeightedPolicy
+func NewBalancerWithPolicy(policy BalancePolicy) *Balancer {
+	b := NewBalancer()
+	b.policy = policy
+	return b
+}
+
+// NewZoneAwareBalancer 优先选择和本实例同zone的实例，同zone没有可用实例时才使用其他zone的实例，
+// 本实例的zone来自配置 [balancer] zone 或环境变量 ROC_ZONE
+func NewZoneAwareBalancer() *Balancer {
+	b := NewBalancer()
+	b.zoneAware = true
+	return b
+}
+
+func (m *Balancer) lookup(servLoc string) (servLookup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, ok := m.lookups[servLoc]; ok {
+		return l, nil
+	}
+
+	l, err := m.newLookup(servLoc)
+	if err != nil {
+		return nil, err
+	}
+	m.lookups[servLoc] = l
+	return l, nil
+}
+
+func (m *Balancer) servs(servLoc, processor string) ([]*ServInfo, error) {
+	servs, _, err := m.splitServs(servLoc, processor, m.roll)
+	return servs, err
+}
+
+// splitServs 按roll选择canary或stable的实例后过滤，返回是否选择了canary
+func (m *Balancer) splitServs(servLoc, processor string, roll func(int) int) ([]*ServInfo, bool, error) {
+	l, err := m.lookup(servLoc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	servs := l.GetAllServAddr(processor)
+	if len(servs) == 0 {
+		return nil, false, fmt.Errorf("no available instance, serv:%s processor:%s", servLoc, processor)
+	}
+	servs, canary := splitCanary(l, processor, servs, roll)
+	servs = m.outlier.filter(servLoc+"/"+processor, servs)
+	servs = m.breaker.filter(servLoc+"/"+processor, servs)
+	if len(servs) == 0 {
+		return nil, false, ErrCircuitOpen
+	}
+	if m.zoneAware {
+		servs = preferZone(getLocalZone(), servs)
+	}
+	return servs, canary, nil
+}
+
+// Report 上报请求结果，用于摘除错误率或耗时异常的实例及熔断，addr为Next/NextForKey/GetConn返回实例的Addr
+func (m *Balancer) Report(servLoc, processor, addr string, err error, latency time.Duration) {
+	m.outlier.report(servLoc+"/"+processor, addr, err, latency)
+	m.breaker.report(servLoc+"/"+processor, addr, err)
+}
+
+// Do 用GetConn选择的实例执行fn并上报结果，实例熔断中时返回ErrCircuitOpen，不执行fn
+func (m *Balancer) Do(servLoc, processor string, fn func(s *ServInfo) error) error {
+	s, err := m.GetConn(servLoc, processor)
+	if err != nil {
+		return err
+	}
+
+	if !m.breaker.allow(servLoc+"/"+processor, s.Addr) {
+		return ErrCircuitOpen
+	}
+
+	st := time.Now()
+	err = fn(s)
+	m.Report(servLoc, processor, s.Addr, err, time.Since(st))
+	return err
+}
+
+// Next 在实例间轮询
+func (m *Balancer) Next(servLoc, processor string) (*ServInfo, error) {
+	servs, err := m.servs(servLoc, processor)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.rr.Pick(servLoc+"/"+processor, servs), nil
+}
+
+// GetConn 按Balancer的策略选择实例，实例列表随etcd中的注册信息实时更新，
+// 下线或租约过期的实例不会被选中
+func (m *Balancer) GetConn(servLoc, processor string) (*ServInfo, error) {
+	servs, err := m.servs(servLoc, processor)
+	if err != nil {
+		return nil, err
+	}
+
+	key := servLoc + "/" + processor
+	if m.policy == nil {
+		return m.rr.Pick(key, servs), nil
+	}
+	return m.policy.Pick(key, servs), nil
+}
+
+// NextForKey 按key一致性hash选择实例，实例列表不变时同一key总是落到同一实例，
+// 实例增减时只有少量key会重新分配，适用于需要缓存亲和的场景；
+// 开启canary时按key的hash分流，同一key总是落到同一侧
+func (m *Balancer) NextForKey(servLoc, processor, key string) (*ServInfo, error) {
+	fun := "Balancer.NextForKey -->"
+
+	servs, canary, err := m.splitServs(servLoc, processor, keyRoll(key))
+	if err != nil {
+		return nil, err
+	}
+
+	// canary和stable分别使用各自的hash环，避免两侧交替时重建
+	ringKey := servLoc + "/" + processor
+	if canary {
+		ringKey += "/" + GROUP_CANARY
+	}
+	ring, err := m.ring(ringKey, servs)
+	if err != nil {
+		slog.Errorf("%s build hash ring serv:%s processor:%s err:%s", fun, servLoc, processor, err)
+		return nil, err
+	}
+
+	addr, err := ring.hash.Get(key)
+	if err != nil {
+		slog.Errorf("%s get serv:%s processor:%s key:%s err:%s", fun, servLoc, processor, key, err)
+		return nil, err
+	}
+	return ring.servs[addr], nil
+}
+
+// ring 实例列表变化时重建hash环
+func (m *Balancer) ring(key string, servs []*ServInfo) (*hashRing, error) {
+	addrs := make([]string, 0, len(servs))
+	servMap := make(map[string]*ServInfo, len(servs))
+	for _, s := range servs {
+		addrs = append(addrs, s.Addr)
+		servMap[s.Addr] = s
+	}
+	sort.Strings(addrs)
+	sign := strings.Join(addrs, ",")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r, ok := m.rings[key]; ok && r.sign == sign {
+		return r, nil
+	}
+
+	hash := consistent.NewWithElts(addrs)
+	if hash == nil {
+		return nil, fmt.Errorf("new consistent hash nil")
+	}
+
+	r := &hashRing{
+		sign:  sign,
+		hash:  hash,
+		servs: servMap,
+	}
+	m.rings[key] = r
+	return r, nil
+}
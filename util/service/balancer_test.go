@@ -0,0 +1,136 @@
+package rocserv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestBalancer(lookups map[string]servLookup) *Balancer {
+	b := NewBalancer()
+	b.newLookup = func(servLoc string) (servLookup, error) {
+		l, ok := lookups[servLoc]
+		if !ok {
+			return nil, fmt.Errorf("unknown serv:%s", servLoc)
+		}
+		return l, nil
+	}
+	return b
+}
+
+func TestBalancerNextForKey(t *testing.T) {
+	lookup := &testServLookup{}
+	for i := 0; i < 5; i++ {
+		lookup.servs = append(lookup.servs, &ServInfo{Type: PROCESSOR_HTTP, Addr: fmt.Sprintf("10.0.0.%d:80", i)})
+	}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	const keys = 1000
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		s, err := b.NextForKey("base/account", "proc_http", key)
+		if err != nil {
+			t.Fatalf("next for key err:%s", err)
+		}
+		again, _ := b.NextForKey("base/account", "proc_http", key)
+		if again.Addr != s.Addr {
+			t.Fatalf("key:%s mapped to %s then %s", key, s.Addr, again.Addr)
+		}
+		before[key] = s.Addr
+	}
+
+	// 去掉一个实例，只有原来落在该实例上的key需要重新分配
+	removed := lookup.servs[2].Addr
+	lookup.servs = append(lookup.servs[:2], lookup.servs[3:]...)
+
+	moved := 0
+	for key, addr := range before {
+		s, err := b.NextForKey("base/account", "proc_http", key)
+		if err != nil {
+			t.Fatalf("next for key err:%s", err)
+		}
+		if s.Addr == removed {
+			t.Fatalf("key:%s mapped to removed instance", key)
+		}
+		if s.Addr != addr {
+			if addr != removed {
+				t.Errorf("key:%s moved from surviving instance %s to %s", key, addr, s.Addr)
+			}
+			moved++
+		}
+	}
+	if moved == 0 || moved > keys/2 {
+		t.Errorf("unexpected moved keys:%d", moved)
+	}
+
+	if _, err := b.NextForKey("base/none", "proc_http", "user-1"); err == nil {
+		t.Errorf("unknown serv should fail")
+	}
+}
+
+func TestBalancerNext(t *testing.T) {
+	lookup := &testServLookup{servs: []*ServInfo{{Addr: "a"}, {Addr: "b"}}}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		s, err := b.Next("base/account", "proc_http")
+		if err != nil {
+			t.Fatalf("next err:%s", err)
+		}
+		seen[s.Addr]++
+	}
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("round robin got:%v", seen)
+	}
+}
+
+func TestBalancerGetConn(t *testing.T) {
+	lookup := &testServLookup{servs: []*ServInfo{{Addr: "a", Weight: 300}, {Addr: "b"}}}
+
+	// 按权重 a:300 b:100(默认)
+	policy := NewWeightedPolicy().(*weightedPolicy)
+	var n int
+	policy.roll = func(total int) int {
+		if total != 400 {
+			t.Fatalf("total weight:%d", total)
+		}
+		n = (n + 1) % total
+		return n
+	}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+	b.policy = policy
+
+	seen := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		s, err := b.GetConn("base/account", "proc_http")
+		if err != nil {
+			t.Fatalf("get conn err:%s", err)
+		}
+		seen[s.Addr]++
+	}
+	if seen["a"] != 300 || seen["b"] != 100 {
+		t.Errorf("weighted got:%v", seen)
+	}
+
+	// 实例下线后不再被选中
+	lookup.servs = lookup.servs[1:]
+	for i := 0; i < 10; i++ {
+		if s, _ := b.GetConn("base/account", "proc_http"); s.Addr != "b" {
+			t.Fatalf("removed instance picked:%s", s.Addr)
+		}
+	}
+
+	lookup.servs = nil
+	if _, err := b.GetConn("base/account", "proc_http"); err == nil {
+		t.Errorf("no instance should fail")
+	}
+
+	random := NewRandomPolicy().(*randomPolicy)
+	random.roll = func(n int) int { return n - 1 }
+	b = newTestBalancer(map[string]servLookup{"base/account": &testServLookup{servs: []*ServInfo{{Addr: "a"}, {Addr: "b"}}}})
+	b.policy = random
+	if s, _ := b.GetConn("base/account", "proc_http"); s.Addr != "b" {
+		t.Errorf("random got:%s", s.Addr)
+	}
+}
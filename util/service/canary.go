@@ -0,0 +1,82 @@
+package rocserv
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+)
+
+const (
+	// 灰度实例所在分组，通过 POST /backdoor/group?set=canary 设置
+	GROUP_CANARY = "canary"
+)
+
+// groupServLookup 可以按分组获取实例的服务发现
+type groupServLookup interface {
+	GetAllServAddrWithGroup(group, processor string) []*ServInfo
+}
+
+// canaryPercent 路由到canary分组的请求比例，0-100，由配置 [balancer] canarypercent 统一控制
+var canaryPercent int32
+
+func setCanaryPercent(p int) {
+	if p < 0 {
+		p = 0
+	}
+	if p > 100 {
+		p = 100
+	}
+	atomic.StoreInt32(&canaryPercent, int32(p))
+}
+
+func getCanaryPercent() int {
+	return int(atomic.LoadInt32(&canaryPercent))
+}
+
+// keyRoll 按key的hash得到[0,n)，同一key总是得到相同的结果，用于NextForKey保持亲和
+func keyRoll(key string) func(int) int {
+	return func(n int) int {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return int(h.Sum32() % uint32(n))
+	}
+}
+
+// splitCanary 按比例选择canary或stable(不在canary分组)的实例，某一侧没有实例时使用全部实例，
+// 返回的bool表示是否选择了canary
+func splitCanary(l servLookup, processor string, servs []*ServInfo, roll func(int) int) ([]*ServInfo, bool) {
+	percent := getCanaryPercent()
+	if percent <= 0 {
+		return servs, false
+	}
+
+	gl, ok := l.(groupServLookup)
+	if !ok {
+		return servs, false
+	}
+
+	canary := gl.GetAllServAddrWithGroup(GROUP_CANARY, processor)
+	if len(canary) == 0 {
+		return servs, false
+	}
+
+	if roll(100) < percent {
+		return canary, true
+	}
+
+	isCanary := make(map[string]bool, len(canary))
+	for _, s := range canary {
+		isCanary[s.Addr] = true
+	}
+
+	var stable []*ServInfo
+	for _, s := range servs {
+		if !isCanary[s.Addr] {
+			stable = append(stable, s)
+		}
+	}
+
+	if len(stable) == 0 {
+		return servs, false
+	}
+	return stable, false
+}
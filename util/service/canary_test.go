@@ -0,0 +1,100 @@
+package rocserv
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testGroupServLookup struct {
+	testServLookup
+	groups map[string][]*ServInfo
+}
+
+func (m *testGroupServLookup) GetAllServAddrWithGroup(group, processor string) []*ServInfo {
+	return m.groups[group]
+}
+
+func TestCanaryPercent(t *testing.T) {
+	setCanaryPercent(20)
+	defer setCanaryPercent(0)
+
+	canary := &ServInfo{Addr: "canary"}
+	lookup := &testGroupServLookup{
+		testServLookup: testServLookup{servs: []*ServInfo{{Addr: "stable1"}, {Addr: "stable2"}, canary}},
+		groups:         map[string][]*ServInfo{GROUP_CANARY: {canary}},
+	}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	const total = 10000
+	hits := 0
+	for i := 0; i < total; i++ {
+		s, err := b.Next("base/account", "proc_http")
+		if err != nil {
+			t.Fatalf("next err:%s", err)
+		}
+		if s.Addr == "canary" {
+			hits++
+		}
+	}
+
+	if pct := hits * 100 / total; pct < 17 || pct > 23 {
+		t.Errorf("canary percent:%d hits:%d", pct, hits)
+	}
+
+	// stable实例全部下线时使用canary实例
+	lookup.servs = []*ServInfo{canary}
+	for i := 0; i < 10; i++ {
+		s, err := b.Next("base/account", "proc_http")
+		if err != nil || s.Addr != "canary" {
+			t.Fatalf("fallback to canary got:%v err:%v", s, err)
+		}
+	}
+}
+
+func TestCanaryDisabled(t *testing.T) {
+	lookup := &testGroupServLookup{
+		testServLookup: testServLookup{servs: []*ServInfo{{Addr: "stable"}, {Addr: "canary"}}},
+		groups:         map[string][]*ServInfo{GROUP_CANARY: {{Addr: "canary"}}},
+	}
+
+	servs, _ := splitCanary(lookup, "proc_http", lookup.servs, func(int) int { return 0 })
+	if len(servs) != 2 {
+		t.Errorf("canary percent 0 should not split, servs:%v", fmt.Sprint(servs))
+	}
+}
+
+func TestCanaryNextForKey(t *testing.T) {
+	setCanaryPercent(20)
+	defer setCanaryPercent(0)
+
+	canary := &ServInfo{Addr: "canary"}
+	lookup := &testGroupServLookup{
+		testServLookup: testServLookup{servs: []*ServInfo{{Addr: "stable1"}, {Addr: "stable2"}, canary}},
+		groups:         map[string][]*ServInfo{GROUP_CANARY: {canary}},
+	}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	const total = 1000
+	hits := 0
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("user%d", i)
+		first, err := b.NextForKey("base/account", "proc_http", key)
+		if err != nil {
+			t.Fatalf("next for key err:%s", err)
+		}
+		// 开启canary后同一key仍然总是落到同一实例
+		for j := 0; j < 5; j++ {
+			s, err := b.NextForKey("base/account", "proc_http", key)
+			if err != nil || s.Addr != first.Addr {
+				t.Fatalf("key:%s got:%v err:%v want:%s", key, s, err, first.Addr)
+			}
+		}
+		if first.Addr == "canary" {
+			hits++
+		}
+	}
+
+	if pct := hits * 100 / total; pct < 15 || pct > 25 {
+		t.Errorf("canary percent:%d hits:%d", pct, hits)
+	}
+}
@@ -0,0 +1,246 @@
+package rocserv
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog"
+	xprom "gitlab.pri.ibanyu.com/middleware/seaweed/xstat/xmetric/xprometheus"
+)
+
+const (
+	defaultBreakerWindow         = time.Second * 10
+	defaultBreakerCooldown       = time.Second * 30
+	defaultBreakerMinRequests    = 20
+	defaultBreakerHalfOpenProbes = 3
+
+	breakerType = "breaker"
+
+	labelEvent = "event"
+
+	breakerEventTrip            = "trip"
+	breakerEventHalfOpenSuccess = "half_open_success"
+)
+
+// ErrCircuitOpen 实例熔断中，调用被直接拒绝
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+var _metricCircuitBreakerCount = xprom.NewCounter(&xprom.CounterVecOpts{
+	Namespace:  namespacePalfish,
+	Subsystem:  breakerType,
+	Name:       "event_count",
+	Help:       "instance circuit breaker events, trip or half_open_success",
+	LabelNames: []string{xprom.LabelGroupName, xprom.LabelServiceName, xprom.LabelCalleeService, xprom.LabelInstance, labelEvent},
+})
+
+// breakerConfig 按实例熔断的阈值，failureRate<=0 时不开启
+type breakerConfig struct {
+	// 统计窗口内 错误请求/总请求 达到该比例时熔断
+	failureRate float64
+	// 统计窗口内请求数达到该值才判断
+	minRequests int
+	// 统计窗口
+	window time.Duration
+	// 熔断时长，到期后进入半开状态
+	cooldown time.Duration
+	// 半开状态允许的探测请求数，全部成功后恢复，任一失败重新熔断
+	halfOpenProbes int
+}
+
+var breakerOptions atomic.Value
+
+func init() {
+	setBreakerConfig(breakerConfig{})
+}
+
+// setBreakerConfig 未设置的项使用默认值
+func setBreakerConfig(c breakerConfig) {
+	if c.minRequests <= 0 {
+		c.minRequests = defaultBreakerMinRequests
+	}
+	if c.window <= 0 {
+		c.window = defaultBreakerWindow
+	}
+	if c.cooldown <= 0 {
+		c.cooldown = defaultBreakerCooldown
+	}
+	if c.halfOpenProbes <= 0 {
+		c.halfOpenProbes = defaultBreakerHalfOpenProbes
+	}
+	breakerOptions.Store(c)
+}
+
+func getBreakerConfig() breakerConfig {
+	return breakerOptions.Load().(breakerConfig)
+}
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type breakerState struct {
+	state int
+
+	windowStart time.Time
+	requests    int
+	failures    int
+
+	openUntil time.Time
+	// 半开状态下已放行及已成功的探测请求数
+	probes    int
+	successes int
+}
+
+// circuitBreaker 按实例统计调用结果，错误率超过阈值时熔断
+type circuitBreaker struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	states map[string]*breakerState
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		now:    time.Now,
+		states: make(map[string]*breakerState),
+	}
+}
+
+// refresh 熔断到期后进入半开状态，需要持有锁
+func (m *circuitBreaker) refresh(s *breakerState, now time.Time) {
+	if s.state == breakerOpen && !now.Before(s.openUntil) {
+		s.state = breakerHalfOpen
+		s.probes = 0
+		s.successes = 0
+	}
+}
+
+// allow 熔断中返回false，半开状态下只放行配置数量的探测请求
+func (m *circuitBreaker) allow(key, addr string) bool {
+	c := getBreakerConfig()
+	if c.failureRate <= 0 {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.states[key+"/"+addr]
+	if !ok {
+		return true
+	}
+
+	m.refresh(s, m.now())
+	switch s.state {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if s.probes >= c.halfOpenProbes {
+			return false
+		}
+		s.probes++
+	}
+	return true
+}
+
+func (m *circuitBreaker) report(key, addr string, err error) {
+	fun := "circuitBreaker.report -->"
+
+	c := getBreakerConfig()
+	if c.failureRate <= 0 {
+		return
+	}
+
+	now := m.now()
+	failed := err != nil && err != ErrCircuitOpen
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := key + "/" + addr
+	s, ok := m.states[id]
+	if !ok {
+		s = &breakerState{windowStart: now}
+		m.states[id] = s
+	}
+
+	m.refresh(s, now)
+	switch s.state {
+	case breakerOpen:
+		return
+	case breakerHalfOpen:
+		if failed {
+			slog.Warnf("%s half open probe failed, trip again serv:%s addr:%s cooldown:%s", fun, key, addr, c.cooldown)
+			m.trip(s, key, addr, now, c.cooldown)
+			return
+		}
+		s.successes++
+		if s.successes >= c.halfOpenProbes {
+			slog.Infof("%s half open probes succ, close serv:%s addr:%s", fun, key, addr)
+			*s = breakerState{windowStart: now}
+			incBreakerEvent(key, addr, breakerEventHalfOpenSuccess)
+		}
+		return
+	}
+
+	if now.Sub(s.windowStart) > c.window {
+		s.windowStart = now
+		s.requests = 0
+		s.failures = 0
+	}
+
+	s.requests++
+	if failed {
+		s.failures++
+	}
+
+	if s.requests < c.minRequests || float64(s.failures)/float64(s.requests) < c.failureRate {
+		return
+	}
+
+	slog.Warnf("%s trip serv:%s addr:%s failures:%d/%d cooldown:%s", fun, key, addr, s.failures, s.requests, c.cooldown)
+	m.trip(s, key, addr, now, c.cooldown)
+}
+
+func (m *circuitBreaker) trip(s *breakerState, key, addr string, now time.Time, cooldown time.Duration) {
+	*s = breakerState{
+		state:       breakerOpen,
+		windowStart: now,
+		openUntil:   now.Add(cooldown),
+	}
+	incBreakerEvent(key, addr, breakerEventTrip)
+}
+
+// filter 去掉熔断中及半开状态下探测请求已满的实例
+func (m *circuitBreaker) filter(key string, servs []*ServInfo) []*ServInfo {
+	c := getBreakerConfig()
+	if c.failureRate <= 0 {
+		return servs
+	}
+
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var avail []*ServInfo
+	for _, serv := range servs {
+		if s, ok := m.states[key+"/"+serv.Addr]; ok {
+			m.refresh(s, now)
+			if s.state == breakerOpen || (s.state == breakerHalfOpen && s.probes >= c.halfOpenProbes) {
+				continue
+			}
+		}
+		avail = append(avail, serv)
+	}
+	return avail
+}
+
+func incBreakerEvent(key, addr, event string) {
+	group, service := GetGroupAndService()
+	_metricCircuitBreakerCount.With(xprom.LabelGroupName, group, xprom.LabelServiceName, service, xprom.LabelCalleeService, key, xprom.LabelInstance, addr, labelEvent, event).Inc()
+}
@@ -0,0 +1,74 @@
+package rocserv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTripAndHalfOpen(t *testing.T) {
+	setBreakerConfig(breakerConfig{failureRate: 0.5, minRequests: 4, cooldown: time.Minute, halfOpenProbes: 2})
+	defer setBreakerConfig(breakerConfig{})
+
+	lookup := &testServLookup{servs: []*ServInfo{{Addr: "bad"}}}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	now := time.Now()
+	b.breaker.now = func() time.Time { return now }
+
+	errFail := errors.New("fail")
+	for i := 0; i < 4; i++ {
+		b.Report("base/account", "proc_grpc", "bad", errFail, time.Millisecond)
+	}
+
+	// 熔断中短路调用
+	called := false
+	err := b.Do("base/account", "proc_grpc", func(s *ServInfo) error {
+		called = true
+		return nil
+	})
+	if err != ErrCircuitOpen || called {
+		t.Fatalf("tripped instance called:%t err:%v", called, err)
+	}
+
+	// 冷却结束进入半开，只放行halfOpenProbes个探测请求
+	now = now.Add(time.Minute + time.Second)
+	if !b.breaker.allow("base/account/proc_grpc", "bad") || !b.breaker.allow("base/account/proc_grpc", "bad") {
+		t.Fatalf("half open probes not allowed")
+	}
+	if b.breaker.allow("base/account/proc_grpc", "bad") {
+		t.Errorf("probes over limit allowed")
+	}
+	if _, err := b.GetConn("base/account", "proc_grpc"); err != ErrCircuitOpen {
+		t.Errorf("instance with full probes selected, err:%v", err)
+	}
+
+	// 探测全部成功后恢复
+	b.Report("base/account", "proc_grpc", "bad", nil, time.Millisecond)
+	b.Report("base/account", "proc_grpc", "bad", nil, time.Millisecond)
+	if err := b.Do("base/account", "proc_grpc", func(s *ServInfo) error { return nil }); err != nil {
+		t.Errorf("closed breaker err:%v", err)
+	}
+
+	// 半开探测失败重新熔断
+	for i := 0; i < 4; i++ {
+		b.Report("base/account", "proc_grpc", "bad", errFail, time.Millisecond)
+	}
+	now = now.Add(time.Minute + time.Second)
+	b.Do("base/account", "proc_grpc", func(s *ServInfo) error { return errFail })
+	if _, err := b.GetConn("base/account", "proc_grpc"); err != ErrCircuitOpen {
+		t.Errorf("failed probe should trip again, err:%v", err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	lookup := &testServLookup{servs: []*ServInfo{{Addr: "a"}}}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	for i := 0; i < 100; i++ {
+		b.Report("base/account", "proc_grpc", "a", errors.New("fail"), 0)
+	}
+	if err := b.Do("base/account", "proc_grpc", func(s *ServInfo) error { return nil }); err != nil {
+		t.Errorf("breaker should be disabled by default, err:%v", err)
+	}
+}
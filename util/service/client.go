@@ -34,7 +34,7 @@ type ClientLookup interface {
 }
 
 func NewClientLookup(etcdaddrs []string, baseLoc string, servlocation string) (*ClientEtcdV2, error) {
-	return NewClientEtcdV2(configEtcd{etcdaddrs, baseLoc}, servlocation)
+	return NewClientEtcdV2(configEtcd{etcdAddrs: etcdaddrs, useBaseloc: baseLoc}, servlocation)
 }
 
 type ClientWrapper struct {
@@ -42,6 +42,7 @@ type ClientWrapper struct {
 	processor    string
 	breaker      *Breaker
 	router       Router
+	retryBudget  *RetryBudget
 }
 
 func NewClientWrapper(cb ClientLookup, processor string) *ClientWrapper {
@@ -58,9 +59,15 @@ func NewClientWrapperWithRouterType(cb ClientLookup, processor string, routerTyp
 		processor:    processor,
 		breaker:      NewBreaker(cb),
 		router:       NewRouter(routerType, cb),
+		retryBudget:  retryBudgetFromConfig(),
 	}
 }
 
+// SetRetryBudget 替换默认的重试预算
+func (m *ClientWrapper) SetRetryBudget(budget *RetryBudget) {
+	m.retryBudget = budget
+}
+
 func (m *ClientWrapper) Do(hashKey string, timeout time.Duration, run func(addr string, timeout time.Duration) error) error {
 	fun := "ClientWrapper.Do -->"
 	si := m.router.Route(context.TODO(), m.processor, hashKey)
@@ -111,6 +118,27 @@ func (m *ClientWrapper) Call(ctx context.Context, hashKey, funcName string, run
 	return err
 }
 
+// CallWithRetry 调用失败时最多重试retries次，重试受重试预算限制
+func (m *ClientWrapper) CallWithRetry(ctx context.Context, hashKey, funcName string, retries int, run func(addr string) error) error {
+	fun := "ClientWrapper.CallWithRetry -->"
+
+	m.retryBudget.Deposit()
+
+	var err error
+	for i := 0; ; i++ {
+		err = m.Call(ctx, hashKey, funcName, run)
+		if err == nil || i >= retries {
+			return err
+		}
+
+		if !m.retryBudget.Withdraw() {
+			slog.Warnf("%s retry budget exhausted, service:%s processor:%s func:%s err:%s", fun, m.clientLookup.ServPath(), m.processor, funcName, err)
+			return err
+		}
+		slog.Infof("%s retry:%d service:%s processor:%s func:%s err:%s", fun, i+1, m.clientLookup.ServPath(), m.processor, funcName, err)
+	}
+}
+
 func collector(servkey string, processor string, duration time.Duration, source int, servid int, funcName string, err interface{}) {
 	servBase := GetServBase()
 	instance := ""
@@ -193,6 +221,9 @@ type ClientThrift struct {
 	pool         *ClientPool
 	breaker      *Breaker
 	router       Router
+
+	// 为true时通过RpcWithContextV2将ctx中的trace/request id传递给server
+	propagateContext bool
 }
 
 type rpcClient interface {
@@ -205,6 +236,7 @@ type rpcClient1 struct {
 	tsock         *thrift.TSocket
 	trans         thrift.TTransport
 	serviceClient interface{}
+	ctxFactory    *ThriftContextProtocolFactory
 }
 
 func (m *rpcClient1) SetTimeout(timeout time.Duration) error {
@@ -219,6 +251,12 @@ func (m *rpcClient1) GetServiceClient() interface{} {
 	return m.serviceClient
 }
 
+func (m *rpcClient1) setContext(ctx context.Context) {
+	if m.ctxFactory != nil {
+		m.ctxFactory.SetContext(ctx)
+	}
+}
+
 func NewClientThrift(cb ClientLookup, processor string, fn func(thrift.TTransport, thrift.TProtocolFactory) interface{}, poollen int) *ClientThrift {
 	return NewClientThriftWithRouterType(cb, processor, fn, poollen, 0)
 }
@@ -245,6 +283,12 @@ func NewClientThriftWithRouterType(cb ClientLookup, processor string, fn func(th
 	return ct
 }
 
+// SetContextPropagation 开启后请求参数中带上trace及request id，只对之后新建的连接生效，
+// server需要使用ThriftContextProcessor才能在handler中获取，没有解析的server会忽略这些元数据
+func (m *ClientThrift) SetContextPropagation(enable bool) {
+	m.propagateContext = enable
+}
+
 func (m *ClientThrift) newClient(addr string) rpcClient {
 	fun := "ClientThrift.newClient -->"
 
@@ -266,6 +310,16 @@ func (m *ClientThrift) newClient(addr string) rpcClient {
 	//useTransport.Close()
 
 	slog.Infof("%s new client addr:%s serv:%s", fun, addr, m.clientLookup.ServKey())
+	if m.propagateContext {
+		ctxFactory := NewThriftContextProtocolFactory(protocolFactory)
+		return &rpcClient1{
+			tsock:         transport,
+			trans:         useTransport,
+			serviceClient: m.fnFactory(useTransport, ctxFactory),
+			ctxFactory:    ctxFactory,
+		}
+	}
+
 	return &rpcClient1{
 		tsock:         transport,
 		trans:         useTransport,
@@ -284,11 +338,13 @@ func (m *ClientThrift) route(ctx context.Context, key string) (*ServInfo, rpcCli
 
 // deprecated
 func (m *ClientThrift) Rpc(hashKey string, timeout time.Duration, fnrpc func(interface{}) error) error {
+	deprecated("ClientThrift.Rpc", "ClientThrift.RpcWithContextV2")
 	return m.RpcWithContext(context.Background(), hashKey, timeout, fnrpc)
 }
 
 // deprecated
 func (m *ClientThrift) RpcWithContext(ctx context.Context, hashKey string, timeout time.Duration, fnrpc func(interface{}) error) error {
+	deprecated("ClientThrift.RpcWithContext", "ClientThrift.RpcWithContextV2")
 	si, rc := m.route(ctx, hashKey)
 	if rc == nil {
 		return fmt.Errorf("not find thrift service:%s processor:%s", m.clientLookup.ServPath(), m.processor)
@@ -364,7 +420,16 @@ func (m *ClientThrift) rpcWithContext(ctx context.Context, si *ServInfo, rc rpcC
 	rc.SetTimeout(timeout)
 	c := rc.GetServiceClient()
 
+	// NOTE: 归还连接池前需要清理ctx，避免被其他请求使用
+	rc1, ok := rc.(*rpcClient1)
+	if ok {
+		rc1.setContext(ctx)
+	}
+
 	err := fnrpc(ctx, c)
+	if ok {
+		rc1.setContext(nil)
+	}
 	m.pool.Put(si.Addr, rc, err)
 	return err
 }
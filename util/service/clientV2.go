@@ -122,10 +122,7 @@ func checkDistVersion(client etcd.KeysAPI, prefloc, servlocation string) string
 func NewClientEtcdV2(confEtcd configEtcd, servlocation string) (*ClientEtcdV2, error) {
 	//fun := "NewClientEtcdV2 -->"
 
-	cfg := etcd.Config{
-		Endpoints: confEtcd.etcdAddrs,
-		Transport: etcd.DefaultTransport,
-	}
+	cfg := confEtcd.clientConfig(confEtcd.etcdAddrs)
 
 	c, err := etcd.New(cfg)
 	if err != nil {
@@ -215,6 +212,10 @@ func (m *ClientEtcdV2) watch(path string, handler func(*etcd.Response), d time.D
 	firstSync := make(chan bool)
 	var firstOnce sync.Once
 
+	// 第一次同步直接回调，之后的变更按配置合并
+	debounce := newDebouncer(getReloadDebounce(), handler)
+	var synced bool
+
 	var chg chan *etcd.Response
 	go func() {
 		slog.Infof("%s start watch:%s", fun, path)
@@ -236,7 +237,12 @@ func (m *ClientEtcdV2) watch(path string, handler func(*etcd.Response), d time.D
 				backoff.BackOff()
 			} else {
 				slog.Infof("%s update v:%s serv:%s", fun, r.Node.Key, path)
-				handler(r)
+				if synced {
+					debounce.push(r)
+				} else {
+					handler(r)
+					synced = true
+				}
 
 				firstOnce.Do(func() {
 					close(firstSync)
@@ -6,3 +6,241 @@ type BaseConfig struct {
 		CrossRegisterCenters []string `sep:"," sconf:"crossRegisterCenters"`
 	}
 }
+
+// NetConfig 网络相关配置
+type NetConfig struct {
+	Net struct {
+		// 并发启动processor的数量
+		BindParallel int
+		// 为true时，无法得到可路由的注册地址(只有回环或通配地址)则启动失败
+		StrictAdvertise bool
+		// 端口被占用时的重试次数，默认不重试
+		BindRetries int
+		// 每个来源ip允许的最大连接数，<=0 不限制，对http/gin/grpc/ws/thrift生效
+		MaxConnsPerIP int
+		// 可信代理的ip或cidr，逗号分隔，来自可信代理的连接完全不受MaxConnsPerIP限制，
+		// 按连接的来源ip统计，不解析X-Forwarded-For，代理后面的单个client占满连接也不会被限制
+		TrustedProxies string
+		// 请求剩余超时时间低于该值时直接拒绝(504/DeadlineExceeded)，单位毫秒，<=0 不拒绝
+		DeadlineFloor int
+		// processor监听地址，优先于Driver返回的地址，格式 name=addr,name2=addr2，启动参数-processor-addr优先
+		ProcessorAddrs string
+		// 注册到服务发现的ip，支持ipv6，为空时使用监听地址，监听通配地址时选择本机可路由的ip
+		AdvertiseIP string `sconf:"advertise_ip"`
+	}
+}
+
+// MetricConfig metric相关配置
+type MetricConfig struct {
+	Metric struct {
+		// metrics暴露的路径，默认/metrics
+		Path string
+		// 抓取结果缓存时长，单位毫秒，高频抓取时复用同一份数据，0不缓存
+		CacheTTL int
+		// processor请求耗时分布(RED metrics)，单位秒，为空使用默认分布
+		Buckets []float64 `sep:","`
+		// 推送到statsd，Addr为空不推送
+		Statsd struct {
+			Addr string
+			// metric名称前缀
+			Prefix string
+			// 推送间隔，单位毫秒，默认10s
+			Interval int
+			// 使用DogStatsD格式，label作为tag推送
+			DogStatsD bool
+		}
+	}
+}
+
+// RegistryConfig 服务发现相关配置
+type RegistryConfig struct {
+	Registry struct {
+		// 服务发现、熔断配置变更后等待该时长内没有新的变更再重新加载，单位毫秒，0 每次变更都重新加载
+		ReloadDebounce int
+		// 注册信息的ttl，单位秒，默认60，最小3，每ttl/3续约一次；进程异常退出时注册信息在ttl后过期，
+		// 正常下线时主动删除，不等待过期
+		TTL int
+		// 下线时删除注册信息前等待的时长，防止新实例还没有完成注册，单位毫秒，默认2000，<0 不等待；
+		// 没有注册信息时不等待
+		DeregisterDelay int
+	}
+}
+
+// BalancerConfig 负载均衡相关配置，对所有Balancer生效
+type BalancerConfig struct {
+	Balancer struct {
+		// 本实例所在zone，为空时使用环境变量ROC_ZONE，会发布到服务发现供ZoneAwareBalancer使用
+		Zone string
+		// 路由到canary分组实例的请求比例，0-100，0 不区分分组
+		CanaryPercent int
+		// 被动健康检查，ErrorRate<=0 不开启
+		Outlier struct {
+			// 统计窗口内错误请求比例达到该值时摘除实例
+			ErrorRate float64
+			// 统计窗口内请求数达到该值才判断，默认10
+			MinRequests int
+			// 耗时超过该值的请求按错误计算，单位毫秒，<=0 不按耗时判断
+			MaxLatency int
+			// 统计窗口，单位毫秒，默认10s
+			Interval int
+			// 摘除时长，单位毫秒，默认30s，到期后放回一个请求探测
+			Cooldown int
+			// 最多摘除的实例比例，默认50
+			MaxEjectPercent int
+		}
+		// 按实例熔断，FailureRate<=0 不开启，熔断中的实例不会被选中，Balancer.Do直接返回ErrCircuitOpen
+		Breaker struct {
+			// 统计窗口内错误请求比例达到该值时熔断
+			FailureRate float64
+			// 统计窗口内请求数达到该值才判断，默认20
+			MinRequests int
+			// 统计窗口，单位毫秒，默认10s
+			Window int
+			// 熔断时长，单位毫秒，默认30s，到期后进入半开状态
+			Cooldown int
+			// 半开状态的探测请求数，全部成功后恢复，默认3
+			HalfOpenProbes int
+		}
+	}
+}
+
+// BackdoorConfig 后门相关配置
+type BackdoorConfig struct {
+	Backdoor struct {
+		// 监听地址，如只允许本机访问 127.0.0.1:60000，启动参数-backdoor-addr优先，默认0.0.0.0:60000
+		Addr string
+		// 管理接口token文件，为空不鉴权，请求需要带 X-Backdoor-Token；文件无法读取或内容为空时拒绝所有管理请求；
+		// 配置后才开启 /backdoor/restart；配置变更时重新读取，修改为新的文件即可轮换token，不需要重启
+		TokenFile string
+		// 开启 /backdoor/debug/pprof/*，默认关闭
+		Pprof bool
+		// 在后门端口暴露 /backdoor/metrics，只需抓取后门端口，默认关闭，原metrics processor保持不变
+		Metrics bool
+	}
+}
+
+// GrpcConfig grpc server相关配置，对启动前已创建的GrpcServer同样生效
+type GrpcConfig struct {
+	Grpc struct {
+		// 每个连接上同时处理的stream数量，超过时返回ResourceExhausted，<=0 不限制
+		MaxConcurrentStreams int
+	}
+}
+
+// InitConfig 应用初始化相关配置
+type InitConfig struct {
+	Init struct {
+		// initfn失败后的重试次数，默认不重试
+		Retries int
+		// 第一次重试的间隔，单位毫秒，之后每次翻倍
+		RetryInterval int
+		// initfn执行中打印进度日志的间隔，单位毫秒，默认10s
+		ProgressInterval int
+		// initfn执行超过该时长时打印告警，单位毫秒，<=0 不告警
+		SoftLimit int
+		// 启动顺序，逗号分隔，默认 backdoor,processors,metrics，
+		// 如 backdoor,metrics,processors 使健康检查和metrics在业务processor预热前可用
+		Order string
+	}
+}
+
+// DependencyConfig 依赖探测相关配置，key为AddDependencyProbe注册的依赖名称
+type DependencyConfig struct {
+	Dependency map[string]struct {
+		// 关键依赖持续不可用时实例会从服务发现中摘除
+		Critical bool
+		// 关键依赖持续不可用多久后摘除，单位毫秒，默认10s
+		DownThreshold int
+		// 可选依赖不可用时实例为degraded状态，不影响ready
+		Optional bool
+	}
+}
+
+// HealthConfig 健康检查相关配置
+type HealthConfig struct {
+	Health struct {
+		// 各状态返回的http状态码，为0时使用默认值
+		// 默认 healthy:200 degraded:200 draining:503 unhealthy:503
+		HealthyCode   int
+		DegradedCode  int
+		DrainingCode  int
+		UnhealthyCode int
+		// 后门上额外的就绪检查路径，逗号分隔，如 /,/healthz，
+		// 返回和 /backdoor/health/ready 相同的结果，用于只能探测固定路径的负载均衡
+		ExtraPaths []string `sep:","`
+	}
+}
+
+// LogConfig 服务日志，启动参数-logdir优先于Dir
+type LogConfig struct {
+	Log struct {
+		Level string
+		Dir   string
+		// 单个日志文件的大小上限，单位MB，<=0 使用启动参数-logmaxsize，变更后实时生效
+		MaxSize int
+		// 保留的切分文件数，<=0 使用启动参数-logmaxbackups，变更后实时生效
+		MaxBackups int
+	}
+}
+
+// AccessLogConfig http、gin processor的访问日志，每个请求一行
+type AccessLogConfig struct {
+	AccessLog struct {
+		Enable bool
+		// text 或 json，默认text
+		Format string
+	}
+}
+
+// RateLimitConfig 按processor及方法限流，第一层key为processor名称，第二层为方法：http/gin为匹配的路由如 "GET /v1/user/:id"，
+// grpc为FullMethod，thrift为方法名，"*" 为其他方法共用的规则；超过限制时http返回429，grpc返回RESOURCE_EXHAUSTED，
+// 配置变更后实时生效
+type RateLimitConfig struct {
+	RateLimit map[string]map[string]RateLimitRule
+}
+
+// SloConfig 各processor的SLO目标，key为processor名称，统计结果见 /backdoor/slo 及metrics palfish_slo_*
+type SloConfig struct {
+	Slo map[string]struct {
+		// 99%请求的耗时目标，单位毫秒，<=0 不统计耗时
+		LatencyP99 int
+		// 错误率目标，如0.01，<=0 不统计错误率
+		ErrorRate float64
+		// 滚动统计窗口，单位毫秒，默认5分钟
+		Window int
+	}
+}
+
+// ProcessorConfig 按名称启用RegisterProcessorFactory注册的processor
+type ProcessorConfig struct {
+	Processor struct {
+		// 启用的processor名称，逗号分隔
+		Enable []string `sep:","`
+		// 不启动的processor名称，逗号分隔，对代码中传入的processor同样生效
+		Disable []string `sep:","`
+	}
+}
+
+// TlsConfig 各processor的TLS配置，key为processor名称，只对http、gin、grpc、ws生效，未配置的processor使用明文
+type TlsConfig struct {
+	Tls map[string]struct {
+		CertFile string
+		KeyFile  string
+		// 校验客户端证书使用的CA，为空时不校验客户端证书
+		ClientCAFile string
+		// 为true时客户端必须提供证书(mTLS)
+		RequireClientCert bool
+	}
+}
+
+// ClientConfig 客户端相关配置
+type ClientConfig struct {
+	Client struct {
+		RetryBudget struct {
+			// 每次正常请求允许的重试比例
+			Ratio float64
+			// 每秒保底允许的重试次数
+			MinPerSecond int
+		}
+	}
+}
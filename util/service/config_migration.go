@@ -0,0 +1,83 @@
+package rocserv
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/shawnfeng/sutil/sconf"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// 服务配置中记录配置版本的位置
+// [config]
+// version = 2
+const (
+	configVersionSection = "config"
+	configVersionKey     = "version"
+)
+
+var configMigrations = struct {
+	mu  sync.RWMutex
+	fns map[int]func(raw []byte) ([]byte, error)
+}{
+	fns: make(map[int]func(raw []byte) ([]byte, error)),
+}
+
+// RegisterConfigMigration 注册配置升级函数，将fromVersion版本的原始配置升级为fromVersion+1版本，
+// 升级后的配置需要同时更新 [config] version，加载配置时会依次执行直到没有对应版本的升级函数
+func RegisterConfigMigration(fromVersion int, fn func(raw []byte) ([]byte, error)) {
+	configMigrations.mu.Lock()
+	defer configMigrations.mu.Unlock()
+
+	configMigrations.fns[fromVersion] = fn
+}
+
+func getConfigMigration(version int) func(raw []byte) ([]byte, error) {
+	configMigrations.mu.RLock()
+	defer configMigrations.mu.RUnlock()
+
+	return configMigrations.fns[version]
+}
+
+func configVersion(raw []byte) (int, error) {
+	tf := sconf.NewTierConf()
+	err := tf.Load(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	return tf.ToIntWithDefault(configVersionSection, configVersionKey, 0), nil
+}
+
+// migrateConfig 将原始配置升级到已注册的最新版本
+func migrateConfig(raw []byte) ([]byte, error) {
+	fun := "migrateConfig -->"
+
+	version, err := configVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		fn := getConfigMigration(version)
+		if fn == nil {
+			return raw, nil
+		}
+
+		migrated, err := fn(raw)
+		if err != nil {
+			return nil, fmt.Errorf("migrate config from version:%d err:%s", version, err)
+		}
+
+		newVersion, err := configVersion(migrated)
+		if err != nil {
+			return nil, fmt.Errorf("migrate config from version:%d err:%s", version, err)
+		}
+		if newVersion <= version {
+			return nil, fmt.Errorf("migrate config from version:%d not upgrade version, got:%d", version, newVersion)
+		}
+
+		slog.Infof("%s migrate config version:%d -> %d", fun, version, newVersion)
+		raw, version = migrated, newVersion
+	}
+}
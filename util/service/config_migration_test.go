@@ -0,0 +1,44 @@
+package rocserv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shawnfeng/sutil/sconf"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	RegisterConfigMigration(1, func(raw []byte) ([]byte, error) {
+		s := strings.Replace(string(raw), "version = 1", "version = 2", 1)
+		s = strings.Replace(s, "loglevel", "level", 1)
+		return []byte(s), nil
+	})
+
+	old := []byte("[config]\nversion = 1\n\n[log]\nloglevel = DEBUG\n")
+	migrated, err := migrateConfig(old)
+	if err != nil {
+		t.Fatalf("migrate err:%s", err)
+	}
+
+	tf := sconf.NewTierConf()
+	if err := tf.Load(migrated); err != nil {
+		t.Fatalf("load err:%s", err)
+	}
+
+	var cfg struct {
+		Log struct {
+			Level string
+		}
+	}
+	if err := tf.Unmarshal(&cfg); err != nil {
+		t.Fatalf("unmarshal err:%s", err)
+	}
+
+	if cfg.Log.Level != "DEBUG" {
+		t.Errorf("migrated config:%s level:%s", migrated, cfg.Log.Level)
+	}
+
+	if v, _ := configVersion(migrated); v != 2 {
+		t.Errorf("version:%d", v)
+	}
+}
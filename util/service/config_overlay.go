@@ -0,0 +1,62 @@
+package rocserv
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shawnfeng/sutil/sconf"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// envConfigEnv 配置环境，如dev/staging/prod，设置后在基础配置上叠加对应环境的配置：
+// etc/global.<env> 覆盖 etc/global，etc/<servLoc>.<env> 覆盖 etc/<servLoc>
+const envConfigEnv = "ROC_ENV"
+
+func configEnv() string {
+	return os.Getenv(envConfigEnv)
+}
+
+// overlayPath 环境配置和基础配置是同级的节点
+func overlayPath(path, env string) string {
+	return fmt.Sprintf("%s.%s", path, env)
+}
+
+// mergeConfig 按顺序加载各层配置，同一section下后加载的key覆盖之前的值，未覆盖的key保留
+func mergeConfig(layers ...[]byte) (*sconf.TierConf, error) {
+	tf := sconf.NewTierConf()
+	for _, layer := range layers {
+		if err := tf.Load(layer); err != nil {
+			return nil, err
+		}
+	}
+	return tf, nil
+}
+
+// handleConfig 查看合并环境配置后实际生效的配置，secret不展示
+func handleConfig(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	fun := "handleConfig -->"
+
+	sb, ok := GetServBase().(*ServBaseV2)
+	if !ok {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service not init"})
+		return
+	}
+
+	tf, err := sb.loadRedactedServConfig()
+	if err != nil {
+		slog.Errorf("%s load serv config err:%s", fun, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	conf, err := tf.StringCheck()
+	if err != nil {
+		slog.Errorf("%s dump serv config err:%s", fun, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"env": configEnv(), "config": conf})
+}
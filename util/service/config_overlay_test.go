@@ -0,0 +1,37 @@
+package rocserv
+
+import "testing"
+
+func TestMergeConfigOverlay(t *testing.T) {
+	base := []byte("[log]\nlevel = INFO\ndir = /data/logs\n\n[net]\nbindretries = 3\n")
+	prod := []byte("[log]\nlevel = WARN\n")
+
+	tf, err := mergeConfig(base, nil, prod)
+	if err != nil {
+		t.Fatalf("merge err:%s", err)
+	}
+
+	var cfg struct {
+		Log struct {
+			Level string
+			Dir   string
+		}
+		Net struct {
+			BindRetries int
+		}
+	}
+	if err := tf.Unmarshal(&cfg); err != nil {
+		t.Fatalf("unmarshal err:%s", err)
+	}
+
+	if cfg.Log.Level != "WARN" {
+		t.Errorf("overlay not applied, level:%s", cfg.Log.Level)
+	}
+	if cfg.Log.Dir != "/data/logs" || cfg.Net.BindRetries != 3 {
+		t.Errorf("base keys not inherited:%+v", cfg)
+	}
+
+	if p := overlayPath("/roc/etc/base/account", "prod"); p != "/roc/etc/base/account.prod" {
+		t.Errorf("overlay path:%s", p)
+	}
+}
@@ -0,0 +1,167 @@
+package rocserv
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/shawnfeng/sutil/slog"
+	"github.com/shawnfeng/sutil/stime"
+	"golang.org/x/net/context"
+)
+
+// configWatcher 记录WatchConfig注册的回调，第一次注册时开始监听
+type configWatcher struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	fns     []func(newRaw []byte)
+	started bool
+	// 最近一次通知的配置，内容不变时不重复通知
+	last []byte
+}
+
+func newConfigWatcher() *configWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &configWatcher{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// add 返回true时需要开始监听
+func (m *configWatcher) add(fn func(newRaw []byte)) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fns = append(m.fns, fn)
+	if m.started {
+		return false
+	}
+	m.started = true
+	return true
+}
+
+// changed 记录新配置，和上次通知的相同时返回false
+func (m *configWatcher) changed(raw []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if bytes.Equal(m.last, raw) {
+		return false
+	}
+	m.last = raw
+	return true
+}
+
+func (m *configWatcher) callbacks() []func(newRaw []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append(([]func(newRaw []byte))(nil), m.fns...)
+}
+
+func (m *configWatcher) stop() {
+	m.cancel()
+}
+
+// servConfigPath 服务配置节点
+func (m *ServBaseV2) servConfigPath() string {
+	return fmt.Sprintf("%s/%s/%s", m.confEtcd.useBaseloc, BASE_LOC_ETC, m.servLocation)
+}
+
+// WatchConfig 服务配置节点变更时回调fn，参数为节点的原始内容，合并全局及环境配置后的结果通过ServConfig获取。
+// 所有回调在同一个goroutine中按注册顺序依次执行，多次变更按etcd中的顺序通知，回调阻塞会延迟之后的通知；
+// 新配置无法解析时记录错误日志并跳过本次变更，不回调，业务保留旧配置
+func (m *ServBaseV2) WatchConfig(fn func(newRaw []byte)) {
+	if m.configWatch.add(fn) {
+		go m.watchConfigLoop()
+	}
+}
+
+func (m *ServBaseV2) watchConfigLoop() {
+	fun := "ServBaseV2.watchConfigLoop -->"
+
+	path := m.servConfigPath()
+	ctx := m.configWatch.ctx
+	backoff := stime.NewBackOffCtrl(time.Millisecond*100, time.Second*10)
+
+	slog.Infof("%s start watch config:%s", fun, path)
+
+	var index uint64
+	for {
+		if index == 0 {
+			var err error
+			index, err = m.syncConfigIndex(ctx, path)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Warnf("%s get config:%s err:%s", fun, path, err)
+				backoff.BackOff()
+				continue
+			}
+		}
+
+		resp, err := m.etcdClient.Watcher(path, &etcd.WatcherOptions{AfterIndex: index}).Next(ctx)
+		if ctx.Err() != nil {
+			slog.Infof("%s stop watch config:%s", fun, path)
+			return
+		}
+		if err != nil {
+			slog.Warnf("%s watch config:%s index:%d err:%s", fun, path, index, err)
+			// 事件已被etcd清理时重新获取当前index
+			if e, ok := err.(etcd.Error); ok && e.Code == etcd.ErrorCodeEventIndexCleared {
+				index = 0
+			}
+			backoff.BackOff()
+			continue
+		}
+		backoff.Reset()
+
+		index = resp.Node.ModifiedIndex
+		slog.Infof("%s config:%s action:%s index:%d", fun, path, resp.Action, index)
+
+		var raw []byte
+		if resp.Action != "delete" && resp.Action != "expire" {
+			raw = []byte(resp.Node.Value)
+		}
+		m.notifyConfig(raw)
+	}
+}
+
+// syncConfigIndex 记录当前配置，返回开始监听的index
+func (m *ServBaseV2) syncConfigIndex(ctx context.Context, path string) (uint64, error) {
+	r, err := m.etcdClient.Get(ctx, path, nil)
+	if err != nil {
+		if e, ok := err.(etcd.Error); ok && e.Code == etcd.ErrorCodeKeyNotFound {
+			m.configWatch.changed(nil)
+			return e.Index, nil
+		}
+		return 0, err
+	}
+
+	m.configWatch.changed([]byte(r.Node.Value))
+	return r.Index, nil
+}
+
+// notifyConfig 新配置可以加载时依次回调
+func (m *ServBaseV2) notifyConfig(raw []byte) {
+	fun := "ServBaseV2.notifyConfig -->"
+
+	if !m.configWatch.changed(raw) {
+		return
+	}
+
+	if _, err := m.loadServConfig(); err != nil {
+		slog.Errorf("%s load new config err:%s, keep old config", fun, err)
+		return
+	}
+
+	for _, fn := range m.configWatch.callbacks() {
+		fn(raw)
+	}
+}
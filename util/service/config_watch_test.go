@@ -0,0 +1,108 @@
+package rocserv
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+// testConfigKeysAPI 只实现配置读取及监听
+type testConfigKeysAPI struct {
+	etcd.KeysAPI
+
+	mu     sync.Mutex
+	values map[string]string
+	index  uint64
+	events chan *etcd.Response
+}
+
+func (m *testConfigKeysAPI) set(path, value string) {
+	m.mu.Lock()
+	m.values[path] = value
+	m.index++
+	index := m.index
+	m.mu.Unlock()
+
+	m.events <- &etcd.Response{Action: "set", Index: index, Node: &etcd.Node{Key: path, Value: value, ModifiedIndex: index}}
+}
+
+func (m *testConfigKeysAPI) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.values[key]
+	if !ok {
+		return nil, etcd.Error{Code: etcd.ErrorCodeKeyNotFound, Index: m.index}
+	}
+	return &etcd.Response{Action: "get", Index: m.index, Node: &etcd.Node{Key: key, Value: v}}, nil
+}
+
+func (m *testConfigKeysAPI) Watcher(key string, opts *etcd.WatcherOptions) etcd.Watcher {
+	return m
+}
+
+func (m *testConfigKeysAPI) Next(ctx context.Context) (*etcd.Response, error) {
+	select {
+	case r := <-m.events:
+		return r, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := "/roc/etc/base/test"
+	keys := &testConfigKeysAPI{
+		values: map[string]string{path: "[log]\nlevel = \"INFO\"\n"},
+		index:  1,
+		events: make(chan *etcd.Response),
+	}
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		etcdClient:   keys,
+		configWatch:  newConfigWatcher(),
+	}
+	defer sb.configWatch.stop()
+
+	// 回调按注册顺序执行
+	got := make(chan string, 10)
+	sb.WatchConfig(func(newRaw []byte) { got <- "first:" + string(newRaw) })
+	sb.WatchConfig(func(newRaw []byte) { got <- "second:" + string(newRaw) })
+
+	next := func(timeout time.Duration) string {
+		select {
+		case s := <-got:
+			return s
+		case <-time.After(timeout):
+			return ""
+		}
+	}
+
+	debug := "[log]\nlevel = \"DEBUG\"\n"
+	keys.set(path, debug)
+	if s := next(time.Second); s != "first:"+debug {
+		t.Errorf("first callback got:%q", s)
+	}
+	if s := next(time.Second); s != "second:"+debug {
+		t.Errorf("second callback got:%q", s)
+	}
+
+	// 无法解析的配置不回调
+	keys.set(path, "[log\nlevel = ")
+	if s := next(100 * time.Millisecond); len(s) > 0 {
+		t.Errorf("invalid config should be skipped, got:%q", s)
+	}
+
+	warn := "[log]\nlevel = \"WARN\"\n"
+	keys.set(path, warn)
+	if s := next(time.Second); s != "first:"+warn {
+		t.Errorf("first callback got:%q", s)
+	}
+	if s := next(time.Second); s != "second:"+warn {
+		t.Errorf("second callback got:%q", s)
+	}
+}
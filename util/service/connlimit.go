@@ -0,0 +1,265 @@
+package rocserv
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// 拒绝连接的日志最多每个间隔打印一次，汇总间隔内拒绝的连接数
+const connLimitLogInterval = time.Second * 10
+
+// connLimit 每个来源ip允许的最大连接数，trusted为可信代理，代理汇聚了大量client，来自代理的连接完全不做限制
+type connLimit struct {
+	mu      sync.RWMutex
+	max     int
+	trusted []*net.IPNet
+}
+
+var connLimits = &connLimit{}
+
+// parseTrustedProxies 逗号分隔的ip或cidr
+func parseTrustedProxies(s string) []*net.IPNet {
+	fun := "parseTrustedProxies -->"
+
+	var nets []*net.IPNet
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			slog.Warnf("%s invalid trusted proxy:%s err:%s", fun, p, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func (m *connLimit) set(max int, trusted []*net.IPNet) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.max = max
+	m.trusted = trusted
+}
+
+func (m *connLimit) get() (int, []*net.IPNet) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.max, m.trusted
+}
+
+func newConnLimiter(name string) (*connLimiter, bool) {
+	max, trusted := connLimits.get()
+	if max <= 0 {
+		return nil, false
+	}
+
+	return &connLimiter{
+		name:    name,
+		max:     max,
+		trusted: trusted,
+		conns:   make(map[string]int),
+	}, true
+}
+
+// connLimiter 统计每个来源ip的活跃连接，超过上限的新连接直接关闭
+type connLimiter struct {
+	name    string
+	max     int
+	trusted []*net.IPNet
+
+	mu    sync.Mutex
+	conns map[string]int
+	// 上次打印日志后拒绝的连接数
+	rejected int
+	lastLog  time.Time
+}
+
+func (m *connLimiter) isTrusted(ip net.IP) bool {
+	for _, n := range m.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *connLimiter) acquire(ip string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conns[ip] >= m.max {
+		return false
+	}
+	m.conns[ip]++
+	return true
+}
+
+func (m *connLimiter) release(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.conns[ip]--
+	if m.conns[ip] <= 0 {
+		delete(m.conns, ip)
+	}
+}
+
+// reject 记录拒绝的连接，距上次打印超过connLimitLogInterval时返回需要打印及期间拒绝的连接数
+func (m *connLimiter) reject(now time.Time) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rejected++
+	if now.Sub(m.lastLog) < connLimitLogInterval {
+		return 0, false
+	}
+
+	n := m.rejected
+	m.rejected = 0
+	m.lastLog = now
+	return n, true
+}
+
+// admit 判断是否接受来自addr的新连接，接受时返回连接关闭时需要调用的release，不计数时release为nil
+func (m *connLimiter) admit(addr net.Addr) (func(), bool) {
+	fun := "connLimiter.admit -->"
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, true
+	}
+
+	if ip := net.ParseIP(host); ip != nil && m.isTrusted(ip) {
+		return nil, true
+	}
+
+	if !m.acquire(host) {
+		if n, ok := m.reject(time.Now()); ok {
+			slog.Warnf("%s listener:%s ip:%s exceeds max conns:%d, rejected:%d", fun, m.name, host, m.max, n)
+		}
+		return nil, false
+	}
+	return func() { m.release(host) }, true
+}
+
+// newConnLimitListener 未配置 Net.MaxConnsPerIP 时直接返回l
+func newConnLimitListener(l net.Listener, name string) net.Listener {
+	limiter, ok := newConnLimiter(name)
+	if !ok {
+		return l
+	}
+
+	return &connLimitListener{
+		Listener: l,
+		limiter:  limiter,
+	}
+}
+
+type connLimitListener struct {
+	net.Listener
+	limiter *connLimiter
+}
+
+func (m *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := m.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		release, ok := m.limiter.admit(conn.RemoteAddr())
+		if !ok {
+			conn.Close()
+			continue
+		}
+		if release == nil {
+			return conn, nil
+		}
+		return &limitedConn{Conn: conn, release: release}, nil
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (m *limitedConn) Close() error {
+	m.once.Do(m.release)
+	return m.Conn.Close()
+}
+
+// newConnLimitServerTransport thrift使用，未配置 Net.MaxConnsPerIP 时直接返回t
+func newConnLimitServerTransport(t thrift.TServerTransport, name string) thrift.TServerTransport {
+	limiter, ok := newConnLimiter(name)
+	if !ok {
+		return t
+	}
+
+	return &connLimitServerTransport{
+		TServerTransport: t,
+		limiter:          limiter,
+	}
+}
+
+// connLimitServerTransport 按TSocket底层连接的来源ip限制，超过上限的连接直接关闭，不交给thrift server处理
+type connLimitServerTransport struct {
+	thrift.TServerTransport
+	limiter *connLimiter
+}
+
+func (m *connLimitServerTransport) Accept() (thrift.TTransport, error) {
+	for {
+		trans, err := m.TServerTransport.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		sock, ok := trans.(interface{ Conn() net.Conn })
+		if !ok || sock.Conn() == nil {
+			return trans, nil
+		}
+
+		release, ok := m.limiter.admit(sock.Conn().RemoteAddr())
+		if !ok {
+			trans.Close()
+			continue
+		}
+		if release == nil {
+			return trans, nil
+		}
+		return &limitedTransport{TTransport: trans, release: release}, nil
+	}
+}
+
+// limitedTransport thrift server处理完连接后Close时释放计数
+type limitedTransport struct {
+	thrift.TTransport
+	once    sync.Once
+	release func()
+}
+
+func (m *limitedTransport) Close() error {
+	m.once.Do(m.release)
+	return m.TTransport.Close()
+}
@@ -0,0 +1,165 @@
+package rocserv
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+func TestConnLimitPerIP(t *testing.T) {
+	connLimits.set(2, parseTrustedProxies("127.0.0.3"))
+	defer connLimits.set(0, nil)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	l := newConnLimitListener(lis, "test")
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("ok"))
+		}
+	}()
+
+	dial := func(local string) net.Conn {
+		d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(local)}}
+		conn, err := d.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatalf("dial from:%s err:%s", local, err)
+		}
+		return conn
+	}
+	accepted := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 2)
+		_, err := io.ReadFull(conn, buf)
+		return err == nil
+	}
+
+	for i := 0; i < 2; i++ {
+		conn := dial("127.0.0.1")
+		defer conn.Close()
+		if !accepted(conn) {
+			t.Fatalf("conn:%d under limit rejected", i)
+		}
+	}
+
+	conn := dial("127.0.0.1")
+	defer conn.Close()
+	if accepted(conn) {
+		t.Errorf("conn over limit accepted")
+	}
+
+	other := dial("127.0.0.2")
+	defer other.Close()
+	if !accepted(other) {
+		t.Errorf("other ip rejected")
+	}
+
+	for i := 0; i < 3; i++ {
+		proxy := dial("127.0.0.3")
+		defer proxy.Close()
+		if !accepted(proxy) {
+			t.Errorf("trusted proxy conn:%d rejected", i)
+		}
+	}
+}
+
+func TestConnLimitThrift(t *testing.T) {
+	connLimits.set(1, parseTrustedProxies("127.0.0.3"))
+	defer connLimits.set(0, nil)
+
+	serverTransport, err := thrift.NewTServerSocket("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("new server socket err:%s", err)
+	}
+	if err := serverTransport.Listen(); err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	defer serverTransport.Close()
+	trans := newConnLimitServerTransport(serverTransport, "test")
+
+	clients := make(chan thrift.TTransport, 10)
+	go func() {
+		for {
+			client, err := trans.Accept()
+			if err != nil {
+				return
+			}
+			client.Write([]byte("ok"))
+			client.Flush()
+			clients <- client
+		}
+	}()
+
+	dial := func(local string) net.Conn {
+		d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(local)}}
+		conn, err := d.Dial("tcp", serverTransport.Addr().String())
+		if err != nil {
+			t.Fatalf("dial from:%s err:%s", local, err)
+		}
+		return conn
+	}
+	accepted := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 2)
+		_, err := io.ReadFull(conn, buf)
+		return err == nil
+	}
+
+	conn := dial("127.0.0.1")
+	defer conn.Close()
+	if !accepted(conn) {
+		t.Fatalf("conn under limit rejected")
+	}
+
+	over := dial("127.0.0.1")
+	defer over.Close()
+	if accepted(over) {
+		t.Errorf("conn over limit accepted")
+	}
+
+	for i := 0; i < 2; i++ {
+		proxy := dial("127.0.0.3")
+		defer proxy.Close()
+		if !accepted(proxy) {
+			t.Errorf("trusted proxy conn:%d rejected", i)
+		}
+	}
+
+	// thrift server关闭连接后释放计数
+	(<-clients).Close()
+	again := dial("127.0.0.1")
+	defer again.Close()
+	if !accepted(again) {
+		t.Errorf("conn after release rejected")
+	}
+}
+
+func TestConnLimitRejectLog(t *testing.T) {
+	m := &connLimiter{}
+	now := time.Now()
+
+	// 第一次拒绝立即打印，之后间隔内只计数
+	if n, ok := m.reject(now); !ok || n != 1 {
+		t.Errorf("first reject n:%d log:%t", n, ok)
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := m.reject(now.Add(time.Second)); ok {
+			t.Fatalf("reject within interval should not log")
+		}
+	}
+
+	if n, ok := m.reject(now.Add(connLimitLogInterval)); !ok || n != 101 {
+		t.Errorf("reject after interval n:%d log:%t", n, ok)
+	}
+}
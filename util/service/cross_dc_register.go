@@ -33,33 +33,45 @@ func (m *ServBaseV2) RegisterCrossDCService(servs map[string]*ServInfo) error {
 func (m *ServBaseV2) doCrossDCRegister(path, js string, refresh bool) error {
 	fun := "ServBaseV2.doCrossDCRegister -->"
 
+	if m.dryRun {
+		slog.Infof("%s dry run, skip cross dc register path:%s data:%s", fun, path, js)
+		return nil
+	}
+
 	for addr, _ := range m.crossRegisterClients {
+		addr := addr
 		// 创建完成标志
 		var iscreate bool
 
 		slog.Infof("%s path:%s data:%s refresh:%t", fun, path, js, refresh)
 
-		go func() {
+		m.goRegister(func(stopC <-chan struct{}) {
 
 			for j := 0; ; j++ {
+				if m.isStop() {
+					slog.Infof("%s service stop, register [%s] stop", fun, path)
+					return
+				}
+
+				ttl, interval := m.registerTTL()
 				var err error
 				var r *etcd.Response
 				if !iscreate {
 					slog.Warnf("%s create idx:%d servs:%s", fun, j, js)
 					r, err = m.crossRegisterClients[addr].Set(context.Background(), path, js, &etcd.SetOptions{
-						TTL: time.Second * 60,
+						TTL: ttl,
 					})
 				} else {
 					if refresh {
 						// 在刷新ttl时候，不允许变更value
 						r, err = m.crossRegisterClients[addr].Set(context.Background(), path, "", &etcd.SetOptions{
 							PrevExist: etcd.PrevExist,
-							TTL:       time.Second * 60,
+							TTL:       ttl,
 							Refresh:   true,
 						})
 					} else {
 						r, err = m.crossRegisterClients[addr].Set(context.Background(), path, js, &etcd.SetOptions{
-							TTL: time.Second * 60,
+							TTL: ttl,
 						})
 					}
 
@@ -73,15 +85,13 @@ func (m *ServBaseV2) doCrossDCRegister(path, js string, refresh bool) error {
 					iscreate = true
 				}
 
-				time.Sleep(time.Second * 20)
-
-				if m.isStop() {
-					slog.Infof("%s service stop, register [%s] stop", fun, path)
-					return
+				select {
+				case <-stopC:
+				case <-time.After(interval):
 				}
 			}
 
-		}()
+		})
 	}
 
 	return nil
@@ -90,21 +100,15 @@ func (m *ServBaseV2) doCrossDCRegister(path, js string, refresh bool) error {
 func (m *ServBaseV2) clearCrossDCRegisterInfos() {
 	fun := "ServBaseV2.clearCrossDCRegisterInfos -->"
 
-	//延迟清理注册信息,防止新实例还没有完成注册
-	time.Sleep(time.Second * 2)
-
-	m.muReg.Lock()
-	defer m.muReg.Unlock()
+	if m.dryRun {
+		return
+	}
 
-	for addr, _ := range m.crossRegisterClients {
-		for path, _ := range m.regInfos {
-			_, err := m.crossRegisterClients[addr].Set(context.Background(), path, "", &etcd.SetOptions{
-				PrevExist: etcd.PrevExist,
-				TTL:       0,
-				Refresh:   true,
-			})
-			if err != nil {
-				slog.Warnf("%s path:%s err:%v", fun, path, err)
+	regInfos := m.registerInfos()
+	for addr, client := range m.crossRegisterClients {
+		for path, regInfo := range regInfos {
+			if err := deregister(client, path, regInfo); err != nil {
+				slog.Warnf("%s addr:%s path:%s err:%v", fun, addr, path, err)
 			}
 		}
 	}
@@ -118,10 +122,7 @@ func initCrossRegisterCenter(sb *ServBaseV2) error {
 		return err
 	}
 	for _, addr := range baseConfig.Base.CrossRegisterCenters {
-		baseCfg := etcd.Config{
-			Endpoints: []string{addr},
-			Transport: etcd.DefaultTransport,
-		}
+		baseCfg := sb.confEtcd.clientConfig([]string{addr})
 		baseClient, err := etcd.New(baseCfg)
 		if err != nil {
 			return fmt.Errorf("create etcd client failed, config: %v", baseCfg)
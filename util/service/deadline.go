@@ -0,0 +1,120 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultOutboundDeadlineMargin = time.Millisecond * 20
+
+	// http请求的超时时间，单位毫秒，请求ctx会带上对应的deadline
+	REQUEST_TIMEOUT_HEADER = "X-Request-Timeout"
+
+	middlewareDeadlineShed = "deadline_shed"
+)
+
+// 下游调用相对上游deadline预留的时间
+var outboundDeadlineMargin = int64(defaultOutboundDeadlineMargin)
+
+// SetOutboundDeadlineMargin 设置下游调用需要预留的时间
+func SetOutboundDeadlineMargin(margin time.Duration) {
+	if margin < 0 {
+		margin = 0
+	}
+	atomic.StoreInt64(&outboundDeadlineMargin, int64(margin))
+}
+
+// GetOutboundDeadlineMargin 获取下游调用需要预留的时间
+func GetOutboundDeadlineMargin() time.Duration {
+	return time.Duration(atomic.LoadInt64(&outboundDeadlineMargin))
+}
+
+// OutboundContext 根据上游ctx的deadline，减去预留时间后生成下游调用使用的ctx，
+// 保证链式调用不会超出上游的超时预算；上游没有deadline时只附加cancel
+func OutboundContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadline(ctx, deadline.Add(-GetOutboundDeadlineMargin()))
+}
+
+// 上游请求剩余时间低于该值时直接拒绝，<=0 不拒绝
+var inboundDeadlineFloor int64
+
+// SetInboundDeadlineFloor 设置处理请求需要的最短剩余时间
+func SetInboundDeadlineFloor(floor time.Duration) {
+	if floor < 0 {
+		floor = 0
+	}
+	atomic.StoreInt64(&inboundDeadlineFloor, int64(floor))
+}
+
+// GetInboundDeadlineFloor 获取处理请求需要的最短剩余时间
+func GetInboundDeadlineFloor() time.Duration {
+	return time.Duration(atomic.LoadInt64(&inboundDeadlineFloor))
+}
+
+// shouldShed 剩余时间不足以完成请求时返回true，没有deadline的请求不拒绝
+func shouldShed(ctx context.Context) bool {
+	floor := GetInboundDeadlineFloor()
+	if floor <= 0 {
+		return false
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return false
+	}
+	return time.Until(deadline) < floor
+}
+
+// deadlineShedMiddleware 按 X-Request-Timeout 设置请求ctx的deadline，剩余时间不足时返回504
+func deadlineShedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get(REQUEST_TIMEOUT_HEADER); len(v) > 0 {
+			if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+				ctx, cancel := context.WithTimeout(r.Context(), time.Duration(ms)*time.Millisecond)
+				defer cancel()
+				r = r.WithContext(ctx)
+			}
+		}
+
+		if shouldShed(r.Context()) {
+			slog.Warnf("deadlineShedMiddleware --> shed request path:%s remote:%s", r.URL.Path, r.RemoteAddr)
+			writeError(w, r, http.StatusGatewayTimeout, "deadline too short")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func deadlineShedServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if shouldShed(ctx) {
+			slog.Warnf("deadlineShedServerInterceptor --> shed request method:%s", info.FullMethod)
+			return nil, status.Error(codes.DeadlineExceeded, "deadline too short")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func deadlineShedStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if shouldShed(ss.Context()) {
+			slog.Warnf("deadlineShedStreamServerInterceptor --> shed stream method:%s", info.FullMethod)
+			return status.Error(codes.DeadlineExceeded, "deadline too short")
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,85 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOutboundContext(t *testing.T) {
+	margin := time.Millisecond * 100
+	SetOutboundDeadlineMargin(margin)
+	defer SetOutboundDeadlineMargin(defaultOutboundDeadlineMargin)
+
+	inbound, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	outbound, ocancel := OutboundContext(inbound)
+	defer ocancel()
+
+	in, _ := inbound.Deadline()
+	out, ok := outbound.Deadline()
+	if !ok {
+		t.Fatalf("outbound ctx has no deadline")
+	}
+
+	if in.Sub(out) != margin {
+		t.Errorf("outbound deadline:%v inbound deadline:%v margin:%v", out, in, margin)
+	}
+
+	outbound, ocancel = OutboundContext(context.Background())
+	defer ocancel()
+	if _, ok := outbound.Deadline(); ok {
+		t.Errorf("outbound ctx should not have deadline")
+	}
+}
+
+func TestDeadlineShed(t *testing.T) {
+	SetInboundDeadlineFloor(50 * time.Millisecond)
+	defer SetInboundDeadlineFloor(0)
+
+	called := 0
+	h := deadlineShedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+
+	r := httptest.NewRequest("GET", "/echo", nil)
+	r.Header.Set(REQUEST_TIMEOUT_HEADER, "10")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusGatewayTimeout || called != 0 {
+		t.Errorf("nearly expired request code:%d called:%d", w.Code, called)
+	}
+
+	r = httptest.NewRequest("GET", "/echo", nil)
+	r.Header.Set(REQUEST_TIMEOUT_HEADER, "1000")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || called != 1 {
+		t.Errorf("request with enough time code:%d called:%d", w.Code, called)
+	}
+
+	// 没有deadline的请求不拒绝
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/echo", nil))
+	if w.Code != http.StatusOK || called != 2 {
+		t.Errorf("request without deadline code:%d called:%d", w.Code, called)
+	}
+
+	unary := deadlineShedServerInterceptor()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Echo/Echo"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Errorf("handler should not be called")
+		return nil, nil
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("grpc shed err:%v", err)
+	}
+}
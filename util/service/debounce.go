@@ -0,0 +1,74 @@
+package rocserv
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+)
+
+// reloadDebounce 服务发现、熔断配置等watch回调的合并间隔，单位纳秒，<=0 每次变更都回调
+var reloadDebounce int64
+
+func setReloadDebounce(d time.Duration) {
+	atomic.StoreInt64(&reloadDebounce, int64(d))
+}
+
+func getReloadDebounce() time.Duration {
+	return time.Duration(atomic.LoadInt64(&reloadDebounce))
+}
+
+// debouncer 变更停止d之后才用最新的数据回调一次，避免批量修改时频繁重新加载
+type debouncer struct {
+	d  time.Duration
+	fn func(r *etcd.Response)
+
+	mu     sync.Mutex
+	timer  *time.Timer
+	latest *etcd.Response
+	seq    uint64
+
+	// 保证回调串行，且不会用旧数据覆盖新数据
+	runMu   sync.Mutex
+	applied uint64
+}
+
+func newDebouncer(d time.Duration, fn func(r *etcd.Response)) *debouncer {
+	return &debouncer{
+		d:  d,
+		fn: fn,
+	}
+}
+
+func (m *debouncer) push(r *etcd.Response) {
+	if m.d <= 0 {
+		m.fn(r)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.latest = r
+	m.seq++
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.timer = time.AfterFunc(m.d, m.fire)
+}
+
+func (m *debouncer) fire() {
+	m.mu.Lock()
+	r, seq := m.latest, m.seq
+	m.mu.Unlock()
+
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+
+	if seq <= m.applied {
+		return
+	}
+	m.applied = seq
+	m.fn(r)
+}
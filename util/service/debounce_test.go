@@ -0,0 +1,44 @@
+package rocserv
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+)
+
+func TestDebouncerBurst(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	d := newDebouncer(50*time.Millisecond, func(r *etcd.Response) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, r.Node.Value)
+	})
+
+	for i := 1; i <= 10; i++ {
+		d.push(&etcd.Response{Node: &etcd.Node{Value: strconv.Itoa(i)}})
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 || got[0] != "10" {
+		t.Errorf("callbacks:%v", got)
+	}
+}
+
+func TestDebouncerDisabled(t *testing.T) {
+	var got []string
+	d := newDebouncer(0, func(r *etcd.Response) {
+		got = append(got, r.Node.Value)
+	})
+	d.push(&etcd.Response{Node: &etcd.Node{Value: "1"}})
+	d.push(&etcd.Response{Node: &etcd.Node{Value: "2"}})
+	if len(got) != 2 {
+		t.Errorf("callbacks:%v", got)
+	}
+}
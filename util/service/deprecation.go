@@ -0,0 +1,23 @@
+package rocserv
+
+import (
+	"sync"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+var (
+	deprecatedUsed sync.Map
+
+	// 便于测试替换
+	deprecationWarnf = slog.Warnf
+)
+
+// deprecated 使用已废弃的入口时打印告警，每个进程每个入口只打印一次
+func deprecated(name, replacement string) {
+	if _, loaded := deprecatedUsed.LoadOrStore(name, struct{}{}); loaded {
+		return
+	}
+
+	deprecationWarnf("DEPRECATED --> %s is deprecated and will be removed, use %s instead", name, replacement)
+}
@@ -0,0 +1,22 @@
+package rocserv
+
+import (
+	"testing"
+)
+
+func TestDeprecatedOnce(t *testing.T) {
+	origin := deprecationWarnf
+	defer func() { deprecationWarnf = origin }()
+
+	var warns int
+	deprecationWarnf = func(format string, args ...interface{}) {
+		warns++
+	}
+
+	for i := 0; i < 3; i++ {
+		deprecated("test.Old", "test.New")
+	}
+	if warns != 1 {
+		t.Errorf("deprecation warns:%d", warns)
+	}
+}
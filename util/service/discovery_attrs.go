@@ -0,0 +1,47 @@
+package rocserv
+
+import (
+	"strings"
+
+	"github.com/shawnfeng/sutil/sconf"
+)
+
+// 发布到服务发现中的配置，key为 section.key，如
+// [discovery]
+// publish = proto.version,grpc.max_msg_size
+const (
+	discoverySection    = "discovery"
+	discoveryPublishKey = "publish"
+)
+
+// configAttrs 读取需要发布的配置，配置不存在的key忽略
+func configAttrs(tf *sconf.TierConf) map[string]string {
+	keys := tf.ToSliceStringWithDefault(discoverySection, discoveryPublishKey, ",", nil)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	attrs := make(map[string]string, len(keys))
+	for _, k := range keys {
+		k = strings.TrimSpace(k)
+		idx := strings.Index(k, ".")
+		if idx <= 0 {
+			continue
+		}
+
+		if v := tf.ToStringWithDefault(k[:idx], k[idx+1:], ""); len(v) > 0 {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func setServAttrs(infos map[string]*ServInfo, attrs map[string]string) {
+	if len(attrs) == 0 {
+		return
+	}
+
+	for _, info := range infos {
+		info.Attrs = attrs
+	}
+}
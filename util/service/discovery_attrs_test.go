@@ -0,0 +1,51 @@
+package rocserv
+
+import (
+	"encoding/json"
+	"testing"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/shawnfeng/sutil/sconf"
+)
+
+func TestPublishedAttrsLookup(t *testing.T) {
+	tf := sconf.NewTierConf()
+	err := tf.Load([]byte("[discovery]\npublish = proto.version,proto.missing\n\n[proto]\nversion = 2\n"))
+	if err != nil {
+		t.Fatalf("load config err:%s", err)
+	}
+
+	infos := map[string]*ServInfo{
+		"proc_http": {Type: PROCESSOR_HTTP, Addr: "10.1.1.1:8080"},
+	}
+	setServAttrs(infos, configAttrs(tf))
+
+	js, _ := json.Marshal(&RegData{Servs: infos})
+	servPath := "/roc/dist2/base/test"
+	cli := &ClientEtcdV2{servPath: servPath}
+	cli.parseResponseV2(&etcd.Response{
+		Node: &etcd.Node{
+			Key: servPath,
+			Dir: true,
+			Nodes: etcd.Nodes{{
+				Key: servPath + "/1",
+				Dir: true,
+				Nodes: etcd.Nodes{{
+					Key:   servPath + "/1/" + BASE_LOC_REG_SERV,
+					Value: string(js),
+				}},
+			}},
+		},
+	})
+
+	s := cli.GetServAddr("proc_http", "key")
+	if s == nil {
+		t.Fatalf("serv not found")
+	}
+	if s.Attrs["proto.version"] != "2" {
+		t.Errorf("attrs:%v", s.Attrs)
+	}
+	if _, ok := s.Attrs["proto.missing"]; ok {
+		t.Errorf("missing key published:%v", s.Attrs)
+	}
+}
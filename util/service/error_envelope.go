@@ -0,0 +1,118 @@
+package rocserv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	ERROR_SERIALIZER_JSON = "json"
+)
+
+// ErrorEnvelope 框架返回给业务调用方的错误，如暂停流量、超时拒绝、网关转发失败
+type ErrorEnvelope struct {
+	// http状态码
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+// ErrorSerializer 错误的序列化方式，需要和processor的内容协商一致，如protobuf、msgpack
+type ErrorSerializer interface {
+	ContentType() string
+	Marshal(e *ErrorEnvelope) ([]byte, error)
+}
+
+type jsonErrorSerializer struct{}
+
+func (jsonErrorSerializer) ContentType() string {
+	return "application/json"
+}
+
+func (jsonErrorSerializer) Marshal(e *ErrorEnvelope) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+type errorSerializers struct {
+	mu sync.RWMutex
+	// 按名称注册的序列化方式
+	serializers map[string]ErrorSerializer
+	// processor使用的序列化方式名称，未设置的使用json
+	processors map[string]string
+}
+
+var errSerializers = &errorSerializers{
+	serializers: map[string]ErrorSerializer{ERROR_SERIALIZER_JSON: jsonErrorSerializer{}},
+	processors:  make(map[string]string),
+}
+
+// RegisterErrorSerializer 注册错误序列化方式，名称重复时覆盖
+func RegisterErrorSerializer(name string, s ErrorSerializer) error {
+	if len(name) == 0 || s == nil {
+		return fmt.Errorf("error serializer name or serializer empty")
+	}
+
+	errSerializers.mu.Lock()
+	defer errSerializers.mu.Unlock()
+
+	errSerializers.serializers[name] = s
+	return nil
+}
+
+// SetErrorSerializer 设置processor返回错误使用的序列化方式，需要先注册
+func SetErrorSerializer(processor, name string) error {
+	errSerializers.mu.Lock()
+	defer errSerializers.mu.Unlock()
+
+	if _, ok := errSerializers.serializers[name]; !ok {
+		return fmt.Errorf("error serializer:%s not registered", name)
+	}
+	errSerializers.processors[processor] = name
+	return nil
+}
+
+func (m *errorSerializers) get(processor string) ErrorSerializer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if s, ok := m.serializers[m.processors[processor]]; ok {
+		return s
+	}
+	return m.serializers[ERROR_SERIALIZER_JSON]
+}
+
+type processorContextKey struct{}
+
+// processorMiddleware 在请求ctx中记录处理请求的processor名称
+func processorMiddleware(processor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), processorContextKey{}, processor)))
+	})
+}
+
+func processorFromContext(ctx context.Context) string {
+	processor, _ := ctx.Value(processorContextKey{}).(string)
+	return processor
+}
+
+// writeError 按processor设置的序列化方式返回错误
+func writeError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	fun := "writeError -->"
+
+	processor := processorFromContext(r.Context())
+	s := errSerializers.get(processor)
+	body, err := s.Marshal(&ErrorEnvelope{Code: code, Error: msg})
+	if err != nil {
+		slog.Errorf("%s processor:%s marshal error envelope err:%s", fun, processor, err)
+		writeJSON(w, code, &ErrorEnvelope{Code: code, Error: msg})
+		return
+	}
+
+	w.Header().Set("Content-Type", s.ContentType())
+	w.WriteHeader(code)
+	w.Write(body)
+}
@@ -0,0 +1,75 @@
+package rocserv
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testProtoErrorSerializer 按protobuf编码 message { int32 code = 1; string error = 2; }
+type testProtoErrorSerializer struct{}
+
+func (testProtoErrorSerializer) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (testProtoErrorSerializer) Marshal(e *ErrorEnvelope) ([]byte, error) {
+	buf := make([]byte, 0, 16+len(e.Error))
+	buf = append(buf, 1<<3|0)
+	buf = appendUvarint(buf, uint64(e.Code))
+	buf = append(buf, 2<<3|2)
+	buf = appendUvarint(buf, uint64(len(e.Error)))
+	return append(buf, e.Error...), nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func TestErrorSerializerPerProcessor(t *testing.T) {
+	setTrafficPaused(true)
+	defer setTrafficPaused(false)
+
+	if err := SetErrorSerializer("proc_pb", "protobuf"); err == nil {
+		t.Errorf("unregistered serializer should fail")
+	}
+	if err := RegisterErrorSerializer("protobuf", testProtoErrorSerializer{}); err != nil {
+		t.Fatalf("register err:%s", err)
+	}
+	if err := SetErrorSerializer("proc_pb", "protobuf"); err != nil {
+		t.Fatalf("set serializer err:%s", err)
+	}
+	defer func() {
+		errSerializers.mu.Lock()
+		delete(errSerializers.processors, "proc_pb")
+		delete(errSerializers.serializers, "protobuf")
+		errSerializers.mu.Unlock()
+	}()
+
+	business := pauseMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	processorMiddleware("proc_pb", business).ServeHTTP(w, httptest.NewRequest("GET", "/echo", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Errorf("content type:%s", ct)
+	}
+	want, _ := testProtoErrorSerializer{}.Marshal(&ErrorEnvelope{Code: http.StatusServiceUnavailable, Error: trafficPausedMsg})
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != string(want) {
+		t.Errorf("protobuf code:%d body:%q want:%q", w.Code, w.Body.Bytes(), want)
+	}
+
+	// 未设置的processor使用json
+	w = httptest.NewRecorder()
+	processorMiddleware("proc_http", business).ServeHTTP(w, httptest.NewRequest("GET", "/echo", nil))
+	var e ErrorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatalf("json body:%s err:%s", w.Body.String(), err)
+	}
+	if e.Code != http.StatusServiceUnavailable || e.Error != trafficPausedMsg {
+		t.Errorf("json envelope:%+v", e)
+	}
+}
@@ -0,0 +1,176 @@
+package rocserv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/client"
+)
+
+// EtcdOption 自定义etcd client的连接参数，如TLS、用户名密码
+type EtcdOption func(*etcdOptions)
+
+type etcdOptions struct {
+	tls         *tls.Config
+	username    string
+	password    string
+	dialTimeout time.Duration
+}
+
+// WithEtcdTLS 使用TLS连接etcd
+func WithEtcdTLS(cfg *tls.Config) EtcdOption {
+	return func(o *etcdOptions) {
+		o.tls = cfg
+	}
+}
+
+// WithEtcdAuth 使用用户名密码连接etcd
+func WithEtcdAuth(username, password string) EtcdOption {
+	return func(o *etcdOptions) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithEtcdDialTimeout 连接etcd的超时时间
+func WithEtcdDialTimeout(d time.Duration) EtcdOption {
+	return func(o *etcdOptions) {
+		o.dialTimeout = d
+	}
+}
+
+var etcdOpts = struct {
+	mu   sync.Mutex
+	opts etcdOptions
+}{}
+
+// SetEtcdOptions 设置框架内创建etcd client使用的参数，需要在Serve及NewClientLookup之前调用
+func SetEtcdOptions(opts ...EtcdOption) {
+	etcdOpts.mu.Lock()
+	defer etcdOpts.mu.Unlock()
+
+	var o etcdOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	etcdOpts.opts = o
+}
+
+// newEtcdConfig 按SetEtcdOptions设置的参数生成etcd client配置
+func newEtcdConfig(endpoints []string) etcd.Config {
+	etcdOpts.mu.Lock()
+	o := etcdOpts.opts
+	etcdOpts.mu.Unlock()
+
+	return buildEtcdConfig(endpoints, o)
+}
+
+// buildEtcdConfig 多个endpoint时由etcd client在节点间failover
+func buildEtcdConfig(endpoints []string, o etcdOptions) etcd.Config {
+	cfg := etcd.Config{
+		Endpoints: endpoints,
+		Transport: etcd.DefaultTransport,
+		Username:  o.username,
+		Password:  o.password,
+	}
+
+	if o.tls != nil || o.dialTimeout > 0 {
+		dialTimeout := o.dialTimeout
+		if dialTimeout <= 0 {
+			dialTimeout = 30 * time.Second
+		}
+		cfg.Transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   dialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     o.tls,
+		}
+	}
+
+	return cfg
+}
+
+// EtcdConfig etcd的地址及连接参数，用于ServeWithConfig
+type EtcdConfig struct {
+	// etcd节点地址，多个节点时自动failover
+	Endpoints []string
+	BaseLoc   string
+
+	// CA证书，设置后使用TLS连接
+	CAFile string
+	// 客户端证书及私钥，etcd开启双向认证时设置
+	CertFile string
+	KeyFile  string
+
+	Username string
+	Password string
+
+	DialTimeout time.Duration
+}
+
+// toConfigEtcd 加载证书，未设置的连接参数使用SetEtcdOptions设置的值
+func (m *EtcdConfig) toConfigEtcd() (configEtcd, error) {
+	if len(m.Endpoints) == 0 {
+		return configEtcd{}, fmt.Errorf("etcd endpoints empty")
+	}
+
+	etcdOpts.mu.Lock()
+	o := etcdOpts.opts
+	etcdOpts.mu.Unlock()
+
+	if len(m.CAFile) > 0 || len(m.CertFile) > 0 {
+		tlsConfig, err := loadEtcdTLS(m.CAFile, m.CertFile, m.KeyFile)
+		if err != nil {
+			return configEtcd{}, err
+		}
+		o.tls = tlsConfig
+	}
+	if len(m.Username) > 0 {
+		o.username = m.Username
+		o.password = m.Password
+	}
+	if m.DialTimeout > 0 {
+		o.dialTimeout = m.DialTimeout
+	}
+
+	return configEtcd{
+		etcdAddrs:  m.Endpoints,
+		useBaseloc: m.BaseLoc,
+		opts:       &o,
+	}, nil
+}
+
+func loadEtcdTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	if len(caFile) > 0 {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read etcd ca:%s err:%s", caFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("parse etcd ca:%s failed", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load etcd cert:%s key:%s err:%s", certFile, keyFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,69 @@
+package rocserv
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEtcdOptions(t *testing.T) {
+	defer SetEtcdOptions()
+
+	tlsConfig := &tls.Config{ServerName: "etcd.test"}
+	SetEtcdOptions(WithEtcdTLS(tlsConfig), WithEtcdAuth("roc", "secret"))
+
+	cfg := newEtcdConfig([]string{"https://127.0.0.1:2379"})
+	if cfg.Username != "roc" || cfg.Password != "secret" {
+		t.Errorf("auth user:%s password:%s", cfg.Username, cfg.Password)
+	}
+
+	tr, ok := cfg.Transport.(*http.Transport)
+	if !ok || tr.TLSClientConfig != tlsConfig {
+		t.Errorf("transport tls not applied:%T", cfg.Transport)
+	}
+
+	SetEtcdOptions()
+	cfg = newEtcdConfig([]string{"http://127.0.0.1:2379"})
+	if len(cfg.Username) != 0 || cfg.Transport.(*http.Transport).TLSClientConfig != nil {
+		t.Errorf("options not reset")
+	}
+}
+
+func TestEtcdConfigOverride(t *testing.T) {
+	defer SetEtcdOptions()
+	SetEtcdOptions(WithEtcdAuth("global", "global"))
+
+	conf := EtcdConfig{
+		Endpoints:   []string{"http://127.0.0.1:2379", "http://127.0.0.2:2379"},
+		BaseLoc:     "/roc",
+		Username:    "roc",
+		Password:    "secret",
+		DialTimeout: time.Second,
+	}
+	confEtcd, err := conf.toConfigEtcd()
+	if err != nil {
+		t.Fatalf("to config err:%s", err)
+	}
+
+	cfg := confEtcd.clientConfig(confEtcd.etcdAddrs)
+	if len(cfg.Endpoints) != 2 || cfg.Username != "roc" || cfg.Password != "secret" {
+		t.Errorf("unexpected config endpoints:%v user:%s", cfg.Endpoints, cfg.Username)
+	}
+	if _, ok := cfg.Transport.(*http.Transport); !ok {
+		t.Errorf("dial timeout not applied:%T", cfg.Transport)
+	}
+
+	// 未设置连接参数的配置使用全局参数
+	if cfg := (configEtcd{}).clientConfig(nil); cfg.Username != "global" {
+		t.Errorf("global options not used, user:%s", cfg.Username)
+	}
+
+	conf.CAFile = "not_exist.pem"
+	if _, err := conf.toConfigEtcd(); err == nil {
+		t.Errorf("missing ca file should fail")
+	}
+	if _, err := (&EtcdConfig{}).toConfigEtcd(); err == nil {
+		t.Errorf("empty endpoints should fail")
+	}
+}
@@ -0,0 +1,4 @@
+package rocserv
+
+// ResetForTest 供其他包的测试重置全局service
+var ResetForTest = resetForTest
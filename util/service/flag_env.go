@@ -0,0 +1,48 @@
+package rocserv
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// 启动参数对应的环境变量，命令行参数未指定时使用环境变量，都未指定时使用默认值
+const (
+	envServ              = "ROC_SERV"
+	envSkey              = "ROC_SKEY"
+	envGroup             = "ROC_GROUP"
+	envLogDir            = "ROC_LOGDIR"
+	envLogMaxSize        = "ROC_LOGMAXSIZE"
+	envLogMaxBackups     = "ROC_LOGMAXBACKUPS"
+	envSidOffset         = "ROC_SIDOFFSET"
+	envSkipNilProcessor  = "ROC_SKIPNILPROCESSOR"
+	envPrintRegistration = "ROC_PRINTREGISTRATION"
+	envBackdoorAddr      = "ROC_BACKDOOR_ADDR"
+	envProcessorAddr     = "ROC_PROCESSOR_ADDR"
+	envWeight            = "ROC_WEIGHT"
+)
+
+func envInt(getenv func(string) string, name string) (int, error) {
+	v := getenv(name)
+	if len(v) == 0 {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("env %s:%s not int", name, v)
+	}
+	return n, nil
+}
+
+func envBool(getenv func(string) string, name string) (bool, error) {
+	v := getenv(name)
+	if len(v) == 0 {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("env %s:%s not bool", name, v)
+	}
+	return b, nil
+}
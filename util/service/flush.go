@@ -0,0 +1,74 @@
+package rocserv
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shawnfeng/sutil/slog"
+	"github.com/shawnfeng/sutil/slog/statlog"
+)
+
+// 方便测试替换
+var (
+	syncLog     = func() error { return recoverSync(slog.Sync) }
+	syncStatLog = func() error { return recoverSync(statlog.Sync) }
+
+	// 日志落盘失败时无法再写日志，输出到stderr
+	syncErrOutput io.Writer = os.Stderr
+)
+
+// recoverSync slog.Sync不返回错误，文件已关闭等异常时会panic，转为错误返回
+func recoverSync(sync func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	sync()
+	return nil
+}
+
+// syncWithRetry 失败时重试一次，仍失败则输出到stderr，提示日志可能不完整
+func syncWithRetry(name string, sync func() error) error {
+	err := sync()
+	if err == nil {
+		return nil
+	}
+
+	if err = sync(); err != nil {
+		fmt.Fprintf(syncErrOutput, "rocserv: sync %s failed, logs may be incomplete: %s\n", name, err)
+	}
+	return err
+}
+
+// syncLogs 落盘统计日志及业务日志，返回最后一个错误
+func syncLogs() error {
+	errStat := syncWithRetry("statlog", syncStatLog)
+	if err := syncWithRetry("log", syncLog); err != nil {
+		return err
+	}
+	return errStat
+}
+
+// handleFlush 落盘业务日志、统计日志并推送metrics，用于下线节点或短生命周期的调试实例
+func handleFlush(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	fun := "handleFlush -->"
+
+	slog.Infof("%s flush logs and metrics, remote:%s", fun, r.RemoteAddr)
+	if err := syncLogs(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := service.flushMetrics(); err != nil {
+		slog.Errorf("%s flush metrics err:%s", fun, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,105 @@
+package rocserv
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBackdoorFlush(t *testing.T) {
+	var logSynced, statSynced bool
+	oldLog, oldStat := syncLog, syncStatLog
+	syncLog = func() error { logSynced = true; return nil }
+	syncStatLog = func() error { statSynced = true; return nil }
+	defer func() { syncLog, syncStatLog = oldLog, oldStat }()
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "flush_total", Help: "test"})
+	registry.MustRegister(counter)
+	counter.Inc()
+
+	exporter, err := newStatsdExporter(server.LocalAddr().String(), "", false, registry)
+	if err != nil {
+		t.Fatalf("new exporter err:%s", err)
+	}
+	service.mutex.Lock()
+	service.statsd = exporter
+	service.mutex.Unlock()
+	defer func() {
+		service.mutex.Lock()
+		service.statsd = nil
+		service.mutex.Unlock()
+	}()
+
+	_, driver := (&backDoorHttp{}).Driver()
+	router := driver.(http.Handler)
+
+	// GET不会触发
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/backdoor/flush", nil))
+	if w.Code != http.StatusMethodNotAllowed || logSynced || statSynced {
+		t.Fatalf("get flush code:%d log synced:%t stat synced:%t", w.Code, logSynced, statSynced)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/backdoor/flush", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("flush code:%d body:%s", w.Code, w.Body.String())
+	}
+	if !logSynced || !statSynced {
+		t.Errorf("log synced:%t stat synced:%t", logSynced, statSynced)
+	}
+
+	lines := recvStatsd(t, server)
+	if len(lines) != 1 || lines[0] != "flush_total:1|c" {
+		t.Errorf("metrics not flushed, lines:%v", lines)
+	}
+}
+
+func TestSyncLogsFailure(t *testing.T) {
+	oldLog, oldStat, oldOut := syncLog, syncStatLog, syncErrOutput
+	defer func() { syncLog, syncStatLog, syncErrOutput = oldLog, oldStat, oldOut }()
+
+	var out bytes.Buffer
+	syncErrOutput = &out
+
+	// 第一次失败，重试成功
+	var logCalls int
+	syncLog = func() error {
+		logCalls++
+		if logCalls == 1 {
+			return errors.New("disk busy")
+		}
+		return nil
+	}
+	syncStatLog = func() error { return nil }
+	if err := syncLogs(); err != nil || logCalls != 2 {
+		t.Errorf("retry err:%v calls:%d", err, logCalls)
+	}
+	if out.Len() != 0 {
+		t.Errorf("recovered sync reported:%s", out.String())
+	}
+
+	// 重试仍失败时输出到stderr
+	syncStatLog = func() error {
+		return recoverSync(func() { panic("file already closed") })
+	}
+	if err := syncLogs(); err == nil {
+		t.Errorf("sync failure swallowed")
+	}
+	if !strings.Contains(out.String(), "sync statlog failed") || !strings.Contains(out.String(), "file already closed") {
+		t.Errorf("sync failure not reported:%s", out.String())
+	}
+}
@@ -0,0 +1,144 @@
+package rocserv
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/opentracing/opentracing-go"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	// 网关默认转发到后端的processor名称
+	PROCESSOR_HTTP_PROPERTY_NAME = "proc_http"
+)
+
+type gatewayRoute struct {
+	prefix  string
+	servLoc string
+	lookup  servLookup
+	next    uint64
+}
+
+// GatewayProcessor 按路径前缀将http请求转发到服务发现中的后端实例，实例间轮询
+type GatewayProcessor struct {
+	addr   string
+	routes map[string]string
+
+	// 后端服务注册的processor名称，默认proc_http
+	Processor string
+
+	newLookup func(servLoc string) (servLookup, error)
+
+	mu       sync.RWMutex
+	matchers []*gatewayRoute
+}
+
+// NewGatewayProcessor routes的key为路径前缀，value为后端服务的servLoc，如 base/account
+func NewGatewayProcessor(addr string, routes map[string]string) *GatewayProcessor {
+	return &GatewayProcessor{
+		addr:      addr,
+		routes:    routes,
+		Processor: PROCESSOR_HTTP_PROPERTY_NAME,
+		newLookup: newEtcdServLookup,
+	}
+}
+
+func (m *GatewayProcessor) Init() error {
+	fun := "GatewayProcessor.Init -->"
+
+	var matchers []*gatewayRoute
+	for prefix, servLoc := range m.routes {
+		lookup, err := m.newLookup(servLoc)
+		if err != nil {
+			slog.Errorf("%s lookup prefix:%s serv:%s err:%s", fun, prefix, servLoc, err)
+			return err
+		}
+
+		matchers = append(matchers, &gatewayRoute{
+			prefix:  prefix,
+			servLoc: servLoc,
+			lookup:  lookup,
+		})
+		slog.Infof("%s route prefix:%s to serv:%s", fun, prefix, servLoc)
+	}
+
+	// 最长前缀优先匹配
+	sort.Slice(matchers, func(i, j int) bool {
+		return len(matchers[i].prefix) > len(matchers[j].prefix)
+	})
+
+	m.mu.Lock()
+	m.matchers = matchers
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *GatewayProcessor) Driver() (string, interface{}) {
+	router := httprouter.New()
+	router.NotFound = m
+	return m.addr, router
+}
+
+func (m *GatewayProcessor) match(path string) *gatewayRoute {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.matchers {
+		if strings.HasPrefix(path, r.prefix) {
+			return r
+		}
+	}
+	return nil
+}
+
+// pick 在后端实例间轮询
+func (m *GatewayProcessor) pick(route *gatewayRoute) *ServInfo {
+	servs := route.lookup.GetAllServAddr(m.Processor)
+	if len(servs) == 0 {
+		return nil
+	}
+
+	idx := atomic.AddUint64(&route.next, 1)
+	return servs[idx%uint64(len(servs))]
+}
+
+func (m *GatewayProcessor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fun := "GatewayProcessor.ServeHTTP -->"
+
+	route := m.match(r.URL.Path)
+	if route == nil {
+		writeError(w, r, http.StatusNotFound, "route not found")
+		return
+	}
+
+	serv := m.pick(route)
+	if serv == nil {
+		slog.Warnf("%s no instance, path:%s serv:%s processor:%s", fun, r.URL.Path, route.servLoc, m.Processor)
+		writeError(w, r, http.StatusServiceUnavailable, "no available instance")
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = serv.Addr
+
+			// 传递trace
+			if span := opentracing.SpanFromContext(req.Context()); span != nil {
+				span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			slog.Errorf("%s proxy path:%s serv:%s addr:%s err:%s", fun, req.URL.Path, route.servLoc, serv.Addr, err)
+			writeError(w, req, http.StatusBadGateway, "bad gateway")
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
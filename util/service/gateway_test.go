@@ -0,0 +1,61 @@
+package rocserv
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testServLookup struct {
+	servs []*ServInfo
+}
+
+func (m *testServLookup) GetAllServAddr(processor string) []*ServInfo {
+	return m.servs
+}
+
+func TestGatewayProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("account:" + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	g := NewGatewayProcessor("127.0.0.1:", map[string]string{
+		"/api/":         "base/other",
+		"/api/account/": "base/account",
+	})
+	lookups := map[string]servLookup{
+		"base/account": &testServLookup{servs: []*ServInfo{{Type: PROCESSOR_HTTP, Addr: strings.TrimPrefix(backend.URL, "http://")}}},
+		"base/other":   &testServLookup{},
+	}
+	g.newLookup = func(servLoc string) (servLookup, error) {
+		return lookups[servLoc], nil
+	}
+	if err := g.Init(); err != nil {
+		t.Fatalf("init err:%s", err)
+	}
+
+	_, driver := g.Driver()
+	router := driver.(http.Handler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/account/get", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	if w.Code != http.StatusOK || string(body) != "account:/api/account/get" {
+		t.Errorf("proxy code:%d body:%s", w.Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/other", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("no instance code:%d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("no route code:%d", w.Code)
+	}
+}
@@ -117,6 +117,7 @@ func (m *ClientGrpc) getClient(provider *Provider) (*ServInfo, rpcClient, error)
 
 // deprecated
 func (m *ClientGrpc) Rpc(hashKey string, fnrpc func(interface{}) error) error {
+	deprecated("ClientGrpc.Rpc", "ClientGrpc.RpcWithContext")
 	return m.RpcWithContext(context.TODO(), hashKey, fnrpc)
 }
 
@@ -0,0 +1,74 @@
+package rocserv
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+// GrpcGateway grpc-gateway processor的driver，将同一服务中GrpcServer processor的接口以REST/JSON暴露，
+// 和grpc processor分别监听，启动时在其他processor之后绑定，下线时先于其他业务processor停止
+type GrpcGateway struct {
+	// grpc-gateway的runtime.ServeMux
+	Mux http.Handler
+	// 转发到的grpc processor名称
+	GrpcProcessor string
+	// 开始监听前调用，endpoint为GrpcProcessor的监听地址，用于RegisterXxxHandlerFromEndpoint，
+	// ctx在processor下线时结束，关闭到grpc processor的连接
+	Register func(ctx context.Context, endpoint string) error
+}
+
+func isGrpcGateway(p Processor) bool {
+	if p == nil {
+		return false
+	}
+	_, driver := p.Driver()
+	_, ok := driver.(*GrpcGateway)
+	return ok
+}
+
+// grpcEndpoint grpc processor启动后的监听地址
+func (m *Service) grpcEndpoint(processor string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	gs, ok := m.servers[processor].(*GrpcServer)
+	if !ok {
+		return "", fmt.Errorf("grpc processor:%s not started", processor)
+	}
+	return gs.addr, nil
+}
+
+// grpcGatewayHandle http server停止后关闭到grpc processor的连接
+type grpcGatewayHandle struct {
+	powerHandle
+	cancel context.CancelFunc
+}
+
+func (m *grpcGatewayHandle) Stop(ctx context.Context) error {
+	defer m.cancel()
+	return m.powerHandle.Stop(ctx)
+}
+
+// powerGrpcGateway chain为mux外层的中间件
+func powerGrpcGateway(processor, addr string, gw *GrpcGateway, endpoint string, chain *httpChain, tlsConfig *tls.Config) (string, powerHandle, error) {
+	if gw.Mux == nil {
+		return "", nil, fmt.Errorf("grpc gateway mux nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if gw.Register != nil {
+		if err := gw.Register(ctx, endpoint); err != nil {
+			cancel()
+			return "", nil, fmt.Errorf("register endpoint:%s err:%s", endpoint, err)
+		}
+	}
+
+	sa, h, err := powerHttp(processor, addr, chain.then(gw.Mux), tlsConfig)
+	if err != nil {
+		cancel()
+		return "", nil, err
+	}
+	return sa, &grpcGatewayHandle{powerHandle: h, cancel: cancel}, nil
+}
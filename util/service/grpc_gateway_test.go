@@ -0,0 +1,72 @@
+package rocserv
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestGrpcGatewayProcessor(t *testing.T) {
+	m := NewService()
+
+	var endpoint string
+	var registerCtx context.Context
+	gw := &GrpcGateway{
+		Mux: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+		GrpcProcessor: "proc_grpc",
+		Register: func(ctx context.Context, ep string) error {
+			registerCtx, endpoint = ctx, ep
+			return nil
+		},
+	}
+
+	// 名称排序在grpc processor之前，仍然在其之后启动
+	procs := map[string]Processor{
+		"api":       &testProcessor{addr: "127.0.0.1:", driver: gw},
+		"proc_grpc": &testProcessor{addr: "127.0.0.1:", driver: NewGrpcServer()},
+	}
+	infos, err := m.loadDriver(nil, procs, nil)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+
+	if infos["api"].Type != PROCESSOR_GRPC_GATEWAY || infos["proc_grpc"].Type != PROCESSOR_GRPC {
+		t.Errorf("infos:%+v %+v", infos["api"], infos["proc_grpc"])
+	}
+	if endpoint != infos["proc_grpc"].Addr {
+		t.Errorf("endpoint got:%s want:%s", endpoint, infos["proc_grpc"].Addr)
+	}
+	if infos["api"].Addr == endpoint {
+		t.Errorf("gateway and grpc should listen on separate ports")
+	}
+
+	resp, err := http.Get("http://" + infos["api"].Addr + "/v1/echo")
+	if err != nil {
+		t.Fatalf("get err:%s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("body:%s", body)
+	}
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown err:%s", err)
+	}
+	if registerCtx.Err() == nil {
+		t.Errorf("register ctx should be done after shutdown")
+	}
+}
+
+func TestGrpcGatewayWithoutGrpc(t *testing.T) {
+	m := NewService()
+
+	gw := &GrpcGateway{Mux: http.NotFoundHandler(), GrpcProcessor: "proc_grpc"}
+	_, err := m.loadDriver(nil, map[string]Processor{"api": &testProcessor{addr: "127.0.0.1:", driver: gw}}, nil)
+	if err == nil {
+		t.Errorf("gateway without grpc processor should fail")
+	}
+}
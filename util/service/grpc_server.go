@@ -2,6 +2,8 @@ package rocserv
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 
 	"github.com/shawnfeng/sutil/slog/slog"
 	"github.com/shawnfeng/sutil/stime"
@@ -13,24 +15,171 @@ import (
 	"github.com/opentracing-contrib/go-grpc"
 	"github.com/opentracing/opentracing-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+const middlewareStreamLimit = "stream_limit"
+
+// grpcMaxConcurrentStreams 每个连接上同时处理的stream数量，0 不限制
+var grpcMaxConcurrentStreams uint32
+
+// setGrpcMaxConcurrentStreams n<=0 时不限制
+func setGrpcMaxConcurrentStreams(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreUint32(&grpcMaxConcurrentStreams, uint32(n))
+}
+
+func getGrpcMaxConcurrentStreams() uint32 {
+	return atomic.LoadUint32(&grpcMaxConcurrentStreams)
+}
+
+// grpcStreamLimiter 按连接统计处理中的stream，超过grpcMaxConcurrentStreams时拒绝，
+// 业务在Serve之前创建GrpcServer，之后加载的配置无法再通过grpc.MaxConcurrentStreams设置到grpc.Server，
+// 因此在拦截器中使用当前配置
+type grpcStreamLimiter struct {
+	mu     sync.Mutex
+	active map[string]uint32
+}
+
+func (m *grpcStreamLimiter) acquire(ctx context.Context) (func(), error) {
+	limit := getGrpcMaxConcurrentStreams()
+	if limit == 0 {
+		return func() {}, nil
+	}
+
+	var conn string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		conn = p.Addr.String()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.active[conn] >= limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent streams, limit:%d", limit)
+	}
+	if m.active == nil {
+		m.active = make(map[string]uint32)
+	}
+	m.active[conn]++
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.active[conn]--
+		if m.active[conn] == 0 {
+			delete(m.active, conn)
+		}
+	}, nil
+}
+
+func (m *GrpcServer) streamLimitServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := m.streams.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+func (m *GrpcServer) streamLimitStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := m.streams.acquire(ss.Context())
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
 type GrpcServer struct {
 	Server *grpc.Server
+
+	// 框架添加的拦截器，按执行顺序
+	interceptors []grpcInterceptor
+
+	// 下线时关闭，通知进行中的stream
+	shutdown grpcShutdown
+
+	// 每个连接上处理中的stream
+	streams grpcStreamLimiter
+
+	// 为1时校验请求，见EnableValidation
+	validate int32
+
+	// 启动时设置的processor名称，用于按processor统计SLO
+	processor string
+	// 启动后的监听地址，grpc-gateway转发到该地址
+	addr string
+	// 启动时设置的Use添加的拦截器，为nil时不执行
+	uses *useGrpcChain
 }
 
 type FunInterceptor func(ctx context.Context, req interface{}, fun string) error
 
+// grpcInterceptor 带名称的拦截器，unary或stream为nil时该类型的请求不经过
+type grpcInterceptor struct {
+	name   string
+	unary  grpc.UnaryServerInterceptor
+	stream grpc.StreamServerInterceptor
+}
+
+// middlewares 按执行顺序返回生效的拦截器，uses为启动时Use添加的中间件，校验未开启时不列出
+func (m *GrpcServer) middlewares(uses []MiddlewareInfo) []MiddlewareInfo {
+	var infos []MiddlewareInfo
+	for _, i := range m.interceptors {
+		switch {
+		case i.name == middlewareUse:
+			infos = append(infos, uses...)
+		case i.name == middlewareValidate && !m.validateEnabled():
+		default:
+			infos = append(infos, frameworkMiddlewares(i.name)...)
+		}
+	}
+	return infos
+}
+
 // NewGrpcServer create grpc server with interceptors before handler
 func NewGrpcServer(fns ...FunInterceptor) *GrpcServer {
 
 	var unaryInterceptors []grpc.UnaryServerInterceptor
 	var streamInterceptors []grpc.StreamServerInterceptor
 
-	// add tracer、monitor interceptor
-	tracer := opentracing.GlobalTracer()
-	unaryInterceptors = append(unaryInterceptors, otgrpc.OpenTracingServerInterceptor(tracer), monitorServerInterceptor())
-	streamInterceptors = append(streamInterceptors, otgrpc.OpenTracingStreamServerInterceptor(tracer), monitorStreamServerInterceptor())
+	// add recovery、tracer、monitor interceptor，recovery在最外层，其他interceptor的panic也能恢复
+	gs := &GrpcServer{}
+
+	tracer := globalTracer{}
+	gs.interceptors = []grpcInterceptor{
+		{middlewareRecovery, gs.recoveryServerInterceptor(), gs.recoveryStreamServerInterceptor()},
+		{middlewareTracing, otgrpc.OpenTracingServerInterceptor(tracer), otgrpc.OpenTracingStreamServerInterceptor(tracer)},
+		{middlewareTraceForce, traceForceServerInterceptor(), traceForceStreamServerInterceptor()},
+		{middlewareShutdownNotify, nil, gs.shutdown.streamServerInterceptor()},
+		{middlewareStreamLimit, gs.streamLimitServerInterceptor(), gs.streamLimitStreamServerInterceptor()},
+		{middlewareMonitor, monitorServerInterceptor(), monitorStreamServerInterceptor()},
+		{middlewareRed, gs.redServerInterceptor(), gs.redStreamServerInterceptor()},
+		{middlewareSlo, gs.sloServerInterceptor(), gs.sloStreamServerInterceptor()},
+		{middlewarePause, pauseServerInterceptor(), pauseStreamServerInterceptor()},
+		{middlewareDeadlineShed, deadlineShedServerInterceptor(), deadlineShedStreamServerInterceptor()},
+		{middlewareRateLimit, gs.rateLimitServerInterceptor(), gs.rateLimitStreamServerInterceptor()},
+		{middlewareUse, gs.useServerInterceptor(), gs.useStreamServerInterceptor()},
+		{middlewareValidate, gs.validateServerInterceptor(), gs.validateStreamServerInterceptor()},
+	}
+	for _, i := range gs.interceptors {
+		if i.unary != nil {
+			unaryInterceptors = append(unaryInterceptors, i.unary)
+		}
+		if i.stream != nil {
+			streamInterceptors = append(streamInterceptors, i.stream)
+		}
+	}
 
 	// TODO 采用框架内显式注入interceptors的方式，不再进行二次包装，后续该部分功能会删除掉
 	//for _, fn := range fns {
@@ -64,8 +213,24 @@ func NewGrpcServer(fns ...FunInterceptor) *GrpcServer {
 	opts = append(opts, grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)))
 
 	// 实例化grpc Server
-	server := grpc.NewServer(opts...)
-	return &GrpcServer{Server: server}
+	gs.Server = grpc.NewServer(opts...)
+	return gs
+}
+
+// globalTracer 每次使用时取opentracing.GlobalTracer，
+// 业务通常在Serve之前调用NewGrpcServer，此时initTracer还未设置GlobalTracer
+type globalTracer struct{}
+
+func (globalTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	return opentracing.GlobalTracer().StartSpan(operationName, opts...)
+}
+
+func (globalTracer) Inject(sm opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	return opentracing.GlobalTracer().Inject(sm, format, carrier)
+}
+
+func (globalTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	return opentracing.GlobalTracer().Extract(format, carrier)
 }
 
 // server rpc cost, record to log and prometheus
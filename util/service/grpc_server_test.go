@@ -0,0 +1,183 @@
+package rocserv
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcMaxConcurrentStreams(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	// 和业务一样在加载配置之前创建server
+	server := NewGrpcServer()
+	server.Server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.Block",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName: "Block",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		}},
+	}, struct{}{})
+
+	setGrpcMaxConcurrentStreams(1)
+	defer setGrpcMaxConcurrentStreams(0)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	go server.Server.Serve(lis)
+	defer server.Server.Stop()
+
+	dial := func() *grpc.ClientConn {
+		conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+		if err != nil {
+			t.Fatalf("dial err:%s", err)
+		}
+		return conn
+	}
+	conn := dial()
+	defer conn.Close()
+
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	if _, err := conn.NewStream(context.Background(), desc, "/test.Block/Block"); err != nil {
+		t.Fatalf("first stream err:%s", err)
+	}
+	<-started
+
+	stream, err := conn.NewStream(context.Background(), desc, "/test.Block/Block")
+	if err == nil {
+		err = stream.RecvMsg(&struct{}{})
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("stream beyond limit err:%v", err)
+	}
+
+	// 按连接限制，其他连接不受影响
+	other := dial()
+	defer other.Close()
+	if _, err := other.NewStream(context.Background(), desc, "/test.Block/Block"); err != nil {
+		t.Fatalf("other conn stream err:%s", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Errorf("stream on other conn should be handled")
+	}
+}
+
+func TestGrpcStreamShutdownNotify(t *testing.T) {
+	server := NewGrpcServer()
+	server.Server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.Watch",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName: "Watch",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				<-ShutdownNotify(stream.Context())
+				return status.Error(codes.Unavailable, "server shutting down")
+			},
+			ServerStreams: true,
+		}},
+	}, struct{}{})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	go server.Server.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial err:%s", err)
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/test.Watch/Watch")
+	if err != nil {
+		t.Fatalf("new stream err:%s", err)
+	}
+	stream.CloseSend()
+	time.Sleep(100 * time.Millisecond)
+
+	go server.GracefulStop(time.Second)
+
+	err = stream.RecvMsg(&struct{}{})
+	if st, _ := status.FromError(err); st.Code() != codes.Unavailable || st.Message() != "server shutting down" {
+		t.Errorf("stream err:%v", err)
+	}
+}
+
+type testValidateReq struct {
+	name string
+}
+
+func (m *testValidateReq) Validate() error {
+	if len(m.name) == 0 {
+		return errors.New("name required")
+	}
+	return nil
+}
+
+func TestGrpcValidation(t *testing.T) {
+	gs := &GrpcServer{}
+	interceptor := gs.validateServerInterceptor()
+
+	var called int
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called++
+		return req, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Svc/Call"}
+
+	// 未开启时不校验
+	if _, err := interceptor(context.Background(), &testValidateReq{}, info, handler); err != nil || called != 1 {
+		t.Fatalf("validation should be opt-in, err:%v called:%d", err, called)
+	}
+
+	gs.EnableValidation()
+	gs.EnableValidation()
+
+	_, err := interceptor(context.Background(), &testValidateReq{}, info, handler)
+	if status.Code(err) != codes.InvalidArgument || called != 1 {
+		t.Errorf("invalid request err:%v called:%d", err, called)
+	}
+
+	if _, err := interceptor(context.Background(), &testValidateReq{name: "a"}, info, handler); err != nil || called != 2 {
+		t.Errorf("valid request err:%v called:%d", err, called)
+	}
+	if _, err := interceptor(context.Background(), struct{}{}, info, handler); err != nil || called != 3 {
+		t.Errorf("request without Validate err:%v called:%d", err, called)
+	}
+}
+
+func TestGrpcServerTracerSetLater(t *testing.T) {
+	// NewGrpcServer在initTracer之前调用，之后设置的tracer仍然生效
+	var tracer opentracing.Tracer = globalTracer{}
+
+	mock := mocktracer.New()
+	opentracing.SetGlobalTracer(mock)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	tracer.StartSpan("/test.Svc/Call").Finish()
+	if spans := mock.FinishedSpans(); len(spans) != 1 || spans[0].OperationName != "/test.Svc/Call" {
+		t.Errorf("spans:%v", spans)
+	}
+}
@@ -0,0 +1,103 @@
+package rocserv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog"
+	"google.golang.org/grpc"
+)
+
+const middlewareShutdownNotify = "shutdown_notify"
+
+type grpcShutdownKey struct{}
+
+// grpcShutdown 服务下线时关闭channel，通知进行中的stream尽快结束，client可以到其他实例重新订阅
+type grpcShutdown struct {
+	mu sync.Mutex
+	c  chan struct{}
+}
+
+func (m *grpcShutdown) channel() chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.c == nil {
+		m.c = make(chan struct{})
+	}
+	return m.c
+}
+
+func (m *grpcShutdown) notify() {
+	c := m.channel()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case <-c:
+	default:
+		close(c)
+	}
+}
+
+func (m *grpcShutdown) streamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &shutdownServerStream{
+			ServerStream: ss,
+			ctx:          context.WithValue(ss.Context(), grpcShutdownKey{}, m.channel()),
+		})
+	}
+}
+
+type shutdownServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (m *shutdownServerStream) Context() context.Context {
+	return m.ctx
+}
+
+// ShutdownNotify stream handler中使用，服务下线时返回的channel关闭
+// 非NewGrpcServer创建的server返回nil
+func ShutdownNotify(ctx context.Context) <-chan struct{} {
+	c, _ := ctx.Value(grpcShutdownKey{}).(chan struct{})
+	return c
+}
+
+// NotifyShutdown 通知进行中的stream服务即将下线
+func (m *GrpcServer) NotifyShutdown() {
+	m.shutdown.notify()
+}
+
+// GracefulStop 通知进行中的stream后等待结束，超过grace后强制关闭
+func (m *GrpcServer) GracefulStop(grace time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	m.Stop(ctx)
+}
+
+// Stop 通知进行中的stream后等待结束，ctx结束后强制关闭
+func (m *GrpcServer) Stop(ctx context.Context) error {
+	fun := "GrpcServer.Stop -->"
+
+	m.NotifyShutdown()
+
+	done := make(chan struct{})
+	go func() {
+		m.Server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		slog.Warnf("%s graceful stop err:%s, force stop", fun, ctx.Err())
+		m.Server.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package rocserv
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const middlewareValidate = "validate"
+
+// validator protoc-gen-validate生成的请求消息实现该接口
+type validator interface {
+	Validate() error
+}
+
+func validateMessage(msg interface{}) error {
+	v, ok := msg.(validator)
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// EnableValidation 开启请求校验，请求消息实现了 Validate() error 时在handler之前校验，
+// 失败返回InvalidArgument，需要在Serve之前调用
+func (m *GrpcServer) EnableValidation() {
+	atomic.StoreInt32(&m.validate, 1)
+}
+
+func (m *GrpcServer) validateEnabled() bool {
+	return atomic.LoadInt32(&m.validate) == 1
+}
+
+func (m *GrpcServer) validateServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if m.validateEnabled() {
+			if err := validateMessage(req); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (m *GrpcServer) validateStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if m.validateEnabled() {
+			ss = &validateServerStream{ServerStream: ss}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// validateServerStream 校验stream中收到的每个消息
+type validateServerStream struct {
+	grpc.ServerStream
+}
+
+func (m *validateServerStream) RecvMsg(msg interface{}) error {
+	if err := m.ServerStream.RecvMsg(msg); err != nil {
+		return err
+	}
+	return validateMessage(msg)
+}
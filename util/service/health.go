@@ -0,0 +1,349 @@
+// Copyright 2014 The roc Author. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rocserv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	healthStatusOK = "ok"
+
+	HEALTH_STATE_HEALTHY   = "healthy"
+	HEALTH_STATE_DEGRADED  = "degraded"
+	HEALTH_STATE_DRAINING  = "draining"
+	HEALTH_STATE_UNHEALTHY = "unhealthy"
+	// 服务还未完成注册
+	HEALTH_STATE_STARTING = "starting"
+)
+
+// dependencyProbes 定时探测服务依赖(db, redis等)，任何一个依赖探测失败服务都处于not ready状态
+type dependencyProbes struct {
+	mu     sync.RWMutex
+	status map[string]error
+	// 依赖开始探测失败的时间
+	downSince map[string]time.Time
+	// 可选依赖不可用时实例为degraded状态，仍然ready
+	optional map[string]bool
+
+	stopOnce sync.Once
+	stopC    chan struct{}
+}
+
+func newDependencyProbes() *dependencyProbes {
+	return &dependencyProbes{
+		status:    make(map[string]error),
+		downSince: make(map[string]time.Time),
+		optional:  make(map[string]bool),
+		stopC:     make(chan struct{}),
+	}
+}
+
+func (m *dependencyProbes) add(name string, interval time.Duration, probe func(context.Context) error) error {
+	if len(name) == 0 {
+		return fmt.Errorf("dependency name empty")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("dependency:%s interval must be positive", name)
+	}
+	if probe == nil {
+		return fmt.Errorf("dependency:%s probe is nil", name)
+	}
+
+	m.mu.Lock()
+	if _, ok := m.status[name]; ok {
+		m.mu.Unlock()
+		return fmt.Errorf("dependency:%s already added", name)
+	}
+	// 第一次探测完成前认为依赖不可用
+	m.status[name] = fmt.Errorf("not probed yet")
+	m.mu.Unlock()
+
+	go m.run(name, interval, probe)
+	return nil
+}
+
+func (m *dependencyProbes) run(name string, interval time.Duration, probe func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.probe(name, interval, probe)
+
+		select {
+		case <-ticker.C:
+		case <-m.stopC:
+			return
+		}
+	}
+}
+
+func (m *dependencyProbes) probe(name string, timeout time.Duration, probe func(context.Context) error) {
+	fun := "dependencyProbes.probe -->"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := probe(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := m.status[name]
+	if err != nil && prev == nil {
+		slog.Warnf("%s dependency:%s down err:%s", fun, name, err)
+	} else if err == nil && prev != nil {
+		slog.Infof("%s dependency:%s up", fun, name)
+	}
+	m.status[name] = err
+
+	if err == nil {
+		delete(m.downSince, name)
+	} else if _, ok := m.downSince[name]; !ok {
+		m.downSince[name] = time.Now()
+	}
+}
+
+// downFor 依赖持续探测失败的时长，依赖正常时返回0
+func (m *dependencyProbes) downFor(name string, now time.Time) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	since, ok := m.downSince[name]
+	if !ok {
+		return 0
+	}
+	return now.Sub(since)
+}
+
+func (m *dependencyProbes) setOptional(name string, optional bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.optional[name] = optional
+}
+
+// check 返回必需依赖是否全部可用，可选依赖是否有不可用的，以及每个依赖的状态
+func (m *dependencyProbes) check() (ready bool, degraded bool, status map[string]string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ready = true
+	status = make(map[string]string, len(m.status))
+	for name, err := range m.status {
+		if err == nil {
+			status[name] = healthStatusOK
+			continue
+		}
+
+		status[name] = err.Error()
+		if m.optional[name] {
+			degraded = true
+		} else {
+			ready = false
+		}
+	}
+
+	return ready, degraded, status
+}
+
+func (m *dependencyProbes) stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopC)
+	})
+}
+
+// dependencyDrainer 关键依赖持续不可用超过阈值时将实例摘除，恢复后重新加入
+type dependencyDrainer struct {
+	probes *dependencyProbes
+	// 关键依赖及其不可用阈值
+	critical map[string]time.Duration
+	setDrain func(drain bool) error
+
+	drained bool
+}
+
+func (m *dependencyDrainer) check(now time.Time) {
+	fun := "dependencyDrainer.check -->"
+
+	var down []string
+	for name, threshold := range m.critical {
+		if d := m.probes.downFor(name, now); d > 0 && d >= threshold {
+			down = append(down, name)
+		}
+	}
+
+	drain := len(down) > 0
+	if drain == m.drained {
+		return
+	}
+
+	if drain {
+		slog.Errorf("%s critical dependency down:%v, drain instance", fun, down)
+	} else {
+		slog.Infof("%s critical dependency recovered, undrain instance", fun)
+	}
+
+	err := m.setDrain(drain)
+	if err != nil {
+		slog.Errorf("%s set drain:%t err:%s", fun, drain, err)
+		return
+	}
+	m.drained = drain
+}
+
+func (m *dependencyDrainer) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.check(now)
+		case <-m.probes.stopC:
+			return
+		}
+	}
+}
+
+// registryKeepalive 记录注册信息在etcd中的续约情况，续约超过ttl未成功则认为注册已失效
+type registryKeepalive struct {
+	mu     sync.RWMutex
+	ttl    time.Duration
+	lastOK map[string]time.Time
+}
+
+func newRegistryKeepalive(ttl time.Duration) *registryKeepalive {
+	return &registryKeepalive{
+		ttl:    ttl,
+		lastOK: make(map[string]time.Time),
+	}
+}
+
+func (m *registryKeepalive) setTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ttl = ttl
+}
+
+func (m *registryKeepalive) track(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.lastOK[path]; !ok {
+		m.lastOK[path] = time.Time{}
+	}
+}
+
+func (m *registryKeepalive) ok(path string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastOK[path] = now
+}
+
+func (m *registryKeepalive) check(now time.Time) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for path, last := range m.lastOK {
+		if last.IsZero() {
+			return fmt.Errorf("path:%s not registered", path)
+		}
+		if now.Sub(last) >= m.ttl {
+			return fmt.Errorf("path:%s lease lost, last refresh:%s", path, last.Format("2006-01-02 15:04:05"))
+		}
+	}
+
+	return nil
+}
+
+type healthReport struct {
+	Ready        bool              `json:"ready"`
+	State        string            `json:"state"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+	Registry     string            `json:"registry"`
+	// RegisterHealthCheck注册的检查结果
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// readiness 必需依赖全部可用且注册信息续约正常时，实例才是ready的
+func (m *ServBaseV2) readiness() *healthReport {
+	ready, degraded, deps := m.probes.check()
+	report := &healthReport{
+		Dependencies: deps,
+		Registry:     healthStatusOK,
+	}
+
+	if err := m.keepalive.check(time.Now()); err != nil {
+		ready = false
+		report.Registry = err.Error()
+	}
+
+	switch {
+	case m.isStop() || m.isDrained():
+		report.State = HEALTH_STATE_DRAINING
+		ready = false
+	case !ready:
+		report.State = HEALTH_STATE_UNHEALTHY
+	case degraded:
+		report.State = HEALTH_STATE_DEGRADED
+	default:
+		report.State = HEALTH_STATE_HEALTHY
+	}
+
+	report.Ready = ready
+	return report
+}
+
+// healthCodes 各健康状态对应的http状态码
+type healthCodes struct {
+	mu    sync.RWMutex
+	codes map[string]int
+}
+
+var healthStatusCodes = &healthCodes{
+	codes: defaultHealthCodes(),
+}
+
+func defaultHealthCodes() map[string]int {
+	return map[string]int{
+		HEALTH_STATE_HEALTHY:   200,
+		HEALTH_STATE_DEGRADED:  200,
+		HEALTH_STATE_DRAINING:  503,
+		HEALTH_STATE_UNHEALTHY: 503,
+	}
+}
+
+// set 配置为0的状态使用默认值
+func (m *healthCodes) set(codes map[string]int) {
+	merged := defaultHealthCodes()
+	for state, code := range codes {
+		if code > 0 {
+			merged[state] = code
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.codes = merged
+}
+
+func (m *healthCodes) get(state string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if code, ok := m.codes[state]; ok {
+		return code
+	}
+	return 503
+}
@@ -0,0 +1,120 @@
+package rocserv
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// healthCheckTimeout 单个检查的超时时间，避免检查阻塞导致健康检查接口无响应
+const healthCheckTimeout = time.Second * 5
+
+// healthCheckers 业务注册的健康检查，每次请求/backdoor/health/check时同步执行
+type healthCheckers struct {
+	mu     sync.RWMutex
+	checks map[string]func() error
+	// 方便测试修改
+	timeout time.Duration
+}
+
+func newHealthCheckers() *healthCheckers {
+	return &healthCheckers{
+		checks:  make(map[string]func() error),
+		timeout: healthCheckTimeout,
+	}
+}
+
+func (m *healthCheckers) add(name string, fn func() error) error {
+	if len(name) == 0 {
+		return fmt.Errorf("health check name empty")
+	}
+	if fn == nil {
+		return fmt.Errorf("health check:%s fn is nil", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checks[name] = fn
+	return nil
+}
+
+// run 并发执行所有检查，返回是否全部通过及每个检查的结果
+func (m *healthCheckers) run() (bool, map[string]string) {
+	m.mu.RLock()
+	checks := make(map[string]func() error, len(m.checks))
+	for n, fn := range m.checks {
+		checks[n] = fn
+	}
+	m.mu.RUnlock()
+
+	type result struct {
+		name string
+		err  error
+	}
+	resC := make(chan result, len(checks))
+	for n, fn := range checks {
+		go func(n string, fn func() error) {
+			resC <- result{n, fn()}
+		}(n, fn)
+	}
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+
+	ok := true
+	status := make(map[string]string, len(checks))
+	for range checks {
+		select {
+		case r := <-resC:
+			if r.err != nil {
+				ok = false
+				status[r.name] = r.err.Error()
+			} else {
+				status[r.name] = healthStatusOK
+			}
+		case <-timer.C:
+			for n := range checks {
+				if _, done := status[n]; !done {
+					status[n] = "timeout"
+				}
+			}
+			return false, status
+		}
+	}
+	return ok, status
+}
+
+// RegisterHealthCheck 注册健康检查，如db ping、下游服务探测，任何一个检查失败时
+// /backdoor/health/check 返回503及各检查的结果，同名检查覆盖
+func (m *Service) RegisterHealthCheck(name string, fn func() error) error {
+	fun := "Service.RegisterHealthCheck -->"
+
+	err := m.healthChecks.add(name, fn)
+	if err != nil {
+		slog.Errorf("%s %s", fun, err)
+		return err
+	}
+
+	slog.Infof("%s health check:%s", fun, name)
+	return nil
+}
+
+func RegisterHealthCheck(name string, fn func() error) error {
+	return service.RegisterHealthCheck(name, fn)
+}
+
+// failedChecks 失败的检查名称，用于打日志
+func failedChecks(status map[string]string) []string {
+	var names []string
+	for n, s := range status {
+		if s != healthStatusOK {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,261 @@
+// Copyright 2014 The roc Author. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rocserv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitReady(probes *dependencyProbes, want bool) bool {
+	for i := 0; i < 100; i++ {
+		if ready, _, _ := probes.check(); ready == want {
+			return true
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return false
+}
+
+func TestDependencyProbe(t *testing.T) {
+	probes := newDependencyProbes()
+	defer probes.stop()
+
+	var down int32 = 1
+	err := probes.add("db", time.Millisecond*10, func(ctx context.Context) error {
+		if atomic.LoadInt32(&down) == 1 {
+			return fmt.Errorf("db down")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("add probe err:%s", err)
+	}
+
+	if !waitReady(probes, false) {
+		t.Errorf("failing probe should make readiness fail")
+	}
+
+	atomic.StoreInt32(&down, 0)
+	if !waitReady(probes, true) {
+		t.Errorf("recovered probe should restore readiness")
+	}
+
+	_, _, status := probes.check()
+	if status["db"] != healthStatusOK {
+		t.Errorf("unexpected status:%v", status)
+	}
+}
+
+func TestDependencyDrainer(t *testing.T) {
+	probes := newDependencyProbes()
+	defer probes.stop()
+
+	var drains []bool
+	drainer := &dependencyDrainer{
+		probes:   probes,
+		critical: map[string]time.Duration{"db": time.Second},
+		setDrain: func(drain bool) error {
+			drains = append(drains, drain)
+			return nil
+		},
+	}
+
+	now := time.Now()
+	probes.status["db"] = fmt.Errorf("db down")
+	probes.downSince["db"] = now
+
+	drainer.check(now.Add(time.Millisecond * 500))
+	if len(drains) != 0 {
+		t.Errorf("should not drain before threshold:%v", drains)
+	}
+
+	drainer.check(now.Add(time.Second * 2))
+	if len(drains) != 1 || !drains[0] {
+		t.Errorf("should drain on sustained failure:%v", drains)
+	}
+
+	probes.probe("db", time.Second, func(ctx context.Context) error { return nil })
+	drainer.check(now.Add(time.Second * 3))
+	if len(drains) != 2 || drains[1] {
+		t.Errorf("should undrain on recovery:%v", drains)
+	}
+}
+
+func TestRegistryKeepalive(t *testing.T) {
+	ka := newRegistryKeepalive(time.Minute)
+	now := time.Now()
+
+	ka.track("/roc/dist2/base/test/1/serve")
+	if err := ka.check(now); err == nil {
+		t.Errorf("unregistered path should not be ready")
+	}
+
+	ka.ok("/roc/dist2/base/test/1/serve", now)
+	if err := ka.check(now.Add(time.Second * 30)); err != nil {
+		t.Errorf("registered path should be ready:%s", err)
+	}
+
+	// 超过ttl未续约成功，认为lease已丢失
+	if err := ka.check(now.Add(time.Minute * 2)); err == nil {
+		t.Errorf("lease lost should not be ready")
+	}
+}
+
+func TestOptionalDependencyDegraded(t *testing.T) {
+	probes := newDependencyProbes()
+	defer probes.stop()
+
+	probes.setOptional("cache", true)
+	probes.probe("cache", time.Second, func(ctx context.Context) error { return fmt.Errorf("cache down") })
+
+	ready, degraded, _ := probes.check()
+	if !ready || !degraded {
+		t.Errorf("optional dependency down should be degraded, ready:%t degraded:%t", ready, degraded)
+	}
+}
+
+func TestHealthCodes(t *testing.T) {
+	codes := &healthCodes{codes: defaultHealthCodes()}
+	if codes.get(HEALTH_STATE_HEALTHY) != 200 || codes.get(HEALTH_STATE_DRAINING) != 503 {
+		t.Errorf("unexpected default codes:%v", codes.codes)
+	}
+
+	codes.set(map[string]int{
+		HEALTH_STATE_DEGRADED: 299,
+		HEALTH_STATE_DRAINING: 410,
+	})
+
+	want := map[string]int{
+		HEALTH_STATE_HEALTHY:   200,
+		HEALTH_STATE_DEGRADED:  299,
+		HEALTH_STATE_DRAINING:  410,
+		HEALTH_STATE_UNHEALTHY: 503,
+	}
+	for state, code := range want {
+		if got := codes.get(state); got != code {
+			t.Errorf("state:%s code:%d want:%d", state, got, code)
+		}
+	}
+}
+
+func TestHealthCheckers(t *testing.T) {
+	m := NewService()
+	if err := m.RegisterHealthCheck("", func() error { return nil }); err == nil {
+		t.Errorf("empty name should fail")
+	}
+	m.RegisterHealthCheck("db", func() error { return nil })
+
+	if ok, status := m.healthChecks.run(); !ok || status["db"] != healthStatusOK {
+		t.Errorf("checks should pass, status:%v", status)
+	}
+
+	m.RegisterHealthCheck("etcd", func() error { return fmt.Errorf("unreachable") })
+	m.healthChecks.timeout = 50 * time.Millisecond
+	block := make(chan struct{})
+	defer close(block)
+	m.RegisterHealthCheck("downstream", func() error { <-block; return nil })
+
+	ok, status := m.healthChecks.run()
+	if ok {
+		t.Errorf("checks should fail")
+	}
+	if status["db"] != healthStatusOK || status["etcd"] != "unreachable" || status["downstream"] != "timeout" {
+		t.Errorf("status:%v", status)
+	}
+}
+
+func TestHealthCheckHandler(t *testing.T) {
+	old := service
+	service = NewService()
+	defer func() { service = old }()
+
+	_, driver := (&backDoorHttp{}).Driver()
+	check := func() (int, string) {
+		w := httptest.NewRecorder()
+		driver.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", "/backdoor/health/check", nil))
+		return w.Code, w.Body.String()
+	}
+
+	if code, _ := check(); code != http.StatusOK {
+		t.Errorf("no checks code:%d", code)
+	}
+
+	RegisterHealthCheck("db", func() error { return fmt.Errorf("ping timeout") })
+	code, body := check()
+	if code != http.StatusServiceUnavailable || !strings.Contains(body, `"db":"ping timeout"`) {
+		t.Errorf("failed check code:%d body:%s", code, body)
+	}
+}
+
+func TestLiveAndReady(t *testing.T) {
+	old := service
+	service = NewService()
+	defer func() { service = old }()
+
+	_, driver := (&backDoorHttp{}).Driver()
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		driver.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	if code := get("/backdoor/health/live"); code != http.StatusOK {
+		t.Errorf("live code:%d", code)
+	}
+	if code := get("/backdoor/health/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("ready before registration code:%d", code)
+	}
+
+	service.markReady(nil)
+	if code := get("/backdoor/health/ready"); code != http.StatusOK {
+		t.Errorf("ready after registration code:%d", code)
+	}
+
+	RegisterHealthCheck("db", func() error { return fmt.Errorf("down") })
+	if code := get("/backdoor/health/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("ready with failed check code:%d", code)
+	}
+	if code := get("/backdoor/health/live"); code != http.StatusOK {
+		t.Errorf("live with failed check code:%d", code)
+	}
+}
+
+func TestExtraHealthPaths(t *testing.T) {
+	old := service
+	service = NewService()
+	defer func() { service = old }()
+
+	paths := checkExtraHealthPaths([]string{"/", " /healthz", "/healthz", "healthz", "/backdoor/health/ready", "/:id"})
+	if len(paths) != 2 || paths[0] != "/" || paths[1] != "/healthz" {
+		t.Fatalf("paths:%v", paths)
+	}
+
+	_, driver := (&backDoorHttp{extraHealthPaths: paths}).Driver()
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		driver.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	check := func() {
+		code, body := get("/backdoor/health/ready")
+		for _, path := range paths {
+			if c, b := get(path); c != code || b != body {
+				t.Errorf("path:%s code:%d body:%s, ready code:%d body:%s", path, c, b, code, body)
+			}
+		}
+	}
+
+	check()
+	service.markReady(nil)
+	check()
+}
@@ -0,0 +1,140 @@
+package rocserv
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// parseAddrOverrides 解析processor监听地址，格式 name=addr,name2=addr2
+func parseAddrOverrides(s string) (map[string]string, error) {
+	addrs := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		idx := strings.Index(item, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("processor addr:%s must be name=addr", item)
+		}
+		name, addr := strings.TrimSpace(item[:idx]), strings.TrimSpace(item[idx+1:])
+		if len(addr) == 0 {
+			return nil, fmt.Errorf("processor:%s addr empty", name)
+		}
+		if err := validateListenAddr(addr); err != nil {
+			return nil, fmt.Errorf("processor:%s invalid addr:%s err:%s", name, addr, err)
+		}
+		addrs[name] = addr
+	}
+	return addrs, nil
+}
+
+// validateListenAddr 检查processor的监听地址，格式为host:port，host为空时监听所有地址，port为空或0时随机端口，
+// 为兼容已有的processor允许空地址
+func validateListenAddr(addr string) error {
+	if len(addr) == 0 {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if len(port) > 0 {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 0 || p > 65535 {
+			return fmt.Errorf("invalid port:%s", port)
+		}
+	}
+
+	if len(host) == 0 || net.ParseIP(host) != nil {
+		return nil
+	}
+	if strings.Contains(host, ":") || strings.Trim(host, "0123456789.") == "" {
+		return fmt.Errorf("invalid ip:%s", host)
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return fmt.Errorf("invalid host:%s", host)
+		}
+	}
+	return nil
+}
+
+// addrOverrideProcessor 使用指定的地址代替Driver返回的地址监听
+type addrOverrideProcessor struct {
+	Processor
+	name string
+	addr string
+}
+
+func (m *addrOverrideProcessor) Driver() (string, interface{}) {
+	fun := "addrOverrideProcessor.Driver -->"
+
+	requested, driver := m.Processor.Driver()
+	slog.Infof("%s processor:%s driver addr:%s override:%s", fun, m.name, requested, m.addr)
+	return m.addr, driver
+}
+
+// withAddrOverrides 替换有指定监听地址的processor，返回新的map
+func withAddrOverrides(procs map[string]Processor, addrs map[string]string) map[string]Processor {
+	if len(addrs) == 0 {
+		return procs
+	}
+
+	res := make(map[string]Processor, len(procs))
+	for n, p := range procs {
+		if addr, ok := addrs[n]; ok && p != nil {
+			p = &addrOverrideProcessor{Processor: p, name: n, addr: addr}
+		}
+		res[n] = p
+	}
+	return res
+}
+
+// initProcessorAddrs 配置 [net] processoraddrs 及启动参数-processor-addr指定的processor监听地址，启动参数优先
+func (m *Service) initProcessorAddrs(sb *ServBaseV2, flagAddrs string) error {
+	fun := "Service.initProcessorAddrs -->"
+
+	var netConfig NetConfig
+	err := sb.ServConfig(&netConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	addrs, err := parseAddrOverrides(netConfig.Net.ProcessorAddrs)
+	if err != nil {
+		slog.Errorf("%s config processor addrs err:%s", fun, err)
+		return err
+	}
+
+	flags, err := parseAddrOverrides(flagAddrs)
+	if err != nil {
+		slog.Errorf("%s flag processor addrs err:%s", fun, err)
+		return err
+	}
+	for n, addr := range flags {
+		addrs[n] = addr
+	}
+
+	m.mutex.Lock()
+	m.addrOverrides = addrs
+	m.mutex.Unlock()
+
+	slog.Infof("%s processor addrs:%v", fun, addrs)
+	return nil
+}
+
+func (m *Service) getAddrOverrides() map[string]string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.addrOverrides
+}
@@ -0,0 +1,128 @@
+package rocserv
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	minAcceptDelay = time.Millisecond * 5
+	maxAcceptDelay = time.Second
+
+	minBindDelay = time.Millisecond * 100
+	maxBindDelay = time.Second
+)
+
+// 端口被占用时的重试次数，快速重启时老进程可能还没有释放端口
+var bindRetries int32
+
+func setBindRetries(n int) {
+	atomic.StoreInt32(&bindRetries, int32(n))
+}
+
+func getBindRetries() int {
+	return int(atomic.LoadInt32(&bindRetries))
+}
+
+func isAddrInUse(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "address already in use")
+}
+
+// bindWithRetry 端口被占用时按退避重试retries次
+func bindWithRetry(addr string, retries int, bind func() error) error {
+	fun := "bindWithRetry -->"
+
+	delay := minBindDelay
+	for i := 0; ; i++ {
+		err := bind()
+		if err == nil || !isAddrInUse(err) || i >= retries {
+			return err
+		}
+
+		slog.Warnf("%s addr:%s in use, retry:%d/%d in %s", fun, addr, i+1, retries, delay)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxBindDelay {
+			delay = maxBindDelay
+		}
+	}
+}
+
+// listen 端口被占用时按Net.BindRetries配置重试
+func listen(network, addr string) (net.Listener, error) {
+	var lis net.Listener
+	err := bindWithRetry(addr, getBindRetries(), func() error {
+		var err error
+		lis, err = net.Listen(network, addr)
+		return err
+	})
+	return lis, err
+}
+
+func isTemporaryErr(err error) bool {
+	if ne, ok := err.(net.Error); ok {
+		return ne.Temporary()
+	}
+
+	// thrift等会将net错误包装一层
+	if we, ok := err.(interface{ Err() error }); ok && we.Err() != nil && we.Err() != err {
+		return isTemporaryErr(we.Err())
+	}
+
+	return false
+}
+
+func nextAcceptDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+
+	delay *= 2
+	if delay > maxAcceptDelay {
+		delay = maxAcceptDelay
+	}
+	return delay
+}
+
+// backoffServerTransport thrift的accept循环出错后会立即重试，这里遇到临时错误(如too many open files)时进行退避，
+// 避免空转占满cpu；http、grpc自身已有退避，不需要包装
+type backoffServerTransport struct {
+	thrift.TServerTransport
+	name  string
+	delay time.Duration
+}
+
+func newBackoffServerTransport(t thrift.TServerTransport, name string) *backoffServerTransport {
+	return &backoffServerTransport{
+		TServerTransport: t,
+		name:             name,
+	}
+}
+
+func (m *backoffServerTransport) Accept() (thrift.TTransport, error) {
+	fun := "backoffServerTransport.Accept -->"
+
+	trans, err := m.TServerTransport.Accept()
+	if err == nil {
+		m.delay = 0
+		return trans, nil
+	}
+
+	// 非临时错误(如监听已关闭)直接返回
+	if !isTemporaryErr(err) {
+		m.delay = 0
+		return nil, err
+	}
+
+	m.delay = nextAcceptDelay(m.delay)
+	slog.Warnf("%s %s accept temporary err:%s retry in %s", fun, m.name, err, m.delay)
+	time.Sleep(m.delay)
+
+	return nil, err
+}
@@ -0,0 +1,92 @@
+package rocserv
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+type tempErr struct{}
+
+func (tempErr) Error() string   { return "too many open files" }
+func (tempErr) Timeout() bool   { return false }
+func (tempErr) Temporary() bool { return true }
+
+type fakeServerTransport struct {
+	thrift.TServerTransport
+	fails int
+	calls int
+	err   error
+}
+
+func (m *fakeServerTransport) Accept() (thrift.TTransport, error) {
+	m.calls++
+	if m.calls <= m.fails {
+		return nil, m.err
+	}
+	return thrift.NewTMemoryBuffer(), nil
+}
+
+func TestBackoffServerTransport(t *testing.T) {
+	ft := &fakeServerTransport{fails: 3, err: tempErr{}}
+	trans := newBackoffServerTransport(ft, "test")
+
+	// thrift的accept循环出错后立即重试
+	st := time.Now()
+	var err error
+	for i := 0; i < 4; i++ {
+		if _, err = trans.Accept(); err == nil {
+			break
+		}
+	}
+	if err != nil || ft.calls != 4 {
+		t.Fatalf("accept calls:%d err:%v", ft.calls, err)
+	}
+
+	// 5ms + 10ms + 20ms
+	if d := time.Since(st); d < minAcceptDelay*7 {
+		t.Errorf("accept should back off, elapsed:%s", d)
+	}
+
+	// 非临时错误不退避
+	ft = &fakeServerTransport{fails: 1, err: errors.New("closed")}
+	st = time.Now()
+	if _, err := newBackoffServerTransport(ft, "test").Accept(); err == nil {
+		t.Errorf("fatal err should be returned")
+	}
+	if d := time.Since(st); d >= minAcceptDelay {
+		t.Errorf("fatal err should not back off, elapsed:%s", d)
+	}
+}
+
+func TestBindWithRetry(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	addr := occupied.Addr().String()
+
+	if _, err := net.Listen("tcp", addr); !isAddrInUse(err) {
+		t.Fatalf("addr should be in use, err:%v", err)
+	}
+
+	// 模拟老进程稍后释放端口
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		occupied.Close()
+	}()
+
+	var lis net.Listener
+	err = bindWithRetry(addr, 5, func() error {
+		var err error
+		lis, err = net.Listen("tcp", addr)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("bind with retry err:%s", err)
+	}
+	lis.Close()
+}
@@ -0,0 +1,100 @@
+package rocserv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+var logLevels = map[string]bool{
+	"TRACE": true,
+	"DEBUG": true,
+	"INFO":  true,
+	"WARN":  true,
+	"ERROR": true,
+	"FATAL": true,
+	"PANIC": true,
+}
+
+// logState 当前日志目录及级别，slog没有单独设置级别的接口，变更级别时用同样的目录重新Init
+type logState struct {
+	mu    sync.Mutex
+	dir   string
+	level string
+	// 方便测试替换
+	init func(dir, level string)
+}
+
+var currentLog = &logState{
+	init: func(dir, level string) { slog.Init(dir, "serv.log", level) },
+}
+
+func (m *logState) setup(dir, level string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dir = dir
+	m.level = strings.ToUpper(level)
+	m.init(m.dir, m.level)
+}
+
+// reopen 日志切分后用同样的目录及级别重新打开
+func (m *logState) reopen() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.init(m.dir, m.level)
+}
+
+func (m *logState) setLevel(level string) error {
+	level = strings.ToUpper(level)
+	if !logLevels[level] {
+		return fmt.Errorf("unknown log level:%s", level)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.level = level
+	m.init(m.dir, m.level)
+	return nil
+}
+
+func (m *logState) getLevel() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.level
+}
+
+// handleGetLogLevel 查看当前日志级别
+func handleGetLogLevel(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]string{"level": currentLog.getLevel()})
+}
+
+// handleSetLogLevel 运行时变更日志级别, body: {"level":"DEBUG"}
+func handleSetLogLevel(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	fun := "handleSetLogLevel -->"
+
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	from := currentLog.getLevel()
+	if err := currentLog.setLevel(req.Level); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	slog.Warnf("%s change log level from:%s to:%s remote:%s", fun, from, currentLog.getLevel(), r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]string{"level": currentLog.getLevel()})
+}
@@ -0,0 +1,45 @@
+package rocserv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackdoorLogLevel(t *testing.T) {
+	var inits []string
+	old := currentLog
+	currentLog = &logState{init: func(dir, level string) { inits = append(inits, dir+":"+level) }}
+	defer func() { currentLog = old }()
+
+	currentLog.setup("/tmp/log", "info")
+
+	_, driver := (&backDoorHttp{}).Driver()
+	router := driver.(http.Handler)
+	do := func(method, body string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/backdoor/log/level", strings.NewReader(body)))
+		var res map[string]string
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res["level"]
+	}
+
+	if code, level := do("GET", ""); code != http.StatusOK || level != "INFO" {
+		t.Errorf("get level code:%d level:%s", code, level)
+	}
+	if code, level := do("POST", `{"level":"debug"}`); code != http.StatusOK || level != "DEBUG" {
+		t.Errorf("set level code:%d level:%s", code, level)
+	}
+	if code, _ := do("POST", `{"level":"verbose"}`); code != http.StatusBadRequest {
+		t.Errorf("unknown level code:%d", code)
+	}
+	if _, level := do("GET", ""); level != "DEBUG" {
+		t.Errorf("level after invalid set:%s", level)
+	}
+
+	if len(inits) != 2 || inits[1] != "/tmp/log:DEBUG" {
+		t.Errorf("slog init calls:%v", inits)
+	}
+}
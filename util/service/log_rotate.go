@@ -0,0 +1,227 @@
+package rocserv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	// 启动参数未设置时的默认值
+	defaultLogMaxSize    = 100 // MB
+	defaultLogMaxBackups = 7
+
+	logRotateInterval = time.Second * 10
+
+	// 切分文件名中的时间，和slog使用的lumberjack一致，如 serv.log 切分为 serv-2019-01-01T00-00-00.000.log
+	logBackupTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// logRotation 返回生效的切分参数，<=0 时使用默认值
+func logRotation(maxSize, maxBackups int) (int, int) {
+	if maxSize <= 0 {
+		maxSize = defaultLogMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = defaultLogMaxBackups
+	}
+	return maxSize, maxBackups
+}
+
+// logRotationConfig 配置中的切分参数优先，未配置时使用启动参数
+func logRotationConfig(cfg LogConfig, args *cmdArgs) (int, int) {
+	maxSize, maxBackups := cfg.Log.MaxSize, cfg.Log.MaxBackups
+	if maxSize <= 0 {
+		maxSize = args.logMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = args.logMaxBackups
+	}
+	return logRotation(maxSize, maxBackups)
+}
+
+// watchLogRotation 配置变更时更新切分参数，下次检查时按新的参数切分及清理
+func watchLogRotation(sb ServBase, r *logRotator, args *cmdArgs) {
+	fun := "watchLogRotation -->"
+
+	sb.WatchConfig(func(newRaw []byte) {
+		var logConfig LogConfig
+		if err := sb.ServConfig(&logConfig); err != nil {
+			slog.Errorf("%s serv config err:%s", fun, err)
+			return
+		}
+
+		maxSize, maxBackups := logRotationConfig(logConfig, args)
+		if oldSize, oldBackups := r.limits(); oldSize == maxSize && oldBackups == maxBackups {
+			return
+		}
+		r.setLimits(maxSize, maxBackups)
+		slog.Infof("%s log maxsize:%dMB maxbackups:%d", fun, maxSize, maxBackups)
+	})
+}
+
+// logBackupName active在t时刻切分出的文件名
+func logBackupName(active string, t time.Time) string {
+	ext := filepath.Ext(active)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(active, ext), t.Format(logBackupTimeFormat), ext)
+}
+
+// isLogBackup name是否为active切分出的文件，只匹配logBackupName的格式，允许压缩后的.gz后缀
+func isLogBackup(active, name string) bool {
+	ext := filepath.Ext(active)
+	stamp := strings.TrimPrefix(strings.TrimSuffix(name, ".gz"), strings.TrimSuffix(active, ext)+"-")
+	if !strings.HasSuffix(stamp, ext) || len(stamp) != len(logBackupTimeFormat)+len(ext) {
+		return false
+	}
+	_, err := time.Parse(logBackupTimeFormat, strings.TrimSuffix(stamp, ext))
+	return err == nil
+}
+
+// pruneLogBackups 删除dir下active切分出的旧文件，按修改时间保留最新的maxBackups个
+func pruneLogBackups(dir, active string, maxBackups int) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []os.FileInfo
+	for _, f := range files {
+		if f.IsDir() || !isLogBackup(active, f.Name()) {
+			continue
+		}
+		backups = append(backups, f)
+	}
+
+	if len(backups) <= maxBackups {
+		return nil, nil
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ModTime().After(backups[j].ModTime())
+	})
+
+	var removed []string
+	for _, f := range backups[maxBackups:] {
+		path := filepath.Join(dir, f.Name())
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// rotatedLog 框架按大小切分的日志文件，reopen重新打开name
+type rotatedLog struct {
+	name   string
+	reopen func()
+}
+
+// logRotator 按大小切分dir下的日志并清理超出数量的切分文件，
+// slog不支持设置切分大小，超过maxSize时将日志重命名为切分文件后重新打开，
+// 重新打开之前写入的日志仍在重命名后的文件中，不会丢失
+type logRotator struct {
+	dir  string
+	logs []rotatedLog
+	now  func() time.Time
+
+	mu         sync.Mutex
+	maxSize    int // MB
+	maxBackups int
+}
+
+func newLogRotator(dir string, maxSize, maxBackups int, logs ...rotatedLog) *logRotator {
+	m := &logRotator{
+		dir:  dir,
+		logs: logs,
+		now:  time.Now,
+	}
+	m.setLimits(maxSize, maxBackups)
+	return m
+}
+
+// setLimits <=0 时使用默认值
+func (m *logRotator) setLimits(maxSize, maxBackups int) {
+	maxSize, maxBackups = logRotation(maxSize, maxBackups)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maxSize, m.maxBackups = maxSize, maxBackups
+}
+
+func (m *logRotator) limits() (int, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.maxSize, m.maxBackups
+}
+
+// rotate 切分超过maxSize的日志，清理超过maxBackups的切分文件
+func (m *logRotator) rotate() error {
+	fun := "logRotator.rotate -->"
+
+	maxSize, maxBackups := m.limits()
+
+	var errs []string
+	for _, l := range m.logs {
+		path := filepath.Join(m.dir, l.name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+
+		if fi.Size() >= int64(maxSize)<<20 {
+			backup := filepath.Join(m.dir, logBackupName(l.name, m.now()))
+			if err := os.Rename(path, backup); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			l.reopen()
+			slog.Infof("%s rotate log:%s size:%d to:%s", fun, path, fi.Size(), backup)
+		}
+
+		removed, err := pruneLogBackups(m.dir, l.name, maxBackups)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		if len(removed) > 0 {
+			slog.Infof("%s removed log backups:%v", fun, removed)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// rotateLogsLoop 定期切分及清理日志，服务下线后退出
+func (m *Service) rotateLogsLoop(r *logRotator) {
+	fun := "Service.rotateLogsLoop -->"
+
+	ticker := time.NewTicker(logRotateInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.rotate(); err != nil {
+			slog.Warnf("%s dir:%s err:%s", fun, r.dir, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.stopC:
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package rocserv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestLogRotationDefaults(t *testing.T) {
+	if size, backups := logRotation(0, 0); size != defaultLogMaxSize || backups != defaultLogMaxBackups {
+		t.Errorf("defaults size:%d backups:%d", size, backups)
+	}
+	if size, backups := logRotation(10, 3); size != 10 || backups != 3 {
+		t.Errorf("flags size:%d backups:%d", size, backups)
+	}
+}
+
+func TestIsLogBackup(t *testing.T) {
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"serv-2019-01-01T00-00-00.000.log", true},
+		{"serv-2019-01-01T00-00-00.000.log.gz", true},
+		{"serv.log", false},
+		{"serv.log.1", false},
+		{"serv-notes.log", false},
+		{"serv-2019-01-01.log", false},
+		{"serv-2019-01-01T00-00-00.000.txt", false},
+		{"service-2019-01-01T00-00-00.000.log", false},
+		{"stat-2019-01-01T00-00-00.000.log", false},
+	}
+	for _, c := range cases {
+		if got := isLogBackup("serv.log", c.name); got != c.want {
+			t.Errorf("name:%s got:%t want:%t", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPruneLogBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roc_log")
+	if err != nil {
+		t.Fatalf("temp dir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	touch := func(name string, age time.Duration) {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("write err:%s", err)
+		}
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+
+	touch("serv.log", 0)
+	touch("stat.log", 0)
+	touch("stat.log.1", 10*time.Hour)
+	touch("service.txt", 10*time.Hour)
+	// 前缀相同但不是切分文件，不能删除
+	touch("serv-notes.log", 10*time.Hour)
+	touch("serv.log.bak", 10*time.Hour)
+	var backups []string
+	for i := 1; i <= 4; i++ {
+		name := logBackupName("serv.log", now.Add(-time.Duration(i)*time.Hour))
+		backups = append(backups, name)
+		touch(name, time.Duration(i)*time.Hour)
+	}
+
+	removed, err := pruneLogBackups(dir, "serv.log", 2)
+	if err != nil {
+		t.Fatalf("prune err:%s", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("removed:%v", removed)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	want := []string{backups[1], backups[0], "serv-notes.log", "serv.log", "serv.log.bak", "service.txt", "stat.log", "stat.log.1"}
+	sort.Strings(want)
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("files:%v want:%v", names, want)
+	}
+}
+
+func TestLogRotatorRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roc_log")
+	if err != nil {
+		t.Fatalf("temp dir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "serv.log")
+	var reopened int
+	r := newLogRotator(dir, 1, 1, rotatedLog{name: "serv.log", reopen: func() {
+		reopened++
+		ioutil.WriteFile(path, nil, 0644)
+	}})
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	// 未超过maxSize时不切分
+	ioutil.WriteFile(path, make([]byte, 1<<20-1), 0644)
+	if err := r.rotate(); err != nil || reopened != 0 {
+		t.Fatalf("rotate err:%v reopened:%d", err, reopened)
+	}
+
+	ioutil.WriteFile(path, make([]byte, 1<<20), 0644)
+	if err := r.rotate(); err != nil || reopened != 1 {
+		t.Fatalf("rotate err:%v reopened:%d", err, reopened)
+	}
+	fi, err := os.Stat(filepath.Join(dir, logBackupName("serv.log", now)))
+	if err != nil || fi.Size() != 1<<20 {
+		t.Errorf("backup:%v err:%v", fi, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 0 {
+		t.Errorf("active:%v err:%v", fi, err)
+	}
+
+	// 超过maxBackups的切分文件被清理
+	ioutil.WriteFile(path, make([]byte, 1<<20), 0644)
+	now = now.Add(time.Second)
+	if err := r.rotate(); err != nil || reopened != 2 {
+		t.Fatalf("rotate err:%v reopened:%d", err, reopened)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("files:%d", len(files))
+	}
+}
+
+func TestWatchLogRotation(t *testing.T) {
+	sb, err := NewTestServBase("base/test", 1, "[log]\nmaxsize = 10\n")
+	if err != nil {
+		t.Fatalf("new servbase err:%s", err)
+	}
+	args := &cmdArgs{logMaxSize: 50, logMaxBackups: 3}
+
+	var cfg LogConfig
+	sb.ServConfig(&cfg)
+	size, backups := logRotationConfig(cfg, args)
+	if size != 10 || backups != 3 {
+		t.Fatalf("config size:%d backups:%d", size, backups)
+	}
+
+	r := newLogRotator("", size, backups)
+	watchLogRotation(sb, r, args)
+
+	sb.SetConfig("[log]\nmaxsize = 20\nmaxbackups = 5\n")
+	if size, backups := r.limits(); size != 20 || backups != 5 {
+		t.Errorf("changed size:%d backups:%d", size, backups)
+	}
+
+	// 去掉配置后使用启动参数
+	sb.SetConfig("")
+	if size, backups := r.limits(); size != 50 || backups != 3 {
+		t.Errorf("removed size:%d backups:%d", size, backups)
+	}
+}
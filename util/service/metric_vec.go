@@ -0,0 +1,111 @@
+package rocserv
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// labelKey label值拼接为缓存key，单个label时不分配内存
+func labelKey(labelValues []string) string {
+	if len(labelValues) == 1 {
+		return labelValues[0]
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// CounterVec 缓存每组label对应的counter，热点路径上使用动态label时避免每次请求查找、分配
+type CounterVec struct {
+	vec      *prometheus.CounterVec
+	children sync.Map
+}
+
+// NewCounterVec 创建并注册到默认registry，和已注册的metric冲突时返回错误
+func NewCounterVec(opts prometheus.CounterOpts, labelNames []string) (*CounterVec, error) {
+	return newCounterVec(prometheus.DefaultRegisterer, opts, labelNames)
+}
+
+func newCounterVec(registry prometheus.Registerer, opts prometheus.CounterOpts, labelNames []string) (*CounterVec, error) {
+	vec := prometheus.NewCounterVec(opts, labelNames)
+	if err := registerMetric(registry, vec); err != nil {
+		return nil, err
+	}
+	return &CounterVec{vec: vec}, nil
+}
+
+// With 获取label对应的counter，label数量不匹配时返回错误
+func (m *CounterVec) With(labelValues ...string) (prometheus.Counter, error) {
+	key := labelKey(labelValues)
+	if c, ok := m.children.Load(key); ok {
+		return c.(prometheus.Counter), nil
+	}
+
+	c, err := m.vec.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := m.children.LoadOrStore(key, c)
+	return actual.(prometheus.Counter), nil
+}
+
+func (m *CounterVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+func (m *CounterVec) Add(v float64, labelValues ...string) {
+	fun := "CounterVec.Add -->"
+
+	c, err := m.With(labelValues...)
+	if err != nil {
+		slog.Errorf("%s labels:%v err:%s", fun, labelValues, err)
+		return
+	}
+	c.Add(v)
+}
+
+// HistogramVec 缓存每组label对应的histogram
+type HistogramVec struct {
+	vec      *prometheus.HistogramVec
+	children sync.Map
+}
+
+// NewHistogramVec 创建并注册到默认registry，和已注册的metric冲突时返回错误
+func NewHistogramVec(opts prometheus.HistogramOpts, labelNames []string) (*HistogramVec, error) {
+	return newHistogramVec(prometheus.DefaultRegisterer, opts, labelNames)
+}
+
+func newHistogramVec(registry prometheus.Registerer, opts prometheus.HistogramOpts, labelNames []string) (*HistogramVec, error) {
+	vec := prometheus.NewHistogramVec(opts, labelNames)
+	if err := registerMetric(registry, vec); err != nil {
+		return nil, err
+	}
+	return &HistogramVec{vec: vec}, nil
+}
+
+// With 获取label对应的histogram，label数量不匹配时返回错误
+func (m *HistogramVec) With(labelValues ...string) (prometheus.Observer, error) {
+	key := labelKey(labelValues)
+	if o, ok := m.children.Load(key); ok {
+		return o.(prometheus.Observer), nil
+	}
+
+	o, err := m.vec.GetMetricWithLabelValues(labelValues...)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := m.children.LoadOrStore(key, o)
+	return actual.(prometheus.Observer), nil
+}
+
+func (m *HistogramVec) Observe(v float64, labelValues ...string) {
+	fun := "HistogramVec.Observe -->"
+
+	o, err := m.With(labelValues...)
+	if err != nil {
+		slog.Errorf("%s labels:%v err:%s", fun, labelValues, err)
+		return
+	}
+	o.Observe(v)
+}
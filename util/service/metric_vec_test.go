@@ -0,0 +1,65 @@
+package rocserv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCounterVecConcurrent(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter, err := newCounterVec(registry, prometheus.CounterOpts{Name: "test_vec_total", Help: "test"}, []string{"api", "code"})
+	if err != nil {
+		t.Fatalf("new counter vec err:%s", err)
+	}
+	if _, err := newCounterVec(registry, prometheus.CounterOpts{Name: "test_vec_total", Help: "test"}, []string{"api", "code"}); err == nil {
+		t.Errorf("duplicate register should fail")
+	}
+
+	hist, err := newHistogramVec(registry, prometheus.HistogramOpts{Name: "test_vec_seconds", Help: "test"}, []string{"api"})
+	if err != nil {
+		t.Fatalf("new histogram vec err:%s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				api := fmt.Sprintf("/api/%d", j%2)
+				counter.Inc(api, "200")
+				hist.Observe(0.1, api)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	a, _ := counter.With("/api/0", "200")
+	b, _ := counter.With("/api/0", "200")
+	if a != b {
+		t.Errorf("child counter not reused")
+	}
+
+	var children int
+	counter.children.Range(func(_, _ interface{}) bool {
+		children++
+		return true
+	})
+	if children != 2 {
+		t.Errorf("cached children:%d", children)
+	}
+
+	var m dto.Metric
+	a.Write(&m)
+	if v := m.GetCounter().GetValue(); v != 400 {
+		t.Errorf("counter value:%v", v)
+	}
+
+	if _, err := counter.With("/api/0"); err == nil {
+		t.Errorf("label count mismatch should fail")
+	}
+}
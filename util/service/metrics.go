@@ -1,6 +1,14 @@
 package rocserv
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shawnfeng/sutil/slog"
 	"gitlab.pri.ibanyu.com/middleware/seaweed/xstat/xmetric"
 	xprom "gitlab.pri.ibanyu.com/middleware/seaweed/xstat/xmetric/xprometheus"
 )
@@ -18,6 +26,8 @@ const (
 
 	labelStatus = "status"
 
+	defaultMetricsPath = "/metrics"
+
 	apiType = "api"
 	logType = "log"
 	dbType  = "db"
@@ -98,6 +108,14 @@ var (
 		Help:       "db request time",
 		LabelNames: []string{xprom.LabelGroupName, xprom.LabelServiceName, xprom.LabelSource},
 	})
+
+	// 从Init开始到服务注册完成可以接收请求的耗时
+	_metricTimeToReady = xprom.NewGauge(&xprom.GaugeVecOpts{
+		Namespace:  namespacePalfish,
+		Name:       "time_to_ready_seconds",
+		Help:       "duration from service init to ready in seconds",
+		LabelNames: []string{xprom.LabelGroupName, xprom.LabelServiceName},
+	})
 )
 
 func GetSlaDurationMetric() xmetric.Histogram {
@@ -131,3 +149,90 @@ func GetDBRequestCountMetric() xmetric.Counter {
 func GetDBRequestTimeMetric() xmetric.Histogram {
 	return _metricDBRequestTime
 }
+
+// metricsProcessor 包装metrics processor，支持将metrics暴露在配置的路径上
+type metricsProcessor struct {
+	Processor
+	path string
+	// 抓取结果缓存时长，<=0 不缓存
+	cacheTTL time.Duration
+}
+
+func newMetricsProcessor(p Processor, path string) (*metricsProcessor, error) {
+	if len(path) == 0 {
+		path = defaultMetricsPath
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("metrics path:%s must start with '/'", path)
+	}
+
+	return &metricsProcessor{
+		Processor: p,
+		path:      path,
+	}, nil
+}
+
+func (m *metricsProcessor) Driver() (string, interface{}) {
+	fun := "metricsProcessor.Driver -->"
+
+	addr, driver := m.Processor.Driver()
+	if m.path == defaultMetricsPath && m.cacheTTL <= 0 {
+		return addr, driver
+	}
+
+	handler, ok := driver.(http.Handler)
+	if !ok {
+		slog.Warnf("%s driver:%T not http handler, use default path without cache", fun, driver)
+		return addr, driver
+	}
+
+	if m.cacheTTL > 0 {
+		handler = newMetricsCache(handler, m.cacheTTL)
+	}
+	if m.path == defaultMetricsPath {
+		return addr, handler
+	}
+
+	router := httprouter.New()
+	router.Handler("GET", m.path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = defaultMetricsPath
+		handler.ServeHTTP(w, r)
+	}))
+
+	return addr, router
+}
+
+// RegisterMetric 注册自定义metric，和框架或已注册的metric冲突时返回错误，不会panic
+func RegisterMetric(c prometheus.Collector) error {
+	return registerMetric(prometheus.DefaultRegisterer, c)
+}
+
+func registerMetric(registry prometheus.Registerer, c prometheus.Collector) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("register metric panic: %v", r)
+		}
+	}()
+
+	err = registry.Register(c)
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return fmt.Errorf("metric collides with a registered metric: %s", err)
+	}
+	return err
+}
+
+// safeRegisterer MustRegister冲突时只打日志，避免业务metric导致服务启动失败
+type safeRegisterer struct {
+	prometheus.Registerer
+}
+
+func (m safeRegisterer) MustRegister(cs ...prometheus.Collector) {
+	fun := "safeRegisterer.MustRegister -->"
+
+	for _, c := range cs {
+		if err := registerMetric(m.Registerer, c); err != nil {
+			slog.Errorf("%s register metric err:%s", fun, err)
+		}
+	}
+}
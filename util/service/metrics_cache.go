@@ -0,0 +1,130 @@
+package rocserv
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsSnapshot 一次抓取的完整响应
+type metricsSnapshot struct {
+	code   int
+	header http.Header
+	body   []byte
+	expire time.Time
+}
+
+// metricsRecorder 缓存handler输出的响应
+type metricsRecorder struct {
+	code   int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{
+		code:   http.StatusOK,
+		header: make(http.Header),
+	}
+}
+
+func (m *metricsRecorder) Header() http.Header {
+	return m.header
+}
+
+func (m *metricsRecorder) Write(b []byte) (int, error) {
+	return m.body.Write(b)
+}
+
+func (m *metricsRecorder) WriteHeader(code int) {
+	m.code = code
+}
+
+// metricsFormat 按Accept协商的输出格式，和prometheus expfmt.Negotiate一致：
+// 支持的protobuf编码返回对应格式，其他都为text
+func metricsFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil || mediaType != "application/vnd.google.protobuf" {
+			continue
+		}
+		if params["proto"] != "io.prometheus.client.MetricFamily" {
+			continue
+		}
+		switch enc := params["encoding"]; enc {
+		case "delimited", "text", "compact-text":
+			return "protobuf;" + enc
+		}
+	}
+	return "text"
+}
+
+// acceptGzip Accept-Encoding中是否包含gzip
+func acceptGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		if strings.TrimSpace(strings.Split(part, ";")[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsCache 在ttl内复用序列化好的metrics，避免高频抓取时重复计算，
+// 按协商出的格式及是否gzip分别缓存，key的取值有限，不随抓取方传入的header增长
+type metricsCache struct {
+	handler http.Handler
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu        sync.Mutex
+	snapshots map[string]*metricsSnapshot
+}
+
+func newMetricsCache(handler http.Handler, ttl time.Duration) *metricsCache {
+	return &metricsCache{
+		handler:   handler,
+		ttl:       ttl,
+		now:       time.Now,
+		snapshots: make(map[string]*metricsSnapshot),
+	}
+}
+
+func (m *metricsCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := metricsFormat(r.Header.Get("Accept"))
+	if acceptGzip(r.Header.Get("Accept-Encoding")) {
+		key += "|gzip"
+	}
+
+	// 持锁计算，同一时刻的并发抓取只计算一次
+	m.mu.Lock()
+	now := m.now()
+	s, ok := m.snapshots[key]
+	if !ok || !now.Before(s.expire) {
+		// 清理过期的缓存
+		for k, old := range m.snapshots {
+			if !now.Before(old.expire) {
+				delete(m.snapshots, k)
+			}
+		}
+
+		rec := newMetricsRecorder()
+		m.handler.ServeHTTP(rec, r)
+		s = &metricsSnapshot{
+			code:   rec.code,
+			header: rec.header,
+			body:   rec.body.Bytes(),
+			expire: now.Add(m.ttl),
+		}
+		m.snapshots[key] = s
+	}
+	m.mu.Unlock()
+
+	for k, v := range s.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(s.code)
+	w.Write(s.body)
+}
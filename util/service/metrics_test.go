@@ -0,0 +1,148 @@
+package rocserv
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestMetricsProcessorPath(t *testing.T) {
+	origin := httprouter.New()
+	origin.GET(defaultMetricsPath, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Write([]byte("metrics"))
+	})
+
+	if _, err := newMetricsProcessor(&testProcessor{driver: origin}, "metrics"); err == nil {
+		t.Errorf("path without '/' should be rejected")
+	}
+
+	p, err := newMetricsProcessor(&testProcessor{driver: origin}, "/custom/metrics")
+	if err != nil {
+		t.Fatalf("new metrics processor err:%s", err)
+	}
+
+	_, driver := p.Driver()
+	router, ok := driver.(*httprouter.Router)
+	if !ok {
+		t.Fatalf("unexpected driver:%T", driver)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/custom/metrics", nil))
+	if w.Code != 200 || w.Body.String() != "metrics" {
+		t.Errorf("custom path code:%d body:%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", defaultMetricsPath, nil))
+	if w.Code != 404 {
+		t.Errorf("default path code:%d", w.Code)
+	}
+}
+
+func TestBeforeMetricsInit(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "test_custom_collector",
+		Help: "custom collector registered before metrics init",
+	})
+
+	m := NewService()
+	m.BeforeMetricsInit(func(registry prometheus.Registerer) {
+		registry.MustRegister(gauge)
+	})
+	m.runBeforeMetricsInit(registry)
+
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", defaultMetricsPath, nil))
+	if !strings.Contains(w.Body.String(), "test_custom_collector") {
+		t.Errorf("custom collector not scraped:%s", w.Body.String())
+	}
+}
+
+func TestRegisterMetricCollision(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	newGauge := func() prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "test_collision",
+			Help: "collision test",
+		})
+	}
+
+	if err := registerMetric(registry, newGauge()); err != nil {
+		t.Fatalf("first register err:%s", err)
+	}
+	if err := registerMetric(registry, newGauge()); err == nil {
+		t.Errorf("colliding register should return error")
+	}
+
+	// MustRegister冲突时不panic
+	safeRegisterer{registry}.MustRegister(newGauge())
+}
+
+func TestMetricsCache(t *testing.T) {
+	var scrapes int
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scrapes++
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(fmt.Sprintf("scrape %d", scrapes)))
+	})
+
+	p, err := newMetricsProcessor(&testProcessor{driver: origin}, "")
+	if err != nil {
+		t.Fatalf("new metrics processor err:%s", err)
+	}
+	p.cacheTTL = time.Second
+	_, driver := p.Driver()
+	cache := driver.(*metricsCache)
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	scrape := func(accept string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", defaultMetricsPath, nil)
+		r.Header.Set("Accept", accept)
+		cache.ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("content type:%s", ct)
+		}
+		return w.Body.String()
+	}
+
+	for i := 0; i < 3; i++ {
+		if body := scrape(""); body != "scrape 1" {
+			t.Errorf("scrape within ttl body:%s", body)
+		}
+	}
+	protobuf := "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited"
+	if body := scrape(protobuf); body != "scrape 2" {
+		t.Errorf("different format should not share cache, body:%s", body)
+	}
+
+	// 协商结果相同的Accept共用缓存，不会按header无限增长
+	for i := 0; i < 100; i++ {
+		if body := scrape(fmt.Sprintf("text/plain;v=%d", i)); body != "scrape 1" {
+			t.Fatalf("same format should share cache, body:%s", body)
+		}
+	}
+	if len(cache.snapshots) != 2 {
+		t.Errorf("snapshots:%d", len(cache.snapshots))
+	}
+
+	now = now.Add(time.Second)
+	if body := scrape(""); body != "scrape 3" {
+		t.Errorf("scrape after ttl body:%s", body)
+	}
+	// 过期的缓存被清理
+	if len(cache.snapshots) != 1 {
+		t.Errorf("expired snapshots should be dropped, snapshots:%d", len(cache.snapshots))
+	}
+}
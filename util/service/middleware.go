@@ -0,0 +1,135 @@
+package rocserv
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	MIDDLEWARE_SOURCE_FRAMEWORK = "framework"
+	MIDDLEWARE_SOURCE_USER      = "user"
+
+	middlewareStreaming  = "streaming"
+	middlewareTracing    = "tracing"
+	middlewareTrafficLog = "traffic_log"
+	middlewareMonitor    = "monitor"
+	middlewareSlo        = "slo"
+)
+
+// MiddlewareInfo processor上生效的中间件
+type MiddlewareInfo struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// middlewareRegistry 记录每个processor上按顺序生效的中间件，
+// chain为启动processor时实际组装的中间件，declared为RegisterMiddleware声明的用户中间件，列在chain之后
+type middlewareRegistry struct {
+	mu       sync.RWMutex
+	chain    map[string][]MiddlewareInfo
+	declared map[string][]MiddlewareInfo
+}
+
+func newMiddlewareRegistry() *middlewareRegistry {
+	return &middlewareRegistry{
+		chain:    make(map[string][]MiddlewareInfo),
+		declared: make(map[string][]MiddlewareInfo),
+	}
+}
+
+// set 启动processor时记录组装的中间件，替换processor时覆盖
+func (m *middlewareRegistry) set(processor string, infos []MiddlewareInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chain[processor] = append([]MiddlewareInfo(nil), infos...)
+}
+
+func (m *middlewareRegistry) declare(processor string, infos ...MiddlewareInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.declared[processor] = append(m.declared[processor], infos...)
+}
+
+func (m *middlewareRegistry) remove(processor string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.chain, processor)
+	delete(m.declared, processor)
+}
+
+func (m *middlewareRegistry) all() map[string][]MiddlewareInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make(map[string][]MiddlewareInfo, len(m.chain))
+	for p, infos := range m.chain {
+		res[p] = append([]MiddlewareInfo(nil), infos...)
+	}
+	for p, infos := range m.declared {
+		res[p] = append(res[p], infos...)
+	}
+	return res
+}
+
+func frameworkMiddlewares(names ...string) []MiddlewareInfo {
+	infos := make([]MiddlewareInfo, 0, len(names))
+	for _, n := range names {
+		infos = append(infos, MiddlewareInfo{Name: n, Source: MIDDLEWARE_SOURCE_FRAMEWORK})
+	}
+	return infos
+}
+
+func funcName(f interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+}
+
+// ginHandlerInfos gin engine上用户添加的全局中间件，在框架中间件之内执行
+func ginHandlerInfos(engine *gin.Engine) []MiddlewareInfo {
+	var infos []MiddlewareInfo
+	for _, h := range engine.Handlers {
+		infos = append(infos, MiddlewareInfo{Name: funcName(h), Source: MIDDLEWARE_SOURCE_USER})
+	}
+	return infos
+}
+
+// httpChain 按执行顺序组装http中间件并记录名称，/backdoor/middleware 展示的即实际组装的链
+type httpChain struct {
+	infos []MiddlewareInfo
+	wraps []func(http.Handler) http.Handler
+}
+
+func (m *httpChain) add(name string, wrap func(http.Handler) http.Handler) *httpChain {
+	return m.addInfos(frameworkMiddlewares(name), wrap)
+}
+
+// addInfos wrap包含多个中间件时使用，如Use添加的中间件
+func (m *httpChain) addInfos(infos []MiddlewareInfo, wrap func(http.Handler) http.Handler) *httpChain {
+	m.infos = append(m.infos, infos...)
+	m.wraps = append(m.wraps, wrap)
+	return m
+}
+
+// then 先添加的在外层
+func (m *httpChain) then(h http.Handler) http.Handler {
+	for i := len(m.wraps) - 1; i >= 0; i-- {
+		h = m.wraps[i](h)
+	}
+	return h
+}
+
+// RegisterMiddleware 声明用户在processor上自行添加的中间件，便于在 /backdoor/middleware 中查看
+func RegisterMiddleware(processor, name string) {
+	service.middlewares.declare(processor, MiddlewareInfo{Name: name, Source: MIDDLEWARE_SOURCE_USER})
+}
+
+func handleMiddleware(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, 200, service.middlewares.all())
+}
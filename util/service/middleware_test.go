@@ -0,0 +1,95 @@
+package rocserv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func middlewareNames(infos []MiddlewareInfo) []string {
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+func TestMiddlewareEndpoint(t *testing.T) {
+	var used, called bool
+	m := NewService()
+	m.Use(NewCallMiddleware("a", func(ctx context.Context, method string, next func(ctx context.Context) error) error {
+		used = true
+		return next(ctx)
+	}))
+
+	router := httprouter.New()
+	router.GET("/ok", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		called = true
+	})
+	gs := NewGrpcServer()
+	gs.EnableValidation()
+	procs := map[string]Processor{
+		"api":       &testProcessor{addr: "127.0.0.1:", driver: router},
+		"proc_grpc": &testProcessor{addr: "127.0.0.1:", driver: gs},
+	}
+	infos, err := m.loadDriver(nil, procs, nil)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+	defer m.Shutdown(context.Background())
+
+	old := service
+	service = m
+	defer func() { service = old }()
+	RegisterMiddleware("proc_grpc", "auth")
+
+	w := httptest.NewRecorder()
+	handleMiddleware(w, httptest.NewRequest("GET", "/backdoor/middleware", nil), nil)
+
+	var res map[string][]MiddlewareInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal err:%s", err)
+	}
+
+	check := func(processor string, want []string) {
+		got := middlewareNames(res[processor])
+		if len(got) != len(want) {
+			t.Fatalf("processor:%s got:%v want:%v", processor, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("processor:%s idx:%d got:%s want:%s", processor, i, got[i], want[i])
+			}
+		}
+	}
+
+	// Use添加的中间件在暂停流量、限流之内
+	check("api", []string{
+		middlewareRecovery, middlewareStreaming, middlewareTracing, middlewareTrafficLog, middlewareAccessLog,
+		middlewareRed, middlewareSlo, middlewareDeadlineShed, middlewarePause, middlewareRateLimit, "a",
+	})
+	check("proc_grpc", []string{
+		middlewareRecovery, middlewareTracing, middlewareTraceForce, middlewareShutdownNotify, middlewareStreamLimit,
+		middlewareMonitor, middlewareRed, middlewareSlo, middlewarePause, middlewareDeadlineShed, middlewareRateLimit,
+		"a", middlewareValidate, "auth",
+	})
+	if res["api"][10].Source != MIDDLEWARE_SOURCE_USER || res["proc_grpc"][13].Source != MIDDLEWARE_SOURCE_USER {
+		t.Errorf("user middleware source api:%v grpc:%v", res["api"][10], res["proc_grpc"][13])
+	}
+
+	// 列出的顺序即执行顺序：暂停流量时不会执行之内的Use中间件及handler
+	setTrafficPaused(true)
+	defer setTrafficPaused(false)
+	resp, err := http.Get("http://" + infos["api"].Addr + "/ok")
+	if err != nil {
+		t.Fatalf("get err:%s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || used || called {
+		t.Errorf("paused code:%d used:%t called:%t", resp.StatusCode, used, called)
+	}
+}
@@ -0,0 +1,191 @@
+package rocserv
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	defaultOutlierInterval        = time.Second * 10
+	defaultOutlierCooldown        = time.Second * 30
+	defaultOutlierMinRequests     = 10
+	defaultOutlierMaxEjectPercent = 50
+)
+
+// outlierConfig 被动健康检查的阈值，ErrorRate<=0 时不开启
+type outlierConfig struct {
+	// 统计窗口内 错误(含超时)请求/总请求 达到该比例时摘除实例
+	errorRate float64
+	// 统计窗口内请求数达到该值才判断
+	minRequests int
+	// 耗时超过该值的请求按错误计算，<=0 不按耗时判断
+	maxLatency time.Duration
+	// 统计窗口
+	interval time.Duration
+	// 摘除时长，到期后放回一个请求探测，成功则恢复，失败继续摘除
+	cooldown time.Duration
+	// 最多摘除的实例比例，避免全部实例被摘除
+	maxEjectPercent int
+}
+
+var outlierOptions atomic.Value
+
+func init() {
+	setOutlierConfig(outlierConfig{})
+}
+
+// setOutlierConfig 未设置的项使用默认值
+func setOutlierConfig(c outlierConfig) {
+	if c.minRequests <= 0 {
+		c.minRequests = defaultOutlierMinRequests
+	}
+	if c.interval <= 0 {
+		c.interval = defaultOutlierInterval
+	}
+	if c.cooldown <= 0 {
+		c.cooldown = defaultOutlierCooldown
+	}
+	if c.maxEjectPercent <= 0 || c.maxEjectPercent > 100 {
+		c.maxEjectPercent = defaultOutlierMaxEjectPercent
+	}
+	outlierOptions.Store(c)
+}
+
+func getOutlierConfig() outlierConfig {
+	return outlierOptions.Load().(outlierConfig)
+}
+
+type instanceStat struct {
+	windowStart time.Time
+	requests    int
+	errors      int
+
+	ejectedUntil time.Time
+	// 摘除到期后放回，等待探测结果
+	probing bool
+}
+
+// outlierDetector 按实例统计请求结果，摘除异常实例
+type outlierDetector struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	stats map[string]*instanceStat
+}
+
+func newOutlierDetector() *outlierDetector {
+	return &outlierDetector{
+		now:   time.Now,
+		stats: make(map[string]*instanceStat),
+	}
+}
+
+func (m *outlierDetector) report(key, addr string, err error, latency time.Duration) {
+	fun := "outlierDetector.report -->"
+
+	c := getOutlierConfig()
+	if c.errorRate <= 0 {
+		return
+	}
+
+	failed := err != nil || (c.maxLatency > 0 && latency > c.maxLatency)
+	id := key + "/" + addr
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.stats[id]
+	if !ok {
+		s = &instanceStat{windowStart: now}
+		m.stats[id] = s
+	}
+
+	if now.Before(s.ejectedUntil) {
+		return
+	}
+
+	if s.probing {
+		s.probing = false
+		if failed {
+			s.ejectedUntil = now.Add(c.cooldown)
+			s.probing = true
+			slog.Warnf("%s probe failed, eject again serv:%s addr:%s cooldown:%s", fun, key, addr, c.cooldown)
+		} else {
+			slog.Infof("%s probe succ, readmit serv:%s addr:%s", fun, key, addr)
+		}
+		return
+	}
+
+	if now.Sub(s.windowStart) > c.interval {
+		s.windowStart = now
+		s.requests = 0
+		s.errors = 0
+	}
+
+	s.requests++
+	if failed {
+		s.errors++
+	}
+
+	if s.requests < c.minRequests || float64(s.errors)/float64(s.requests) < c.errorRate {
+		return
+	}
+
+	if !m.canEject(key, now, c.maxEjectPercent) {
+		slog.Warnf("%s reach max eject percent:%d, keep serv:%s addr:%s errors:%d/%d", fun, c.maxEjectPercent, key, addr, s.errors, s.requests)
+		return
+	}
+
+	slog.Warnf("%s eject serv:%s addr:%s errors:%d/%d cooldown:%s", fun, key, addr, s.errors, s.requests, c.cooldown)
+	s.ejectedUntil = now.Add(c.cooldown)
+	s.probing = true
+	s.windowStart = now
+	s.requests = 0
+	s.errors = 0
+}
+
+// canEject 摘除后被摘除的实例比例不超过maxPercent
+func (m *outlierDetector) canEject(key string, now time.Time, maxPercent int) bool {
+	var total, ejected int
+	prefix := key + "/"
+	for id, s := range m.stats {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		total++
+		if now.Before(s.ejectedUntil) {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= total*maxPercent
+}
+
+// filter 去掉摘除中的实例，全部被摘除时返回原列表
+func (m *outlierDetector) filter(key string, servs []*ServInfo) []*ServInfo {
+	if getOutlierConfig().errorRate <= 0 {
+		return servs
+	}
+
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var avail []*ServInfo
+	for _, s := range servs {
+		if st, ok := m.stats[key+"/"+s.Addr]; ok && now.Before(st.ejectedUntil) {
+			continue
+		}
+		avail = append(avail, s)
+	}
+
+	if len(avail) == 0 {
+		return servs
+	}
+	return avail
+}
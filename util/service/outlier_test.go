@@ -0,0 +1,83 @@
+package rocserv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutlierEjectAndReadmit(t *testing.T) {
+	setOutlierConfig(outlierConfig{errorRate: 0.5, minRequests: 4, cooldown: time.Minute})
+	defer setOutlierConfig(outlierConfig{})
+
+	lookup := &testServLookup{servs: []*ServInfo{{Addr: "good"}, {Addr: "bad"}}}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	now := time.Now()
+	b.outlier.now = func() time.Time { return now }
+
+	errFail := errors.New("fail")
+	for i := 0; i < 4; i++ {
+		b.Report("base/account", "proc_http", "good", nil, time.Millisecond)
+		b.Report("base/account", "proc_http", "bad", errFail, time.Millisecond)
+	}
+
+	for i := 0; i < 4; i++ {
+		s, err := b.Next("base/account", "proc_http")
+		if err != nil {
+			t.Fatalf("next err:%s", err)
+		}
+		if s.Addr == "bad" {
+			t.Fatalf("bad instance not ejected")
+		}
+	}
+
+	// 冷却结束后放回探测，探测失败继续摘除
+	now = now.Add(time.Minute + time.Second)
+	servs, _ := b.servs("base/account", "proc_http")
+	if len(servs) != 2 {
+		t.Fatalf("instance not readmitted after cooldown, servs:%d", len(servs))
+	}
+	b.Report("base/account", "proc_http", "bad", errFail, time.Millisecond)
+	if servs, _ := b.servs("base/account", "proc_http"); len(servs) != 1 {
+		t.Fatalf("failed probe should eject again, servs:%d", len(servs))
+	}
+
+	// 探测成功后恢复
+	now = now.Add(time.Minute + time.Second)
+	b.Report("base/account", "proc_http", "bad", nil, time.Millisecond)
+	b.Report("base/account", "proc_http", "bad", errFail, time.Millisecond)
+	if servs, _ := b.servs("base/account", "proc_http"); len(servs) != 2 {
+		t.Errorf("instance not readmitted after successful probe, servs:%d", len(servs))
+	}
+}
+
+func TestOutlierLatencyAndMaxEject(t *testing.T) {
+	setOutlierConfig(outlierConfig{errorRate: 0.5, minRequests: 2, maxLatency: 100 * time.Millisecond, maxEjectPercent: 50})
+	defer setOutlierConfig(outlierConfig{})
+
+	lookup := &testServLookup{servs: []*ServInfo{{Addr: "a"}, {Addr: "b"}}}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	for i := 0; i < 2; i++ {
+		b.Report("base/account", "proc_http", "a", nil, time.Second)
+		b.Report("base/account", "proc_http", "b", nil, time.Second)
+	}
+
+	// 最多摘除一半实例
+	if servs, _ := b.servs("base/account", "proc_http"); len(servs) != 1 {
+		t.Errorf("slow instances servs:%d", len(servs))
+	}
+}
+
+func TestOutlierDisabled(t *testing.T) {
+	lookup := &testServLookup{servs: []*ServInfo{{Addr: "a"}, {Addr: "b"}}}
+	b := newTestBalancer(map[string]servLookup{"base/account": lookup})
+
+	for i := 0; i < 100; i++ {
+		b.Report("base/account", "proc_http", "a", errors.New("fail"), 0)
+	}
+	if servs, _ := b.servs("base/account", "proc_http"); len(servs) != 2 {
+		t.Errorf("outlier detection should be disabled by default")
+	}
+}
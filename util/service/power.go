@@ -5,9 +5,10 @@
 package rocserv
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"git.apache.org/thrift.git/lib/go/thrift"
-	"github.com/gin-gonic/gin"
 	"github.com/julienschmidt/httprouter"
 	"github.com/opentracing-contrib/go-stdlib/nethttp"
 	"github.com/opentracing/opentracing-go"
@@ -16,62 +17,121 @@ import (
 	"github.com/shawnfeng/sutil/trace"
 	"net"
 	"net/http"
+	"sync/atomic"
 )
 
-func powerHttp(addr string, router *httprouter.Router) (string, error) {
+// powerHandle 启动后的server，下线时按顺序停止
+type powerHandle interface {
+	// 停止接收新连接并等待处理中的请求结束，ctx结束时强制关闭
+	Stop(ctx context.Context) error
+}
+
+type httpHandle struct {
+	server *http.Server
+}
+
+func (m *httpHandle) Stop(ctx context.Context) error {
+	err := m.server.Shutdown(ctx)
+	if err != nil {
+		m.server.Close()
+	}
+	return err
+}
+
+type thriftHandle struct {
+	server  *thrift.TSimpleServer
+	stopped int32
+}
+
+// Stop thrift server只能关闭监听，不能等待处理中的请求
+func (m *thriftHandle) Stop(ctx context.Context) error {
+	atomic.StoreInt32(&m.stopped, 1)
+	return m.server.Stop()
+}
+
+func (m *thriftHandle) isStopped() bool {
+	return atomic.LoadInt32(&m.stopped) == 1
+}
+
+// tracingMiddleware operation为span名称
+func tracingMiddleware(operation func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return nethttp.Middleware(
+			opentracing.GlobalTracer(),
+			next,
+			nethttp.OperationNameFunc(operation),
+			nethttp.MWSpanFilter(trace.UrlSpanFilter),
+			nethttp.MWSpanObserver(traceForceSpanObserver))
+	}
+}
+
+func httpOperationName(r *http.Request) string {
+	return "HTTP " + r.Method + ": " + r.URL.Path
+}
+
+// frameworkHTTPChain http、gin、grpc-gateway processor上框架的中间件，按执行顺序
+func frameworkHTTPChain() *httpChain {
+	return (&httpChain{}).
+		add(middlewareRecovery, recoveryMiddleware).
+		add(middlewareStreaming, streamingMiddleware).
+		add(middlewareTracing, tracingMiddleware(httpOperationName)).
+		add(middlewareTrafficLog, httpTrafficLogMiddleware).
+		add(middlewareAccessLog, accessLogMiddleware).
+		add(middlewareRed, redMiddleware).
+		add(middlewareSlo, sloMiddleware).
+		add(middlewareDeadlineShed, deadlineShedMiddleware)
+}
+
+// powerHttp handler为组装好中间件的handler，tlsConfig不为nil时使用TLS
+func powerHttp(processor, addr string, handler http.Handler, tlsConfig *tls.Config) (string, powerHandle, error) {
 	fun := "powerHttp -->"
 
 	paddr, err := snetutil.GetListenAddr(addr)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	slog.Infof("%s config addr[%s]", fun, paddr)
 
 	tcpAddr, err := net.ResolveTCPAddr("tcp", paddr)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	netListen, err := net.Listen(tcpAddr.Network(), tcpAddr.String())
+	netListen, err := listen(tcpAddr.Network(), tcpAddr.String())
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	laddr, err := snetutil.GetServAddr(netListen.Addr())
 	if err != nil {
 		netListen.Close()
-		return "", err
+		return "", nil, err
 	}
 
-	slog.Infof("%s listen addr[%s]", fun, laddr)
-
-	// tracing
-	mw := nethttp.Middleware(
-		opentracing.GlobalTracer(),
-		// add logging middleware
-		httpTrafficLogMiddleware(router),
-		nethttp.OperationNameFunc(func(r *http.Request) string {
-			return "HTTP " + r.Method + ": " + r.URL.Path
-		}),
-		nethttp.MWSpanFilter(trace.UrlSpanFilter))
+	slog.Infof("%s listen addr[%s] tls:%t", fun, laddr, tlsConfig != nil)
+	netListen = newConnLimitListener(netListen, laddr)
+	if tlsConfig != nil {
+		netListen = tls.NewListener(netListen, tlsConfig)
+	}
 
+	serv := &http.Server{Handler: processorMiddleware(processor, handler)}
 	go func() {
-		err := http.Serve(netListen, mw)
-		if err != nil {
+		err := serv.Serve(netListen)
+		if err != nil && err != http.ErrServerClosed {
 			slog.Panicf("%s laddr[%s]", fun, laddr)
 		}
 	}()
 
-	return laddr, nil
+	return laddr, &httpHandle{server: serv}, nil
 }
 
-func powerThrift(addr string, processor thrift.TProcessor) (string, error) {
+func powerThrift(addr string, processor thrift.TProcessor) (string, powerHandle, error) {
 	fun := "powerThrift -->"
 
 	paddr, err := snetutil.GetListenAddr(addr)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	slog.Infof("%s config addr[%s]", fun, paddr)
@@ -82,62 +142,71 @@ func powerThrift(addr string, processor thrift.TProcessor) (string, error) {
 
 	serverTransport, err := thrift.NewTServerSocket(paddr)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	server := thrift.NewTSimpleServer4(processor, serverTransport, transportFactory, protocolFactory)
+	server := thrift.NewTSimpleServer4(&thriftTracingProcessor{processor}, newBackoffServerTransport(newConnLimitServerTransport(serverTransport, paddr), paddr), transportFactory, protocolFactory)
 
 	// Listen后就可以拿到端口了
 	//err = server.Listen()
-	err = serverTransport.Listen()
+	err = bindWithRetry(paddr, getBindRetries(), serverTransport.Listen)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	laddr, err := snetutil.GetServAddr(serverTransport.Addr())
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	slog.Infof("%s listen addr[%s]", fun, laddr)
 
+	handle := &thriftHandle{server: server}
 	go func() {
 		err := server.Serve()
-		if err != nil {
+		if err != nil && !handle.isStopped() {
 			slog.Panicf("%s laddr[%s]", fun, laddr)
 		}
 	}()
 
-	return laddr, nil
+	return laddr, handle, nil
 
 }
 
 //启动grpc ，并返回端口信息
-func powerGrpc(addr string, server *GrpcServer) (string, error) {
+func powerGrpc(addr string, server *GrpcServer, tlsConfig *tls.Config) (string, powerHandle, error) {
 	fun := "powerGrpc -->"
 	paddr, err := snetutil.GetListenAddr(addr)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	slog.Infof("%s config addr[%s]", fun, paddr)
-	lis, err := net.Listen("tcp", paddr)
+	lis, err := listen("tcp", paddr)
 	if err != nil {
-		return "", fmt.Errorf("grpc tcp Listen err:%v", err)
+		return "", nil, fmt.Errorf("grpc tcp Listen err:%v", err)
 	}
 	laddr, err := snetutil.GetServAddr(lis.Addr())
 	if err != nil {
-		return "", fmt.Errorf(" GetServAddr err:%v", err)
+		return "", nil, fmt.Errorf(" GetServAddr err:%v", err)
+	}
+	slog.Infof("%s listen grpc addr[%s] tls:%t", fun, laddr, tlsConfig != nil)
+	lis = newConnLimitListener(lis, laddr)
+	if tlsConfig != nil {
+		// grpc基于http2，客户端通过ALPN协商h2
+		cfg := tlsConfig.Clone()
+		cfg.NextProtos = []string{"h2"}
+		lis = tls.NewListener(lis, cfg)
 	}
-	slog.Infof("%s listen grpc addr[%s]", fun, laddr)
 	go func() {
 		if err := server.Server.Serve(lis); err != nil {
 			slog.Panicf("%s grpc laddr[%s]", fun, laddr)
 		}
 	}()
-	return laddr, nil
+	return laddr, server, nil
 }
 
-func powerGin(addr string, router *gin.Engine) (string, *http.Server, error) {
+// powerGin handler为组装好中间件的gin engine，reloadRouter时替换
+func powerGin(processor, addr string, handler http.Handler, tlsConfig *tls.Config) (string, *httpHandle, error) {
 	fun := "powerGin -->"
 
 	paddr, err := snetutil.GetListenAddr(addr)
@@ -152,7 +221,7 @@ func powerGin(addr string, router *gin.Engine) (string, *http.Server, error) {
 		return "", nil, err
 	}
 
-	netListen, err := net.Listen(tcpAddr.Network(), tcpAddr.String())
+	netListen, err := listen(tcpAddr.Network(), tcpAddr.String())
 	if err != nil {
 		return "", nil, err
 	}
@@ -163,29 +232,25 @@ func powerGin(addr string, router *gin.Engine) (string, *http.Server, error) {
 		return "", nil, err
 	}
 
-	slog.Infof("%s listen addr[%s]", fun, laddr)
-
-	// tracing
-	mw := nethttp.Middleware(
-		opentracing.GlobalTracer(),
-		httpTrafficLogMiddleware(router),
-		nethttp.OperationNameFunc(func(r *http.Request) string {
-			return "HTTP " + r.Method + ": " + r.URL.Path
-		}),
-		nethttp.MWSpanFilter(trace.UrlSpanFilter))
+	slog.Infof("%s listen addr[%s] tls:%t", fun, laddr, tlsConfig != nil)
+	netListen = newConnLimitListener(netListen, laddr)
+	if tlsConfig != nil {
+		netListen = tls.NewListener(netListen, tlsConfig)
+	}
 
-	serv := &http.Server{Handler: mw}
+	serv := &http.Server{Handler: newSwappableHandler(processorMiddleware(processor, handler))}
 	go func() {
 		err := serv.Serve(netListen)
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			slog.Panicf("%s laddr[%s]", fun, laddr)
 		}
 	}()
 
-	return laddr, serv, nil
+	return laddr, &httpHandle{server: serv}, nil
 }
 
-func reloadRouter(processor string, server interface{}, driver interface{}) error {
+// reloadRouter handler为组装好中间件的新router
+func reloadRouter(processor string, server interface{}, handler http.Handler) error {
 	fun := "reloadRouter -->"
 
 	s, ok := server.(*http.Server)
@@ -193,19 +258,36 @@ func reloadRouter(processor string, server interface{}, driver interface{}) erro
 		return fmt.Errorf("server type error")
 	}
 
-	switch router := driver.(type) {
-	case *gin.Engine:
-		mw := nethttp.Middleware(
-			opentracing.GlobalTracer(),
-			router,
-			nethttp.OperationNameFunc(func(r *http.Request) string {
-				return "HTTP " + r.Method + ": " + r.URL.Path
-			}))
-		s.Handler = mw
-		slog.Infof("%s reload ok, processors:%s", fun, processor)
-	default:
-		return fmt.Errorf("processor:%s driver not recognition", processor)
+	sh, ok := s.Handler.(*swappableHandler)
+	if !ok {
+		return fmt.Errorf("server handler type error")
 	}
 
+	sh.store(processorMiddleware(processor, handler))
+	slog.Infof("%s reload ok, processors:%s", fun, processor)
 	return nil
 }
+
+// swappableHandler 原子替换handler，处理中的请求使用旧的handler，新请求使用新的handler，请求路径上不加锁
+type swappableHandler struct {
+	handler atomic.Value
+}
+
+type handlerHolder struct {
+	http.Handler
+}
+
+func newSwappableHandler(h http.Handler) *swappableHandler {
+	sh := &swappableHandler{}
+	sh.store(h)
+	return sh
+}
+
+func (m *swappableHandler) store(h http.Handler) {
+	// atomic.Value要求每次存储的类型一致
+	m.handler.Store(handlerHolder{h})
+}
+
+func (m *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.Load().(handlerHolder).ServeHTTP(w, r)
+}
@@ -0,0 +1,59 @@
+package rocserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestSwappableHandlerConcurrentReload(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+	sh := newSwappableHandler(ok)
+
+	stop := make(chan struct{})
+	var reloads sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		reloads.Add(1)
+		go func() {
+			defer reloads.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					sh.store(ok)
+				}
+			}
+		}()
+	}
+
+	var reqs sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for i := 0; i < 16; i++ {
+		reqs.Add(1)
+		go func() {
+			defer reqs.Done()
+			for j := 0; j < 500; j++ {
+				w := httptest.NewRecorder()
+				sh.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+				if w.Code != 200 {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	reqs.Wait()
+	close(stop)
+	reloads.Wait()
+
+	if failed > 0 {
+		t.Errorf("dropped requests:%d", failed)
+	}
+}
@@ -0,0 +1,35 @@
+package rocserv
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const backdoorPprofPrefix = "/backdoor/debug/pprof/"
+
+// pprofProfiles runtime/pprof内置的profile
+var pprofProfiles = []string{"heap", "goroutine", "allocs", "block", "mutex", "threadcreate"}
+
+// httprouterHandler http.Handler转为httprouter.Handle
+func httprouterHandler(h http.Handler) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		h.ServeHTTP(w, r)
+	}
+}
+
+// registerPprof 在后门注册pprof, /backdoor/debug/pprof/profile?seconds=30
+func registerPprof(router *HttpRouter) {
+	router.GET(backdoorPprofPrefix, backdoorAuth(httprouterHandler(http.HandlerFunc(pprof.Index))))
+	router.GET(backdoorPprofPrefix+"cmdline", backdoorAuth(httprouterHandler(http.HandlerFunc(pprof.Cmdline))))
+	router.GET(backdoorPprofPrefix+"profile", backdoorAuth(httprouterHandler(http.HandlerFunc(pprof.Profile))))
+	router.GET(backdoorPprofPrefix+"symbol", backdoorAuth(httprouterHandler(http.HandlerFunc(pprof.Symbol))))
+	router.POST(backdoorPprofPrefix+"symbol", backdoorAuth(httprouterHandler(http.HandlerFunc(pprof.Symbol))))
+	router.GET(backdoorPprofPrefix+"trace", backdoorAuth(httprouterHandler(http.HandlerFunc(pprof.Trace))))
+
+	// pprof.Index只识别/debug/pprof/前缀，profile需要单独注册
+	for _, name := range pprofProfiles {
+		router.GET(backdoorPprofPrefix+name, backdoorAuth(httprouterHandler(pprof.Handler(name))))
+	}
+}
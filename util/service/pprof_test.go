@@ -0,0 +1,49 @@
+package rocserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackdoorPprof(t *testing.T) {
+	get := func(b *backDoorHttp, path string) int {
+		_, driver := b.Driver()
+		w := httptest.NewRecorder()
+		driver.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	if code := get(&backDoorHttp{}, "/backdoor/debug/pprof/heap"); code != http.StatusNotFound {
+		t.Errorf("pprof should be disabled by default, code:%d", code)
+	}
+
+	b := &backDoorHttp{pprof: true}
+	for _, path := range []string{"/backdoor/debug/pprof/", "/backdoor/debug/pprof/heap", "/backdoor/debug/pprof/goroutine", "/backdoor/debug/pprof/cmdline"} {
+		if code := get(b, path); code != http.StatusOK {
+			t.Errorf("path:%s code:%d", path, code)
+		}
+	}
+}
+
+func TestBackdoorMetrics(t *testing.T) {
+	get := func(b *backDoorHttp) *httptest.ResponseRecorder {
+		_, driver := b.Driver()
+		w := httptest.NewRecorder()
+		driver.(http.Handler).ServeHTTP(w, httptest.NewRequest("GET", "/backdoor/metrics", nil))
+		return w
+	}
+
+	if w := get(&backDoorHttp{}); w.Code != http.StatusNotFound {
+		t.Errorf("metrics should be disabled by default, code:%d", w.Code)
+	}
+
+	w := get(&backDoorHttp{metrics: true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("code:%d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Errorf("default registry metrics not found")
+	}
+}
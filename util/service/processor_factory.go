@@ -0,0 +1,96 @@
+package rocserv
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// ProcessorFactory 创建processor，sb可用于读取processor自身的配置
+type ProcessorFactory func(sb ServBase) (Processor, error)
+
+var processorFactories = struct {
+	mu        sync.RWMutex
+	factories map[string]ProcessorFactory
+}{
+	factories: make(map[string]ProcessorFactory),
+}
+
+// RegisterProcessorFactory 按名称注册processor，配置 [processor] enable 中包含该名称时由框架创建并启动，
+// 一般在init中调用，名称重复时返回错误
+func RegisterProcessorFactory(name string, factory ProcessorFactory) error {
+	if err := checkProcessorName(name); err != nil {
+		return err
+	}
+	if factory == nil {
+		return fmt.Errorf("processor:%s factory nil", name)
+	}
+
+	processorFactories.mu.Lock()
+	defer processorFactories.mu.Unlock()
+
+	if _, ok := processorFactories.factories[name]; ok {
+		return fmt.Errorf("processor:%s factory already registered", name)
+	}
+	processorFactories.factories[name] = factory
+	return nil
+}
+
+func getProcessorFactory(name string) (ProcessorFactory, bool) {
+	processorFactories.mu.RLock()
+	defer processorFactories.mu.RUnlock()
+
+	f, ok := processorFactories.factories[name]
+	return f, ok
+}
+
+// buildConfiguredProcessors 创建配置中启用的processor并和代码传入的processor合并，
+// 名称冲突或启用的processor未注册时返回错误，配置中禁用的processor不启动
+func buildConfiguredProcessors(sb ServBase, procs map[string]Processor) (map[string]Processor, error) {
+	fun := "buildConfiguredProcessors -->"
+
+	var cfg ProcessorConfig
+	if err := sb.ServConfig(&cfg); err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return nil, err
+	}
+
+	res := make(map[string]Processor, len(procs)+len(cfg.Processor.Enable))
+	for n, p := range procs {
+		res[n] = p
+	}
+
+	for _, n := range cfg.Processor.Enable {
+		if _, ok := res[n]; ok {
+			return nil, fmt.Errorf("processor:%s enabled in config conflicts with existing processor", n)
+		}
+
+		factory, ok := getProcessorFactory(n)
+		if !ok {
+			return nil, fmt.Errorf("processor:%s enabled in config not registered", n)
+		}
+
+		p, err := factory(sb)
+		if err != nil {
+			return nil, fmt.Errorf("processor:%s build err:%s", n, err)
+		}
+		res[n] = p
+	}
+
+	for _, n := range cfg.Processor.Disable {
+		if _, ok := res[n]; ok {
+			slog.Infof("%s processor:%s disabled in config", fun, n)
+			delete(res, n)
+		}
+	}
+
+	names := make([]string, 0, len(res))
+	for n := range res {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	slog.Infof("%s processors:%v", fun, names)
+	return res, nil
+}
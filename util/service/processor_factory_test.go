@@ -0,0 +1,82 @@
+package rocserv
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/shawnfeng/sutil/sconf"
+)
+
+// tomlServBase 从toml内容读取服务配置
+type tomlServBase struct {
+	ServBase
+	conf string
+}
+
+func (m *tomlServBase) ServConfig(cfg interface{}) error {
+	tf := sconf.NewTierConf()
+	if err := tf.Load([]byte(m.conf)); err != nil {
+		return err
+	}
+	return tf.Unmarshal(cfg)
+}
+
+func TestConfiguredProcessors(t *testing.T) {
+	defer func() {
+		processorFactories.mu.Lock()
+		delete(processorFactories.factories, "echo")
+		processorFactories.mu.Unlock()
+	}()
+
+	err := RegisterProcessorFactory("echo", func(sb ServBase) (Processor, error) {
+		router := httprouter.New()
+		router.GET("/echo", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			w.Write([]byte("echo"))
+		})
+		return &testProcessor{addr: "127.0.0.1:", driver: router}, nil
+	})
+	if err != nil {
+		t.Fatalf("register err:%s", err)
+	}
+	if err := RegisterProcessorFactory("echo", func(sb ServBase) (Processor, error) { return nil, nil }); err == nil {
+		t.Errorf("duplicate register should fail")
+	}
+
+	sb := &tomlServBase{conf: "[processor]\nenable = echo\ndisable = legacy\n"}
+	procs, err := buildConfiguredProcessors(sb, map[string]Processor{
+		"legacy": &testProcessor{},
+	})
+	if err != nil {
+		t.Fatalf("build err:%s", err)
+	}
+	if _, ok := procs["legacy"]; ok || procs["echo"] == nil || len(procs) != 1 {
+		t.Fatalf("unexpected processors:%v", procs)
+	}
+
+	m := NewService()
+	infos, err := m.loadDriver(sb, procs, nil)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+	defer m.Shutdown(context.Background())
+
+	resp, err := http.Get("http://" + infos["echo"].Addr + "/echo")
+	if err != nil {
+		t.Fatalf("get err:%s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "echo" {
+		t.Errorf("configured processor not served, body:%s", body)
+	}
+
+	if _, err := buildConfiguredProcessors(&tomlServBase{conf: "[processor]\nenable = missing\n"}, nil); err == nil {
+		t.Errorf("unregistered processor should fail")
+	}
+	if _, err := buildConfiguredProcessors(sb, map[string]Processor{"echo": &testProcessor{}}); err == nil {
+		t.Errorf("conflicting processor should fail")
+	}
+}
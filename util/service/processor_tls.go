@@ -0,0 +1,91 @@
+package rocserv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// SCHEME_HTTPS 开启TLS的processor注册到服务发现的scheme，明文时为空
+const SCHEME_HTTPS = "https"
+
+// loadServerTLS 加载processor的证书，clientCAFile不为空时校验客户端证书，requireClientCert为true时必须提供客户端证书
+func loadServerTLS(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load cert:%s key:%s err:%s", certFile, keyFile, err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if len(clientCAFile) > 0 {
+		ca, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client ca:%s err:%s", clientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("parse client ca:%s failed", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if requireClientCert {
+		if cfg.ClientCAs == nil {
+			return nil, fmt.Errorf("client ca required to verify client cert")
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// initTlsConfig 加载 [tls] 中配置的processor证书，加载失败时启动失败，避免以明文提供服务
+func (m *Service) initTlsConfig(sb *ServBaseV2) error {
+	fun := "Service.initTlsConfig -->"
+
+	var tlsConfig TlsConfig
+	err := sb.ServConfig(&tlsConfig)
+	if err != nil {
+		slog.Errorf("%s serv config err:%s", fun, err)
+		return err
+	}
+
+	configs := make(map[string]*tls.Config, len(tlsConfig.Tls))
+	for processor, c := range tlsConfig.Tls {
+		cfg, err := loadServerTLS(c.CertFile, c.KeyFile, c.ClientCAFile, c.RequireClientCert)
+		if err != nil {
+			slog.Errorf("%s processor:%s load tls err:%s", fun, processor, err)
+			return fmt.Errorf("processor:%s load tls err:%s", processor, err)
+		}
+		slog.Infof("%s processor:%s tls enabled, client auth:%d", fun, processor, cfg.ClientAuth)
+		configs[processor] = cfg
+	}
+
+	m.mutex.Lock()
+	m.tlsConfigs = configs
+	m.mutex.Unlock()
+	return nil
+}
+
+// getTlsConfig 未配置TLS的processor返回nil，使用明文
+func (m *Service) getTlsConfig(processor string) *tls.Config {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.tlsConfigs[processor]
+}
+
+// schemeOf 注册到服务发现的scheme，明文时为空兼容已有的client
+func schemeOf(tlsConfig *tls.Config) string {
+	if tlsConfig != nil {
+		return SCHEME_HTTPS
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package rocserv
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// writeTestCert 生成127.0.0.1的自签名证书
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key err:%s", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "roc-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create cert err:%s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key err:%s", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write cert err:%s", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("write key err:%s", err)
+	}
+	return certFile, keyFile
+}
+
+func TestProcessorTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roc_tls")
+	if err != nil {
+		t.Fatalf("temp dir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	// 需要客户端证书时必须配置CA
+	if _, err := loadServerTLS(certFile, keyFile, "", true); err == nil {
+		t.Errorf("require client cert without ca should fail")
+	}
+
+	cfg, err := loadServerTLS(certFile, keyFile, certFile, true)
+	if err != nil {
+		t.Fatalf("load tls err:%s", err)
+	}
+
+	old := service
+	service = NewService()
+	defer func() { service = old }()
+	service.sbase = &testRegServBase{}
+	service.tlsConfigs = map[string]*tls.Config{"api": cfg}
+
+	router := NewHttpRouter()
+	router.GET("/ping", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Write([]byte("pong"))
+	})
+
+	info, err := service.powerProcessor("api", &testProcessor{addr: "127.0.0.1:", driver: router}, false)
+	if err != nil {
+		t.Fatalf("power processor err:%s", err)
+	}
+	defer service.Shutdown(context.Background())
+
+	if info.Scheme != SCHEME_HTTPS {
+		t.Errorf("scheme got:%s want:%s", info.Scheme, SCHEME_HTTPS)
+	}
+
+	ca, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("read cert err:%s", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+
+	get := func(certs []tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs},
+		}}
+		resp, err := client.Get("https://" + info.Addr + "/ping")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(nil); err == nil {
+		t.Errorf("request without client cert should fail")
+	}
+
+	clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load client cert err:%s", err)
+	}
+	if err := get([]tls.Certificate{clientCert}); err != nil {
+		t.Errorf("request with client cert err:%s", err)
+	}
+}
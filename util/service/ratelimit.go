@@ -0,0 +1,75 @@
+package rocserv
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket 简单的令牌桶，rate为每秒产生的令牌数，burst为桶容量
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (m *tokenBucket) allow() bool {
+	return m.allowAt(time.Now())
+}
+
+func (m *tokenBucket) allowAt(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.After(m.last) {
+		m.tokens += now.Sub(m.last).Seconds() * m.rate
+		if m.tokens > m.burst {
+			m.tokens = m.burst
+		}
+		m.last = now
+	}
+
+	if m.tokens < 1 {
+		return false
+	}
+
+	m.tokens--
+	return true
+}
+
+// fullAt now时令牌是否已补满，补满的桶和新建的桶等价，可以丢弃
+func (m *tokenBucket) fullAt(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.tokens+now.Sub(m.last).Seconds()*m.rate >= m.burst
+}
+
+// setRate 变更速率和容量，已有的令牌数不超过新的容量
+func (m *tokenBucket) setRate(rate float64, burst int) {
+	if burst <= 0 {
+		burst = 1
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rate = rate
+	m.burst = float64(burst)
+	if m.tokens > m.burst {
+		m.tokens = m.burst
+	}
+}
@@ -0,0 +1,166 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/shawnfeng/sutil/slog"
+	xprom "gitlab.pri.ibanyu.com/middleware/seaweed/xstat/xmetric/xprometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	middlewareRateLimit = "ratelimit"
+
+	rateLimitType = "ratelimit"
+
+	rateLimitAllowed  = "allowed"
+	rateLimitRejected = "rejected"
+
+	// 没有单独配置的方法共用的限流规则
+	rateLimitMethodDefault = "*"
+
+	rateLimitedMsg = "rate limited"
+)
+
+var _metricRateLimitCount = xprom.NewCounter(&xprom.CounterVecOpts{
+	Namespace:  namespacePalfish,
+	Subsystem:  rateLimitType,
+	Name:       "request_count",
+	Help:       "requests checked by processor rate limit, allowed or rejected",
+	LabelNames: []string{xprom.LabelGroupName, xprom.LabelServiceName, labelProcessor, labelMethod, labelResult},
+})
+
+// RateLimitRule 令牌桶限流，Rate为每秒允许的请求数，<=0 不限流，Burst默认1
+type RateLimitRule struct {
+	Rate  float64
+	Burst int
+}
+
+// methodRateLimiter 按processor及方法限流，规则变更时保留已有的令牌
+type methodRateLimiter struct {
+	mu sync.RWMutex
+	// processor -> 方法 -> 令牌桶
+	buckets map[string]map[string]*tokenBucket
+}
+
+var rateLimits = &methodRateLimiter{
+	buckets: make(map[string]map[string]*tokenBucket),
+}
+
+// set 替换所有规则，没有配置的processor及方法不再限流
+func (m *methodRateLimiter) set(rules map[string]map[string]RateLimitRule) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buckets := make(map[string]map[string]*tokenBucket, len(rules))
+	for processor, methods := range rules {
+		for method, rule := range methods {
+			if rule.Rate <= 0 {
+				continue
+			}
+			if buckets[processor] == nil {
+				buckets[processor] = make(map[string]*tokenBucket)
+			}
+
+			b, ok := m.buckets[processor][method]
+			if ok {
+				b.setRate(rule.Rate, rule.Burst)
+			} else {
+				b = newTokenBucket(rule.Rate, rule.Burst)
+			}
+			buckets[processor][method] = b
+		}
+	}
+	m.buckets = buckets
+}
+
+// allow 方法没有单独的规则时使用processor的默认规则，都没有时不限流
+func (m *methodRateLimiter) allow(processor, method string) bool {
+	m.mu.RLock()
+	methods := m.buckets[processor]
+	b, ok := methods[method]
+	if !ok {
+		method = rateLimitMethodDefault
+		b, ok = methods[method]
+	}
+	m.mu.RUnlock()
+
+	if !ok {
+		return true
+	}
+
+	allowed := b.allow()
+	result := rateLimitAllowed
+	if !allowed {
+		result = rateLimitRejected
+	}
+	group, service := GetGroupAndService()
+	_metricRateLimitCount.With(xprom.LabelGroupName, group, xprom.LabelServiceName, service, labelProcessor, processor, labelMethod, method, labelResult, result).Inc()
+	return allowed
+}
+
+// rateLimitMiddleware 需要在processorMiddleware之内，方法为匹配到的路由，如 GET /v1/user/:id
+func (m *Service) rateLimitMiddleware(next http.Handler) http.Handler {
+	fun := "Service.rateLimitMiddleware -->"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		processor := processorFromContext(r.Context())
+		method := redMethodOther
+		if path, ok := matchRoute(m.routes.get(processor), r.Method, r.URL.Path); ok {
+			method = r.Method + " " + path
+		}
+
+		if !rateLimits.allow(processor, method) {
+			slog.Warnf("%s processor:%s method:%s rate limited", fun, processor, method)
+			writeError(w, r, http.StatusTooManyRequests, rateLimitedMsg)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *GrpcServer) rateLimitServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !rateLimits.allow(m.processor, info.FullMethod) {
+			return nil, status.Error(codes.ResourceExhausted, rateLimitedMsg)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (m *GrpcServer) rateLimitStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !rateLimits.allow(m.processor, info.FullMethod) {
+			return status.Error(codes.ResourceExhausted, rateLimitedMsg)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// rateLimitThriftProcessor 需要在thriftTracingProcessor之内，从thriftContextServerProtocol获取方法名
+type rateLimitThriftProcessor struct {
+	thrift.TProcessor
+	processor string
+}
+
+func (m *rateLimitThriftProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	p, ok := in.(*thriftContextServerProtocol)
+	if !ok || p.read || rateLimits.allow(m.processor, p.name) {
+		return m.TProcessor.Process(in, out)
+	}
+
+	// 读掉请求并返回异常，保持连接
+	in.Skip(thrift.STRUCT)
+	in.ReadMessageEnd()
+
+	x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, rateLimitedMsg)
+	out.WriteMessageBegin(p.name, thrift.EXCEPTION, p.seqid)
+	x.Write(out)
+	out.WriteMessageEnd()
+	out.Flush()
+	return true, nil
+}
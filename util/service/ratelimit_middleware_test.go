@@ -0,0 +1,109 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMethodRateLimiter(t *testing.T) {
+	l := &methodRateLimiter{buckets: make(map[string]map[string]*tokenBucket)}
+	l.set(map[string]map[string]RateLimitRule{
+		"api": {
+			"GET /v1/echo": {Rate: 0.001, Burst: 2},
+			"*":            {Rate: 0.001, Burst: 1},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		if !l.allow("api", "GET /v1/echo") {
+			t.Fatalf("request:%d should be allowed", i)
+		}
+	}
+	if l.allow("api", "GET /v1/echo") {
+		t.Errorf("request over burst should be rejected")
+	}
+
+	// 其他方法共用默认规则
+	if !l.allow("api", "GET /v1/a") || l.allow("api", "GET /v1/b") {
+		t.Errorf("default rule not applied")
+	}
+
+	// 没有规则的processor不限流
+	for i := 0; i < 10; i++ {
+		if !l.allow("admin", "GET /v1/echo") {
+			t.Fatalf("processor without rules should not be limited")
+		}
+	}
+
+	// 规则变更后保留已有的令牌，删除的规则不再限流
+	l.set(map[string]map[string]RateLimitRule{
+		"api": {"GET /v1/echo": {Rate: 0.001, Burst: 5}},
+	})
+	if l.allow("api", "GET /v1/echo") {
+		t.Errorf("tokens should be kept after update")
+	}
+	if !l.allow("api", "GET /v1/b") {
+		t.Errorf("removed rule should not limit")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	defer rateLimits.set(nil)
+	rateLimits.set(map[string]map[string]RateLimitRule{
+		"api": {"GET /v1/user/:id": {Rate: 0.001, Burst: 1}},
+	})
+
+	m := NewService()
+	router := httprouter.New()
+	router.GET("/v1/user/:id", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {})
+	infos, err := m.loadDriver(nil, map[string]Processor{"api": &testProcessor{addr: "127.0.0.1:", driver: router}}, nil)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+	defer m.Shutdown(context.Background())
+
+	get := func(path string) int {
+		resp, err := http.Get("http://" + infos["api"].Addr + path)
+		if err != nil {
+			t.Fatalf("get err:%s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// 同一路由的不同路径共用限流
+	if code := get("/v1/user/1"); code != http.StatusOK {
+		t.Errorf("first request code:%d", code)
+	}
+	if code := get("/v1/user/2"); code != http.StatusTooManyRequests {
+		t.Errorf("limited request code:%d", code)
+	}
+}
+
+func TestRateLimitServerInterceptor(t *testing.T) {
+	defer rateLimits.set(nil)
+	rateLimits.set(map[string]map[string]RateLimitRule{
+		"proc_grpc": {"/test.Echo/Echo": {Rate: 0.001, Burst: 1}},
+	})
+
+	gs := NewGrpcServer()
+	gs.processor = "proc_grpc"
+	interceptor := gs.rateLimitServerInterceptor()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Echo/Echo"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Errorf("first request err:%s", err)
+	}
+	if _, err := interceptor(context.Background(), nil, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("limited request err:%v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/shawnfeng/sutil/slog"
+	xprom "gitlab.pri.ibanyu.com/middleware/seaweed/xstat/xmetric/xprometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	middlewareRecovery = "recovery"
+
+	labelProcessor = "processor"
+
+	panicMsg = "internal server error"
+)
+
+var _metricPanicCount = xprom.NewCounter(&xprom.CounterVecOpts{
+	Namespace:  namespacePalfish,
+	Subsystem:  apiType,
+	Name:       "panic_count",
+	Help:       "panics recovered in processor handlers",
+	LabelNames: []string{xprom.LabelGroupName, xprom.LabelServiceName, labelProcessor},
+})
+
+// onPanic 打印堆栈并打点
+func onPanic(processor, method string, r interface{}) {
+	fun := "onPanic -->"
+
+	slog.Errorf("%s processor:%s method:%s panic:%v\n%s", fun, processor, method, r, debug.Stack())
+
+	group, service := GetGroupAndService()
+	_metricPanicCount.With(xprom.LabelGroupName, group, xprom.LabelServiceName, service, labelProcessor, processor).Inc()
+}
+
+// recoveryMiddleware 业务handler(httprouter、gin)panic时返回500，不影响其他请求
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// 标准库用于中断响应的panic，保持原有行为
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			onPanic(processorFromContext(r.Context()), r.Method+" "+r.URL.Path, p)
+			writeError(w, r, http.StatusInternalServerError, panicMsg)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *GrpcServer) recoveryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				onPanic(m.processor, info.FullMethod, p)
+				resp, err = nil, status.Error(codes.Internal, panicMsg)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func (m *GrpcServer) recoveryStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				onPanic(m.processor, info.FullMethod, p)
+				err = status.Error(codes.Internal, panicMsg)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,60 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	h := processorMiddleware("api", recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("code got:%d want:%d", w.Code, http.StatusInternalServerError)
+	}
+
+	// 中断响应的panic不处理
+	abort := recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("abort panic got:%v", p)
+			}
+		}()
+		abort.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+	}()
+}
+
+func TestRecoveryServerInterceptor(t *testing.T) {
+	gs := &GrpcServer{processor: "proc_grpc"}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Svc/Call"}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	}
+	interceptor := gs.recoveryServerInterceptor()
+	_, err := interceptor(context.Background(), nil, info, handler)
+	if status.Code(err) != codes.Internal {
+		t.Errorf("unary err:%v", err)
+	}
+
+	streamHandler := func(srv interface{}, ss grpc.ServerStream) error {
+		panic("boom")
+	}
+	streamInterceptor := gs.recoveryStreamServerInterceptor()
+	err = streamInterceptor(nil, nil, &grpc.StreamServerInfo{FullMethod: "/test.Svc/Stream"}, streamHandler)
+	if status.Code(err) != codes.Internal {
+		t.Errorf("stream err:%v", err)
+	}
+}
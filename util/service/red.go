@@ -0,0 +1,164 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/prometheus/client_golang/prometheus"
+	xprom "gitlab.pri.ibanyu.com/middleware/seaweed/xstat/xmetric/xprometheus"
+	"google.golang.org/grpc"
+)
+
+const (
+	middlewareRed = "red"
+
+	redType     = "processor"
+	labelMethod = "method"
+
+	// 未匹配到注册路由的http请求，避免按原始路径打点导致label过多
+	redMethodOther = "other"
+)
+
+// redMetrics 按processor和方法统计的请求数(rate)、错误数(errors)、耗时(duration)
+type redMetrics struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+var (
+	redCollectors atomic.Value
+	// 保证替换collector时注销和注册的顺序
+	redMutex sync.Mutex
+)
+
+func init() {
+	redCollectors.Store((*redMetrics)(nil))
+}
+
+func newRedMetrics(b []float64) *redMetrics {
+	labels := []string{xprom.LabelGroupName, xprom.LabelServiceName, labelProcessor, labelMethod}
+	return &redMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespacePalfish,
+			Subsystem: redType,
+			Name:      "request_count",
+			Help:      "processor request count",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespacePalfish,
+			Subsystem: redType,
+			Name:      "error_count",
+			Help:      "processor request error count, http 5xx, grpc/thrift returned error",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespacePalfish,
+			Subsystem: redType,
+			Name:      "request_duration_seconds",
+			Help:      "processor request duration in seconds",
+			Buckets:   b,
+		}, labels),
+	}
+}
+
+// setRedMetrics 按耗时分布创建并注册RED metrics，替换已注册的，b为空时使用默认分布
+func setRedMetrics(registry prometheus.Registerer, b []float64) error {
+	if len(b) == 0 {
+		b = buckets
+	}
+	b = append([]float64(nil), b...)
+	sort.Float64s(b)
+
+	redMutex.Lock()
+	defer redMutex.Unlock()
+
+	if old := redCollectors.Load().(*redMetrics); old != nil {
+		registry.Unregister(old.requests)
+		registry.Unregister(old.errors)
+		registry.Unregister(old.duration)
+	}
+
+	r := newRedMetrics(b)
+	for _, c := range []prometheus.Collector{r.requests, r.errors, r.duration} {
+		if err := registerMetric(registry, c); err != nil {
+			return err
+		}
+	}
+	redCollectors.Store(r)
+	return nil
+}
+
+// recordRed 未初始化时不统计
+func recordRed(processor, method string, d time.Duration, failed bool) {
+	r := redCollectors.Load().(*redMetrics)
+	if r == nil {
+		return
+	}
+
+	group, service := GetGroupAndService()
+	r.requests.WithLabelValues(group, service, processor, method).Inc()
+	if failed {
+		r.errors.WithLabelValues(group, service, processor, method).Inc()
+	}
+	r.duration.WithLabelValues(group, service, processor, method).Observe(d.Seconds())
+}
+
+// redHttpMethod 使用匹配到的注册路由作为方法，httprouter需使用NewHttpRouter创建才能匹配
+func redHttpMethod(processor string, r *http.Request) string {
+	if path, ok := matchRoute(service.routes.get(processor), r.Method, r.URL.Path); ok {
+		return r.Method + " " + path
+	}
+	return redMethodOther
+}
+
+// redMiddleware 需要在processorMiddleware之内，按ctx中的processor统计
+func redMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		processor := processorFromContext(r.Context())
+		sw := &sloWriter{ResponseWriter: w}
+		st := time.Now()
+		next.ServeHTTP(sw, r)
+		recordRed(processor, redHttpMethod(processor, r), time.Since(st), sw.code >= http.StatusInternalServerError)
+	})
+}
+
+func (m *GrpcServer) redServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		st := time.Now()
+		resp, err := handler(ctx, req)
+		recordRed(m.processor, info.FullMethod, time.Since(st), err != nil)
+		return resp, err
+	}
+}
+
+func (m *GrpcServer) redStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		st := time.Now()
+		err := handler(srv, ss)
+		recordRed(m.processor, info.FullMethod, time.Since(st), err != nil)
+		return err
+	}
+}
+
+// redThriftProcessor 方法名来自thriftTracingProcessor解析的消息头
+type redThriftProcessor struct {
+	thrift.TProcessor
+	processor string
+}
+
+func (m *redThriftProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	method := redMethodOther
+	if p, ok := in.(*thriftContextServerProtocol); ok && !p.read {
+		method = p.name
+	}
+
+	st := time.Now()
+	ok, err := m.TProcessor.Process(in, out)
+	recordRed(m.processor, method, time.Since(st), err != nil)
+	return ok, err
+}
@@ -0,0 +1,112 @@
+package rocserv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// redValue 返回processor、method对应的计数，histogram返回样本数
+func redValue(t *testing.T, registry *prometheus.Registry, name, processor, method string) float64 {
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather err:%s", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels[labelProcessor] != processor || labels[labelMethod] != method {
+				continue
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestRedMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if err := setRedMetrics(registry, []float64{1, 0.1}); err != nil {
+		t.Fatalf("set red metrics err:%s", err)
+	}
+	defer redCollectors.Store((*redMetrics)(nil))
+
+	// 重复设置替换已注册的metrics
+	if err := setRedMetrics(registry, nil); err != nil {
+		t.Fatalf("reset red metrics err:%s", err)
+	}
+
+	service.routes.set("api", []RouteInfo{{Method: "GET", Path: "/user/:id"}})
+	defer service.routes.remove("api")
+
+	h := processorMiddleware("api", redMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user/2" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})))
+	for _, path := range []string{"/user/1", "/user/2", "/unknown/1"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if v := redValue(t, registry, "palfish_processor_request_count", "api", "GET /user/:id"); v != 2 {
+		t.Errorf("http requests:%v", v)
+	}
+	if v := redValue(t, registry, "palfish_processor_error_count", "api", "GET /user/:id"); v != 1 {
+		t.Errorf("http errors:%v", v)
+	}
+	if v := redValue(t, registry, "palfish_processor_request_duration_seconds", "api", redMethodOther); v != 1 {
+		t.Errorf("unmatched route duration samples:%v", v)
+	}
+
+	gs := &GrpcServer{processor: "proc_grpc"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errors.New("fail")
+	}
+	interceptor := gs.redServerInterceptor()
+	interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Svc/Call"}, handler)
+
+	if v := redValue(t, registry, "palfish_processor_error_count", "proc_grpc", "/test.Svc/Call"); v != 1 {
+		t.Errorf("grpc errors:%v", v)
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	routes := []RouteInfo{
+		{Method: "GET", Path: "/"},
+		{Method: "GET", Path: "/user/:id"},
+		{Method: "GET", Path: "/static/*filepath"},
+	}
+
+	cases := []struct {
+		method, path, want string
+		ok                 bool
+	}{
+		{"GET", "/", "/", true},
+		{"GET", "/user/1", "/user/:id", true},
+		{"GET", "/user/1/", "/user/:id", true},
+		{"GET", "/static/js/app.js", "/static/*filepath", true},
+		{"POST", "/user/1", "", false},
+		{"GET", "/user/1/orders", "", false},
+		{"GET", "/user", "", false},
+	}
+	for _, c := range cases {
+		got, ok := matchRoute(routes, c.method, c.path)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%s %s got:%s,%t want:%s,%t", c.method, c.path, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package rocserv
+
+import (
+	"context"
+	"sync"
+)
+
+// resetForTest 下线当前的service并替换为新实例，同时恢复框架的全局状态，
+// 使依次执行Init/AddProcessor的测试互不影响，只用于测试
+func resetForTest() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	service.Shutdown(ctx)
+
+	service = NewService()
+	setTrafficPaused(false)
+	setSloObjectives(nil)
+	restartOnce = sync.Once{}
+}
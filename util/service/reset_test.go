@@ -0,0 +1,50 @@
+package rocserv
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// addTestProcessor 在全局service上启动名为api的processor
+func addTestProcessor(t *testing.T) *testRegServBase {
+	sb := &testRegServBase{}
+	service.sbase = sb
+
+	router := httprouter.New()
+	router.GET("/ping", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {})
+	if err := service.AddProcessor("api", &testProcessor{addr: "127.0.0.1:", driver: router}); err != nil {
+		t.Fatalf("add processor err:%s", err)
+	}
+	return sb
+}
+
+func TestResetForTestFirst(t *testing.T) {
+	resetForTest()
+	defer resetForTest()
+
+	setTrafficPaused(true)
+	sb := addTestProcessor(t)
+	if _, ok := sb.registered()["api"]; !ok {
+		t.Fatalf("processor not registered")
+	}
+}
+
+func TestResetForTestSecond(t *testing.T) {
+	resetForTest()
+	defer resetForTest()
+
+	if isTrafficPaused() {
+		t.Errorf("traffic pause leaked from previous test")
+	}
+	if len(service.handles) != 0 || len(service.procs) != 0 {
+		t.Errorf("servers leaked from previous test, handles:%d procs:%d", len(service.handles), len(service.procs))
+	}
+
+	// 同名processor可以再次启动
+	sb := addTestProcessor(t)
+	if len(sb.registered()) != 1 {
+		t.Errorf("registered:%v", sb.registered())
+	}
+}
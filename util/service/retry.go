@@ -0,0 +1,88 @@
+package rocserv
+
+import (
+	"sync"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	defaultRetryBudgetRatio        = 0.1
+	defaultRetryBudgetMinPerSecond = 10
+	// 余额最多累积这么多次正常请求产生的重试额度
+	retryBudgetMaxRequests = 1000
+	// 余额按千分之一个令牌计数，避免浮点累加误差
+	retryBudgetUnit = 1000
+)
+
+// RetryBudget 重试预算，每次正常请求存入ratio个令牌，每次重试消耗一个，
+// 另外每秒保底允许minPerSecond次重试，防止大面积故障时重试放大下游压力
+type RetryBudget struct {
+	mu         sync.Mutex
+	deposit    int64
+	balance    int64
+	maxBalance int64
+
+	minBucket *tokenBucket
+}
+
+func NewRetryBudget(ratio float64, minPerSecond int) *RetryBudget {
+	if ratio < 0 {
+		ratio = 0
+	}
+
+	deposit := int64(ratio*retryBudgetUnit + 0.5)
+	m := &RetryBudget{
+		deposit:    deposit,
+		maxBalance: deposit * retryBudgetMaxRequests,
+	}
+	if minPerSecond > 0 {
+		m.minBucket = newTokenBucket(float64(minPerSecond), minPerSecond)
+	}
+	return m
+}
+
+// Deposit 每次正常请求(非重试)调用一次
+func (m *RetryBudget) Deposit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.balance += m.deposit
+	if m.balance > m.maxBalance {
+		m.balance = m.maxBalance
+	}
+}
+
+// Withdraw 每次重试前调用，返回false表示预算耗尽，不应该再重试
+func (m *RetryBudget) Withdraw() bool {
+	if m.minBucket != nil && m.minBucket.allow() {
+		return true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.balance < retryBudgetUnit {
+		return false
+	}
+	m.balance -= retryBudgetUnit
+	return true
+}
+
+// retryBudgetFromConfig 按服务配置 Client.RetryBudget 创建重试预算，服务未初始化时使用默认值
+func retryBudgetFromConfig() *RetryBudget {
+	fun := "retryBudgetFromConfig -->"
+
+	var clientConfig ClientConfig
+	clientConfig.Client.RetryBudget.Ratio = defaultRetryBudgetRatio
+	clientConfig.Client.RetryBudget.MinPerSecond = defaultRetryBudgetMinPerSecond
+
+	if sb := GetServBase(); sb != nil {
+		err := sb.ServConfig(&clientConfig)
+		if err != nil {
+			slog.Warnf("%s serv config err:%s", fun, err)
+		}
+	}
+
+	return NewRetryBudget(clientConfig.Client.RetryBudget.Ratio, clientConfig.Client.RetryBudget.MinPerSecond)
+}
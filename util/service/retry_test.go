@@ -0,0 +1,35 @@
+package rocserv
+
+import (
+	"testing"
+)
+
+func TestRetryBudgetExhausted(t *testing.T) {
+	budget := NewRetryBudget(0.1, 0)
+
+	if budget.Withdraw() {
+		t.Errorf("empty budget should not allow retry")
+	}
+
+	for i := 0; i < 10; i++ {
+		budget.Deposit()
+	}
+
+	if !budget.Withdraw() {
+		t.Errorf("budget should allow one retry after 10 requests")
+	}
+	if budget.Withdraw() {
+		t.Errorf("retry should be suppressed once budget exhausted")
+	}
+}
+
+func TestRetryBudgetMinPerSecond(t *testing.T) {
+	budget := NewRetryBudget(0, 2)
+
+	if !budget.Withdraw() || !budget.Withdraw() {
+		t.Errorf("min retries per second should be allowed")
+	}
+	if budget.Withdraw() {
+		t.Errorf("retry beyond min per second should be suppressed")
+	}
+}
@@ -0,0 +1,193 @@
+package rocserv
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/julienschmidt/httprouter"
+)
+
+// RouteInfo processor上注册的路由
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// HttpRouter 记录注册路由的httprouter，httprouter本身无法列出已注册的路由，
+// 作为processor的driver返回时，路由可以在 /backdoor/routes 中查看
+type HttpRouter struct {
+	*httprouter.Router
+
+	mu     sync.Mutex
+	routes []RouteInfo
+}
+
+func NewHttpRouter() *HttpRouter {
+	return &HttpRouter{Router: httprouter.New()}
+}
+
+func (m *HttpRouter) record(method, path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.routes = append(m.routes, RouteInfo{Method: method, Path: path})
+}
+
+// Routes 按注册顺序返回路由
+func (m *HttpRouter) Routes() []RouteInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]RouteInfo(nil), m.routes...)
+}
+
+func (m *HttpRouter) Handle(method, path string, handle httprouter.Handle) {
+	m.Router.Handle(method, path, handle)
+	m.record(method, path)
+}
+
+func (m *HttpRouter) Handler(method, path string, handler http.Handler) {
+	m.Router.Handler(method, path, handler)
+	m.record(method, path)
+}
+
+func (m *HttpRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	m.Handler(method, path, handler)
+}
+
+func (m *HttpRouter) GET(path string, handle httprouter.Handle) {
+	m.Handle("GET", path, handle)
+}
+
+func (m *HttpRouter) HEAD(path string, handle httprouter.Handle) {
+	m.Handle("HEAD", path, handle)
+}
+
+func (m *HttpRouter) OPTIONS(path string, handle httprouter.Handle) {
+	m.Handle("OPTIONS", path, handle)
+}
+
+func (m *HttpRouter) POST(path string, handle httprouter.Handle) {
+	m.Handle("POST", path, handle)
+}
+
+func (m *HttpRouter) PUT(path string, handle httprouter.Handle) {
+	m.Handle("PUT", path, handle)
+}
+
+func (m *HttpRouter) PATCH(path string, handle httprouter.Handle) {
+	m.Handle("PATCH", path, handle)
+}
+
+func (m *HttpRouter) DELETE(path string, handle httprouter.Handle) {
+	m.Handle("DELETE", path, handle)
+}
+
+// driverRoutes 返回driver上的路由，以及实际用于启动的driver
+func driverRoutes(driver interface{}) ([]RouteInfo, interface{}, bool) {
+	switch d := driver.(type) {
+	case *HttpRouter:
+		return d.Routes(), d.Router, true
+	case *gin.Engine:
+		var routes []RouteInfo
+		for _, r := range d.Routes() {
+			routes = append(routes, RouteInfo{Method: r.Method, Path: r.Path})
+		}
+		return routes, d, true
+	case *WsRouter:
+		return d.Routes(), d, true
+	}
+	return nil, driver, false
+}
+
+// routeRegistry 记录每个processor的路由
+type routeRegistry struct {
+	mu     sync.RWMutex
+	routes map[string][]RouteInfo
+}
+
+func newRouteRegistry() *routeRegistry {
+	return &routeRegistry{
+		routes: make(map[string][]RouteInfo),
+	}
+}
+
+func (m *routeRegistry) set(processor string, routes []RouteInfo) {
+	sorted := append([]RouteInfo(nil), routes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Path != sorted[j].Path {
+			return sorted[i].Path < sorted[j].Path
+		}
+		return sorted[i].Method < sorted[j].Method
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.routes[processor] = sorted
+}
+
+func (m *routeRegistry) remove(processor string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.routes, processor)
+}
+
+// get 返回的slice不能修改
+func (m *routeRegistry) get(processor string) []RouteInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.routes[processor]
+}
+
+func (m *routeRegistry) all() map[string][]RouteInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make(map[string][]RouteInfo, len(m.routes))
+	for p, routes := range m.routes {
+		res[p] = append([]RouteInfo(nil), routes...)
+	}
+	return res
+}
+
+// matchRoute 按httprouter的规则匹配路由，返回注册的路径，:name匹配一段，*name匹配剩余部分
+func matchRoute(routes []RouteInfo, method, path string) (string, bool) {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	for _, r := range routes {
+		if r.Method == method && matchRoutePath(strings.Split(strings.Trim(r.Path, "/"), "/"), segs) {
+			return r.Path, true
+		}
+	}
+	return "", false
+}
+
+func matchRoutePath(pattern, segs []string) bool {
+	for i, p := range pattern {
+		if strings.HasPrefix(p, "*") {
+			return true
+		}
+		if i >= len(segs) {
+			return false
+		}
+		if strings.HasPrefix(p, ":") {
+			if len(segs[i]) == 0 {
+				return false
+			}
+			continue
+		}
+		if p != segs[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(segs)
+}
+
+func handleRoutes(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, service.routes.all())
+}
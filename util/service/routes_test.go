@@ -0,0 +1,61 @@
+package rocserv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRoutesEndpoint(t *testing.T) {
+	old := service
+	service = NewService()
+	defer func() { service = old }()
+	service.sbase = &testRegServBase{}
+
+	router := NewHttpRouter()
+	handle := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {}
+	router.POST("/user/:id", handle)
+	router.GET("/user/:id", handle)
+	router.HandlerFunc("GET", "/ping", func(w http.ResponseWriter, r *http.Request) {})
+
+	if err := service.AddProcessor("api", &testProcessor{addr: "127.0.0.1:", driver: router}); err != nil {
+		t.Fatalf("add processor err:%s", err)
+	}
+	defer service.Shutdown(context.Background())
+
+	get := func() map[string][]RouteInfo {
+		w := httptest.NewRecorder()
+		handleRoutes(w, httptest.NewRequest("GET", "/backdoor/routes", nil), nil)
+		var res map[string][]RouteInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("unmarshal err:%s", err)
+		}
+		return res
+	}
+
+	want := []RouteInfo{
+		{Method: "GET", Path: "/ping"},
+		{Method: "GET", Path: "/user/:id"},
+		{Method: "POST", Path: "/user/:id"},
+	}
+	got := get()["api"]
+	if len(got) != len(want) {
+		t.Fatalf("got:%v want:%v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("idx:%d got:%v want:%v", i, got[i], want[i])
+		}
+	}
+
+	if err := service.RemoveProcessor("api"); err != nil {
+		t.Fatalf("remove processor err:%s", err)
+	}
+	if _, ok := get()["api"]; ok {
+		t.Errorf("routes of removed processor still listed")
+	}
+}
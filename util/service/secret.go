@@ -0,0 +1,98 @@
+package rocserv
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	SECRET_SCHEME_ENV = "env"
+
+	secretRedacted = "******"
+)
+
+// 配置中的secret引用，如 ${env:DB_PASSWORD}、${vault:path/to/secret}
+var secretRefPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_]+):([^}]+)\}`)
+
+// SecretResolver 按引用获取secret的值
+type SecretResolver func(ref string) (string, error)
+
+var secretResolvers = struct {
+	mu        sync.RWMutex
+	resolvers map[string]SecretResolver
+}{
+	resolvers: map[string]SecretResolver{SECRET_SCHEME_ENV: resolveEnvSecret},
+}
+
+func resolveEnvSecret(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("env:%s not set", name)
+	}
+	return v, nil
+}
+
+// RegisterSecretResolver 注册secret解析方式，如vault，配置中的 ${scheme:ref} 在加载时解析，
+// 避免secret明文存放在etcd中，同名覆盖，需要在Serve之前调用
+func RegisterSecretResolver(scheme string, resolver SecretResolver) error {
+	if len(scheme) == 0 || resolver == nil {
+		return fmt.Errorf("secret scheme or resolver empty")
+	}
+
+	secretResolvers.mu.Lock()
+	defer secretResolvers.mu.Unlock()
+
+	secretResolvers.resolvers[scheme] = resolver
+	return nil
+}
+
+func getSecretResolver(scheme string) SecretResolver {
+	secretResolvers.mu.RLock()
+	defer secretResolvers.mu.RUnlock()
+
+	return secretResolvers.resolvers[scheme]
+}
+
+// resolveSecrets 替换配置中的secret引用，未注册的scheme保持原样
+func resolveSecrets(raw []byte) ([]byte, error) {
+	fun := "resolveSecrets -->"
+
+	var errs []error
+	res := secretRefPattern.ReplaceAllFunc(raw, func(ref []byte) []byte {
+		sub := secretRefPattern.FindSubmatch(ref)
+		scheme, name := string(sub[1]), string(sub[2])
+
+		resolver := getSecretResolver(scheme)
+		if resolver == nil {
+			slog.Warnf("%s secret scheme:%s not registered, keep:%s", fun, scheme, ref)
+			return ref
+		}
+
+		v, err := resolver(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resolve secret %s err:%s", ref, err))
+			return ref
+		}
+		return []byte(v)
+	})
+
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return res, nil
+}
+
+// redactSecrets 将已注册scheme的secret引用替换为掩码
+func redactSecrets(raw []byte) []byte {
+	return secretRefPattern.ReplaceAllFunc(raw, func(ref []byte) []byte {
+		sub := secretRefPattern.FindSubmatch(ref)
+		if getSecretResolver(string(sub[1])) == nil {
+			return ref
+		}
+		return []byte(secretRedacted)
+	})
+}
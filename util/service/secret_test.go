@@ -0,0 +1,66 @@
+package rocserv
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	os.Setenv("ROC_TEST_DB_PASSWORD", "p@ss")
+	defer os.Unsetenv("ROC_TEST_DB_PASSWORD")
+
+	var refs []string
+	RegisterSecretResolver("vault", func(ref string) (string, error) {
+		refs = append(refs, ref)
+		if ref == "missing" {
+			return "", fmt.Errorf("not found")
+		}
+		return "vault-token", nil
+	})
+	defer func() {
+		secretResolvers.mu.Lock()
+		delete(secretResolvers.resolvers, "vault")
+		secretResolvers.mu.Unlock()
+	}()
+
+	raw := []byte("[db]\npassword = ${env:ROC_TEST_DB_PASSWORD}\ntoken = ${vault:secret/data/db}\nother = ${unknown:x}\n")
+	res, err := resolveSecrets(raw)
+	if err != nil {
+		t.Fatalf("resolve err:%s", err)
+	}
+
+	tf, err := mergeConfig(res)
+	if err != nil {
+		t.Fatalf("load err:%s", err)
+	}
+	var cfg struct {
+		Db struct {
+			Password string
+			Token    string
+			Other    string
+		}
+	}
+	if err := tf.Unmarshal(&cfg); err != nil {
+		t.Fatalf("unmarshal err:%s", err)
+	}
+	if cfg.Db.Password != "p@ss" || cfg.Db.Token != "vault-token" || cfg.Db.Other != "${unknown:x}" {
+		t.Errorf("resolved config:%+v", cfg.Db)
+	}
+	if len(refs) != 1 || refs[0] != "secret/data/db" {
+		t.Errorf("vault resolver refs:%v", refs)
+	}
+
+	redacted := string(redactSecrets(raw))
+	if strings.Contains(redacted, "ROC_TEST_DB_PASSWORD") || strings.Contains(redacted, "secret/data/db") || !strings.Contains(redacted, secretRedacted) {
+		t.Errorf("redacted:%s", redacted)
+	}
+
+	if _, err := resolveSecrets([]byte("token = ${vault:missing}")); err == nil {
+		t.Errorf("resolver error should fail")
+	}
+	if _, err := resolveSecrets([]byte("token = ${env:ROC_TEST_NOT_SET}")); err == nil {
+		t.Errorf("missing env should fail")
+	}
+}
@@ -0,0 +1,86 @@
+package rocserv
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	xprom "gitlab.pri.ibanyu.com/middleware/seaweed/xstat/xmetric/xprometheus"
+)
+
+const (
+	singleflightType = "singleflight"
+
+	singleflightHit  = "hit"
+	singleflightMiss = "miss"
+
+	labelResult = "result"
+)
+
+var _metricSingleflightCount = xprom.NewCounter(&xprom.CounterVecOpts{
+	Namespace:  namespacePalfish,
+	Subsystem:  singleflightType,
+	Name:       "request_count",
+	Help:       "singleflight request count, hit means shared another in-flight call",
+	LabelNames: []string{xprom.LabelGroupName, xprom.LabelServiceName, xprom.LabelAPI, labelResult},
+})
+
+var errSingleflightPanic = errors.New("singleflight call panic")
+
+type singleflightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// Singleflight 相同key的并发请求只执行一次，其他请求共享结果，用于缓存穿透等场景
+type Singleflight struct {
+	name string
+
+	mu    sync.Mutex
+	calls map[string]*singleflightCall
+}
+
+// NewSingleflight name用于打点区分不同的调用
+func NewSingleflight(name string) *Singleflight {
+	return &Singleflight{
+		name:  name,
+		calls: make(map[string]*singleflightCall),
+	}
+}
+
+// Do 执行fn，key相同的请求正在执行时等待其结果，shared表示结果是否来自其他请求
+// fn使用第一个请求的ctx执行，等待中的请求ctx结束时返回ctx.Err()，不影响执行中的fn
+func (m *Singleflight) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
+	group, service := GetGroupAndService()
+
+	m.mu.Lock()
+	if c, ok := m.calls[key]; ok {
+		m.mu.Unlock()
+		_metricSingleflightCount.With(xprom.LabelGroupName, group, xprom.LabelServiceName, service, xprom.LabelAPI, m.name, labelResult, singleflightHit).Inc()
+
+		select {
+		case <-c.done:
+			return c.val, c.err, true
+		case <-ctx.Done():
+			return nil, ctx.Err(), true
+		}
+	}
+
+	c := &singleflightCall{done: make(chan struct{})}
+	m.calls[key] = c
+	m.mu.Unlock()
+	_metricSingleflightCount.With(xprom.LabelGroupName, group, xprom.LabelServiceName, service, xprom.LabelAPI, m.name, labelResult, singleflightMiss).Inc()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.calls, key)
+		m.mu.Unlock()
+		close(c.done)
+	}()
+
+	// fn panic时等待的请求拿到该错误
+	c.err = errSingleflightPanic
+	c.val, c.err = fn(ctx)
+	return c.val, c.err, false
+}
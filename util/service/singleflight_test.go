@@ -0,0 +1,49 @@
+package rocserv
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleflightCoalesce(t *testing.T) {
+	sf := NewSingleflight("test")
+
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var shared int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, s := sf.Do(context.Background(), "key", fn)
+			if err != nil || v != "value" {
+				t.Errorf("v:%v err:%v", v, err)
+			}
+			if s {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("underlying calls:%d", calls)
+	}
+	if shared != n-1 {
+		t.Errorf("shared:%d", shared)
+	}
+}
@@ -0,0 +1,289 @@
+package rocserv
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"context"
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+const (
+	defaultSloWindow = time.Minute * 5
+	// 统计窗口划分的桶数，窗口按桶滚动
+	sloBuckets = 10
+	// 耗时目标为p99，即99%的请求需要在目标耗时内完成
+	sloLatencyObjective = 0.99
+
+	SLO_OBJECTIVE_LATENCY = "latency"
+	SLO_OBJECTIVE_ERROR   = "error"
+)
+
+// sloObjective processor的SLO目标，latency、errorRate<=0 时不统计对应目标
+type sloObjective struct {
+	latency   time.Duration
+	errorRate float64
+	window    time.Duration
+}
+
+type sloBucket struct {
+	start    time.Time
+	requests int64
+	slow     int64
+	errors   int64
+}
+
+// sloTracker 按滚动窗口统计processor的请求耗时及错误
+type sloTracker struct {
+	objective sloObjective
+	now       func() time.Time
+
+	mu      sync.Mutex
+	buckets [sloBuckets]sloBucket
+}
+
+func newSloTracker(o sloObjective) *sloTracker {
+	if o.window <= 0 {
+		o.window = defaultSloWindow
+	}
+	return &sloTracker{
+		objective: o,
+		now:       time.Now,
+	}
+}
+
+func (m *sloTracker) bucketWidth() time.Duration {
+	return m.objective.window / sloBuckets
+}
+
+func (m *sloTracker) record(latency time.Duration, failed bool) {
+	width := m.bucketWidth()
+	now := m.now()
+	start := now.Truncate(width)
+	idx := (now.UnixNano() / int64(width)) % sloBuckets
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := &m.buckets[idx]
+	if !b.start.Equal(start) {
+		*b = sloBucket{start: start}
+	}
+	b.requests++
+	if m.objective.latency > 0 && latency > m.objective.latency {
+		b.slow++
+	}
+	if failed {
+		b.errors++
+	}
+}
+
+// SloReport processor在统计窗口内的SLO达成情况
+type SloReport struct {
+	Requests int64 `json:"requests"`
+	// 耗时目标，单位毫秒，0 表示未设置
+	LatencyTarget int64 `json:"latency_target_ms"`
+	// 耗时在目标内的请求比例，需要达到0.99
+	LatencyCompliance float64 `json:"latency_compliance"`
+	// 错误率目标，0 表示未设置
+	ErrorRateTarget float64 `json:"error_rate_target"`
+	ErrorRate       float64 `json:"error_rate"`
+	Met             bool    `json:"met"`
+}
+
+func (m *sloTracker) report() SloReport {
+	cutoff := m.now().Add(-m.objective.window)
+
+	var requests, slow, errors int64
+	m.mu.Lock()
+	for _, b := range m.buckets {
+		if b.start.After(cutoff) {
+			requests += b.requests
+			slow += b.slow
+			errors += b.errors
+		}
+	}
+	m.mu.Unlock()
+
+	r := SloReport{
+		Requests:          requests,
+		LatencyTarget:     int64(m.objective.latency / time.Millisecond),
+		LatencyCompliance: 1,
+		ErrorRateTarget:   m.objective.errorRate,
+		Met:               true,
+	}
+	if requests == 0 {
+		return r
+	}
+
+	if m.objective.latency > 0 {
+		r.LatencyCompliance = float64(requests-slow) / float64(requests)
+		r.Met = r.LatencyCompliance >= sloLatencyObjective
+	}
+	r.ErrorRate = float64(errors) / float64(requests)
+	if m.objective.errorRate > 0 && r.ErrorRate > m.objective.errorRate {
+		r.Met = false
+	}
+	return r
+}
+
+// sloTrackers processor名称到sloTracker，配置加载后整体替换
+var sloTrackers atomic.Value
+
+func init() {
+	sloTrackers.Store(map[string]*sloTracker{})
+}
+
+func setSloObjectives(objectives map[string]sloObjective) {
+	trackers := make(map[string]*sloTracker, len(objectives))
+	for processor, o := range objectives {
+		if o.latency <= 0 && o.errorRate <= 0 {
+			continue
+		}
+		trackers[processor] = newSloTracker(o)
+	}
+	sloTrackers.Store(trackers)
+}
+
+func getSloTracker(processor string) *sloTracker {
+	return sloTrackers.Load().(map[string]*sloTracker)[processor]
+}
+
+func sloReports() map[string]SloReport {
+	trackers := sloTrackers.Load().(map[string]*sloTracker)
+	reports := make(map[string]SloReport, len(trackers))
+	for processor, t := range trackers {
+		reports[processor] = t.report()
+	}
+	return reports
+}
+
+// sloWriter 记录http状态码，5xx按错误统计
+type sloWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (m *sloWriter) WriteHeader(code int) {
+	if m.code == 0 {
+		m.code = code
+	}
+	m.ResponseWriter.WriteHeader(code)
+}
+
+func (m *sloWriter) Flush() {
+	if f, ok := m.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (m *sloWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := m.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer not support hijack")
+}
+
+// sloMiddleware 需要在processorMiddleware之内，按ctx中的processor统计
+func sloMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := getSloTracker(processorFromContext(r.Context()))
+		if t == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &sloWriter{ResponseWriter: w}
+		st := time.Now()
+		next.ServeHTTP(sw, r)
+		t.record(time.Since(st), sw.code >= http.StatusInternalServerError)
+	})
+}
+
+// sloServerInterceptor processor名称在启动grpc server时设置
+func (m *GrpcServer) sloServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		t := getSloTracker(m.processor)
+		if t == nil {
+			return handler(ctx, req)
+		}
+
+		st := time.Now()
+		resp, err := handler(ctx, req)
+		t.record(time.Since(st), err != nil)
+		return resp, err
+	}
+}
+
+func (m *GrpcServer) sloStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		t := getSloTracker(m.processor)
+		if t == nil {
+			return handler(srv, ss)
+		}
+
+		st := time.Now()
+		err := handler(srv, ss)
+		t.record(time.Since(st), err != nil)
+		return err
+	}
+}
+
+// sloThriftProcessor 统计thrift请求，Process返回错误按错误统计
+type sloThriftProcessor struct {
+	thrift.TProcessor
+	processor string
+}
+
+func (m *sloThriftProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	t := getSloTracker(m.processor)
+	if t == nil {
+		return m.TProcessor.Process(in, out)
+	}
+
+	st := time.Now()
+	ok, err := m.TProcessor.Process(in, out)
+	t.record(time.Since(st), err != nil)
+	return ok, err
+}
+
+var (
+	sloComplianceDesc = prometheus.NewDesc(namespacePalfish+"_slo_compliance", "slo compliance ratio in rolling window, latency: requests within target, error: 1 - error rate", []string{"processor", "objective"}, nil)
+	sloMetDesc        = prometheus.NewDesc(namespacePalfish+"_slo_met", "1 if all slo objectives of the processor are met", []string{"processor"}, nil)
+)
+
+// sloCollector 抓取时计算各processor的SLO达成情况
+type sloCollector struct{}
+
+func (sloCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sloComplianceDesc
+	ch <- sloMetDesc
+}
+
+func (sloCollector) Collect(ch chan<- prometheus.Metric) {
+	for processor, r := range sloReports() {
+		if r.LatencyTarget > 0 {
+			ch <- prometheus.MustNewConstMetric(sloComplianceDesc, prometheus.GaugeValue, r.LatencyCompliance, processor, SLO_OBJECTIVE_LATENCY)
+		}
+		if r.ErrorRateTarget > 0 {
+			ch <- prometheus.MustNewConstMetric(sloComplianceDesc, prometheus.GaugeValue, 1-r.ErrorRate, processor, SLO_OBJECTIVE_ERROR)
+		}
+		var met float64
+		if r.Met {
+			met = 1
+		}
+		ch <- prometheus.MustNewConstMetric(sloMetDesc, prometheus.GaugeValue, met, processor)
+	}
+}
+
+func handleSlo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, sloReports())
+}
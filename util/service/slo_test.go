@@ -0,0 +1,78 @@
+package rocserv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSloCompliance(t *testing.T) {
+	setSloObjectives(map[string]sloObjective{
+		"api": {latency: 100 * time.Millisecond, errorRate: 0.01, window: time.Minute},
+	})
+	defer setSloObjectives(nil)
+
+	tracker := getSloTracker("api")
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		tracker.record(10*time.Millisecond, false)
+	}
+	if r := tracker.report(); !r.Met || r.LatencyCompliance != 1 || r.Requests != 100 {
+		t.Fatalf("fast responses report:%+v", r)
+	}
+
+	// 持续的慢请求使达标率低于目标
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Second)
+		for j := 0; j < 10; j++ {
+			tracker.record(300*time.Millisecond, false)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleSlo(w, httptest.NewRequest("GET", "/backdoor/slo", nil), nil)
+	var reports map[string]SloReport
+	if err := json.Unmarshal(w.Body.Bytes(), &reports); err != nil {
+		t.Fatalf("unmarshal err:%s", err)
+	}
+	r := reports["api"]
+	if r.Met || r.LatencyCompliance >= sloLatencyObjective {
+		t.Errorf("slow responses report:%+v", r)
+	}
+	if r.LatencyTarget != 100 || r.ErrorRateTarget != 0.01 {
+		t.Errorf("targets:%+v", r)
+	}
+
+	// 窗口滚动后慢请求不再计入
+	now = now.Add(2 * time.Minute)
+	tracker.record(10*time.Millisecond, false)
+	if r := tracker.report(); !r.Met || r.Requests != 1 {
+		t.Errorf("rolled window report:%+v", r)
+	}
+}
+
+func TestSloMiddleware(t *testing.T) {
+	setSloObjectives(map[string]sloObjective{"api": {errorRate: 0.5}})
+	defer setSloObjectives(nil)
+
+	handler := processorMiddleware("api", sloMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})))
+	for _, path := range []string{"/ok", "/fail", "/fail"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	r := sloReports()["api"]
+	if r.Requests != 3 || r.Met || r.ErrorRate < 0.6 {
+		t.Errorf("report:%+v", r)
+	}
+	if _, ok := sloReports()["other"]; ok {
+		t.Errorf("processor without slo reported")
+	}
+}
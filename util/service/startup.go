@@ -0,0 +1,81 @@
+package rocserv
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// 启动阶段，[init] order 配置各阶段的启动顺序
+const (
+	// 后门，包括健康检查
+	startupBackdoor = "backdoor"
+	// metrics processor
+	startupMetrics = "metrics"
+	// 应用初始化及业务processor
+	startupProcessors = "processors"
+)
+
+var defaultStartupOrder = []string{startupBackdoor, startupProcessors, startupMetrics}
+
+// parseStartupOrder 解析逗号分隔的启动顺序，需要包含且只包含一次所有阶段，为空时使用默认顺序
+func parseStartupOrder(s string) ([]string, error) {
+	if len(strings.TrimSpace(s)) == 0 {
+		return defaultStartupOrder, nil
+	}
+
+	var order []string
+	seen := make(map[string]bool)
+	for _, step := range strings.Split(s, ",") {
+		step = strings.TrimSpace(step)
+		switch step {
+		case startupBackdoor, startupMetrics, startupProcessors:
+		default:
+			return nil, fmt.Errorf("unknown startup step:%s", step)
+		}
+		if seen[step] {
+			return nil, fmt.Errorf("duplicate startup step:%s", step)
+		}
+		seen[step] = true
+		order = append(order, step)
+	}
+
+	if len(order) != len(defaultStartupOrder) {
+		return nil, fmt.Errorf("startup order:%s must contain %s", s, strings.Join(defaultStartupOrder, ","))
+	}
+	return order, nil
+}
+
+func (m *Service) startupOrder(sb *ServBaseV2) []string {
+	fun := "Service.startupOrder -->"
+
+	var initConfig InitConfig
+	err := sb.ServConfig(&initConfig)
+	if err != nil {
+		slog.Warnf("%s serv config err:%s", fun, err)
+	}
+
+	order, err := parseStartupOrder(initConfig.Init.Order)
+	if err != nil {
+		slog.Errorf("%s use default order:%v, err:%s", fun, defaultStartupOrder, err)
+		return defaultStartupOrder
+	}
+
+	slog.Infof("%s startup order:%v", fun, order)
+	return order
+}
+
+// runStartup 按顺序执行各启动阶段，任何一个失败时返回
+func runStartup(order []string, steps map[string]func() error) error {
+	for _, step := range order {
+		fn, ok := steps[step]
+		if !ok {
+			return fmt.Errorf("startup step:%s not found", step)
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
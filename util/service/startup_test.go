@@ -0,0 +1,80 @@
+package rocserv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseStartupOrder(t *testing.T) {
+	if order, err := parseStartupOrder(""); err != nil || !reflect.DeepEqual(order, defaultStartupOrder) {
+		t.Errorf("default order:%v err:%v", order, err)
+	}
+
+	order, err := parseStartupOrder(" backdoor, metrics ,processors")
+	if err != nil {
+		t.Fatalf("parse err:%s", err)
+	}
+	if !reflect.DeepEqual(order, []string{startupBackdoor, startupMetrics, startupProcessors}) {
+		t.Errorf("order:%v", order)
+	}
+
+	for _, s := range []string{"backdoor,processors", "backdoor,backdoor,metrics", "backdoor,processors,tracer"} {
+		if _, err := parseStartupOrder(s); err == nil {
+			t.Errorf("order:%s should be invalid", s)
+		}
+	}
+}
+
+func TestStartupBackdoorBeforeProcessors(t *testing.T) {
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		regInfos:     make(map[string]string),
+		dryRun:       true,
+	}
+	m := NewService()
+	defer m.Shutdown(context.Background())
+
+	order, _ := parseStartupOrder("backdoor,metrics,processors")
+
+	var backdoorAddr string
+	var steps []string
+	err := runStartup(order, map[string]func() error{
+		startupBackdoor: func() error {
+			steps = append(steps, startupBackdoor)
+			binfos, err := m.loadBackdoor(sb, &backDoorHttp{}, "127.0.0.1:0")
+			if err != nil {
+				return err
+			}
+			backdoorAddr = binfos["_PROC_BACKDOOR"].Addr
+			return nil
+		},
+		startupMetrics: func() error {
+			steps = append(steps, startupMetrics)
+			return nil
+		},
+		startupProcessors: func() error {
+			steps = append(steps, startupProcessors)
+			// 业务processor初始化完成前后门已经可以访问
+			resp, err := http.Get("http://" + backdoorAddr + "/backdoor/health/live")
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("live code:%d", resp.StatusCode)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("startup err:%s", err)
+	}
+
+	if !reflect.DeepEqual(steps, order) {
+		t.Errorf("steps:%v order:%v", steps, order)
+	}
+}
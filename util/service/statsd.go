@@ -0,0 +1,201 @@
+package rocserv
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	defaultStatsdInterval = time.Second * 10
+	// 单个udp包的最大长度，避免超过以太网MTU被分片
+	statsdMaxPacketSize = 1432
+)
+
+// statsdExporter 定期从prometheus采集框架及业务的metric推送到statsd，
+// counter推送两次采集间的增量，gauge推送当前值，histogram推送请求数增量及区间内的平均耗时
+type statsdExporter struct {
+	prefix string
+	// 为true时使用DogStatsD格式将label作为tag，否则label值拼接到metric名称中
+	dogstatsd bool
+
+	gatherer prometheus.Gatherer
+	conn     net.Conn
+
+	// 定时推送和 /backdoor/flush 可能同时推送
+	mu sync.Mutex
+	// 上次采集的counter值，用于计算增量
+	last map[string]float64
+}
+
+func newStatsdExporter(addr, prefix string, dogstatsd bool, gatherer prometheus.Gatherer) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsdExporter{
+		prefix:    prefix,
+		dogstatsd: dogstatsd,
+		gatherer:  gatherer,
+		conn:      conn,
+		last:      make(map[string]float64),
+	}, nil
+}
+
+// run 定期推送，不会返回
+func (m *statsdExporter) run(interval time.Duration) {
+	fun := "statsdExporter.run -->"
+
+	if interval <= 0 {
+		interval = defaultStatsdInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := m.flush(); err != nil {
+				slog.Warnf("%s flush to:%s err:%s", fun, m.conn.RemoteAddr(), err)
+			}
+		}
+	}()
+}
+
+func (m *statsdExporter) flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lines, err := m.lines()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+len(line)+1 > statsdMaxPacketSize {
+			if _, err := m.conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+
+	if buf.Len() > 0 {
+		_, err = m.conn.Write(buf.Bytes())
+	}
+	return err
+}
+
+// lines 采集一次metric并转换为statsd协议的行
+func (m *statsdExporter) lines() ([]string, error) {
+	mfs, err := m.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, metric := range mf.GetMetric() {
+			key := name + seriesKey(metric.GetLabel())
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				delta := m.delta(key, metric.GetCounter().GetValue())
+				lines = append(lines, m.format(name, metric.GetLabel(), delta, "c"))
+
+			case dto.MetricType_GAUGE:
+				lines = append(lines, m.format(name, metric.GetLabel(), metric.GetGauge().GetValue(), "g"))
+
+			case dto.MetricType_HISTOGRAM:
+				h := metric.GetHistogram()
+				count := m.delta(key+"_count", float64(h.GetSampleCount()))
+				sum := m.delta(key+"_sum", h.GetSampleSum())
+				lines = append(lines, m.format(name+".count", metric.GetLabel(), count, "c"))
+				if count > 0 {
+					avg := sum / count
+					// prometheus惯例耗时以秒为单位，statsd的timer以毫秒为单位
+					if strings.Contains(name, "second") {
+						avg *= 1000
+					}
+					lines = append(lines, m.format(name, metric.GetLabel(), avg, "ms"))
+				}
+			}
+		}
+	}
+	return lines, nil
+}
+
+func (m *statsdExporter) delta(key string, value float64) float64 {
+	last := m.last[key]
+	m.last[key] = value
+	// 进程内counter不会减小，减小说明metric被重建
+	if value < last {
+		return value
+	}
+	return value - last
+}
+
+func (m *statsdExporter) format(name string, labels []*dto.LabelPair, value float64, typ string) string {
+	if len(m.prefix) > 0 {
+		name = m.prefix + "." + name
+	}
+
+	if m.dogstatsd {
+		line := fmt.Sprintf("%s:%g|%s", name, value, typ)
+		if len(labels) == 0 {
+			return line
+		}
+
+		tags := make([]string, 0, len(labels))
+		for _, l := range labels {
+			tags = append(tags, statsdSanitize(l.GetName())+":"+statsdSanitize(l.GetValue()))
+		}
+		return line + "|#" + strings.Join(tags, ",")
+	}
+
+	parts := []string{name}
+	for _, l := range labels {
+		if v := l.GetValue(); len(v) > 0 {
+			// 非tag模式下.是名称的分隔符
+			parts = append(parts, strings.Replace(statsdSanitize(v), ".", "_", -1))
+		}
+	}
+	return fmt.Sprintf("%s:%g|%s", strings.Join(parts, "."), value, typ)
+}
+
+// seriesKey 同一metric下区分不同label组合
+func seriesKey(labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.GetName()+"="+l.GetValue())
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// statsdSanitize 替换statsd协议中的保留字符
+func statsdSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,88 @@
+package rocserv
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func recvStatsd(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, statsdMaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read statsd err:%s", err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestStatsdExporter(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen err:%s", err)
+	}
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "request_total", Help: "test"}, []string{"api"})
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration_second", Help: "test"}, []string{"api"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "conns", Help: "test"})
+	registry.MustRegister(counter, histogram, gauge)
+
+	exporter, err := newStatsdExporter(server.LocalAddr().String(), "roc", true, registry)
+	if err != nil {
+		t.Fatalf("new exporter err:%s", err)
+	}
+
+	counter.WithLabelValues("/echo").Add(3)
+	histogram.WithLabelValues("/echo").Observe(0.1)
+	histogram.WithLabelValues("/echo").Observe(0.3)
+	gauge.Set(5)
+
+	if err := exporter.flush(); err != nil {
+		t.Fatalf("flush err:%s", err)
+	}
+	got := strings.Join(recvStatsd(t, server), "\n")
+	for _, want := range []string{
+		"roc.request_total:3|c|#api:/echo",
+		"roc.duration_second.count:2|c|#api:/echo",
+		"roc.duration_second:200|ms|#api:/echo",
+		"roc.conns:5|g",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want line:%s got:\n%s", want, got)
+		}
+	}
+
+	// 第二次推送counter为增量
+	counter.WithLabelValues("/echo").Add(2)
+	if err := exporter.flush(); err != nil {
+		t.Fatalf("flush err:%s", err)
+	}
+	got = strings.Join(recvStatsd(t, server), "\n")
+	if !strings.Contains(got, "roc.request_total:2|c|#api:/echo") {
+		t.Errorf("counter delta got:\n%s", got)
+	}
+	if strings.Contains(got, "|ms") {
+		t.Errorf("no observations should not emit timer, got:\n%s", got)
+	}
+}
+
+func TestStatsdFormatWithoutTags(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "request_total", Help: "test"}, []string{"instance"})
+	registry.MustRegister(counter)
+	counter.WithLabelValues("10.0.0.1:80").Inc()
+
+	exporter := &statsdExporter{gatherer: registry, last: make(map[string]float64)}
+	lines, err := exporter.lines()
+	if err != nil {
+		t.Fatalf("lines err:%s", err)
+	}
+	if len(lines) != 1 || lines[0] != "request_total.10_0_0_1_80:1|c" {
+		t.Errorf("lines:%v", lines)
+	}
+}
@@ -0,0 +1,76 @@
+package rocserv
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+type streamStateKey struct{}
+
+type streamState struct {
+	enabled int32
+}
+
+// EnableStreaming 通知框架当前请求的响应不做缓冲，每次Write后立即flush，用于SSE等流式响应
+// 响应Content-Type为text/event-stream或者header设置了 X-Accel-Buffering: no 时会自动开启
+func EnableStreaming(ctx context.Context) bool {
+	st, ok := ctx.Value(streamStateKey{}).(*streamState)
+	if !ok {
+		return false
+	}
+
+	atomic.StoreInt32(&st.enabled, 1)
+	return true
+}
+
+// streamingMiddleware 需要放在最外层，保证拿到的是原始的ResponseWriter
+func streamingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &streamState{}
+		fw := &flushWriter{
+			ResponseWriter: w,
+			state:          st,
+		}
+		next.ServeHTTP(fw, r.WithContext(context.WithValue(r.Context(), streamStateKey{}, st)))
+	})
+}
+
+type flushWriter struct {
+	http.ResponseWriter
+	state *streamState
+}
+
+func (m *flushWriter) streaming() bool {
+	if atomic.LoadInt32(&m.state.enabled) == 1 {
+		return true
+	}
+
+	h := m.Header()
+	return strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") || h.Get("X-Accel-Buffering") == "no"
+}
+
+func (m *flushWriter) Write(b []byte) (int, error) {
+	n, err := m.ResponseWriter.Write(b)
+	if err == nil && m.streaming() {
+		m.Flush()
+	}
+	return n, err
+}
+
+func (m *flushWriter) Flush() {
+	if f, ok := m.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (m *flushWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := m.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer not support hijack")
+}
@@ -0,0 +1,62 @@
+package rocserv
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamingFlush(t *testing.T) {
+	next := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			<-next
+		}
+	})
+
+	// 外层模拟会包装ResponseWriter的框架中间件
+	wrap := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(struct{ http.ResponseWriter }{w}, r)
+	})
+
+	ts := httptest.NewServer(streamingMiddleware(wrap))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("get err:%s", err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		rd := bufio.NewReader(resp.Body)
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			if line != "\n" {
+				lines <- line
+			}
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case line := <-lines:
+			if line != fmt.Sprintf("data: %d\n", i) {
+				t.Errorf("unexpected event:%q", line)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("event %d not flushed", i)
+		}
+		next <- struct{}{}
+	}
+}
@@ -0,0 +1,222 @@
+package rocserv
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shawnfeng/sutil/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultTenantHeader = "X-Tenant-Id"
+	// 默认最多单独限流的租户数
+	defaultMaxTenants = 10000
+	// 租户数达到上限后清理补满的令牌桶的最小间隔
+	tenantSweepInterval = time.Second
+)
+
+type tenantContextKey struct{}
+
+// WithTenant 将租户id放入ctx
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext 获取ctx中的租户id
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenant, ok && len(tenant) > 0
+}
+
+// TenantOptions 租户中间件配置
+type TenantOptions struct {
+	// 读取租户id的header/metadata，默认 X-Tenant-Id
+	Header string
+	// header中没有租户id时，从 Authorization: Bearer <jwt> 的payload中读取的claim，为空不解析
+	// NOTE: 这里不校验jwt签名，签名需要由网关等上游保证
+	JWTClaim string
+	// 每个租户每秒允许的请求数，<=0 时不限流
+	Rate  float64
+	Burst int
+	// 最多单独限流的租户数，<=0 使用默认值10000；租户id由客户端传入，超过后新的租户共用一个令牌桶
+	MaxTenants int
+}
+
+// TenantMiddleware 从请求中提取租户id放入ctx，并可以按租户限流
+type TenantMiddleware struct {
+	opts TenantOptions
+
+	mu        sync.Mutex
+	limiters  map[string]*tokenBucket
+	overflow  *tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewTenantMiddleware(opts TenantOptions) *TenantMiddleware {
+	if len(opts.Header) == 0 {
+		opts.Header = defaultTenantHeader
+	}
+	if opts.MaxTenants <= 0 {
+		opts.MaxTenants = defaultMaxTenants
+	}
+
+	return &TenantMiddleware{
+		opts:     opts,
+		limiters: make(map[string]*tokenBucket),
+		overflow: newTokenBucket(opts.Rate, opts.Burst),
+		now:      time.Now,
+	}
+}
+
+// Name 用于Use添加到所有processor，生效的hook为HTTP及UnaryServerInterceptor
+func (m *TenantMiddleware) Name() string {
+	return "tenant"
+}
+
+func (m *TenantMiddleware) extract(get func(key string) string) string {
+	if tenant := get(m.opts.Header); len(tenant) > 0 {
+		return tenant
+	}
+
+	if len(m.opts.JWTClaim) == 0 {
+		return ""
+	}
+
+	auth := get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+
+	return jwtClaim(strings.TrimPrefix(auth, "Bearer "), m.opts.JWTClaim)
+}
+
+func jwtClaim(token, claim string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+
+	if v, ok := claims[claim].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// allow 租户是否还有配额，没有租户id的请求不限流
+func (m *TenantMiddleware) allow(tenant string) bool {
+	if m.opts.Rate <= 0 || len(tenant) == 0 {
+		return true
+	}
+
+	now := m.now()
+	return m.limiter(tenant, now).allowAt(now)
+}
+
+// limiter 返回租户的令牌桶，租户数达到上限时先清理补满的桶，仍然没有空位时使用共用的桶
+func (m *TenantMiddleware) limiter(tenant string, now time.Time) *tokenBucket {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limiter, ok := m.limiters[tenant]; ok {
+		return limiter
+	}
+
+	if len(m.limiters) >= m.opts.MaxTenants && now.Sub(m.lastSweep) >= tenantSweepInterval {
+		m.lastSweep = now
+		for t, limiter := range m.limiters {
+			if limiter.fullAt(now) {
+				delete(m.limiters, t)
+			}
+		}
+	}
+
+	if len(m.limiters) >= m.opts.MaxTenants {
+		return m.overflow
+	}
+
+	limiter := newTokenBucket(m.opts.Rate, m.opts.Burst)
+	limiter.last = now
+	m.limiters[tenant] = limiter
+	return limiter
+}
+
+// HTTP net/http 及 httprouter 使用的中间件
+func (m *TenantMiddleware) HTTP(next http.Handler) http.Handler {
+	fun := "TenantMiddleware.HTTP -->"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := m.extract(r.Header.Get)
+		if !m.allow(tenant) {
+			slog.Warnf("%s tenant:%s rate limited path:%s", fun, tenant, r.URL.Path)
+			http.Error(w, "tenant rate limited", http.StatusTooManyRequests)
+			return
+		}
+
+		if len(tenant) > 0 {
+			r = r.WithContext(WithTenant(r.Context(), tenant))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Gin gin使用的中间件
+func (m *TenantMiddleware) Gin() gin.HandlerFunc {
+	fun := "TenantMiddleware.Gin -->"
+	return func(c *gin.Context) {
+		tenant := m.extract(c.GetHeader)
+		if !m.allow(tenant) {
+			slog.Warnf("%s tenant:%s rate limited path:%s", fun, tenant, c.Request.URL.Path)
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+
+		if len(tenant) > 0 {
+			c.Request = c.Request.WithContext(WithTenant(c.Request.Context(), tenant))
+		}
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor grpc使用的拦截器，租户id从metadata中读取
+func (m *TenantMiddleware) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	fun := "TenantMiddleware.UnaryServerInterceptor -->"
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		tenant := m.extract(func(key string) string {
+			if vs := md.Get(key); len(vs) > 0 {
+				return vs[0]
+			}
+			return ""
+		})
+
+		if !m.allow(tenant) {
+			slog.Warnf("%s tenant:%s rate limited method:%s", fun, tenant, info.FullMethod)
+			return nil, status.Errorf(codes.ResourceExhausted, "tenant:%s rate limited", tenant)
+		}
+
+		if len(tenant) > 0 {
+			ctx = WithTenant(ctx, tenant)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,87 @@
+package rocserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	var got string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = TenantFromContext(r.Context())
+	})
+
+	mw := NewTenantMiddleware(TenantOptions{Rate: 0.001, Burst: 2}).HTTP(handler)
+
+	do := func(tenant string) int {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set(defaultTenantHeader, tenant)
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do("a"); code != 200 || got != "a" {
+		t.Errorf("code:%d tenant:%s", code, got)
+	}
+	if code := do("a"); code != 200 {
+		t.Errorf("code:%d", code)
+	}
+	if code := do("a"); code != http.StatusTooManyRequests {
+		t.Errorf("tenant a should be limited, code:%d", code)
+	}
+	if code := do("b"); code != 200 || got != "b" {
+		t.Errorf("tenant b should not be limited, code:%d tenant:%s", code, got)
+	}
+}
+
+func TestTenantLimitersBounded(t *testing.T) {
+	m := NewTenantMiddleware(TenantOptions{Rate: 1, Burst: 1, MaxTenants: 2})
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if !m.allow("a") || !m.allow("b") {
+		t.Fatalf("tenant a,b should be allowed")
+	}
+
+	// 达到上限后新的租户共用一个令牌桶，不再新建
+	for i := 0; i < 100; i++ {
+		m.allow("x" + strconv.Itoa(i))
+	}
+	if len(m.limiters) != 2 {
+		t.Errorf("limiters:%d", len(m.limiters))
+	}
+	if m.allow("c") {
+		t.Errorf("overflow bucket should be exhausted")
+	}
+	if m.allow("a") {
+		t.Errorf("tenant a should keep its own bucket")
+	}
+
+	// 令牌补满的桶被清理，新的租户重新单独限流
+	now = now.Add(2 * time.Second)
+	if !m.allow("c") {
+		t.Errorf("tenant c should be allowed after sweep")
+	}
+	if _, ok := m.limiters["c"]; !ok || len(m.limiters) != 1 {
+		t.Errorf("limiters:%v", m.limiters)
+	}
+}
+
+func TestTenantFromJWT(t *testing.T) {
+	// {"tenant_id":"t1"}
+	token := "e30.eyJ0ZW5hbnRfaWQiOiJ0MSJ9.sig"
+	m := NewTenantMiddleware(TenantOptions{JWTClaim: "tenant_id"})
+	tenant := m.extract(func(key string) string {
+		if key == "Authorization" {
+			return "Bearer " + token
+		}
+		return ""
+	})
+	if tenant != "t1" {
+		t.Errorf("tenant:%s", tenant)
+	}
+}
@@ -0,0 +1,317 @@
+package rocserv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/sutil/dbrouter"
+	"github.com/shawnfeng/sutil/slog"
+	"github.com/shawnfeng/sutil/slowid"
+)
+
+const defaultTestShutdownTimeout = 10 * time.Second
+
+// TestServBase 内存中的ServBase，用于单元测试，不需要etcd：
+// 配置来自SetConfig，注册信息记录在内存中，锁在进程内生效，Dbrouter为nil
+type TestServBase struct {
+	IdGenerator
+
+	servLoc string
+	servId  int
+
+	mu       sync.Mutex
+	config   []byte
+	watchers []func(newRaw []byte)
+	services map[string]*ServInfo
+	backdoor map[string]*ServInfo
+	crossDC  map[string]*ServInfo
+	deps     []string
+	probes   map[string]func(ctx context.Context) error
+	locks    map[string]chan struct{}
+}
+
+// NewTestServBase config为toml格式的服务配置
+func NewTestServBase(servLoc string, servId int, config string) (*TestServBase, error) {
+	if _, err := mergeConfig([]byte(config)); err != nil {
+		return nil, fmt.Errorf("parse config err:%s", err)
+	}
+
+	sf, err := initSnowflake(servId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TestServBase{
+		IdGenerator: IdGenerator{
+			workerID: servId,
+			slow:     make(map[string]*slowid.Slowid),
+			snow:     sf,
+		},
+		servLoc:  servLoc,
+		servId:   servId,
+		config:   []byte(config),
+		services: make(map[string]*ServInfo),
+		backdoor: make(map[string]*ServInfo),
+		crossDC:  make(map[string]*ServInfo),
+		probes:   make(map[string]func(ctx context.Context) error),
+		locks:    make(map[string]chan struct{}),
+	}, nil
+}
+
+func (m *TestServBase) RegisterService(servs map[string]*ServInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.services = copyServInfos(servs)
+	return nil
+}
+
+func (m *TestServBase) RegisterBackDoor(servs map[string]*ServInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.backdoor = copyServInfos(servs)
+	return nil
+}
+
+func (m *TestServBase) RegisterCrossDCService(servs map[string]*ServInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.crossDC = copyServInfos(servs)
+	return nil
+}
+
+// Services 注册到服务发现的processor，key为processor名称
+func (m *TestServBase) Services() map[string]*ServInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return copyServInfos(m.services)
+}
+
+// BackDoors 注册的后门processor
+func (m *TestServBase) BackDoors() map[string]*ServInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return copyServInfos(m.backdoor)
+}
+
+func (m *TestServBase) Servname() string {
+	return m.servLoc
+}
+
+func (m *TestServBase) Servid() int {
+	return m.servId
+}
+
+func (m *TestServBase) Copyname() string {
+	return fmt.Sprintf("%s%d", m.servLoc, m.servId)
+}
+
+func (m *TestServBase) ServConfig(cfg interface{}) error {
+	m.mu.Lock()
+	raw := m.config
+	m.mu.Unlock()
+
+	tf, err := mergeConfig(raw)
+	if err != nil {
+		return err
+	}
+	return tf.Unmarshal(cfg)
+}
+
+func (m *TestServBase) WatchConfig(fn func(newRaw []byte)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.watchers = append(m.watchers, fn)
+}
+
+// SetConfig 替换服务配置并回调WatchConfig，新配置无法解析时返回错误且不替换
+func (m *TestServBase) SetConfig(config string) error {
+	if _, err := mergeConfig([]byte(config)); err != nil {
+		return fmt.Errorf("parse config err:%s", err)
+	}
+
+	m.mu.Lock()
+	m.config = []byte(config)
+	watchers := append(([]func(newRaw []byte))(nil), m.watchers...)
+	m.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn([]byte(config))
+	}
+	return nil
+}
+
+// lock 容量为1的channel作为锁，支持Trylock
+func (m *TestServBase) lock(name string) chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, ok := m.locks[name]
+	if !ok {
+		l = make(chan struct{}, 1)
+		m.locks[name] = l
+	}
+	return l
+}
+
+func (m *TestServBase) Lock(name string) error {
+	m.lock(name) <- struct{}{}
+	return nil
+}
+
+// Unlock 和ServBaseV2一致，没有lock时panic
+func (m *TestServBase) Unlock(name string) error {
+	select {
+	case <-m.lock(name):
+		return nil
+	default:
+		panic(fmt.Sprintf("unlock of unlocked lock:%s", name))
+	}
+}
+
+func (m *TestServBase) Trylock(name string) (bool, error) {
+	select {
+	case m.lock(name) <- struct{}{}:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (m *TestServBase) LockGlobal(name string) error {
+	return m.Lock("global/" + name)
+}
+
+func (m *TestServBase) UnlockGlobal(name string) error {
+	return m.Unlock("global/" + name)
+}
+
+func (m *TestServBase) TrylockGlobal(name string) (bool, error) {
+	return m.Trylock("global/" + name)
+}
+
+func (m *TestServBase) Dbrouter() *dbrouter.Router {
+	return nil
+}
+
+// AddDependencyProbe 只记录探测函数，不定时探测，由测试通过Probe调用
+func (m *TestServBase) AddDependencyProbe(name string, interval time.Duration, probe func(ctx context.Context) error) error {
+	if probe == nil {
+		return fmt.Errorf("dependency:%s probe nil", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.probes[name]; ok {
+		return fmt.Errorf("dependency:%s probe already added", name)
+	}
+	m.probes[name] = probe
+	return nil
+}
+
+// Probe 调用注册的依赖探测
+func (m *TestServBase) Probe(ctx context.Context, name string) error {
+	m.mu.Lock()
+	probe, ok := m.probes[name]
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("dependency:%s not found", name)
+	}
+	return probe(ctx)
+}
+
+func (m *TestServBase) DeclareDependencies(servLocs ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deps = append(m.deps, servLocs...)
+	return nil
+}
+
+// Dependencies 声明的依赖服务
+func (m *TestServBase) Dependencies() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.deps...)
+}
+
+func copyServInfos(servs map[string]*ServInfo) map[string]*ServInfo {
+	infos := make(map[string]*ServInfo, len(servs))
+	for n, info := range servs {
+		c := *info
+		infos[n] = &c
+	}
+	return infos
+}
+
+// NewTestService 使用给定的ServBase(如TestServBase)启动服务，不连接etcd、不解析命令行参数，
+// 不加载日志、metrics、后门等框架配置及processor；processor监听后立即返回，teardown下线服务
+func NewTestService(sb ServBase, initfn func(ServBase) error, procs map[string]Processor) (*Service, func(), error) {
+	return NewTestServiceWith(NewService(), sb, initfn, procs)
+}
+
+// NewTestServiceWith 同NewTestService，使用已创建的Service，可以在启动前调用Use等
+func NewTestServiceWith(m *Service, sb ServBase, initfn func(ServBase) error, procs map[string]Processor) (*Service, func(), error) {
+	fun := "NewTestServiceWith -->"
+
+	if sb == nil {
+		return nil, nil, fmt.Errorf("servbase nil")
+	}
+	if err := m.startServing(); err != nil {
+		return nil, nil, err
+	}
+
+	m.sbase = sb
+
+	teardown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTestShutdownTimeout)
+		defer cancel()
+		if err := m.Shutdown(ctx); err != nil {
+			slog.Errorf("%s shutdown err:%s", fun, err)
+		}
+	}
+
+	if initfn != nil {
+		if err := initfn(sb); err != nil {
+			slog.Errorf("%s initfn err:%s", fun, err)
+			teardown()
+			return nil, nil, err
+		}
+	}
+
+	procs, err := checkProcessors(sb, procs, false)
+	if err != nil {
+		teardown()
+		return nil, nil, err
+	}
+
+	infos, err := m.loadDriver(sb, procs, nil)
+	if err != nil {
+		teardown()
+		return nil, nil, err
+	}
+	infos = discoverableInfos(procs, infos)
+
+	m.mutex.Lock()
+	m.procs = procs
+	m.infos = infos
+	m.mutex.Unlock()
+
+	if err := sb.RegisterService(infos); err != nil {
+		teardown()
+		return nil, nil, err
+	}
+
+	m.markReady(nil)
+	return m, teardown, nil
+}
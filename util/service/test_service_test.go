@@ -0,0 +1,95 @@
+package rocserv
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestNewTestService(t *testing.T) {
+	sb, err := NewTestServBase("base/test", 1, "[test]\nname = \"a\"\n")
+	if err != nil {
+		t.Fatalf("new servbase err:%s", err)
+	}
+
+	var cfg struct {
+		Test struct {
+			Name string
+		}
+	}
+	var watched []byte
+	initfn := func(sb ServBase) error {
+		sb.WatchConfig(func(newRaw []byte) {
+			watched = newRaw
+		})
+		return sb.ServConfig(&cfg)
+	}
+
+	router := httprouter.New()
+	router.GET("/ping", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Write([]byte("pong"))
+	})
+	m, teardown, err := NewTestService(sb, initfn, map[string]Processor{"api": &testProcessor{addr: "127.0.0.1:", driver: router}})
+	if err != nil {
+		t.Fatalf("new test service err:%s", err)
+	}
+
+	select {
+	case <-m.Ready():
+	default:
+		t.Errorf("service should be ready")
+	}
+	if cfg.Test.Name != "a" {
+		t.Errorf("config:%+v", cfg)
+	}
+
+	info, ok := sb.Services()["api"]
+	if !ok {
+		t.Fatalf("api not registered:%v", sb.Services())
+	}
+	resp, err := http.Get("http://" + info.Addr + "/ping")
+	if err != nil {
+		t.Fatalf("get err:%s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "pong" {
+		t.Errorf("body:%s", body)
+	}
+
+	if err := sb.SetConfig("[test]\nname = \"b\"\n"); err != nil || string(watched) != "[test]\nname = \"b\"\n" {
+		t.Errorf("set config err:%v watched:%s", err, watched)
+	}
+
+	teardown()
+	if _, err := http.Get("http://" + info.Addr + "/ping"); err == nil {
+		t.Errorf("processor should stop after teardown")
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown again err:%s", err)
+	}
+}
+
+func TestTestServBaseLock(t *testing.T) {
+	sb, err := NewTestServBase("base/test", 1, "")
+	if err != nil {
+		t.Fatalf("new servbase err:%s", err)
+	}
+
+	if ok, _ := sb.Trylock("a"); !ok {
+		t.Errorf("trylock should succeed")
+	}
+	if ok, _ := sb.Trylock("a"); ok {
+		t.Errorf("trylock on locked should fail")
+	}
+	if ok, _ := sb.TrylockGlobal("a"); !ok {
+		t.Errorf("global lock should be separate")
+	}
+	sb.Unlock("a")
+	if ok, _ := sb.Trylock("a"); !ok {
+		t.Errorf("trylock after unlock should succeed")
+	}
+}
@@ -0,0 +1,359 @@
+package rocserv
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+// thrift本身不支持传递header，这里约定将trace/request id等元数据作为参数struct的第一个字段传递：
+// 字段id为thriftHeaderFieldID，类型为map<string,string>，
+// 没有解析该字段的server(老版本roc或其他框架)按未知字段跳过，不影响调用；
+// server端由powerThrift自动解析，client端通过ThriftContextProtocolFactory开启
+const (
+	// 保留的字段id，业务idl不能使用
+	thriftHeaderFieldID   int16 = 32767
+	thriftHeaderFieldName       = "_roc_header"
+
+	ThriftHeaderRequestID = "x-request-id"
+)
+
+type requestIDContextKey struct{}
+
+// WithRequestID 将request id放入ctx
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestIDFromContext 获取ctx中的request id
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDContextKey{}).(string)
+	return requestID, ok && len(requestID) > 0
+}
+
+// thriftHeader ctx中需要传递的trace及request id，没有时返回nil
+func thriftHeader(ctx context.Context, name string) url.Values {
+	fun := "thriftHeader -->"
+
+	header := url.Values{}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		carrier := opentracing.TextMapCarrier{}
+		err := span.Tracer().Inject(span.Context(), opentracing.TextMap, carrier)
+		if err != nil {
+			slog.Warnf("%s inject span name:%s err:%s", fun, name, err)
+		}
+		for k, v := range carrier {
+			header.Set(k, v)
+		}
+	}
+
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		header.Set(ThriftHeaderRequestID, requestID)
+	}
+
+	if len(header) == 0 {
+		return nil
+	}
+	return header
+}
+
+// writeThriftHeader 写入元数据字段
+func writeThriftHeader(out thrift.TProtocol, header url.Values) error {
+	if err := out.WriteFieldBegin(thriftHeaderFieldName, thrift.MAP, thriftHeaderFieldID); err != nil {
+		return err
+	}
+	if err := out.WriteMapBegin(thrift.STRING, thrift.STRING, len(header)); err != nil {
+		return err
+	}
+	for k := range header {
+		if err := out.WriteString(k); err != nil {
+			return err
+		}
+		if err := out.WriteString(header.Get(k)); err != nil {
+			return err
+		}
+	}
+	if err := out.WriteMapEnd(); err != nil {
+		return err
+	}
+	return out.WriteFieldEnd()
+}
+
+// readThriftHeader 读取ReadFieldBegin之后的元数据字段
+func readThriftHeader(in thrift.TProtocol) (url.Values, error) {
+	_, _, size, err := in.ReadMapBegin()
+	if err != nil {
+		return nil, err
+	}
+
+	header := url.Values{}
+	for i := 0; i < size; i++ {
+		k, err := in.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := in.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		header.Set(k, v)
+	}
+
+	if err := in.ReadMapEnd(); err != nil {
+		return nil, err
+	}
+	return header, in.ReadFieldEnd()
+}
+
+// ThriftContextProtocolFactory client端使用，写请求时将SetContext设置的ctx中的元数据带到server
+// 同一factory创建的protocol共享ctx，所以一个factory只能给一个连接使用
+type ThriftContextProtocolFactory struct {
+	factory thrift.TProtocolFactory
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func NewThriftContextProtocolFactory(factory thrift.TProtocolFactory) *ThriftContextProtocolFactory {
+	return &ThriftContextProtocolFactory{
+		factory: factory,
+	}
+}
+
+// SetContext 设置之后请求使用的ctx，传入nil时不再带元数据
+func (m *ThriftContextProtocolFactory) SetContext(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ctx = ctx
+}
+
+func (m *ThriftContextProtocolFactory) context() context.Context {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ctx
+}
+
+func (m *ThriftContextProtocolFactory) GetProtocol(trans thrift.TTransport) thrift.TProtocol {
+	return &thriftContextClientProtocol{
+		TProtocol: m.factory.GetProtocol(trans),
+		factory:   m,
+	}
+}
+
+type thriftContextClientProtocol struct {
+	thrift.TProtocol
+	factory *ThriftContextProtocolFactory
+	// 待写入参数struct的元数据
+	header url.Values
+}
+
+func (m *thriftContextClientProtocol) WriteMessageBegin(name string, typeId thrift.TMessageType, seqid int32) error {
+	m.header = nil
+	if ctx := m.factory.context(); ctx != nil && (typeId == thrift.CALL || typeId == thrift.ONEWAY) {
+		m.header = thriftHeader(ctx, name)
+	}
+	return m.TProtocol.WriteMessageBegin(name, typeId, seqid)
+}
+
+// WriteStructBegin 消息头之后的第一个struct为参数，元数据作为其第一个字段写入
+func (m *thriftContextClientProtocol) WriteStructBegin(name string) error {
+	if err := m.TProtocol.WriteStructBegin(name); err != nil {
+		return err
+	}
+
+	header := m.header
+	if header == nil {
+		return nil
+	}
+	m.header = nil
+	return writeThriftHeader(m.TProtocol, header)
+}
+
+// ThriftContextProcessor server端使用，从请求中提取元数据放入ctx，
+// 由于thrift生成的handler不带ctx，这里每个请求通过newProcessor创建processor，handler可以持有该ctx
+type ThriftContextProcessor struct {
+	newProcessor func(ctx context.Context) thrift.TProcessor
+}
+
+func NewThriftContextProcessor(newProcessor func(ctx context.Context) thrift.TProcessor) *ThriftContextProcessor {
+	return &ThriftContextProcessor{
+		newProcessor: newProcessor,
+	}
+}
+
+func (m *ThriftContextProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	// powerThrift已经解析了元数据并创建了span
+	if p, ok := in.(*thriftContextServerProtocol); ok && p.pending == thriftPendingMessage {
+		return m.newProcessor(p.ctx).Process(in, out)
+	}
+
+	sp, header, err := newThriftContextServerProtocol(in)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, span := startThriftServerSpan(sp.name, header)
+	defer span.Finish()
+	sp.ctx = ctx
+
+	return m.newProcessor(ctx).Process(sp, out)
+}
+
+// startThriftServerSpan 以方法名创建server span，元数据中有trace时作为其子span，ctx中带上request id
+func startThriftServerSpan(name string, header url.Values) (context.Context, opentracing.Span) {
+	carrier := opentracing.TextMapCarrier{}
+	for k := range header {
+		carrier[k] = header.Get(k)
+	}
+
+	opts := []opentracing.StartSpanOption{ext.SpanKindRPCServer}
+	if spanCtx, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, carrier); err == nil {
+		opts = append(opts, opentracing.ChildOf(spanCtx))
+	}
+	span := opentracing.GlobalTracer().StartSpan(name, opts...)
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+
+	if requestID := header.Get(ThriftHeaderRequestID); len(requestID) > 0 {
+		ctx = WithRequestID(ctx, requestID)
+	}
+	return ctx, span
+}
+
+// thriftTracingProcessor powerThrift自动添加在最外层，解析并跳过参数中的元数据字段，创建server span，
+// 需要在handler中使用ctx时使用ThriftContextProcessor
+type thriftTracingProcessor struct {
+	thrift.TProcessor
+}
+
+func (m *thriftTracingProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	sp, header, err := newThriftContextServerProtocol(in)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, span := startThriftServerSpan(sp.name, header)
+	defer span.Finish()
+	sp.ctx = ctx
+
+	ok, exc := m.TProcessor.Process(sp, out)
+	if exc != nil {
+		ext.Error.Set(span, true)
+	}
+	return ok, exc
+}
+
+// thriftContextServerProtocol 中已经读取的部分，依次返回给processor
+const (
+	thriftPendingNone = iota
+	thriftPendingField
+	thriftPendingStruct
+	thriftPendingMessage
+)
+
+// thriftContextServerProtocol 为解析元数据预先读取了消息头、参数struct头及第一个字段头，
+// processor读取时先返回这些已经读取的内容，元数据字段不会返回给processor
+type thriftContextServerProtocol struct {
+	thrift.TProtocol
+	ctx context.Context
+
+	pending int
+
+	name   string
+	typeId thrift.TMessageType
+	seqid  int32
+
+	structName string
+
+	fieldName string
+	fieldType thrift.TType
+	fieldId   int16
+}
+
+// newThriftContextServerProtocol 读取消息头及参数struct的第一个字段，第一个字段为元数据时解析并跳过
+func newThriftContextServerProtocol(in thrift.TProtocol) (*thriftContextServerProtocol, url.Values, error) {
+	m := &thriftContextServerProtocol{
+		TProtocol: in,
+		pending:   thriftPendingMessage,
+	}
+
+	var err error
+	if m.name, m.typeId, m.seqid, err = in.ReadMessageBegin(); err != nil {
+		return nil, nil, err
+	}
+	if m.structName, err = in.ReadStructBegin(); err != nil {
+		return nil, nil, err
+	}
+	if m.fieldName, m.fieldType, m.fieldId, err = in.ReadFieldBegin(); err != nil {
+		return nil, nil, err
+	}
+
+	if m.fieldId != thriftHeaderFieldID || m.fieldType != thrift.MAP {
+		return m, nil, nil
+	}
+
+	header, err := readThriftHeader(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.fieldName, m.fieldType, m.fieldId, err = in.ReadFieldBegin(); err != nil {
+		return nil, nil, err
+	}
+	return m, header, nil
+}
+
+func (m *thriftContextServerProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	if m.pending != thriftPendingMessage {
+		return m.TProtocol.ReadMessageBegin()
+	}
+
+	m.pending = thriftPendingStruct
+	return m.name, m.typeId, m.seqid, nil
+}
+
+func (m *thriftContextServerProtocol) ReadStructBegin() (string, error) {
+	if m.pending != thriftPendingStruct {
+		return m.TProtocol.ReadStructBegin()
+	}
+
+	m.pending = thriftPendingField
+	return m.structName, nil
+}
+
+func (m *thriftContextServerProtocol) ReadFieldBegin() (string, thrift.TType, int16, error) {
+	if m.pending != thriftPendingField {
+		return m.TProtocol.ReadFieldBegin()
+	}
+
+	m.pending = thriftPendingNone
+	return m.fieldName, m.fieldType, m.fieldId, nil
+}
+
+// Skip processor不认识方法时读取消息头后跳过整个参数struct，这里跳过已经读取了头部的struct
+func (m *thriftContextServerProtocol) Skip(fieldType thrift.TType) error {
+	if m.pending != thriftPendingStruct || fieldType != thrift.STRUCT {
+		return m.TProtocol.Skip(fieldType)
+	}
+
+	m.pending = thriftPendingNone
+	for ft := m.fieldType; ft != thrift.STOP; {
+		if err := m.TProtocol.Skip(ft); err != nil {
+			return err
+		}
+		if err := m.TProtocol.ReadFieldEnd(); err != nil {
+			return err
+		}
+
+		var err error
+		if _, ft, _, err = m.TProtocol.ReadFieldBegin(); err != nil {
+			return err
+		}
+	}
+	return m.TProtocol.ReadStructEnd()
+}
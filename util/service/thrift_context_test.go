@@ -0,0 +1,243 @@
+package rocserv
+
+import (
+	"context"
+	"testing"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+type testThriftProcessor struct {
+	name string
+}
+
+func (m *testThriftProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, _, _, err := in.ReadMessageBegin()
+	if err != nil {
+		return false, err
+	}
+	m.name = name
+	in.Skip(thrift.STRUCT)
+	in.ReadMessageEnd()
+	return true, nil
+}
+
+func TestThriftContextRoundTrip(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	span := tracer.StartSpan("client")
+	ctx := opentracing.ContextWithSpan(WithRequestID(context.Background(), "req-1"), span)
+
+	buf := thrift.NewTMemoryBuffer()
+	pf := NewThriftContextProtocolFactory(thrift.NewTBinaryProtocolFactoryDefault())
+	pf.SetContext(ctx)
+	cp := pf.GetProtocol(buf)
+	cp.WriteMessageBegin("echo", thrift.CALL, 1)
+	cp.WriteStructBegin("echo_args")
+	cp.WriteFieldStop()
+	cp.WriteStructEnd()
+	cp.WriteMessageEnd()
+	cp.Flush()
+
+	var serverCtx context.Context
+	proc := &testThriftProcessor{}
+	p := NewThriftContextProcessor(func(ctx context.Context) thrift.TProcessor {
+		serverCtx = ctx
+		return proc
+	})
+
+	sp := thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(buf)
+	if _, err := p.Process(sp, sp); err != nil {
+		t.Fatalf("process err:%s", err)
+	}
+
+	if proc.name != "echo" {
+		t.Errorf("method name:%s", proc.name)
+	}
+
+	if requestID, _ := RequestIDFromContext(serverCtx); requestID != "req-1" {
+		t.Errorf("request id:%s", requestID)
+	}
+
+	serverSpan := opentracing.SpanFromContext(serverCtx)
+	if serverSpan == nil {
+		t.Fatalf("server span not found")
+	}
+	want := span.Context().(mocktracer.MockSpanContext).TraceID
+	if got := serverSpan.Context().(mocktracer.MockSpanContext).TraceID; got != want {
+		t.Errorf("trace id:%d want:%d", got, want)
+	}
+}
+
+// writeTestThriftCall 按生成代码的方式写入echo(1: string msg)调用
+func writeTestThriftCall(ctx context.Context, msg string) *thrift.TMemoryBuffer {
+	buf := thrift.NewTMemoryBuffer()
+	pf := NewThriftContextProtocolFactory(thrift.NewTBinaryProtocolFactoryDefault())
+	pf.SetContext(ctx)
+	cp := pf.GetProtocol(buf)
+	cp.WriteMessageBegin("echo", thrift.CALL, 1)
+	cp.WriteStructBegin("echo_args")
+	cp.WriteFieldBegin("msg", thrift.STRING, 1)
+	cp.WriteString(msg)
+	cp.WriteFieldEnd()
+	cp.WriteFieldStop()
+	cp.WriteStructEnd()
+	cp.WriteMessageEnd()
+	cp.Flush()
+	return buf
+}
+
+// readTestThriftArgs 按生成代码的方式读取echo的参数，未知字段跳过
+func readTestThriftArgs(in thrift.TProtocol) (string, error) {
+	if _, err := in.ReadStructBegin(); err != nil {
+		return "", err
+	}
+
+	var msg string
+	for {
+		_, fieldType, fieldId, err := in.ReadFieldBegin()
+		if err != nil {
+			return "", err
+		}
+		if fieldType == thrift.STOP {
+			break
+		}
+		if fieldId == 1 && fieldType == thrift.STRING {
+			if msg, err = in.ReadString(); err != nil {
+				return "", err
+			}
+		} else if err := in.Skip(fieldType); err != nil {
+			return "", err
+		}
+		if err := in.ReadFieldEnd(); err != nil {
+			return "", err
+		}
+	}
+	return msg, in.ReadStructEnd()
+}
+
+func TestThriftHeaderWithoutExtractor(t *testing.T) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("client")
+	ctx := opentracing.ContextWithSpan(WithRequestID(context.Background(), "req-1"), span)
+
+	// 没有解析元数据的server(老版本或其他框架)方法名不变，元数据字段按未知字段跳过
+	sp := thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(writeTestThriftCall(ctx, "hi"))
+	name, _, _, err := sp.ReadMessageBegin()
+	if err != nil || name != "echo" {
+		t.Fatalf("method name:%s err:%v", name, err)
+	}
+	msg, err := readTestThriftArgs(sp)
+	if err != nil || msg != "hi" {
+		t.Errorf("args msg:%s err:%v", msg, err)
+	}
+	if err := sp.ReadMessageEnd(); err != nil {
+		t.Errorf("read message end err:%s", err)
+	}
+}
+
+func TestThriftHeaderArgs(t *testing.T) {
+	var msg string
+	var requestID string
+	p := NewThriftContextProcessor(func(ctx context.Context) thrift.TProcessor {
+		requestID, _ = RequestIDFromContext(ctx)
+		return thriftProcessorFunc(func(in, out thrift.TProtocol) (bool, thrift.TException) {
+			if _, _, _, err := in.ReadMessageBegin(); err != nil {
+				return false, err
+			}
+			var err error
+			if msg, err = readTestThriftArgs(in); err != nil {
+				return false, err
+			}
+			return true, in.ReadMessageEnd()
+		})
+	})
+
+	// 参数在元数据之后正常读取
+	ctx := WithRequestID(context.Background(), "req-1")
+	sp := thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(writeTestThriftCall(ctx, "hi"))
+	if _, err := p.Process(sp, sp); err != nil {
+		t.Fatalf("process err:%s", err)
+	}
+	if msg != "hi" || requestID != "req-1" {
+		t.Errorf("msg:%s request id:%s", msg, requestID)
+	}
+
+	// 不带元数据的请求
+	sp = thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(writeTestThriftCall(context.Background(), "hello"))
+	if _, err := p.Process(sp, sp); err != nil {
+		t.Fatalf("process err:%s", err)
+	}
+	if msg != "hello" || requestID != "" {
+		t.Errorf("msg:%s request id:%s", msg, requestID)
+	}
+}
+
+type thriftProcessorFunc func(in, out thrift.TProtocol) (bool, thrift.TException)
+
+func (f thriftProcessorFunc) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	return f(in, out)
+}
+
+func TestThriftTracingProcessor(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	span := tracer.StartSpan("client")
+	ctx := opentracing.ContextWithSpan(WithRequestID(context.Background(), "req-1"), span)
+
+	write := func() thrift.TProtocol {
+		buf := thrift.NewTMemoryBuffer()
+		pf := NewThriftContextProtocolFactory(thrift.NewTBinaryProtocolFactoryDefault())
+		pf.SetContext(ctx)
+		cp := pf.GetProtocol(buf)
+		cp.WriteMessageBegin("echo", thrift.CALL, 1)
+		cp.WriteStructBegin("echo_args")
+		cp.WriteFieldStop()
+		cp.WriteStructEnd()
+		cp.WriteMessageEnd()
+		cp.Flush()
+		return thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(buf)
+	}
+
+	// 普通processor读到去掉元数据的方法名
+	proc := &testThriftProcessor{}
+	sp := write()
+	if _, err := (&thriftTracingProcessor{proc}).Process(sp, sp); err != nil {
+		t.Fatalf("process err:%s", err)
+	}
+	if proc.name != "echo" {
+		t.Errorf("method name:%s", proc.name)
+	}
+
+	spans := tracer.FinishedSpans()
+	if len(spans) != 1 || spans[0].OperationName != "echo" {
+		t.Fatalf("server spans:%v", spans)
+	}
+	want := span.Context().(mocktracer.MockSpanContext).TraceID
+	if got := spans[0].SpanContext.TraceID; got != want {
+		t.Errorf("trace id:%d want:%d", got, want)
+	}
+
+	// ThriftContextProcessor使用已经解析的ctx
+	var serverCtx context.Context
+	p := NewThriftContextProcessor(func(ctx context.Context) thrift.TProcessor {
+		serverCtx = ctx
+		return proc
+	})
+	sp = write()
+	if _, err := (&thriftTracingProcessor{p}).Process(sp, sp); err != nil {
+		t.Fatalf("process err:%s", err)
+	}
+	if requestID, _ := RequestIDFromContext(serverCtx); requestID != "req-1" {
+		t.Errorf("request id:%s", requestID)
+	}
+	if n := len(tracer.FinishedSpans()); n != 2 {
+		t.Errorf("server spans:%d want:2", n)
+	}
+}
@@ -0,0 +1,161 @@
+package rocserv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	etcd "github.com/coreos/etcd/client"
+	"github.com/shawnfeng/sutil/slog"
+)
+
+const (
+	TOPOLOGY_EDGE_DECLARED = "declared"
+)
+
+// TopologyEdge 服务间的依赖，From调用To
+type TopologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// Topology 服务依赖图，节点为servLoc
+type Topology struct {
+	Nodes []string       `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+func (m *Topology) JSON() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// DOT graphviz格式
+func (m *Topology) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph topology {\n")
+	for _, n := range m.Nodes {
+		fmt.Fprintf(&b, "\t%q;\n", n)
+	}
+	for _, e := range m.Edges {
+		fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", e.From, e.To, e.Kind)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// TopologyClient 读取注册中心中各服务声明的依赖生成依赖图
+type TopologyClient struct {
+	confEtcd   configEtcd
+	etcdClient etcd.KeysAPI
+}
+
+func NewTopologyClient(etcdaddrs []string, baseLoc string) (*TopologyClient, error) {
+	c, err := etcd.New(newEtcdConfig(etcdaddrs))
+	if err != nil {
+		return nil, fmt.Errorf("create etcd client err:%s", err)
+	}
+
+	return &TopologyClient{
+		confEtcd:   configEtcd{etcdAddrs: etcdaddrs, useBaseloc: baseLoc},
+		etcdClient: etcd.NewKeysAPI(c),
+	}, nil
+}
+
+// Topology namespace为服务分组，如base，为空时返回所有服务
+func (m *TopologyClient) Topology(namespace string) (*Topology, error) {
+	fun := "TopologyClient.Topology -->"
+
+	path := fmt.Sprintf("%s/%s", m.confEtcd.useBaseloc, BASE_LOC_DIST_V2)
+	r, err := m.etcdClient.Get(context.Background(), path, &etcd.GetOptions{Recursive: true, Sort: false})
+	if err != nil {
+		slog.Errorf("%s get path:%s err:%s", fun, path, err)
+		return nil, err
+	}
+
+	kvs := make(map[string]string)
+	collectLeaves(r.Node, kvs)
+	return buildTopology(path+"/", kvs, namespace), nil
+}
+
+func collectLeaves(node *etcd.Node, kvs map[string]string) {
+	if node == nil {
+		return
+	}
+	if !node.Dir {
+		kvs[node.Key] = node.Value
+		return
+	}
+	for _, n := range node.Nodes {
+		collectLeaves(n, kvs)
+	}
+}
+
+// buildTopology key格式为 prefix + servLoc/servid/serve|deps
+func buildTopology(prefix string, kvs map[string]string, namespace string) *Topology {
+	fun := "buildTopology -->"
+
+	inNamespace := func(servLoc string) bool {
+		return len(namespace) == 0 || strings.HasPrefix(servLoc, namespace+"/")
+	}
+
+	nodes := make(map[string]bool)
+	edges := make(map[TopologyEdge]bool)
+	for k, v := range kvs {
+		rel := strings.TrimPrefix(k, prefix)
+		idx := strings.LastIndex(rel, "/")
+		if idx < 0 {
+			continue
+		}
+		leaf := rel[idx+1:]
+
+		idx = strings.LastIndex(rel[:idx], "/")
+		if idx <= 0 {
+			continue
+		}
+		servLoc := rel[:idx]
+		if !inNamespace(servLoc) {
+			continue
+		}
+
+		switch leaf {
+		case BASE_LOC_REG_SERV:
+			nodes[servLoc] = true
+		case BASE_LOC_REG_DEPS:
+			nodes[servLoc] = true
+
+			var deps []string
+			if err := json.Unmarshal([]byte(v), &deps); err != nil {
+				slog.Warnf("%s unmarshal deps key:%s err:%s", fun, k, err)
+				continue
+			}
+			for _, dep := range deps {
+				nodes[dep] = true
+				edges[TopologyEdge{From: servLoc, To: dep, Kind: TOPOLOGY_EDGE_DECLARED}] = true
+			}
+		}
+	}
+
+	topo := &Topology{
+		Nodes: make([]string, 0, len(nodes)),
+		Edges: make([]TopologyEdge, 0, len(edges)),
+	}
+	for n := range nodes {
+		topo.Nodes = append(topo.Nodes, n)
+	}
+	sort.Strings(topo.Nodes)
+
+	for e := range edges {
+		topo.Edges = append(topo.Edges, e)
+	}
+	sort.Slice(topo.Edges, func(i, j int) bool {
+		if topo.Edges[i].From != topo.Edges[j].From {
+			return topo.Edges[i].From < topo.Edges[j].From
+		}
+		return topo.Edges[i].To < topo.Edges[j].To
+	})
+
+	return topo
+}
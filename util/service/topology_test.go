@@ -0,0 +1,50 @@
+package rocserv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildTopology(t *testing.T) {
+	prefix := "/roc/dist2/"
+	kvs := map[string]string{
+		"/roc/dist2/base/gateway/1/serve": `{"servs":{}}`,
+		"/roc/dist2/base/gateway/1/deps":  `["base/account","base/order"]`,
+		"/roc/dist2/base/gateway/2/deps":  `["base/account"]`,
+		"/roc/dist2/base/order/1/deps":    `["base/account"]`,
+		"/roc/dist2/base/account/1/serve": `{"servs":{}}`,
+		"/roc/dist2/other/job/1/deps":     `["base/account"]`,
+	}
+
+	topo := buildTopology(prefix, kvs, "base")
+
+	want := []TopologyEdge{
+		{From: "base/gateway", To: "base/account", Kind: TOPOLOGY_EDGE_DECLARED},
+		{From: "base/gateway", To: "base/order", Kind: TOPOLOGY_EDGE_DECLARED},
+		{From: "base/order", To: "base/account", Kind: TOPOLOGY_EDGE_DECLARED},
+	}
+	if len(topo.Edges) != len(want) {
+		t.Fatalf("edges:%v", topo.Edges)
+	}
+	for i := range want {
+		if topo.Edges[i] != want[i] {
+			t.Errorf("edge:%d got:%v want:%v", i, topo.Edges[i], want[i])
+		}
+	}
+
+	if strings.Join(topo.Nodes, ",") != "base/account,base/gateway,base/order" {
+		t.Errorf("nodes:%v", topo.Nodes)
+	}
+
+	if dot := topo.DOT(); !strings.Contains(dot, `"base/gateway" -> "base/order"`) {
+		t.Errorf("dot:%s", dot)
+	}
+}
+
+func TestDependencyDeclarer(t *testing.T) {
+	for _, sb := range []ServBase{&ServBaseV2{}, &TestServBase{}} {
+		if _, ok := sb.(DependencyDeclarer); !ok {
+			t.Errorf("%T should implement DependencyDeclarer", sb)
+		}
+	}
+}
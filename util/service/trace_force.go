@@ -0,0 +1,67 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// 请求带上该header/metadata时强制采样，不受全局采样率影响
+const (
+	TRACE_FORCE_HEADER = "X-Trace-Force"
+
+	middlewareTraceForce = "trace_force"
+)
+
+func isTraceForced(v string) bool {
+	switch strings.ToLower(v) {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+func forceSample(span opentracing.Span, v string) {
+	if span != nil && isTraceForced(v) {
+		ext.SamplingPriority.Set(span, 1)
+	}
+}
+
+// traceForceSpanObserver 用于nethttp.MWSpanObserver，在span创建后、handler执行前生效
+func traceForceSpanObserver(span opentracing.Span, r *http.Request) {
+	forceSample(span, r.Header.Get(TRACE_FORCE_HEADER))
+}
+
+func traceForceFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	vals := md.Get(TRACE_FORCE_HEADER)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// traceForceServerInterceptor 需要放在tracing interceptor之后
+func traceForceServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		forceSample(opentracing.SpanFromContext(ctx), traceForceFromContext(ctx))
+		return handler(ctx, req)
+	}
+}
+
+func traceForceStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		forceSample(opentracing.SpanFromContext(ctx), traceForceFromContext(ctx))
+		return handler(srv, ss)
+	}
+}
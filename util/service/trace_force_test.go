@@ -0,0 +1,31 @@
+package rocserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing-contrib/go-stdlib/nethttp"
+	"github.com/uber/jaeger-client-go"
+)
+
+func TestTraceForceHeader(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("test", jaeger.NewConstSampler(false), reporter)
+	defer closer.Close()
+
+	h := nethttp.Middleware(tracer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		nethttp.MWSpanObserver(traceForceSpanObserver))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/foo", nil))
+	if n := reporter.SpansSubmitted(); n != 0 {
+		t.Fatalf("unforced request sampled:%d", n)
+	}
+
+	r := httptest.NewRequest("GET", "/foo", nil)
+	r.Header.Set(TRACE_FORCE_HEADER, "1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if n := reporter.SpansSubmitted(); n != 1 {
+		t.Errorf("forced request spans:%d", n)
+	}
+}
@@ -0,0 +1,113 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/julienschmidt/httprouter"
+	"github.com/shawnfeng/sutil/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	middlewarePause = "traffic_pause"
+
+	trafficPausedMsg = "traffic paused"
+)
+
+// trafficPaused 暂停业务流量，业务接口返回503/Unavailable，
+// 但不从服务发现中摘除，后门及健康检查不受影响，用于保留实例身份进行线上调试
+var trafficPaused int32
+
+func setTrafficPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&trafficPaused, v)
+}
+
+func isTrafficPaused() bool {
+	return atomic.LoadInt32(&trafficPaused) == 1
+}
+
+func pauseMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isTrafficPaused() {
+			writeError(w, r, http.StatusServiceUnavailable, trafficPausedMsg)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func pauseServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isTrafficPaused() {
+			return nil, status.Error(codes.Unavailable, trafficPausedMsg)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func pauseStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isTrafficPaused() {
+			return status.Error(codes.Unavailable, trafficPausedMsg)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// pauseThriftProcessor 暂停时读掉请求并返回异常，保持连接
+type pauseThriftProcessor struct {
+	thrift.TProcessor
+}
+
+func (m *pauseThriftProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	if !isTrafficPaused() {
+		return m.TProcessor.Process(in, out)
+	}
+
+	name, _, seqid, err := in.ReadMessageBegin()
+	if err != nil {
+		return false, err
+	}
+	in.Skip(thrift.STRUCT)
+	in.ReadMessageEnd()
+
+	x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, trafficPausedMsg)
+	out.WriteMessageBegin(name, thrift.EXCEPTION, seqid)
+	x.Write(out)
+	out.WriteMessageEnd()
+	out.Flush()
+	return true, nil
+}
+
+// handleTraffic 查看业务流量状态
+func handleTraffic(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": isTrafficPaused()})
+}
+
+// handleSetTraffic 变更业务流量状态, POST /backdoor/traffic?pause=1 暂停, pause=0 恢复
+func handleSetTraffic(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	fun := "handleSetTraffic -->"
+
+	switch r.FormValue("pause") {
+	case "1":
+		slog.Warnf("%s pause traffic, remote:%s", fun, r.RemoteAddr)
+		setTrafficPaused(true)
+	case "0":
+		slog.Infof("%s resume traffic, remote:%s", fun, r.RemoteAddr)
+		setTrafficPaused(false)
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "pause should be 1 or 0"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": isTrafficPaused()})
+}
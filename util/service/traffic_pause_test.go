@@ -0,0 +1,82 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTrafficPause(t *testing.T) {
+	defer setTrafficPaused(false)
+
+	sb := &ServBaseV2{
+		confEtcd:     configEtcd{useBaseloc: "/roc"},
+		servLocation: "base/test",
+		servId:       3,
+		regInfos:     make(map[string]string),
+		dryRun:       true,
+	}
+	servs := map[string]*ServInfo{
+		"proc_http": &ServInfo{Type: PROCESSOR_HTTP, Addr: "127.0.0.1:8080"},
+	}
+	if err := sb.RegisterService(servs); err != nil {
+		t.Fatalf("register err:%s", err)
+	}
+	registered := len(sb.registerInfos())
+
+	business := pauseMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unary := pauseServerInterceptor()
+	grpcHandler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	_, driver := (&backDoorHttp{}).Driver()
+	router := driver.(http.Handler)
+
+	// GET只查看，不变更
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/backdoor/traffic?pause=1", nil))
+	if w.Code != http.StatusOK || isTrafficPaused() {
+		t.Fatalf("get traffic code:%d paused:%t", w.Code, isTrafficPaused())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/backdoor/traffic?pause=1", nil))
+	if w.Code != http.StatusOK || !isTrafficPaused() {
+		t.Fatalf("pause code:%d paused:%t", w.Code, isTrafficPaused())
+	}
+
+	w = httptest.NewRecorder()
+	business.ServeHTTP(w, httptest.NewRequest("GET", "/echo", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("paused business code:%d", w.Code)
+	}
+
+	_, err := unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Echo/Echo"}, grpcHandler)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("paused grpc err:%v", err)
+	}
+
+	// 暂停期间仍然注册在服务发现中
+	if n := len(sb.registerInfos()); n != registered {
+		t.Errorf("register infos changed:%d want:%d", n, registered)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/backdoor/traffic?pause=0", nil))
+	if isTrafficPaused() {
+		t.Fatalf("traffic not resumed")
+	}
+
+	w = httptest.NewRecorder()
+	business.ServeHTTP(w, httptest.NewRequest("GET", "/echo", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("resumed business code:%d", w.Code)
+	}
+	if _, err := unary(context.Background(), nil, &grpc.UnaryServerInfo{}, grpcHandler); err != nil {
+		t.Errorf("resumed grpc err:%v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package rocserv
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// middlewareUse 拦截器链中Use添加的中间件所在的位置
+const middlewareUse = "use"
+
+// Middleware 通过Use添加到所有业务processor上的中间件，按processor类型使用其实现的hook，
+// 没有实现对应hook的processor类型上不生效：
+//
+//	http、gin、grpc-gateway、ws: HTTPMiddleware，ws在升级连接前执行
+//	grpc: GrpcUnaryMiddleware、GrpcStreamMiddleware
+//	thrift: ThriftMiddleware
+//
+// 在框架的tracing、RED、SLO、暂停流量等中间件之内执行，框架内部的processor(后门、metrics)不使用。
+// 同一逻辑在各协议上的实现可以用NewCallMiddleware只写一次
+type Middleware interface {
+	Name() string
+}
+
+type HTTPMiddleware interface {
+	Middleware
+	HTTP(next http.Handler) http.Handler
+}
+
+type GrpcUnaryMiddleware interface {
+	Middleware
+	UnaryServerInterceptor() grpc.UnaryServerInterceptor
+}
+
+type GrpcStreamMiddleware interface {
+	Middleware
+	StreamServerInterceptor() grpc.StreamServerInterceptor
+}
+
+type ThriftMiddleware interface {
+	Middleware
+	Thrift(next thrift.TProcessor) thrift.TProcessor
+}
+
+// useRegistry 记录Use添加的中间件，按添加顺序执行，先添加的在外层
+type useRegistry struct {
+	mu          sync.RWMutex
+	middlewares []Middleware
+}
+
+func newUseRegistry() *useRegistry {
+	return &useRegistry{}
+}
+
+func (m *useRegistry) add(ms ...Middleware) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.middlewares = append(m.middlewares, ms...)
+}
+
+func (m *useRegistry) all() []Middleware {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]Middleware(nil), m.middlewares...)
+}
+
+func (m *useRegistry) wrapHTTP(next http.Handler) http.Handler {
+	ms := m.all()
+	for i := len(ms) - 1; i >= 0; i-- {
+		if hm, ok := ms[i].(HTTPMiddleware); ok {
+			next = hm.HTTP(next)
+		}
+	}
+	return next
+}
+
+func (m *useRegistry) wrapThrift(next thrift.TProcessor) thrift.TProcessor {
+	ms := m.all()
+	for i := len(ms) - 1; i >= 0; i-- {
+		if tm, ok := ms[i].(ThriftMiddleware); ok {
+			next = tm.Thrift(next)
+		}
+	}
+	return next
+}
+
+// grpcChain 启动grpc processor时生成拦截器链，没有对应的中间件时为nil
+func (m *useRegistry) grpcChain() *useGrpcChain {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	for _, mw := range m.all() {
+		if um, ok := mw.(GrpcUnaryMiddleware); ok {
+			unary = append(unary, um.UnaryServerInterceptor())
+		}
+		if sm, ok := mw.(GrpcStreamMiddleware); ok {
+			stream = append(stream, sm.StreamServerInterceptor())
+		}
+	}
+	if len(unary) == 0 && len(stream) == 0 {
+		return nil
+	}
+
+	chain := &useGrpcChain{}
+	if len(unary) > 0 {
+		chain.unary = grpc_middleware.ChainUnaryServer(unary...)
+	}
+	if len(stream) > 0 {
+		chain.stream = grpc_middleware.ChainStreamServer(stream...)
+	}
+	return chain
+}
+
+// infos driver上生效的中间件，用于 /backdoor/middleware
+func (m *useRegistry) infos(driver interface{}) []MiddlewareInfo {
+	var infos []MiddlewareInfo
+	for _, mw := range m.all() {
+		var ok bool
+		switch driver.(type) {
+		case *GrpcServer:
+			_, unary := mw.(GrpcUnaryMiddleware)
+			_, stream := mw.(GrpcStreamMiddleware)
+			ok = unary || stream
+		case thrift.TProcessor:
+			_, ok = mw.(ThriftMiddleware)
+		default:
+			_, ok = mw.(HTTPMiddleware)
+		}
+		if ok {
+			infos = append(infos, MiddlewareInfo{Name: mw.Name(), Source: MIDDLEWARE_SOURCE_USER})
+		}
+	}
+	return infos
+}
+
+type useGrpcChain struct {
+	unary  grpc.UnaryServerInterceptor
+	stream grpc.StreamServerInterceptor
+}
+
+func (m *GrpcServer) useServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if m.uses == nil || m.uses.unary == nil {
+			return handler(ctx, req)
+		}
+		return m.uses.unary(ctx, req, info, handler)
+	}
+}
+
+func (m *GrpcServer) useStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if m.uses == nil || m.uses.stream == nil {
+			return handler(srv, ss)
+		}
+		return m.uses.stream(srv, ss, info, handler)
+	}
+}
+
+// CallFunc 和协议无关的中间件逻辑，method为http路径、grpc的FullMethod或thrift方法名，
+// 调用next继续处理请求；不调用next并返回错误时拒绝请求：
+// http按错误的grpc status code转换为对应的状态码返回ErrorEnvelope，grpc直接返回该错误，thrift返回TApplicationException
+type CallFunc func(ctx context.Context, method string, next func(ctx context.Context) error) error
+
+// CallMiddleware 用CallFunc实现所有processor类型的hook
+type CallMiddleware struct {
+	name string
+	fn   CallFunc
+}
+
+func NewCallMiddleware(name string, fn CallFunc) *CallMiddleware {
+	return &CallMiddleware{name: name, fn: fn}
+}
+
+func (m *CallMiddleware) Name() string {
+	return m.name
+}
+
+func (m *CallMiddleware) HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called := false
+		err := m.fn(r.Context(), r.URL.Path, func(ctx context.Context) error {
+			called = true
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return nil
+		})
+		if err != nil && !called {
+			writeError(w, r, httpStatusFromError(err), status.Convert(err).Message())
+		}
+	})
+}
+
+func (m *CallMiddleware) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var resp interface{}
+		err := m.fn(ctx, info.FullMethod, func(ctx context.Context) error {
+			var err error
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+func (m *CallMiddleware) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return m.fn(ss.Context(), info.FullMethod, func(ctx context.Context) error {
+			return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+		})
+	}
+}
+
+func (m *CallMiddleware) Thrift(next thrift.TProcessor) thrift.TProcessor {
+	return &callThriftProcessor{TProcessor: next, fn: m.fn}
+}
+
+// contextServerStream 使用中间件传入的ctx
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (m *contextServerStream) Context() context.Context {
+	return m.ctx
+}
+
+// callThriftProcessor 需要在thriftTracingProcessor之内，从thriftContextServerProtocol获取方法名及ctx
+type callThriftProcessor struct {
+	thrift.TProcessor
+	fn CallFunc
+}
+
+func (m *callThriftProcessor) Process(in, out thrift.TProtocol) (bool, thrift.TException) {
+	p, ok := in.(*thriftContextServerProtocol)
+	if !ok || p.read {
+		return m.TProcessor.Process(in, out)
+	}
+
+	called := false
+	var success bool
+	var exc thrift.TException
+	err := m.fn(p.ctx, p.name, func(ctx context.Context) error {
+		called = true
+		p.ctx = ctx
+		success, exc = m.TProcessor.Process(p, out)
+		if exc != nil {
+			return exc
+		}
+		return nil
+	})
+	if called || err == nil {
+		return success, exc
+	}
+
+	// 拒绝时读掉请求并返回异常，保持连接
+	in.Skip(thrift.STRUCT)
+	in.ReadMessageEnd()
+
+	x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, status.Convert(err).Message())
+	out.WriteMessageBegin(p.name, thrift.EXCEPTION, p.seqid)
+	x.Write(out)
+	out.WriteMessageEnd()
+	out.Flush()
+	return true, nil
+}
+
+// httpStatusFromError 中间件拒绝请求时返回的http状态码
+func httpStatusFromError(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
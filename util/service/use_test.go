@@ -0,0 +1,144 @@
+package rocserv
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/julienschmidt/httprouter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type useContextKey struct{}
+
+// testUseMiddleware 拒绝deny开头的方法，其他请求在ctx中记录经过的中间件
+func testUseMiddleware(name string) *CallMiddleware {
+	return NewCallMiddleware(name, func(ctx context.Context, method string, next func(ctx context.Context) error) error {
+		if strings.HasPrefix(strings.TrimPrefix(method, "/"), "deny") {
+			return status.Error(codes.PermissionDenied, "denied by "+name)
+		}
+		via, _ := ctx.Value(useContextKey{}).(string)
+		return next(context.WithValue(ctx, useContextKey{}, via+name+","))
+	})
+}
+
+func TestUseHTTP(t *testing.T) {
+	m := NewService()
+	m.Use(testUseMiddleware("a"), testUseMiddleware("b"))
+
+	router := httprouter.New()
+	router.GET("/ok", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		via, _ := r.Context().Value(useContextKey{}).(string)
+		w.Write([]byte(via))
+	})
+	infos, err := m.loadDriver(nil, map[string]Processor{"api": &testProcessor{addr: "127.0.0.1:", driver: router}}, nil)
+	if err != nil {
+		t.Fatalf("load driver err:%s", err)
+	}
+	defer m.Shutdown(context.Background())
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get("http://" + infos["api"].Addr + path)
+		if err != nil {
+			t.Fatalf("get err:%s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// 按添加顺序执行
+	if code, body := get("/ok"); code != http.StatusOK || body != "a,b," {
+		t.Errorf("ok code:%d body:%s", code, body)
+	}
+	if code, body := get("/deny"); code != http.StatusForbidden || !strings.Contains(body, "denied by a") {
+		t.Errorf("deny code:%d body:%s", code, body)
+	}
+
+	var names []string
+	for _, info := range m.middlewares.all()["api"] {
+		if info.Source == MIDDLEWARE_SOURCE_USER {
+			names = append(names, info.Name)
+		}
+	}
+	if strings.Join(names, ",") != "a,b" {
+		t.Errorf("user middlewares:%v", names)
+	}
+}
+
+func TestUseGrpc(t *testing.T) {
+	uses := newUseRegistry()
+	uses.add(testUseMiddleware("a"), testUseMiddleware("b"))
+
+	gs := NewGrpcServer()
+	gs.uses = uses.grpcChain()
+	interceptor := gs.useServerInterceptor()
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		via, _ := ctx.Value(useContextKey{}).(string)
+		return via, nil
+	}
+	resp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Echo/Echo"}, handler)
+	if err != nil || resp != "a,b," {
+		t.Errorf("resp:%v err:%v", resp, err)
+	}
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "deny/Echo"}, handler)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("deny err:%v", err)
+	}
+
+	// 没有Use时直接调用handler
+	gs.uses = newUseRegistry().grpcChain()
+	interceptor = gs.useServerInterceptor()
+	if resp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "deny/Echo"}, handler); err != nil || resp != "" {
+		t.Errorf("resp:%v err:%v", resp, err)
+	}
+}
+
+func TestUseThrift(t *testing.T) {
+	uses := newUseRegistry()
+	uses.add(testUseMiddleware("a"))
+
+	write := func(name string) thrift.TProtocol {
+		buf := thrift.NewTMemoryBuffer()
+		p := thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(buf)
+		p.WriteMessageBegin(name, thrift.CALL, 1)
+		p.WriteStructBegin("args")
+		p.WriteFieldStop()
+		p.WriteStructEnd()
+		p.WriteMessageEnd()
+		p.Flush()
+		return p
+	}
+
+	proc := &testThriftProcessor{}
+	p := &thriftTracingProcessor{uses.wrapThrift(proc)}
+
+	sp := write("echo")
+	if _, err := p.Process(sp, sp); err != nil {
+		t.Fatalf("process err:%s", err)
+	}
+	if proc.name != "echo" {
+		t.Errorf("method name:%s", proc.name)
+	}
+
+	// 拒绝时返回异常，不调用processor
+	proc.name = ""
+	sp = write("deny")
+	if _, err := p.Process(sp, sp); err != nil {
+		t.Fatalf("process err:%s", err)
+	}
+	if len(proc.name) > 0 {
+		t.Errorf("processor should not be called, method:%s", proc.name)
+	}
+	name, typeId, _, err := sp.ReadMessageBegin()
+	if err != nil || name != "deny" || typeId != thrift.EXCEPTION {
+		t.Errorf("reply name:%s type:%d err:%v", name, typeId, err)
+	}
+}
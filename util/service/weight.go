@@ -0,0 +1,18 @@
+package rocserv
+
+// DEFAULT_SERV_WEIGHT 未指定 -weight 时注册的实例权重
+const DEFAULT_SERV_WEIGHT = 100
+
+func (m *Service) getWeight() int {
+	if m.weight <= 0 {
+		return DEFAULT_SERV_WEIGHT
+	}
+	return m.weight
+}
+
+// setServWeight 设置注册到服务发现的实例权重
+func setServWeight(infos map[string]*ServInfo, weight int) {
+	for _, info := range infos {
+		info.Weight = weight
+	}
+}
@@ -0,0 +1,232 @@
+package rocserv
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shawnfeng/sutil/slog"
+	"github.com/shawnfeng/sutil/snetutil"
+	"golang.org/x/net/websocket"
+)
+
+// 下线时检查连接是否都已结束的间隔
+const wsShutdownCheckInterval = time.Millisecond * 50
+
+// WsHandler 处理一个websocket连接，返回后连接被关闭，服务下线时ctx结束，需要尽快返回
+type WsHandler func(ctx context.Context, conn *websocket.Conn)
+
+// WsRouter websocket processor的driver，按路径注册连接的处理函数
+type WsRouter struct {
+	mu       sync.RWMutex
+	handlers map[string]WsHandler
+
+	// 下线时关闭，通知处理中的连接
+	shutdownOnce sync.Once
+	shutdownC    chan struct{}
+
+	muConns sync.Mutex
+	conns   map[*websocket.Conn]struct{}
+}
+
+func NewWsRouter() *WsRouter {
+	return &WsRouter{
+		handlers:  make(map[string]WsHandler),
+		shutdownC: make(chan struct{}),
+		conns:     make(map[*websocket.Conn]struct{}),
+	}
+}
+
+// Handle 注册path上的连接处理函数，重复注册时覆盖
+func (m *WsRouter) Handle(path string, h WsHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlers[path] = h
+}
+
+// Routes 已注册的路径，用于 /backdoor/routes
+func (m *WsRouter) Routes() []RouteInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	routes := make([]RouteInfo, 0, len(m.handlers))
+	for path := range m.handlers {
+		routes = append(routes, RouteInfo{Method: "GET", Path: path})
+	}
+	return routes
+}
+
+func (m *WsRouter) isShutdown() bool {
+	select {
+	case <-m.shutdownC:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *WsRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.RLock()
+	h, ok := m.handlers[r.URL.Path]
+	m.mu.RUnlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if m.isShutdown() {
+		writeError(w, r, http.StatusServiceUnavailable, "server shutting down")
+		return
+	}
+
+	websocket.Server{Handler: func(conn *websocket.Conn) {
+		m.serve(r.Context(), conn, h)
+	}}.ServeHTTP(w, r)
+}
+
+func (m *WsRouter) serve(ctx context.Context, conn *websocket.Conn, h WsHandler) {
+	if !m.track(conn) {
+		return
+	}
+	defer m.untrack(conn)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-m.shutdownC:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	h(ctx, conn)
+}
+
+// track 下线后不再接收新连接
+func (m *WsRouter) track(conn *websocket.Conn) bool {
+	m.muConns.Lock()
+	defer m.muConns.Unlock()
+
+	if m.isShutdown() {
+		return false
+	}
+	m.conns[conn] = struct{}{}
+	return true
+}
+
+func (m *WsRouter) untrack(conn *websocket.Conn) {
+	m.muConns.Lock()
+	defer m.muConns.Unlock()
+
+	delete(m.conns, conn)
+}
+
+func (m *WsRouter) activeConns() int {
+	m.muConns.Lock()
+	defer m.muConns.Unlock()
+
+	return len(m.conns)
+}
+
+// shutdown 通知处理中的连接退出并等待，ctx结束时强制关闭剩余的连接
+func (m *WsRouter) shutdown(ctx context.Context) error {
+	fun := "WsRouter.shutdown -->"
+
+	m.shutdownOnce.Do(func() {
+		close(m.shutdownC)
+	})
+
+	ticker := time.NewTicker(wsShutdownCheckInterval)
+	defer ticker.Stop()
+	for m.activeConns() > 0 {
+		select {
+		case <-ctx.Done():
+			m.muConns.Lock()
+			slog.Warnf("%s force close conns:%d", fun, len(m.conns))
+			for conn := range m.conns {
+				conn.Close()
+			}
+			m.muConns.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// wsHandle http server的Shutdown不等待已升级的连接，由WsRouter等待
+type wsHandle struct {
+	server *http.Server
+	router *WsRouter
+}
+
+func (m *wsHandle) Stop(ctx context.Context) error {
+	err := m.server.Shutdown(ctx)
+	if err != nil {
+		m.server.Close()
+	}
+	if e := m.router.shutdown(ctx); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// powerWs 连接持续时间长，不做耗时相关的统计及过载拒绝，wrap添加Use的中间件，在升级连接前执行，tlsConfig不为nil时使用wss
+// frameworkWsChain ws processor上框架的中间件，按执行顺序
+func frameworkWsChain() *httpChain {
+	return (&httpChain{}).
+		add(middlewareRecovery, recoveryMiddleware).
+		add(middlewareTracing, tracingMiddleware(func(r *http.Request) string {
+			return "WS " + r.URL.Path
+		})).
+		add(middlewareTrafficLog, httpTrafficLogMiddleware)
+}
+
+// powerWs handler为组装好中间件的router，下线时关闭router上的连接
+func powerWs(processor, addr string, router *WsRouter, handler http.Handler, tlsConfig *tls.Config) (string, powerHandle, error) {
+	fun := "powerWs -->"
+
+	paddr, err := snetutil.GetListenAddr(addr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	slog.Infof("%s config addr[%s]", fun, paddr)
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", paddr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	netListen, err := listen(tcpAddr.Network(), tcpAddr.String())
+	if err != nil {
+		return "", nil, err
+	}
+
+	laddr, err := snetutil.GetServAddr(netListen.Addr())
+	if err != nil {
+		netListen.Close()
+		return "", nil, err
+	}
+
+	slog.Infof("%s listen addr[%s] tls:%t", fun, laddr, tlsConfig != nil)
+	netListen = newConnLimitListener(netListen, laddr)
+	if tlsConfig != nil {
+		netListen = tls.NewListener(netListen, tlsConfig)
+	}
+
+	serv := &http.Server{Handler: processorMiddleware(processor, handler)}
+	go func() {
+		err := serv.Serve(netListen)
+		if err != nil && err != http.ErrServerClosed {
+			slog.Panicf("%s laddr[%s]", fun, laddr)
+		}
+	}()
+
+	return laddr, &wsHandle{server: serv, router: router}, nil
+}
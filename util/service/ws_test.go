@@ -0,0 +1,66 @@
+package rocserv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestWsProcessor(t *testing.T) {
+	old := service
+	service = NewService()
+	defer func() { service = old }()
+	service.sbase = &testRegServBase{}
+
+	done := make(chan struct{})
+	router := NewWsRouter()
+	router.Handle("/echo", func(ctx context.Context, conn *websocket.Conn) {
+		defer close(done)
+
+		var msg string
+		if err := websocket.Message.Receive(conn, &msg); err != nil {
+			t.Errorf("receive err:%s", err)
+			return
+		}
+		websocket.Message.Send(conn, msg)
+		// 保持连接直到服务下线
+		<-ctx.Done()
+	})
+
+	info, err := service.powerProcessor("ws", &testProcessor{addr: "127.0.0.1:", driver: router}, false)
+	if err != nil {
+		t.Fatalf("power processor err:%s", err)
+	}
+	if info.Type != PROCESSOR_WS {
+		t.Errorf("type got:%s want:%s", info.Type, PROCESSOR_WS)
+	}
+
+	conn, err := websocket.Dial("ws://"+info.Addr+"/echo", "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("dial err:%s", err)
+	}
+	defer conn.Close()
+
+	websocket.Message.Send(conn, "hello")
+	var reply string
+	if err := websocket.Message.Receive(conn, &reply); err != nil || reply != "hello" {
+		t.Fatalf("reply:%s err:%v", reply, err)
+	}
+
+	if _, err := websocket.Dial("ws://"+info.Addr+"/unknown", "", "http://localhost/"); err == nil {
+		t.Errorf("dial unregistered path should fail")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := service.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown err:%s", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("handler not notified on shutdown")
+	}
+}
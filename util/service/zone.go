@@ -0,0 +1,64 @@
+package rocserv
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+const (
+	// 实例所在zone发布到服务发现中的属性名
+	ATTR_ZONE = "balancer.zone"
+
+	envZone = "ROC_ZONE"
+)
+
+var localZone atomic.Value
+
+func init() {
+	localZone.Store("")
+}
+
+// setLocalZone zone为空时使用环境变量ROC_ZONE
+func setLocalZone(zone string) {
+	if len(zone) == 0 {
+		zone = os.Getenv(envZone)
+	}
+	localZone.Store(zone)
+}
+
+func getLocalZone() string {
+	return localZone.Load().(string)
+}
+
+// withZoneAttr 将本实例的zone加入发布到服务发现的属性
+func withZoneAttr(attrs map[string]string, zone string) map[string]string {
+	if len(zone) == 0 {
+		return attrs
+	}
+
+	res := make(map[string]string, len(attrs)+1)
+	for k, v := range attrs {
+		res[k] = v
+	}
+	res[ATTR_ZONE] = zone
+	return res
+}
+
+// preferZone 返回同zone的实例，没有时返回全部实例
+func preferZone(zone string, servs []*ServInfo) []*ServInfo {
+	if len(zone) == 0 {
+		return servs
+	}
+
+	var local []*ServInfo
+	for _, s := range servs {
+		if s.Attrs[ATTR_ZONE] == zone {
+			local = append(local, s)
+		}
+	}
+
+	if len(local) == 0 {
+		return servs
+	}
+	return local
+}
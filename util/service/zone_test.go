@@ -0,0 +1,66 @@
+package rocserv
+
+import (
+	"os"
+	"testing"
+)
+
+func TestZoneAwareBalancer(t *testing.T) {
+	setLocalZone("az1")
+	defer setLocalZone("")
+
+	lookup := &testServLookup{servs: []*ServInfo{
+		{Addr: "local", Attrs: map[string]string{ATTR_ZONE: "az1"}},
+		{Addr: "remote", Attrs: map[string]string{ATTR_ZONE: "az2"}},
+		{Addr: "unknown"},
+	}}
+	b := NewZoneAwareBalancer()
+	b.newLookup = func(servLoc string) (servLookup, error) {
+		return lookup, nil
+	}
+
+	for i := 0; i < 4; i++ {
+		s, err := b.Next("base/account", "proc_http")
+		if err != nil {
+			t.Fatalf("next err:%s", err)
+		}
+		if s.Addr != "local" {
+			t.Fatalf("local zone not preferred, got:%s", s.Addr)
+		}
+	}
+
+	// 同zone实例下线后使用其他zone
+	lookup.servs = lookup.servs[1:]
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		s, err := b.Next("base/account", "proc_http")
+		if err != nil {
+			t.Fatalf("next err:%s", err)
+		}
+		seen[s.Addr] = true
+	}
+	if !seen["remote"] || !seen["unknown"] {
+		t.Errorf("spillover got:%v", seen)
+	}
+}
+
+func TestLocalZoneFromEnv(t *testing.T) {
+	defer setLocalZone("")
+	os.Setenv(envZone, "az3")
+	defer os.Unsetenv(envZone)
+
+	setLocalZone("")
+	if zone := getLocalZone(); zone != "az3" {
+		t.Errorf("zone from env:%s", zone)
+	}
+
+	setLocalZone("az1")
+	if zone := getLocalZone(); zone != "az1" {
+		t.Errorf("zone from config:%s", zone)
+	}
+
+	attrs := withZoneAttr(map[string]string{"proto.version": "2"}, "az1")
+	if attrs[ATTR_ZONE] != "az1" || attrs["proto.version"] != "2" {
+		t.Errorf("attrs:%v", attrs)
+	}
+}